@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// accessEntry is one line of the JSONL access log of the serve command.
+type accessEntry struct {
+	Time      time.Time `json:"time"`
+	Client    string    `json:"client"`             // Remote address
+	User      string    `json:"user,omitempty"`     // Authenticated caller, see principal
+	Method    string    `json:"method"`             //
+	Path      string    `json:"path"`               // Request path and query, with tokens redacted
+	Document  string    `json:"document,omitempty"` // File name of the document served
+	Status    int       `json:"status"`             //
+	Bytes     int64     `json:"bytes"`              // Body bytes sent
+	Duration  float64   `json:"duration_ms"`        //
+	UserAgent string    `json:"user_agent,omitempty"`
+}
+
+// accessLog appends entries to the access log file; a nil log records nothing.
+type accessLog struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// openAccessLog opens the access log for appending; an empty path disables it.
+func openAccessLog(path string) (*accessLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open access log: %v", err)
+	}
+	return &accessLog{file: file}, nil
+}
+
+// record writes one entry.
+func (access *accessLog) record(entry accessEntry) {
+	if access == nil {
+		return
+	}
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	access.mutex.Lock()
+	defer access.mutex.Unlock()
+	if _, err := access.file.Write(append(encoded, '\n')); err != nil {
+		log.Println("failed to write access log:", err)
+	}
+}
+
+// close closes the log file.
+func (access *accessLog) close() error {
+	if access == nil {
+		return nil
+	}
+	return access.file.Close()
+}
+
+// documentHits is how often a document was consulted through the server, kept in a key-value log by file name.
+type documentHits struct {
+	Document     string    `json:"document"`
+	Hits         int64     `json:"hits"`
+	LastAccessed time.Time `json:"last_accessed"`
+}
+
+// openAccessCounts opens the key-value log of per-document hits; an empty path disables counting.
+func openAccessCounts(path string) (*kvStore, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return openKVStore(path)
+}
+
+// hitMutex serializes the read and write of a hit count, so concurrent downloads aren't lost.
+var hitMutex sync.Mutex
+
+// countHit adds one consultation of a document.
+func countHit(counts *kvStore, document string, at time.Time) {
+	if counts == nil {
+		return
+	}
+	hitMutex.Lock()
+	defer hitMutex.Unlock()
+	hits := documentHits{Document: document}
+	if _, err := counts.get(document, &hits); err != nil {
+		log.Printf("unreadable hit count of %s, starting over: %v", document, err)
+	}
+	hits.Hits = hits.Hits + 1
+	hits.LastAccessed = at.UTC()
+	if err := counts.put(document, hits); err != nil {
+		log.Println("failed to count hit:", err)
+	}
+}
+
+// topDocuments returns the most consulted documents, most hits first, at most limit of them (0 for all).
+func topDocuments(counts *kvStore, limit int) ([]documentHits, error) {
+	top := []documentHits{}
+	if counts == nil {
+		return top, nil
+	}
+	for _, key := range counts.keys() {
+		var hits documentHits
+		if _, err := counts.get(key, &hits); err != nil {
+			return nil, err
+		}
+		top = append(top, hits)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Hits != top[j].Hits {
+			return top[i].Hits > top[j].Hits
+		}
+		return top[i].Document < top[j].Document
+	})
+	if limit > 0 && len(top) > limit {
+		top = top[:limit]
+	}
+	return top, nil
+}
+
+// statusRecorder remembers the status and body size of a response for the access log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (recorder *statusRecorder) WriteHeader(status int) {
+	if recorder.status == 0 {
+		recorder.status = status
+	}
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter.
+func (recorder *statusRecorder) Write(data []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	written, err := recorder.ResponseWriter.Write(data)
+	recorder.bytes = recorder.bytes + int64(written)
+	return written, err
+}
+
+// consulted reports whether a document response counts as someone reading the sheet: the whole file,
+// its start, or a cached copy confirmed current. Later ranges of the same read and HEAD requests don't count.
+func consulted(request *http.Request, recorder *statusRecorder) bool {
+	if request.Method != http.MethodGet {
+		return false
+	}
+	switch recorder.status {
+	case http.StatusOK, http.StatusNotModified:
+		return true
+	case http.StatusPartialContent:
+		return strings.HasPrefix(recorder.Header().Get("Content-Range"), "bytes 0-")
+	}
+	return false
+}
+
+// logAccess records every request of a route in the access log and counts consultations of documents.
+func (srv *corpusServer) logAccess(route apiRoute, next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: writer}
+		next(recorder, request)
+		entry := accessEntry{
+			Time:      start.UTC(),
+			Client:    request.RemoteAddr,
+			Method:    request.Method,
+			Path:      activeRedactor.text(request.URL.RequestURI()),
+			Status:    recorder.status,
+			Bytes:     recorder.bytes,
+			Duration:  float64(time.Since(start).Microseconds()) / 1000,
+			UserAgent: request.UserAgent(),
+		}
+		if caller := principalFromRequest(request); caller != nil {
+			entry.User = caller.Name
+		}
+		if route.OperationID == "getDocument" || route.OperationID == "getSharedDocument" {
+			entry.Document = request.PathValue("name")
+			if consulted(request, recorder) {
+				countHit(srv.hits, entry.Document, start)
+			}
+		}
+		srv.access.record(entry)
+	}
+}
+
+// handleTopDocuments serves GET /api/top-documents.
+func (srv *corpusServer) handleTopDocuments() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		limit := 20
+		if text := request.URL.Query().Get("limit"); text != "" {
+			if _, err := fmt.Sscan(text, &limit); err != nil || limit < 0 {
+				writeJSONError(writer, http.StatusBadRequest, "limit must be a number of documents")
+				return
+			}
+		}
+		top, err := topDocuments(srv.hits, limit)
+		if err != nil {
+			writeJSONError(writer, http.StatusInternalServerError, "failed to read the hit counts")
+			log.Println(err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, top)
+	}
+}
+
+// runTopDocuments implements the top-documents command, the most consulted documents of the server.
+func runTopDocuments(args []string) error {
+	var limit int
+	var asJSON bool
+	cfg, _, err := loadConfig("top-documents", args, func(flagSet *flag.FlagSet) {
+		flagSet.IntVar(&limit, "limit", 20, "documents to list, 0 for all")
+		flagSet.BoolVar(&asJSON, "json", false, "print the report as JSON")
+	})
+	if err != nil {
+		return err
+	}
+	if cfg.AccessCounts == "" || !fileExists(cfg.AccessCounts) {
+		return fmt.Errorf("no hit counts at %q; the serve command records them in access_counts", cfg.AccessCounts)
+	}
+	counts, err := openKVStore(cfg.AccessCounts)
+	if err != nil {
+		return err
+	}
+	defer counts.close()
+	top, err := topDocuments(counts, limit)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(top)
+	}
+	for _, hits := range top {
+		fmt.Printf("%8d  %s  %s\n", hits.Hits, hits.LastAccessed.Format("2006-01-02"), hits.Document)
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions recorded in the audit log.
+const (
+	auditDownloaded = "downloaded" // Document fetched from upstream and stored
+	auditVerified   = "verified"   // Stored document checked against its expected content
+	auditEvicted    = "evicted"    // Stored document removed from the local store
+	auditServed     = "served"     // Stored document handed out from the local store
+	auditShared     = "shared"     // Signed link to a stored document handed out
+	auditImported   = "imported"   // Existing local file adopted into the store
+	auditRenamed    = "renamed"    // Stored document moved from the name in Source to a new naming
+	auditRotated    = "rotated"    // Log continued from the segment named in Document
+)
+
+// auditEntry is one line of the hash-chained audit log.
+type auditEntry struct {
+	Sequence int64     `json:"seq"`              // Position in the chain, starting at 1
+	Time     time.Time `json:"time"`             // When the action happened
+	Actor    string    `json:"actor"`            // user@host that performed the action
+	Action   string    `json:"action"`           // One of the audit* actions
+	Document string    `json:"document"`         // File name of the document
+	Source   string    `json:"source,omitempty"` // Upstream URL, client address, or the path an archived revision was evicted from
+	SHA256   string    `json:"sha256,omitempty"` // Hash of the document content
+	PrevHash string    `json:"prev_hash"`        // Hash of the previous entry, empty for the first
+	Hash     string    `json:"hash"`             // Hash of this entry with Hash left empty
+}
+
+// computeHash returns the chain hash of the entry.
+func (entry auditEntry) computeHash() string {
+	entry.Hash = ""
+	encoded, _ := json.Marshal(entry)
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+// auditLog appends entries to an append-only JSONL file, chaining each to the one before it. Each
+// append holds the lock file beside the log and links to the entry then at its end, so processes
+// writing the same log at once, such as a sync next to serve or import, keep a single chain.
+type auditLog struct {
+	mutex sync.Mutex
+	path  string
+	actor string
+}
+
+// auditLockPath returns the lock file taken by writers of a log.
+func auditLockPath(path string) string {
+	return path + ".lock"
+}
+
+// openAuditLog checks that the chain of an existing log can be continued, or starts a new one; an
+// empty path disables auditing.
+func openAuditLog(path string) (*auditLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if _, err := auditTail(path); err != nil {
+		return nil, err
+	}
+	return &auditLog{path: path, actor: currentActor()}, nil
+}
+
+// auditTail returns the last entry of a log, the zero entry when the log is empty or missing. It reads
+// backwards from the end, so appending stays cheap however long the log grows.
+func auditTail(path string) (auditEntry, error) {
+	var last auditEntry
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return last, nil
+	}
+	if err != nil {
+		return last, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return last, err
+	}
+	end := info.Size()
+	var tail []byte
+	for {
+		trimmed := bytes.TrimRight(tail, "\n")
+		if newline := bytes.LastIndexByte(trimmed, '\n'); newline >= 0 || end == 0 {
+			line := trimmed[newline+1:]
+			if len(line) == 0 {
+				return last, nil
+			}
+			if err := json.Unmarshal(line, &last); err != nil {
+				return last, fmt.Errorf("the last entry of audit log %s is not valid JSON: %v", path, err)
+			}
+			return last, nil
+		}
+		start := max(end-4096, 0)
+		chunk := make([]byte, end-start)
+		if _, err := file.ReadAt(chunk, start); err != nil {
+			return last, err
+		}
+		tail = append(chunk, tail...)
+		end = start
+	}
+}
+
+// currentActor returns user@host for the running process.
+func currentActor() string {
+	username := "unknown"
+	if current, err := user.Current(); err == nil {
+		username = current.Username
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return username + "@" + hostname
+}
+
+// record appends one entry to the log; a nil log records nothing.
+func (audit *auditLog) record(action, document, source, sha string) {
+	if audit == nil {
+		return
+	}
+	audit.mutex.Lock()
+	defer audit.mutex.Unlock()
+	err := withFileLock(auditLockPath(audit.path), func() error {
+		last, err := auditTail(audit.path)
+		if err != nil {
+			return err
+		}
+		return audit.appendEntry(last, action, document, source, sha)
+	})
+	if err != nil {
+		log.Println("failed to write audit log:", err)
+	}
+}
+
+// appendEntry appends an entry linked to last, the entry at the end of the log. Called with the lock file held.
+func (audit *auditLog) appendEntry(last auditEntry, action, document, source, sha string) error {
+	entry := auditEntry{
+		Sequence: last.Sequence + 1,
+		Time:     time.Now().UTC(),
+		Actor:    audit.actor,
+		Action:   action,
+		Document: document,
+		Source:   source,
+		SHA256:   sha,
+		PrevHash: last.Hash,
+	}
+	entry.Hash = entry.computeHash()
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	// Only ever append, never rewrite.
+	file, err := os.OpenFile(audit.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(append(encoded, '\n')); err != nil {
+		return err
+	}
+	// Make sure the entry reaches the disk before moving on.
+	return file.Sync()
+}
+
+// readAuditLog calls visit for every entry in the log, in order.
+func readAuditLog(path string, visit func(entry auditEntry) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line = line + 1
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("audit log line %d is not valid JSON: %v", line, err)
+		}
+		if err := visit(entry); err != nil {
+			return fmt.Errorf("audit log line %d: %v", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// readAuditHistory calls visit for every entry of the log and of the segments it was rotated out of,
+// oldest first. A segment that was moved away ends the history there.
+func readAuditHistory(path string, visit func(entry auditEntry) error) error {
+	first := true
+	return readAuditLog(path, func(entry auditEntry) error {
+		if first && entry.Action == auditRotated {
+			segment := filepath.Join(filepath.Dir(path), entry.Document)
+			if err := readAuditHistory(segment, visit); err != nil {
+				if !os.IsNotExist(err) {
+					return fmt.Errorf("segment %s: %v", entry.Document, err)
+				}
+				log.Printf("audit log segment %s is missing, leaving out the history before it", segment)
+			}
+		}
+		first = false
+		return visit(entry)
+	})
+}
+
+// rotateAuditLog moves the log aside as a segment named after the current time and starts the new log
+// with a rotated entry that links to the segment's last entry, so the chain runs on unbroken.
+// It returns the segment's path.
+func rotateAuditLog(path string, now time.Time) (string, error) {
+	audit := &auditLog{path: path, actor: currentActor()}
+	extension := filepath.Ext(path)
+	segment := strings.TrimSuffix(path, extension) + "-" + now.UTC().Format("20060102T150405Z") + extension
+	// Writers wait on the lock file meanwhile, and link their entries to the rotated one.
+	err := withFileLock(auditLockPath(path), func() error {
+		last, err := auditTail(path)
+		if err != nil {
+			return err
+		}
+		if fileExists(segment) {
+			return fmt.Errorf("audit log segment %s already exists", segment)
+		}
+		if err := os.Rename(path, segment); err != nil {
+			return err
+		}
+		return audit.appendEntry(last, auditRotated, filepath.Base(segment), "", "")
+	})
+	if err != nil {
+		return "", err
+	}
+	return segment, nil
+}
+
+// verifyAuditChain checks that every entry hashes correctly and links to the one before it.
+// A log that starts with a rotated entry is checked back through the segments it continues from.
+func verifyAuditChain(path string) (int64, error) {
+	var sequence int64
+	var lastHash string
+	first := true
+	err := readAuditLog(path, func(entry auditEntry) error {
+		if first && entry.Action == auditRotated {
+			previous, err := verifyAuditChain(filepath.Join(filepath.Dir(path), entry.Document))
+			if err != nil {
+				return fmt.Errorf("segment %s: %v", entry.Document, err)
+			}
+			sequence = previous
+			lastHash = entry.PrevHash
+			if last, err := lastAuditHash(filepath.Join(filepath.Dir(path), entry.Document)); err != nil || last != entry.PrevHash {
+				return fmt.Errorf("entry %d does not link to the end of segment %s", entry.Sequence, entry.Document)
+			}
+		}
+		first = false
+		if entry.Sequence != sequence+1 {
+			return fmt.Errorf("sequence %d follows %d", entry.Sequence, sequence)
+		}
+		if entry.PrevHash != lastHash {
+			return fmt.Errorf("entry %d does not link to the previous entry", entry.Sequence)
+		}
+		if entry.computeHash() != entry.Hash {
+			return fmt.Errorf("entry %d was modified after it was written", entry.Sequence)
+		}
+		sequence = entry.Sequence
+		lastHash = entry.Hash
+		return nil
+	})
+	return sequence, err
+}
+
+// lastAuditHash returns the hash of the last entry of a log.
+func lastAuditHash(path string) (string, error) {
+	var last string
+	err := readAuditLog(path, func(entry auditEntry) error {
+		last = entry.Hash
+		return nil
+	})
+	return last, err
+}
+
+// runVerifyAuditLog implements the verify-audit-log command.
+func runVerifyAuditLog(args []string) error {
+	cfg, _, err := loadConfig("verify-audit-log", args, nil)
+	if err != nil {
+		return err
+	}
+	entries, err := verifyAuditChain(cfg.AuditLog)
+	if err != nil {
+		return fmt.Errorf("audit log %s is broken: %v", cfg.AuditLog, err)
+	}
+	log.Printf("audit log %s is intact: %d entries", cfg.AuditLog, entries)
+	return nil
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Roles a caller can hold; admin implies reader.
+const (
+	roleReader = "reader" // May list and download documents
+	roleAdmin  = "admin"  // May also trigger syncs and other state changing endpoints
+)
+
+// APIKey grants a role to whoever presents the key in the X-API-Key header.
+type APIKey struct {
+	Name string `json:"name"` // Who the key was issued to, shown in logs and the audit trail
+	Key  string `json:"key"`  // The secret itself
+	Role string `json:"role"` // reader or admin
+}
+
+// principal is an authenticated caller.
+type principal struct {
+	Name string
+	Role string
+}
+
+// principalContextKey stores the principal in the request context.
+type principalContextKey struct{}
+
+// principalFromRequest returns the authenticated caller, or nil for anonymous requests.
+func principalFromRequest(request *http.Request) *principal {
+	caller, _ := request.Context().Value(principalContextKey{}).(*principal)
+	return caller
+}
+
+// roleAllows reports whether the held role satisfies the required one.
+func roleAllows(held, required string) bool {
+	switch required {
+	case "":
+		return true
+	case roleReader:
+		return held == roleReader || held == roleAdmin
+	default:
+		return held == required
+	}
+}
+
+// authenticator resolves API keys and OIDC bearer tokens to principals.
+type authenticator struct {
+	apiKeys        []APIKey
+	oidc           *oidcVerifier
+	adminClaim     string
+	adminValue     string
+	allowAnonymous bool
+}
+
+// newAuthenticator builds the authenticator from the config, refusing to run wide open unless asked to.
+func newAuthenticator(cfg *Config) (*authenticator, error) {
+	auth := &authenticator{
+		apiKeys:        cfg.APIKeys,
+		adminClaim:     cfg.OIDCRoleClaim,
+		adminValue:     cfg.OIDCAdminRole,
+		allowAnonymous: cfg.AllowAnonymous,
+	}
+	for _, key := range cfg.APIKeys {
+		if key.Key == "" || (key.Role != roleReader && key.Role != roleAdmin) {
+			return nil, fmt.Errorf("api key %q needs a key and a role of %q or %q", key.Name, roleReader, roleAdmin)
+		}
+	}
+	if cfg.OIDCIssuer != "" {
+		// Without an audience, a token the issuer minted for any other client would do.
+		if cfg.OIDCAudience == "" {
+			return nil, errors.New("oidc_issuer needs oidc_audience, the aud claim tokens for this server carry")
+		}
+		auth.oidc = newOIDCVerifier(cfg.OIDCIssuer, cfg.OIDCAudience)
+	}
+	if len(auth.apiKeys) == 0 && auth.oidc == nil && !auth.allowAnonymous {
+		return nil, errors.New("no api_keys or oidc_issuer configured; pass -allow-anonymous to serve without authentication")
+	}
+	return auth, nil
+}
+
+// authenticate returns the caller behind the request, nil when it carries no credentials.
+func (auth *authenticator) authenticate(request *http.Request) (*principal, error) {
+	// API keys travel in their own header.
+	if presented := request.Header.Get("X-API-Key"); presented != "" {
+		for _, key := range auth.apiKeys {
+			if subtle.ConstantTimeCompare([]byte(presented), []byte(key.Key)) == 1 {
+				return &principal{Name: key.Name, Role: key.Role}, nil
+			}
+		}
+		return nil, errors.New("unknown api key")
+	}
+	// OIDC access tokens travel as bearer tokens.
+	token, found := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		return nil, nil
+	}
+	if auth.oidc == nil {
+		return nil, errors.New("bearer tokens are not accepted")
+	}
+	claims, err := auth.oidc.verify(request.Context(), token)
+	if err != nil {
+		return nil, err
+	}
+	caller := &principal{Name: claimString(claims, "sub"), Role: roleReader}
+	if claimContains(claims, auth.adminClaim, auth.adminValue) {
+		caller.Role = roleAdmin
+	}
+	return caller, nil
+}
+
+// requireRole wraps a handler so only callers holding the role reach it.
+func (auth *authenticator) requireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		caller, err := auth.authenticate(request)
+		if err != nil {
+			writer.Header().Set("WWW-Authenticate", `Bearer realm="sabic-sds"`)
+			writeJSONError(writer, http.StatusUnauthorized, "invalid credentials")
+			return
+		}
+		// Anonymous callers never get more than read access.
+		if caller == nil && auth.allowAnonymous {
+			caller = &principal{Name: "anonymous", Role: roleReader}
+		}
+		if role != "" && caller == nil {
+			writer.Header().Set("WWW-Authenticate", `Bearer realm="sabic-sds"`)
+			writeJSONError(writer, http.StatusUnauthorized, "authentication required")
+			return
+		}
+		if caller != nil && !roleAllows(caller.Role, role) {
+			writeJSONError(writer, http.StatusForbidden, "role "+role+" required")
+			return
+		}
+		next(writer, request.WithContext(context.WithValue(request.Context(), principalContextKey{}, caller)))
+	}
+}
+
+// claimString returns a string claim, empty when missing.
+func claimString(claims map[string]any, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// claimContains reports whether a string or string array claim holds the value.
+func claimContains(claims map[string]any, name, value string) bool {
+	if name == "" || value == "" {
+		return false
+	}
+	switch claim := claims[name].(type) {
+	case string:
+		return claim == value
+	case []any:
+		for _, item := range claim {
+			if item == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// oidcVerifier checks JWT access tokens against the issuer's published signing keys.
+type oidcVerifier struct {
+	issuer     string
+	audience   string
+	client     *http.Client
+	mutex      sync.Mutex
+	keys       map[string]crypto.PublicKey // Signing keys by key ID
+	lastLoaded time.Time                   // When the key set was last fetched
+	loading    *keySetLoad                 // Fetch of the key set in progress, nil when none is
+}
+
+// keySetLoad is one fetch of the key set, which requests needing it wait for together.
+type keySetLoad struct {
+	done chan struct{} // Closed when the fetch is over
+	err  error
+}
+
+// newOIDCVerifier creates a verifier for the issuer; keys are fetched lazily.
+func newOIDCVerifier(issuer, audience string) *oidcVerifier {
+	return &oidcVerifier{
+		issuer:   strings.TrimSuffix(issuer, "/"),
+		audience: audience,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// jsonWebKey is one entry of a JWKS document.
+type jsonWebKey struct {
+	KeyID string `json:"kid"`
+	Type  string `json:"kty"`
+	N     string `json:"n"`
+	E     string `json:"e"`
+	Curve string `json:"crv"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
+}
+
+// getJSON fetches a URL and decodes its JSON body.
+func (verifier *oidcVerifier) getJSON(ctx context.Context, url string, value any) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := verifier.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s: %s", url, response.Status)
+	}
+	return json.NewDecoder(response.Body).Decode(value)
+}
+
+// fetchKeys fetches the issuer's JWKS through its discovery document.
+func (verifier *oidcVerifier) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	var discovery struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := verifier.getJSON(ctx, verifier.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	var keySet struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := verifier.getJSON(ctx, discovery.JWKSURI, &keySet); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, key := range keySet.Keys {
+		publicKey, err := key.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[key.KeyID] = publicKey
+	}
+	return keys, nil
+}
+
+// publicKey converts an RSA or P-256 JWK into a public key.
+func (key jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch key.Type {
+	case "RSA":
+		modulus, err := decode(key.N)
+		if err != nil {
+			return nil, err
+		}
+		exponent, err := decode(key.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(new(big.Int).SetBytes(exponent).Int64())}, nil
+	case "EC":
+		if key.Curve != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s", key.Curve)
+		}
+		x, err := decode(key.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(key.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", key.Type)
+}
+
+// keyFor returns the signing key with the given ID, refreshing the key set at most once a minute. The
+// key set is fetched outside the mutex, once for all the requests that need it, so a slow issuer
+// only holds up requests signed with a key not known yet.
+func (verifier *oidcVerifier) keyFor(ctx context.Context, keyID string) (crypto.PublicKey, error) {
+	verifier.mutex.Lock()
+	if key, ok := verifier.keys[keyID]; ok {
+		verifier.mutex.Unlock()
+		return key, nil
+	}
+	// Unknown key IDs usually mean the issuer rotated its keys.
+	load := verifier.loading
+	if load == nil && time.Since(verifier.lastLoaded) > time.Minute {
+		load = &keySetLoad{done: make(chan struct{})}
+		verifier.loading = load
+		verifier.mutex.Unlock()
+		// Requests waiting for the fetch shouldn't fail because this one went away.
+		keys, err := verifier.fetchKeys(context.WithoutCancel(ctx))
+		verifier.mutex.Lock()
+		if err == nil {
+			verifier.keys = keys
+			verifier.lastLoaded = time.Now()
+		}
+		load.err = err
+		verifier.loading = nil
+		close(load.done)
+	}
+	verifier.mutex.Unlock()
+	if load != nil {
+		select {
+		case <-load.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if load.err != nil {
+			return nil, load.err
+		}
+	}
+	verifier.mutex.Lock()
+	defer verifier.mutex.Unlock()
+	if key, ok := verifier.keys[keyID]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", keyID)
+}
+
+// verify checks the token's signature, issuer, audience and lifetime and returns its claims.
+func (verifier *oidcVerifier) verify(ctx context.Context, token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeTokenPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+	key, err := verifier.keyFor(ctx, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	// Check the signature over header.payload.
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch publicKey := key.(type) {
+	case *rsa.PublicKey:
+		if header.Algorithm != "RS256" || rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature) != nil {
+			return nil, errors.New("invalid token signature")
+		}
+	case *ecdsa.PublicKey:
+		if header.Algorithm != "ES256" || len(signature) != 64 {
+			return nil, errors.New("invalid token signature")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(publicKey, digest[:], r, s) {
+			return nil, errors.New("invalid token signature")
+		}
+	default:
+		return nil, errors.New("unsupported signing key")
+	}
+	// Check the claims.
+	var claims map[string]any
+	if err := decodeTokenPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	now := float64(time.Now().Unix())
+	if expiry, ok := claims["exp"].(float64); !ok || now >= expiry {
+		return nil, errors.New("token expired")
+	}
+	if notBefore, ok := claims["nbf"].(float64); ok && now < notBefore {
+		return nil, errors.New("token not yet valid")
+	}
+	if strings.TrimSuffix(claimString(claims, "iss"), "/") != verifier.issuer {
+		return nil, errors.New("token from another issuer")
+	}
+	if !claimContains(claims, "aud", verifier.audience) {
+		return nil, errors.New("token for another audience")
+	}
+	return claims, nil
+}
+
+// decodeTokenPart decodes one base64url JSON segment of a JWT.
+func decodeTokenPart(part string, value any) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errors.New("malformed token")
+	}
+	if err := json.Unmarshal(decoded, value); err != nil {
+		return errors.New("malformed token")
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+)
+
+// Ways of probing a document.
+const (
+	availabilityHead  = "head"  // HEAD, falling back to a one byte GET where HEAD isn't allowed
+	availabilityRange = "range" // A GET of the first byte only
+)
+
+// Outcomes of probing one document.
+const (
+	availabilityServed    = "served"    // 2xx, the sync would get it
+	availabilityNotFound  = "not_found" // 404 or 410
+	availabilityForbidden = "forbidden" // 401 or 403
+	availabilityOther     = "other"     // Any other status
+	availabilityError     = "error"     // No answer
+	availabilityUnchecked = "unchecked" // Not fetched over HTTP, e.g. by a plugin
+)
+
+// availabilityOutcomes are the outcomes in report order.
+var availabilityOutcomes = []string{availabilityServed, availabilityNotFound, availabilityForbidden, availabilityOther, availabilityError, availabilityUnchecked}
+
+// availabilityRow counts the outcomes of one language or region.
+type availabilityRow struct {
+	Key    string         `json:"key"`
+	Counts map[string]int `json:"counts"`
+}
+
+// availabilityReport is what check-availability found for the documents a sync would plan.
+type availabilityReport struct {
+	Method     string            `json:"method"`
+	Planned    int               `json:"planned"`
+	Counts     map[string]int    `json:"counts"`      // Outcomes over every planned document
+	ByLanguage []availabilityRow `json:"by_language"` // Outcomes by Laiso
+	ByRegion   []availabilityRow `json:"by_region"`   // Outcomes by the region of the Sbgvid
+}
+
+// availabilityTally collects probe outcomes from concurrent workers.
+type availabilityTally struct {
+	mutex      sync.Mutex
+	counts     map[string]int
+	byLanguage map[string]map[string]int
+	byRegion   map[string]map[string]int
+}
+
+// add counts the outcome of one document.
+func (tally *availabilityTally) add(doc documentRef, outcome string) {
+	language, region := strings.ToUpper(doc.id.Laiso), doc.id.region()
+	if language == "" {
+		language = "-"
+	}
+	if region == "" {
+		region = "-"
+	}
+	tally.mutex.Lock()
+	defer tally.mutex.Unlock()
+	tally.counts[outcome] = tally.counts[outcome] + 1
+	for _, group := range []struct {
+		rows map[string]map[string]int
+		key  string
+	}{{tally.byLanguage, language}, {tally.byRegion, region}} {
+		if group.rows[group.key] == nil {
+			group.rows[group.key] = make(map[string]int)
+		}
+		group.rows[group.key][outcome] = group.rows[group.key][outcome] + 1
+	}
+}
+
+// availabilityRows returns the rows of a grouping sorted by key.
+func availabilityRows(rows map[string]map[string]int) []availabilityRow {
+	sorted := make([]availabilityRow, 0, len(rows))
+	for key, counts := range rows {
+		sorted = append(sorted, availabilityRow{Key: key, Counts: counts})
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+	return sorted
+}
+
+// availabilityStatus maps a response status to an outcome.
+func availabilityStatus(status int) string {
+	switch {
+	case status >= 200 && status < 300:
+		return availabilityServed
+	case status == http.StatusNotFound || status == http.StatusGone:
+		return availabilityNotFound
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return availabilityForbidden
+	}
+	return availabilityOther
+}
+
+// probeAvailability asks upstream whether it serves a document without downloading it.
+func probeAvailability(ctx context.Context, cfg *Config, client *http.Client, method string, doc documentRef) string {
+	if !strings.HasPrefix(doc.url, "http://") && !strings.HasPrefix(doc.url, "https://") {
+		return availabilityUnchecked
+	}
+	release, err := upstreamHealth.acquire(ctx, doc.url)
+	if err != nil {
+		return availabilityError
+	}
+	defer release()
+	status, err := probeStatus(ctx, cfg, client, method, doc.url)
+	// Some gateways refuse HEAD on $value; a one byte GET tells as much.
+	if err == nil && method == availabilityHead && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		status, err = probeStatus(ctx, cfg, client, availabilityRange, doc.url)
+	}
+	if err != nil {
+		log.Printf("%s: %v", activeRedactor.text(doc.url), err)
+		return availabilityError
+	}
+	return availabilityStatus(status)
+}
+
+// probeStatus sends one probe and returns the response status.
+func probeStatus(ctx context.Context, cfg *Config, client *http.Client, method string, documentURL string) (int, error) {
+	httpMethod := http.MethodHead
+	if method == availabilityRange {
+		httpMethod = http.MethodGet
+	}
+	request, err := http.NewRequestWithContext(ctx, httpMethod, documentURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	if method == availabilityRange {
+		request.Header.Set("Range", "bytes=0-0")
+	}
+	resp, err := openDownload(ctx, cfg, client, request)
+	if err != nil {
+		return 0, err
+	}
+	// Servers that ignore Range send the whole document; don't wait for it.
+	io.CopyN(io.Discard, resp.Body, 512)
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// runCheckAvailability implements the check-availability command: it plans documents like a sync
+// would, then probes each one with a HEAD or a one byte GET and reports how many upstream serves,
+// with the 404s and 403s by language and region.
+func runCheckAvailability(args []string) error {
+	var method string
+	var asJSON bool
+	cfg, _, err := loadConfig("check-availability", args, func(flagSet *flag.FlagSet) {
+		flagSet.StringVar(&method, "method", availabilityHead, "head probes with HEAD, range with a GET of the first byte")
+		flagSet.BoolVar(&asJSON, "json", false, "print the report as JSON")
+	})
+	if err != nil {
+		return err
+	}
+	if method != availabilityHead && method != availabilityRange {
+		return fmt.Errorf("unknown probe method %q, expected %s or %s", method, availabilityHead, availabilityRange)
+	}
+	materials, err := loadMaterialMap(cfg.MaterialMap)
+	if err != nil {
+		return err
+	}
+	sources, err := openSources(cfg, materials)
+	if err != nil {
+		return err
+	}
+	defer closeSources(sources)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	queueSize := max(cfg.QueueSize, 1)
+	scraped := make(chan documentRef, queueSize)
+	planned := make(chan documentRef, queueSize)
+	listed := make(chan error, 2)
+	var stages sync.WaitGroup
+	stages.Add(2)
+	go func() {
+		defer stages.Done()
+		defer close(scraped)
+		for _, source := range sources {
+			if err := source.List(ctx, scraped, nil); err != nil {
+				listed <- fmt.Errorf("source %s: %w", source.Name(), err)
+				cancel()
+				return
+			}
+		}
+	}()
+	go func() {
+		defer stages.Done()
+		defer close(planned)
+		if err := planDocuments(ctx, cfg, materials, scraped, planned, nil); err != nil {
+			listed <- err
+			cancel()
+		}
+	}()
+	client := newDownloadClient(cfg)
+	tally := &availabilityTally{counts: make(map[string]int), byLanguage: make(map[string]map[string]int), byRegion: make(map[string]map[string]int)}
+	var workers sync.WaitGroup
+	for range max(cfg.Workers, 1) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for doc := range planned {
+				tally.add(doc, probeAvailability(ctx, cfg, client, method, doc))
+			}
+		}()
+	}
+	workers.Wait()
+	stages.Wait()
+	close(listed)
+	if err := <-listed; err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	report := availabilityReport{Method: method, Counts: tally.counts, ByLanguage: availabilityRows(tally.byLanguage), ByRegion: availabilityRows(tally.byRegion)}
+	for _, count := range tally.counts {
+		report.Planned = report.Planned + count
+	}
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	return printAvailability(report)
+}
+
+// printAvailability writes the report as two tables, by language and by region.
+func printAvailability(report availabilityReport) error {
+	table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, grouping := range []struct {
+		title string
+		rows  []availabilityRow
+	}{{"LANGUAGE", report.ByLanguage}, {"REGION", report.ByRegion}} {
+		fmt.Fprintf(table, "%s\t%s\n", grouping.title, strings.ToUpper(strings.Join(availabilityOutcomes, "\t")))
+		for _, row := range append(grouping.rows, availabilityRow{Key: "total", Counts: report.Counts}) {
+			fmt.Fprintf(table, "%s", row.Key)
+			for _, outcome := range availabilityOutcomes {
+				fmt.Fprintf(table, "\t%d", row.Counts[outcome])
+			}
+			fmt.Fprintln(table)
+		}
+		fmt.Fprintln(table)
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+	served := report.Counts[availabilityServed]
+	percent := 0.0
+	if report.Planned > 0 {
+		percent = 100 * float64(served) / float64(report.Planned)
+	}
+	log.Printf("upstream serves %d of %d planned documents (%.1f%%): %d not found, %d forbidden, %d other, %d unanswered",
+		served, report.Planned, percent, report.Counts[availabilityNotFound], report.Counts[availabilityForbidden],
+		report.Counts[availabilityOther], report.Counts[availabilityError])
+	return nil
+}
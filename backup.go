@@ -0,0 +1,261 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupFormat identifies the layout of backup archives.
+const backupFormat = "sabic-backup/1"
+
+// backupIndex is the first entry of a backup archive, describing the rest.
+type backupIndex struct {
+	Format    string            `json:"format"`
+	CreatedAt time.Time         `json:"created_at"`
+	Files     map[string]string `json:"files"` // Archive entry names by state role, see backupRoles
+}
+
+// backupRoles maps each piece of sync state to where this machine's config keeps it.
+// Archives store roles rather than paths, so a restore lands wherever the target is configured.
+func backupRoles(cfg *Config) map[string]string {
+	roles := map[string]string{
+		"catalog":         cfg.CatalogFile,
+		"catalog_history": catalogHistoryPath(cfg.CatalogFile),
+		"audit_log":       cfg.AuditLog,
+		"digest_state":    cfg.DigestState,
+		"metadata_cache":  cfg.MetadataCache,
+		"response_store":  cfg.ResponseStore,
+		"mirror_state":    cfg.MirrorState,
+		"ehs_state":       cfg.EHSState,
+	}
+	// Only the content-addressed layout keeps an index next to the documents.
+	if cfg.StorageLayout == layoutCAS {
+		roles["storage_index"] = filepath.Join(cfg.OutputDir, "index.txt")
+	}
+	return roles
+}
+
+// addFileToTar writes one file into the archive under the given name.
+func addFileToTar(archive *tar.Writer, name string, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{Name: name, Mode: 0o644, Size: info.Size(), ModTime: info.ModTime()}
+	if err := archive.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(archive, file)
+	return err
+}
+
+// writeBackup archives the catalog, manifests, audit log and the other state files into a gzipped tar.
+// The documents themselves are not included; a restored catalog lets sync or import pick them up again.
+func writeBackup(cfg *Config, archivePath string) (int, error) {
+	index := backupIndex{Format: backupFormat, CreatedAt: time.Now().UTC(), Files: make(map[string]string)}
+	sources := make(map[string]string) // Archive entry name to file path
+	for role, filePath := range backupRoles(cfg) {
+		if filePath == "" || !fileExists(filePath) {
+			continue
+		}
+		name := "state/" + role
+		index.Files[role] = name
+		sources[name] = filePath
+	}
+	if cfg.ManifestDir != "" {
+		entries, err := os.ReadDir(cfg.ManifestDir)
+		if err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".jsonl") {
+				continue
+			}
+			name := "manifests/" + entry.Name()
+			index.Files["manifest:"+entry.Name()] = name
+			sources[name] = filepath.Join(cfg.ManifestDir, entry.Name())
+		}
+	}
+	if len(sources) == 0 {
+		return 0, fmt.Errorf("no sync state found to back up")
+	}
+	// Write beside the destination and rename, so a failed backup never replaces a good one.
+	temp, err := os.CreateTemp(filepath.Dir(archivePath), "."+filepath.Base(archivePath)+".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(temp.Name())
+	compressed := gzip.NewWriter(temp)
+	archive := tar.NewWriter(compressed)
+	encodedIndex, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		temp.Close()
+		return 0, err
+	}
+	if err := archive.WriteHeader(&tar.Header{Name: "backup.json", Mode: 0o644, Size: int64(len(encodedIndex)), ModTime: index.CreatedAt}); err != nil {
+		temp.Close()
+		return 0, err
+	}
+	if _, err := archive.Write(encodedIndex); err != nil {
+		temp.Close()
+		return 0, err
+	}
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := addFileToTar(archive, name, sources[name]); err != nil {
+			temp.Close()
+			return 0, fmt.Errorf("failed to archive %s: %v", sources[name], err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		temp.Close()
+		return 0, err
+	}
+	if err := compressed.Close(); err != nil {
+		temp.Close()
+		return 0, err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return 0, err
+	}
+	if err := temp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Chmod(temp.Name(), 0o600); err != nil {
+		return 0, err
+	}
+	return len(names), os.Rename(temp.Name(), archivePath)
+}
+
+// restoreBackup writes the state files of an archive to the paths this machine's config names.
+// Existing files are only replaced when force is set.
+func restoreBackup(cfg *Config, archivePath string, force bool) (int, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	compressed, err := gzip.NewReader(file)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a backup archive: %v", archivePath, err)
+	}
+	archive := tar.NewReader(compressed)
+	header, err := archive.Next()
+	if err != nil || header.Name != "backup.json" {
+		return 0, fmt.Errorf("%s is not a backup archive", archivePath)
+	}
+	var index backupIndex
+	if err := json.NewDecoder(archive).Decode(&index); err != nil || index.Format != backupFormat {
+		return 0, fmt.Errorf("%s is not a %s archive", archivePath, backupFormat)
+	}
+	// Work out every destination before writing anything.
+	roles := backupRoles(cfg)
+	destinations := make(map[string]string) // Archive entry name to file path
+	for role, name := range index.Files {
+		var destination string
+		if manifestName, ok := strings.CutPrefix(role, "manifest:"); ok {
+			if cfg.ManifestDir == "" {
+				continue
+			}
+			destination = filepath.Join(cfg.ManifestDir, filepath.Base(manifestName))
+		} else {
+			destination = roles[role]
+		}
+		if destination == "" {
+			log.Printf("%s is not configured here, skipping it", role)
+			continue
+		}
+		if !force && fileExists(destination) {
+			return 0, fmt.Errorf("%s already exists, use -force to replace it", destination)
+		}
+		destinations[path.Clean(name)] = destination
+	}
+	var restored int
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return restored, err
+		}
+		destination, ok := destinations[path.Clean(header.Name)]
+		if !ok {
+			continue
+		}
+		content, err := io.ReadAll(archive)
+		if err != nil {
+			return restored, err
+		}
+		if err := os.MkdirAll(filepath.Dir(destination), 0o755); err != nil {
+			return restored, err
+		}
+		if err := writeFileAtomically(destination, content, 0o644); err != nil {
+			return restored, err
+		}
+		log.Printf("restored %s", destination)
+		restored = restored + 1
+	}
+	return restored, nil
+}
+
+// runBackup implements the backup command.
+func runBackup(args []string) error {
+	var archivePath string
+	cfg, _, err := loadConfig("backup", args, func(flagSet *flag.FlagSet) {
+		flagSet.StringVar(&archivePath, "o", "", "archive to write, sabic-backup-<time>.tar.gz when empty")
+	})
+	if err != nil {
+		return err
+	}
+	if archivePath == "" {
+		archivePath = "sabic-backup-" + time.Now().UTC().Format("20060102T150405Z") + ".tar.gz"
+	}
+	count, err := writeBackup(cfg, archivePath)
+	if err != nil {
+		return err
+	}
+	log.Printf("backed up %d files to %s", count, archivePath)
+	return nil
+}
+
+// runRestore implements the restore command.
+func runRestore(args []string) error {
+	var force bool
+	cfg, rest, err := loadConfig("restore", args, func(flagSet *flag.FlagSet) {
+		flagSet.BoolVar(&force, "force", false, "replace state files that already exist")
+	})
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return fmt.Errorf("usage: restore [flags] <archive>")
+	}
+	count, err := restoreBackup(cfg, rest[0], force)
+	if err != nil {
+		return err
+	}
+	log.Printf("restored %d files from %s", count, rest[0])
+	return nil
+}
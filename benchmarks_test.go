@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// benchLanguages are the languages every benchmark material is listed in.
+var benchLanguages = []string{"EN", "DE", "FR", "MS"}
+
+// benchHeaders returns the header results of materials documents, one per language of benchLanguages.
+func benchHeaders(materials int) []headerResult {
+	headers := make([]headerResult, 0, materials*len(benchLanguages))
+	for material := range materials {
+		for _, language := range benchLanguages {
+			headers = append(headers, headerResult{
+				MaterialNumber:  fmt.Sprintf("%08d", 22000000+material),
+				SubID:           fmt.Sprintf("63%010d", material),
+				StorageLocation: "SDS_" + language,
+				LanguageISO:     language,
+			})
+		}
+	}
+	return headers
+}
+
+// benchDocuments returns the documents of headers as the SABIC source lists them.
+func benchDocuments(b *testing.B, cfg *Config, headers []headerResult) []documentRef {
+	b.Helper()
+	docs := make([]documentRef, 0, len(headers))
+	for _, header := range headers {
+		doc := documentRef{source: "sabic", url: documentURL(cfg, header), properties: map[string]string{"Maktx": "LEXAN™ RESIN 141R"}}
+		id, err := documentIDFromURL(doc.url)
+		if err != nil {
+			b.Fatal(err)
+		}
+		doc.id = id
+		doc.filename = documentFilename(nil, id)
+		docs = append(docs, doc)
+	}
+	return docs
+}
+
+// quietLogs discards the log output of the benchmark, which logs a line per document.
+func quietLogs(b *testing.B) {
+	b.Helper()
+	output := log.Writer()
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(output) })
+}
+
+// runPlanner plans docs and returns how many documents it sent on.
+func runPlanner(b *testing.B, cfg *Config, docs []documentRef) int {
+	b.Helper()
+	in := make(chan documentRef, cfg.QueueSize)
+	out := make(chan documentRef, cfg.QueueSize)
+	go func() {
+		defer close(in)
+		for _, doc := range docs {
+			in <- doc
+		}
+	}()
+	errs := make(chan error, 1)
+	go func() {
+		defer close(out)
+		errs <- planDocuments(context.Background(), cfg, nil, in, out, nil)
+	}()
+	planned := 0
+	for range out {
+		planned = planned + 1
+	}
+	if err := <-errs; err != nil {
+		b.Fatal(err)
+	}
+	return planned
+}
+
+// BenchmarkDedupe measures the planner on a listing naming every document four times, under URLs
+// whose keys are quoted and ordered differently.
+func BenchmarkDedupe(b *testing.B) {
+	quietLogs(b)
+	cfg := defaultConfig()
+	unique := benchDocuments(b, cfg, benchHeaders(2500))
+	docs := make([]documentRef, 0, 4*len(unique))
+	for _, doc := range unique {
+		id := doc.id
+		for _, url := range []string{
+			doc.url,
+			fmt.Sprintf("%s/DocContentSet(Laiso='%s',Sbgvid='%s',Matnr='%s',Subid='%s')/$value", cfg.ServiceURL, id.Laiso, id.Sbgvid, id.Matnr, id.Subid),
+			fmt.Sprintf("%s/DocContentSet(Matnr=%%27%s%%27,Subid=%%27%s%%27,Sbgvid=%%27%s%%27,Laiso=%%27%s%%27)/$value", cfg.ServiceURL, id.Matnr, id.Subid, id.Sbgvid, id.Laiso),
+			doc.url + "?sap-client=100",
+		} {
+			// The source leaves the ID for the planner to read off the URL.
+			docs = append(docs, documentRef{source: doc.source, url: url, filename: doc.filename, properties: doc.properties})
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for b.Loop() {
+		if planned := runPlanner(b, cfg, docs); planned != len(unique) {
+			b.Fatalf("planned %d documents, want %d", planned, len(unique))
+		}
+	}
+	b.ReportMetric(float64(b.N*len(docs))/b.Elapsed().Seconds(), "docs/s")
+}
+
+// BenchmarkPlan measures the planner choosing one document per material along a language chain,
+// with a report type filter on.
+func BenchmarkPlan(b *testing.B) {
+	quietLogs(b)
+	cfg := defaultConfig()
+	cfg.LanguageFallback = "FR>DE>EN"
+	cfg.ReportTypes = "SDS"
+	materials := 2500
+	docs := benchDocuments(b, cfg, benchHeaders(materials))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for b.Loop() {
+		if planned := runPlanner(b, cfg, docs); planned != materials {
+			b.Fatalf("planned %d documents, want %d", planned, materials)
+		}
+	}
+	b.ReportMetric(float64(b.N*len(docs))/b.Elapsed().Seconds(), "docs/s")
+}
+
+// benchPDF returns the recorded PDF of the fixtures, the size of a typical safety data sheet.
+func benchPDF(b *testing.B) []byte {
+	b.Helper()
+	content, err := os.ReadFile(filepath.Join("testdata", "fixtures", "341c1eb39daa704b.body"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	if !strings.HasPrefix(string(content), "%PDF-") {
+		b.Fatal("the recorded fixture isn't a PDF any more")
+	}
+	return content
+}
+
+// BenchmarkHash measures the hash pool's work on one staged download.
+func BenchmarkHash(b *testing.B) {
+	for _, size := range []int{12 << 10, 1 << 20, 16 << 20} {
+		b.Run(fmt.Sprintf("%dKiB", size>>10), func(b *testing.B) {
+			path := filepath.Join(b.TempDir(), "staged.pdf")
+			if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(size))
+			b.ResetTimer()
+			for b.Loop() {
+				staged := &stagedDocument{url: "https://sds.example.com/staged", tempPath: path}
+				if err := hashStaged(staged); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkWrite measures the writer storing staged downloads: the issue date read, the move into
+// the output directory, the audit record and the catalog update.
+func BenchmarkWrite(b *testing.B) {
+	quietLogs(b)
+	dir := b.TempDir()
+	cfg := defaultConfig()
+	cfg.OutputDir = filepath.Join(dir, "out")
+	cfg.CatalogFile = filepath.Join(dir, "catalog.json")
+	cfg.AuditLog = filepath.Join(dir, "audit.jsonl")
+	if err := os.MkdirAll(cfg.OutputDir, 0o755); err != nil {
+		b.Fatal(err)
+	}
+	docs, err := openCatalog(cfg.CatalogFile)
+	if err != nil {
+		b.Fatal(err)
+	}
+	audit, err := openAuditLog(cfg.AuditLog)
+	if err != nil {
+		b.Fatal(err)
+	}
+	store, err := openDocumentStore(cfg)
+	if err != nil {
+		b.Fatal(err)
+	}
+	fetcher := &downloader{cfg: cfg, audit: audit, catalog: docs, store: store, throttle: &throttleGate{}}
+	content := benchPDF(b)
+	digest := sha256.Sum256(content)
+	headers := benchHeaders(b.N/len(benchLanguages) + 1)
+	written := benchDocuments(b, cfg, headers)
+	b.SetBytes(int64(len(content)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := range b.N {
+		b.StopTimer()
+		doc := written[i]
+		temp := filepath.Join(cfg.OutputDir, fmt.Sprintf(".%s.part-%d", doc.filename, i))
+		if err := os.WriteFile(temp, content, 0o644); err != nil {
+			b.Fatal(err)
+		}
+		staged := &stagedDocument{source: doc.source, id: doc.id, url: doc.url, filename: doc.filename, tempPath: temp,
+			sha256: hex.EncodeToString(digest[:]), size: int64(len(content)), properties: doc.properties}
+		b.StartTimer()
+		if err := fetcher.storePDF(staged); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// mockUpstream serves content for every document the benchmarks list.
+func mockUpstream(b *testing.B, content []byte) *httptest.Server {
+	b.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/$value") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+		w.Write(content)
+	}))
+	b.Cleanup(server.Close)
+	return server
+}
+
+// BenchmarkPipeline measures a whole sync run of 200 documents into an empty output directory,
+// downloading from a mock upstream over HTTP.
+func BenchmarkPipeline(b *testing.B) {
+	quietLogs(b)
+	content := benchPDF(b)
+	server := mockUpstream(b, content)
+	headers := benchHeaders(50)
+	listing, err := json.Marshal(map[string]any{"d": map[string]any{"__count": fmt.Sprint(len(headers)), "results": headers}})
+	if err != nil {
+		b.Fatal(err)
+	}
+	inputFile := filepath.Join(b.TempDir(), "headers.json")
+	if err := os.WriteFile(inputFile, listing, 0o644); err != nil {
+		b.Fatal(err)
+	}
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(int64(len(headers) * len(content)))
+			b.ReportAllocs()
+			for b.Loop() {
+				b.StopTimer()
+				dir := b.TempDir()
+				cfg := defaultConfig()
+				cfg.ServiceURL = server.URL + "/v1/SDS"
+				cfg.UseMetadata = false
+				cfg.InputFile = inputFile
+				cfg.Workers = workers
+				cfg.HashWorkers = workers
+				cfg.LanguageCheck = false
+				cfg.OutputDir = filepath.Join(dir, "out")
+				cfg.CatalogFile = filepath.Join(dir, "catalog.json")
+				cfg.AuditLog = filepath.Join(dir, "audit.jsonl")
+				cfg.ResponseStore = filepath.Join(dir, "responses.jsonl")
+				cfg.ManifestDir = filepath.Join(dir, "manifests")
+				cfg.SnapshotDir = filepath.Join(dir, "snapshots")
+				cfg.ScrapeState = filepath.Join(dir, "scrape-state.json")
+				cfg.MetadataCache = filepath.Join(dir, "metadata.xml")
+				b.StartTimer()
+				if err := runSync(context.Background(), cfg, nil); err != nil {
+					b.Fatal(err)
+				}
+				b.StopTimer()
+				docs, err := openCatalog(cfg.CatalogFile)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if stored := len(docs.all()); stored != len(headers) {
+					b.Fatalf("stored %d documents, want %d", stored, len(headers))
+				}
+				b.StartTimer()
+			}
+		})
+	}
+}
@@ -0,0 +1,509 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// browseItem is one document of the header snapshot as the browser lists it.
+type browseItem struct {
+	doc         documentRef
+	material    string
+	variant     string
+	language    string
+	description string
+	stored      bool
+}
+
+// Download states shown next to a selected document.
+const (
+	browseQueued      = "queued"
+	browseDownloading = "downloading"
+	browseDone        = "done"
+	browseFailed      = "failed"
+)
+
+// browseEvent reports a download state change to the screen.
+type browseEvent struct {
+	item  int
+	state string
+	err   error
+}
+
+// browser is the state of the browse command's screen.
+type browser struct {
+	items    []browseItem
+	visible  []int // Indexes into items that pass the filter
+	cursor   int   // Position in visible
+	offset   int   // First visible row on screen
+	selected map[int]bool
+	states   map[int]string // Download state by item, for the current batch
+	filter   string
+	editing  bool // Typing into the filter
+	busy     bool // A batch is downloading
+	status   string
+	width    int
+	height   int
+}
+
+// loadBrowseItems lists every document of the header snapshot, with its description and whether it is stored.
+func loadBrowseItems(ctx context.Context, cfg *Config, source Source, store documentStore) ([]browseItem, error) {
+	documents := make(chan documentRef)
+	listed := make(chan error, 1)
+	go func() {
+		listed <- source.List(ctx, documents, nil)
+		close(documents)
+	}()
+	var items []browseItem
+	for doc := range documents {
+		keys := doc.id
+		_, stored := store.path(doc.filename)
+		items = append(items, browseItem{doc: doc, material: keys.Matnr, variant: keys.Sbgvid, language: keys.Laiso, description: materialDescription(cfg, doc.properties), stored: stored})
+	}
+	if err := <-listed; err != nil {
+		return nil, err
+	}
+	collator, err := newCollator(cfg.Locale)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].description != items[j].description {
+			return lessByDescription(collator, items[i].description, items[j].description)
+		}
+		return items[i].doc.filename < items[j].doc.filename
+	})
+	return items, nil
+}
+
+// matches reports whether an item passes the filter: every word must occur in its description,
+// material or file name, and lang:XX words name the language exactly.
+func (item browseItem) matches(filter string) bool {
+	text := strings.ToLower(item.description + " " + item.material + " " + item.doc.filename)
+	for _, word := range strings.Fields(strings.ToLower(filter)) {
+		if language, ok := strings.CutPrefix(word, "lang:"); ok {
+			if !strings.EqualFold(item.language, language) {
+				return false
+			}
+			continue
+		}
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// applyFilter recomputes the visible items and keeps the cursor in range.
+func (screen *browser) applyFilter() {
+	screen.visible = screen.visible[:0]
+	for i, item := range screen.items {
+		if item.matches(screen.filter) {
+			screen.visible = append(screen.visible, i)
+		}
+	}
+	screen.cursor = min(screen.cursor, max(len(screen.visible)-1, 0))
+}
+
+// listRows is how many documents fit between the header and the footer.
+func (screen *browser) listRows() int {
+	return max(screen.height-4, 1)
+}
+
+// moveCursor moves the cursor by delta rows, scrolling the list along.
+func (screen *browser) moveCursor(delta int) {
+	screen.cursor = max(min(screen.cursor+delta, len(screen.visible)-1), 0)
+	if screen.cursor < screen.offset {
+		screen.offset = screen.cursor
+	}
+	if rows := screen.listRows(); screen.cursor >= screen.offset+rows {
+		screen.offset = screen.cursor - rows + 1
+	}
+}
+
+// render draws the whole screen. Raw mode needs \r\n line ends.
+func (screen *browser) render() []byte {
+	var out bytes.Buffer
+	out.WriteString("\x1b[H\x1b[2J")
+	header := fmt.Sprintf("%d documents, %d shown, %d selected", len(screen.items), len(screen.visible), len(screen.selected))
+	if screen.filter != "" || screen.editing {
+		header += "  filter: " + screen.filter
+		if screen.editing {
+			header += "_"
+		}
+	}
+	out.WriteString(clipLine(header, screen.width) + "\r\n\r\n")
+	rows := screen.listRows()
+	for row := screen.offset; row < len(screen.visible) && row < screen.offset+rows; row++ {
+		index := screen.visible[row]
+		item := screen.items[index]
+		mark := "[ ]"
+		if screen.selected[index] {
+			mark = "[x]"
+		}
+		stored := " "
+		if item.stored {
+			stored = "*"
+		}
+		state := screen.states[index]
+		line := fmt.Sprintf("%s %s %-3s %-8s %-12s %-11s %s", mark, stored, item.language, item.variant, item.material, state, item.description)
+		line = clipLine(line, screen.width)
+		if row == screen.cursor {
+			line = "\x1b[7m" + line + "\x1b[0m"
+		}
+		out.WriteString(line + "\r\n")
+	}
+	// Footer on the last two lines.
+	fmt.Fprintf(&out, "\x1b[%d;1H", screen.height-1)
+	out.WriteString(clipLine(screen.status, screen.width) + "\r\n")
+	help := "↑/↓ move  space select  a select shown  / filter  d download  q quit   * stored"
+	if screen.editing {
+		help = "type to filter, lang:XX for a language  enter apply  esc clear"
+	}
+	out.WriteString("\x1b[2m" + clipLine(help, screen.width) + "\x1b[0m")
+	return out.Bytes()
+}
+
+// clipLine cuts a line to the terminal width so it never wraps.
+func clipLine(line string, width int) string {
+	runes := []rune(line)
+	if width > 0 && len(runes) > width {
+		return string(runes[:width])
+	}
+	return line
+}
+
+// readKeys sends each key read from the terminal: printable characters as they are,
+// arrows and paging keys by name.
+func readKeys(input *os.File, keys chan<- string) {
+	buffer := make([]byte, 64)
+	for {
+		n, err := input.Read(buffer)
+		if err != nil {
+			close(keys)
+			return
+		}
+		for _, key := range splitKeys(buffer[:n]) {
+			keys <- key
+		}
+	}
+}
+
+// escapeKeys names the escape sequences the browser understands.
+var escapeKeys = map[string]string{
+	"\x1b[A": "up", "\x1b[B": "down", "\x1b[5~": "pgup", "\x1b[6~": "pgdn",
+	"\x1b[H": "home", "\x1b[F": "end", "\x1bOA": "up", "\x1bOB": "down",
+}
+
+// splitKeys splits one read from the terminal into keys.
+func splitKeys(input []byte) []string {
+	var keys []string
+	for len(input) > 0 {
+		if input[0] == 0x1b {
+			matched := false
+			for sequence, name := range escapeKeys {
+				if bytes.HasPrefix(input, []byte(sequence)) {
+					keys = append(keys, name)
+					input = input[len(sequence):]
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				keys = append(keys, "esc")
+				input = input[1:]
+			}
+			continue
+		}
+		switch input[0] {
+		case '\r', '\n':
+			keys = append(keys, "enter")
+		case 0x7f, 0x08:
+			keys = append(keys, "backspace")
+		case 0x03:
+			keys = append(keys, "ctrl-c")
+		default:
+			if input[0] >= 0x20 && input[0] < 0x7f {
+				keys = append(keys, string(input[0]))
+			}
+		}
+		input = input[1:]
+	}
+	return keys
+}
+
+// handleKey applies one key, reporting whether to download the selection or quit.
+func (screen *browser) handleKey(key string) (download bool, quit bool) {
+	if screen.editing {
+		switch key {
+		case "enter":
+			screen.editing = false
+		case "esc":
+			screen.editing, screen.filter = false, ""
+		case "backspace":
+			if runes := []rune(screen.filter); len(runes) > 0 {
+				screen.filter = string(runes[:len(runes)-1])
+			}
+		case "ctrl-c":
+			return false, true
+		default:
+			if len(key) == 1 {
+				screen.filter += key
+			}
+		}
+		screen.applyFilter()
+		screen.moveCursor(0)
+		return false, false
+	}
+	switch key {
+	case "up", "k":
+		screen.moveCursor(-1)
+	case "down", "j":
+		screen.moveCursor(1)
+	case "pgup":
+		screen.moveCursor(-screen.listRows())
+	case "pgdn":
+		screen.moveCursor(screen.listRows())
+	case "home", "g":
+		screen.moveCursor(-len(screen.visible))
+	case "end", "G":
+		screen.moveCursor(len(screen.visible))
+	case " ":
+		if len(screen.visible) > 0 {
+			index := screen.visible[screen.cursor]
+			if screen.selected[index] {
+				delete(screen.selected, index)
+			} else {
+				screen.selected[index] = true
+			}
+			screen.moveCursor(1)
+		}
+	case "a":
+		for _, index := range screen.visible {
+			screen.selected[index] = true
+		}
+	case "/":
+		screen.editing = true
+	case "d", "enter":
+		return len(screen.selected) > 0 && !screen.busy, false
+	case "q", "ctrl-c", "esc":
+		return false, true
+	}
+	return false, false
+}
+
+// lastLogLine keeps the newest log line for the status line, so logging doesn't scribble over the screen.
+type lastLogLine struct {
+	mutex sync.Mutex
+	line  string
+}
+
+// Write implements io.Writer.
+func (last *lastLogLine) Write(data []byte) (int, error) {
+	last.mutex.Lock()
+	defer last.mutex.Unlock()
+	if line := strings.TrimSpace(string(data)); line != "" {
+		last.line = line
+	}
+	return len(data), nil
+}
+
+// get returns the newest line.
+func (last *lastLogLine) get() string {
+	last.mutex.Lock()
+	defer last.mutex.Unlock()
+	return last.line
+}
+
+// downloadSelection fetches and stores the given items with cfg.Workers workers, reporting every
+// state change on events, and closes events when done.
+func downloadSelection(ctx context.Context, fetcher *downloader, items []browseItem, selection []int, events chan<- browseEvent) {
+	defer close(events)
+	lock, err := acquireRunLock(fetcher.cfg)
+	if err != nil {
+		for _, index := range selection {
+			events <- browseEvent{item: index, state: browseFailed, err: err}
+		}
+		return
+	}
+	defer lock.release()
+	jobs := make(chan int)
+	var workers sync.WaitGroup
+	for worker := range max(fetcher.cfg.Workers, 1) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for index := range jobs {
+				events <- browseEvent{item: index, state: browseDownloading}
+				stagingDir, err := workerStagingDir(fetcher.cfg, worker)
+				var staged *stagedDocument
+				if err == nil {
+					staged, err = fetcher.inflight.do(ctx, items[index].doc, func() (*stagedDocument, error) {
+						return fetcher.fetchPDF(ctx, items[index].doc, stagingDir)
+					})
+				}
+				if err == nil {
+					err = fetcher.storePDF(staged)
+				}
+				if err != nil && !errors.Is(err, ErrAlreadyExists) {
+					events <- browseEvent{item: index, state: browseFailed, err: err}
+					continue
+				}
+				events <- browseEvent{item: index, state: browseDone}
+			}
+		}()
+	}
+	for _, index := range selection {
+		select {
+		case jobs <- index:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	workers.Wait()
+	if err := fetcher.catalog.save(); err != nil {
+		log.Println("Failed to save catalog:", err)
+	}
+	if err := fetcher.store.flush(); err != nil {
+		log.Println("Failed to save storage index:", err)
+	}
+}
+
+// runBrowse implements the browse command, an interactive list of the header snapshot to pick
+// documents from and download them.
+func runBrowse(args []string) error {
+	cfg, _, err := loadConfig("browse", args, nil)
+	if err != nil {
+		return err
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return fmt.Errorf("browse needs a terminal; use list or fetch in scripts")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	materials, err := loadMaterialMap(cfg.MaterialMap)
+	if err != nil {
+		return err
+	}
+	source, err := newSABICSource(cfg, materials)
+	if err != nil {
+		return err
+	}
+	store, err := openDocumentStore(cfg)
+	if err != nil {
+		return err
+	}
+	docs, err := openCatalog(cfg.CatalogFile)
+	if err != nil {
+		return err
+	}
+	audit, err := openAuditLog(cfg.AuditLog)
+	if err != nil {
+		return err
+	}
+	responses, err := openResponseStore(cfg)
+	if err != nil {
+		return err
+	}
+	if responses != nil {
+		defer responses.close()
+	}
+	items, err := loadBrowseItems(ctx, cfg, source, store)
+	if err != nil {
+		return err
+	}
+	fetcher := &downloader{cfg: cfg, audit: audit, catalog: docs, materials: materials, store: store, throttle: &throttleGate{}, responses: responses, budget: newMemoryBudget(cfg.MemoryBudget), sources: map[string]Source{source.Name(): source}}
+	if cfg.CoalesceFetches {
+		fetcher.inflight = newFetchGroup()
+	}
+
+	// Take over the terminal; logs go to the status line until the browser quits.
+	state, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return err
+	}
+	defer term.Restore(int(os.Stdin.Fd()), state)
+	logLine := &lastLogLine{}
+	previousLog := log.Writer()
+	log.SetOutput(redactingWriter{redactor: activeRedactor, out: logLine})
+	defer log.SetOutput(previousLog)
+	os.Stdout.WriteString("\x1b[?1049h\x1b[?25l")
+	defer os.Stdout.WriteString("\x1b[?25h\x1b[?1049l")
+
+	screen := &browser{items: items, selected: make(map[int]bool), states: make(map[int]string)}
+	screen.applyFilter()
+	keys := make(chan string)
+	go readKeys(os.Stdin, keys)
+	var events chan browseEvent
+	var done, failed, total int
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		screen.width, screen.height, _ = term.GetSize(int(os.Stdout.Fd()))
+		if screen.busy {
+			screen.status = fmt.Sprintf("downloading %d of %d, %d failed · %s", done+failed, total, failed, logLine.get())
+		} else if line := logLine.get(); line != "" {
+			screen.status = line
+		}
+		os.Stdout.Write(screen.render())
+		select {
+		case <-ctx.Done():
+			return nil
+		case key, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			download, quit := screen.handleKey(key)
+			if quit {
+				return nil
+			}
+			if download {
+				selection := make([]int, 0, len(screen.selected))
+				for index := range screen.selected {
+					selection = append(selection, index)
+				}
+				sort.Ints(selection)
+				screen.states = make(map[int]string)
+				for _, index := range selection {
+					screen.states[index] = browseQueued
+				}
+				done, failed, total = 0, 0, len(selection)
+				screen.busy = true
+				events = make(chan browseEvent)
+				go downloadSelection(ctx, fetcher, screen.items, selection, events)
+			}
+		case event, ok := <-events:
+			if !ok {
+				// A nil channel blocks, so the loop only waits for keys until the next batch.
+				events = nil
+				screen.busy = false
+				screen.selected = make(map[int]bool)
+				screen.status = fmt.Sprintf("downloaded %d of %d, %d failed", done, total, failed)
+				logLine.Write([]byte(screen.status))
+				continue
+			}
+			screen.states[event.item] = event.state
+			switch event.state {
+			case browseDone:
+				done++
+				screen.items[event.item].stored = true
+			case browseFailed:
+				failed++
+				log.Printf("%s: %v", screen.items[event.item].doc.filename, event.err)
+			}
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// memoryBudget is a weighted semaphore over bytes in flight across all download workers.
+// Each download reserves its announced Content-Length, or a per-file cap when the length is unknown,
+// before it reads the body, so many workers streaming large files together can't exceed the budget.
+// Waiters are served in arrival order so a large document isn't starved by a stream of small ones.
+type memoryBudget struct {
+	mutex     sync.Mutex
+	total     int64
+	available int64
+	waiters   list.List // Of *budgetWaiter
+}
+
+// budgetWaiter is a reservation waiting for bytes to be released.
+type budgetWaiter struct {
+	bytes int64
+	ready chan struct{} // Closed once the bytes are reserved
+}
+
+// newMemoryBudget returns a budget of total bytes, nil when total is 0 or less.
+func newMemoryBudget(total int64) *memoryBudget {
+	if total <= 0 {
+		return nil
+	}
+	return &memoryBudget{total: total, available: total}
+}
+
+// weight returns the bytes to reserve for a download of the announced length, -1 when unknown.
+// A document larger than the whole budget takes all of it rather than waiting forever.
+func (budget *memoryBudget) weight(length int64, perFile int64) int64 {
+	if budget == nil {
+		return 0
+	}
+	if length < 0 {
+		length = perFile
+	}
+	return max(min(length, budget.total), 1)
+}
+
+// acquire reserves bytes, blocking until they are available or the run is cancelled.
+// A nil budget reserves nothing.
+func (budget *memoryBudget) acquire(ctx context.Context, bytes int64) error {
+	if budget == nil {
+		return nil
+	}
+	budget.mutex.Lock()
+	if budget.waiters.Len() == 0 && budget.available >= bytes {
+		budget.available = budget.available - bytes
+		budget.mutex.Unlock()
+		return nil
+	}
+	waiter := &budgetWaiter{bytes: bytes, ready: make(chan struct{})}
+	element := budget.waiters.PushBack(waiter)
+	budget.mutex.Unlock()
+	select {
+	case <-waiter.ready:
+		return nil
+	case <-ctx.Done():
+		budget.mutex.Lock()
+		defer budget.mutex.Unlock()
+		select {
+		case <-waiter.ready:
+			// Reserved just as the run was cancelled; hand the bytes back.
+			budget.available = budget.available + bytes
+		default:
+			budget.waiters.Remove(element)
+		}
+		budget.notify()
+		return ctx.Err()
+	}
+}
+
+// release returns bytes reserved by acquire.
+func (budget *memoryBudget) release(bytes int64) {
+	if budget == nil {
+		return
+	}
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	budget.available = budget.available + bytes
+	budget.notify()
+}
+
+// notify hands available bytes to waiters in order; the caller holds the mutex.
+func (budget *memoryBudget) notify() {
+	for {
+		front := budget.waiters.Front()
+		if front == nil {
+			return
+		}
+		waiter := front.Value.(*budgetWaiter)
+		if budget.available < waiter.bytes {
+			return
+		}
+		budget.available = budget.available - waiter.bytes
+		budget.waiters.Remove(front)
+		close(waiter.ready)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// pdfMerger concatenates PDFs into one, in the order given.
+type pdfMerger interface {
+	MergePDFs(ctx context.Context, inputPaths []string, outputPath string) error
+}
+
+// commandMerger merges documents with an external program such as qpdf or Ghostscript.
+// The {inputs} argument expands to one argument per input file.
+type commandMerger struct {
+	command []string
+}
+
+// MergePDFs runs the configured command once for all inputs.
+func (merger commandMerger) MergePDFs(ctx context.Context, inputPaths []string, outputPath string) error {
+	var args []string
+	for _, arg := range merger.command {
+		if arg == "{inputs}" {
+			args = append(args, inputPaths...)
+			continue
+		}
+		args = append(args, strings.ReplaceAll(arg, "{output}", outputPath))
+	}
+	output, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %v: %s", args[0], err, strings.TrimSpace(string(output)))
+	}
+	if !fileExists(outputPath) {
+		return fmt.Errorf("%s did not produce %s", args[0], outputPath)
+	}
+	return nil
+}
+
+// newPDFMerger returns the configured merger, or nil when none is configured.
+func newPDFMerger(cfg *Config) pdfMerger {
+	if len(cfg.BundleCommand) == 0 {
+		return nil
+	}
+	return commandMerger{command: cfg.BundleCommand}
+}
+
+// Layout of generated cover pages, in PDF points on A4.
+const (
+	coverPageWidth   = 595
+	coverPageHeight  = 842
+	coverMargin      = 56
+	coverLineHeight  = 14
+	coverFontSize    = 10
+	coverTitleSize   = 16
+	coverLinesOnPage = (coverPageHeight - 2*coverMargin - 2*coverLineHeight) / coverLineHeight
+)
+
+// pdfText makes a line safe for a PDF literal string in the standard Helvetica encoding:
+// parentheses and backslashes are escaped, and characters outside Latin-1 become '?'.
+func pdfText(line string) string {
+	var text strings.Builder
+	for _, r := range line {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			text.WriteByte('\\')
+			text.WriteRune(r)
+		case r < 0x20:
+			text.WriteByte(' ')
+		case r > 0xff:
+			text.WriteByte('?')
+		default:
+			text.WriteByte(byte(r))
+		}
+	}
+	return text.String()
+}
+
+// writeCoverPDF writes a plain text PDF with a title and lines, continued on further pages as needed.
+func writeCoverPDF(path string, title string, lines []string) error {
+	var pages [][]string
+	for len(lines) > coverLinesOnPage {
+		pages = append(pages, lines[:coverLinesOnPage])
+		lines = lines[coverLinesOnPage:]
+	}
+	pages = append(pages, lines)
+	contents := make([]string, 0, len(pages))
+	for index, page := range pages {
+		var content bytes.Buffer
+		y := coverPageHeight - coverMargin
+		if index == 0 {
+			fmt.Fprintf(&content, "BT /F1 %d Tf %d %d Td (%s) Tj ET\n", coverTitleSize, coverMargin, y-coverTitleSize, pdfText(title))
+		}
+		y = y - 2*coverLineHeight - coverTitleSize
+		for _, line := range page {
+			fmt.Fprintf(&content, "BT /F1 %d Tf %d %d Td (%s) Tj ET\n", coverFontSize, coverMargin, y, pdfText(line))
+			y = y - coverLineHeight
+		}
+		contents = append(contents, content.String())
+	}
+	return writeTextPDF(path, contents)
+}
+
+// writeTextPDF writes a PDF of A4 pages drawn by the given content streams, with Helvetica as /F1.
+// It needs no PDF library, which is all the cover and stamp pages call for.
+func writeTextPDF(path string, contents []string) error {
+	// Objects 1 to 3 are the catalog, the page tree and the font; each page adds itself and its content.
+	objects := []string{"", "", "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>"}
+	var kids []string
+	for _, content := range contents {
+		pageNumber := len(objects) + 1
+		kids = append(kids, fmt.Sprintf("%d 0 R", pageNumber))
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", coverPageWidth, coverPageHeight, pageNumber+1),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content))
+	}
+	objects[0] = "<< /Type /Catalog /Pages 2 0 R >>"
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids))
+	var document bytes.Buffer
+	document.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for index, object := range objects {
+		offsets[index] = document.Len()
+		fmt.Fprintf(&document, "%d 0 obj\n%s\nendobj\n", index+1, object)
+	}
+	xref := document.Len()
+	fmt.Fprintf(&document, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&document, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&document, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return writeFileAtomically(path, document.Bytes(), 0o644)
+}
+
+// bundleCover lists what a material's bundle holds, for the cover page.
+func bundleCover(cfg *Config, docs *catalog, material string, documents []documentInfo, generated time.Time) (string, []string) {
+	title := "Safety data sheets for material " + material
+	lines := []string{}
+	if documents[0].InternalCode != "" {
+		lines = append(lines, "Internal code: "+documents[0].InternalCode)
+	}
+	// Not every language version carries the description; the first one found stands for the material.
+	for _, document := range documents {
+		entry, _ := docs.get(document.Name)
+		if description := materialDescription(cfg, entry.Properties); description != "" {
+			lines = append(lines, "Description: "+description)
+			break
+		}
+	}
+	if family := documents[0].ProductFamily; family != "" {
+		lines = append(lines, "Product family: "+family)
+	}
+	lines = append(lines, "Generated: "+generated.Format(time.RFC3339), fmt.Sprintf("Documents: %d", len(documents)), "")
+	for index, document := range documents {
+		info := jurisdictionFor(cfg, document.Sbgvid)
+		lines = append(lines, fmt.Sprintf("%d. %s  %s  %s", index+1, document.Language, document.Sbgvid, info.Regulation))
+		detail := fmt.Sprintf("    %s, %d bytes, modified %s", document.Name, document.Size, document.Modified.UTC().Format("2006-01-02"))
+		if document.SHA256 != "" {
+			detail = detail + ", SHA-256 " + document.SHA256[:min(16, len(document.SHA256))]
+		}
+		lines = append(lines, detail)
+	}
+	return title, lines
+}
+
+// writeBundle merges the cover page and every language version of one material into dir/<material>.pdf.
+func writeBundle(ctx context.Context, cfg *Config, merger pdfMerger, store documentStore, docs *catalog, material string, documents []documentInfo, dir string) (string, error) {
+	outputPath, err := joinWithin(dir, safeNamePart(material)+".pdf")
+	if err != nil {
+		return "", err
+	}
+	// Build beside the bundle, so a failed merge never replaces a good one.
+	work, err := os.MkdirTemp(dir, ".bundle-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(work)
+	title, lines := bundleCover(cfg, docs, material, documents, time.Now().UTC())
+	coverPath := filepath.Join(work, "cover.pdf")
+	if err := writeCoverPDF(coverPath, title, lines); err != nil {
+		return "", fmt.Errorf("failed to write the cover page of %s: %v", material, err)
+	}
+	inputs := []string{coverPath}
+	for _, document := range documents {
+		pdfPath, release, err := plaintextPath(store, document.Name)
+		if err != nil {
+			return "", err
+		}
+		defer release()
+		inputs = append(inputs, pdfPath)
+	}
+	merged := filepath.Join(work, "bundle.pdf")
+	if err := merger.MergePDFs(ctx, inputs, merged); err != nil {
+		return "", fmt.Errorf("failed to merge the documents of %s: %v", material, err)
+	}
+	return outputPath, os.Rename(merged, outputPath)
+}
+
+// runBundle implements the bundle command, one merged PDF per material with all its language versions.
+func runBundle(args []string) error {
+	var filterText string
+	cfg, _, err := loadConfig("bundle", args, func(flagSet *flag.FlagSet) {
+		flagSet.StringVar(&filterText, "filter", "", "materials to bundle, as for publish; every material when empty")
+	})
+	if err != nil {
+		return err
+	}
+	merger := newPDFMerger(cfg)
+	if merger == nil {
+		return fmt.Errorf("bundle_command is not configured")
+	}
+	filter := publishFilter{}
+	if filterText != "" {
+		if filter, err = parsePublishFilter(filterText); err != nil {
+			return err
+		}
+	}
+	store, err := openDocumentStore(cfg)
+	if err != nil {
+		return err
+	}
+	documents, err := listDocuments(store)
+	if err != nil {
+		return err
+	}
+	docs, err := openCatalog(cfg.CatalogFile)
+	if err != nil {
+		return err
+	}
+	withProductFamilies(docs, documents)
+	withChecksums(docs, documents)
+	byMaterial := make(map[string][]documentInfo)
+	for _, document := range documents {
+		if filter.matches(cfg, document) {
+			byMaterial[document.Material] = append(byMaterial[document.Material], document)
+		}
+	}
+	if len(byMaterial) == 0 {
+		return fmt.Errorf("no documents to bundle")
+	}
+	if err := os.MkdirAll(cfg.BundleDir, 0o755); err != nil {
+		return err
+	}
+	var failed int
+	for _, material := range sortedKeys(byMaterial) {
+		materialDocuments := byMaterial[material]
+		// English first as the common reference, then by language and variant.
+		sort.Slice(materialDocuments, func(i, j int) bool {
+			a, b := materialDocuments[i], materialDocuments[j]
+			if (a.Language == "EN") != (b.Language == "EN") {
+				return a.Language == "EN"
+			}
+			if a.Language != b.Language {
+				return a.Language < b.Language
+			}
+			return a.Sbgvid < b.Sbgvid
+		})
+		outputPath, err := writeBundle(context.Background(), cfg, merger, store, docs, material, materialDocuments, cfg.BundleDir)
+		if err != nil {
+			log.Println(err)
+			failed = failed + 1
+			continue
+		}
+		log.Printf("bundled %d documents of %s into %s", len(materialDocuments), material, outputPath)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d bundles failed", failed, len(byMaterial))
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// catalogEntry is what is known about one stored document.
+type catalogEntry struct {
+	Filename         string     `json:"filename"`                    // File name in the output directory
+	Source           string     `json:"source,omitempty"`            // Name of the source it was pulled from
+	DocumentID       string     `json:"document_id,omitempty"`       // Canonical DocumentID, empty for documents not keyed like SABIC's
+	Format           string     `json:"format,omitempty"`            // Alternate format such as docx, empty for the PDF
+	SourceURL        string     `json:"source_url"`                  // URL it was downloaded from
+	InternalCode     string     `json:"internal_code,omitempty"`     // ERP material code from the material map
+	SHA256           string     `json:"sha256"`                      // Hash of the stored content
+	Size             int64      `json:"size"`                        // Size in bytes
+	DownloadedAt     time.Time  `json:"downloaded_at"`               // When the stored copy was fetched
+	PublishedAt      *time.Time `json:"published_at,omitempty"`      // When the stored copy was published upstream, from publication_fields (see slo.go)
+	PDFAStatus       string     `json:"pdfa_status,omitempty"`       // converted or failed, empty when never attempted
+	PDFAPath         string     `json:"pdfa_path,omitempty"`         // Where the PDF/A copy lives
+	PDFAError        string     `json:"pdfa_error,omitempty"`        // Why the last conversion failed
+	StampStatus      string     `json:"stamp_status,omitempty"`      // stamped or failed, empty when never attempted
+	StampPath        string     `json:"stamp_path,omitempty"`        // Where the stamped copy lives
+	StampError       string     `json:"stamp_error,omitempty"`       // Why the last stamping failed
+	IssueDate        string     `json:"issue_date,omitempty"`        // Issue or revision date of the sheet, YYYY-MM-DD
+	IssueDateFrom    string     `json:"issue_date_from,omitempty"`   // Where the issue date was found, header or pdf
+	RevalidatedAt    *time.Time `json:"revalidated_at,omitempty"`    // When an overdue document was last found unchanged upstream
+	ProductFamily    string     `json:"product_family,omitempty"`    // Product family from the header properties, see family.go
+	DetectedLanguage string     `json:"detected_language,omitempty"` // Language the text reads in, see language.go
+	LanguageMismatch bool       `json:"language_mismatch,omitempty"` // Whether that differs from the requested Laiso
+	Encrypted        bool       `json:"encrypted,omitempty"`         // Whether the stored copy is encrypted; copies stored before encryption was turned on are read as they are
+	// Header properties as listed upstream, including ones added after this tool was written.
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+// documentID returns the identity of the entry. Entries stored before it was recorded fall back to
+// the ID of their source URL.
+func (entry catalogEntry) documentID() DocumentID {
+	if id, err := parseDocumentID(entry.DocumentID); err == nil && !id.isZero() {
+		return id
+	}
+	return documentIDOrEmpty(entry.SourceURL)
+}
+
+// catalog is the on-disk index of stored documents, kept as one JSON file.
+type catalog struct {
+	mutex    sync.Mutex
+	path     string
+	entries  map[string]*catalogEntry // Entries by file name
+	dirty    bool                     // Whether there are unsaved changes
+	journal  *catalogJournal          // Journal of changes not saved yet, nil outside a sync
+	replayed bool                     // Whether opening it replayed a journal left behind
+	touched  map[string]string        // Digests of entries changed since the last save as they were before, "" for new ones
+}
+
+// catalogFile is the JSON layout of the catalog file.
+type catalogFile struct {
+	Documents []*catalogEntry `json:"documents"`
+}
+
+// openCatalog loads the catalog, starting an empty one when the file doesn't exist yet.
+func openCatalog(path string) (*catalog, error) {
+	docs := &catalog{path: path, entries: make(map[string]*catalogEntry)}
+	fileContent, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		var stored catalogFile
+		if err := json.Unmarshal(fileContent, &stored); err != nil {
+			return nil, err
+		}
+		for _, entry := range stored.Documents {
+			docs.entries[entry.Filename] = entry
+		}
+	}
+	// Pick up what a run that crashed before saving had journaled.
+	if err := docs.replayJournal(); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+// get returns a copy of the entry for a file name.
+func (docs *catalog) get(filename string) (catalogEntry, bool) {
+	docs.mutex.Lock()
+	defer docs.mutex.Unlock()
+	entry, ok := docs.entries[filename]
+	if !ok {
+		return catalogEntry{}, false
+	}
+	return *entry, true
+}
+
+// update changes the entry for a file name, creating it when needed.
+func (docs *catalog) update(filename string, change func(entry *catalogEntry)) {
+	docs.mutex.Lock()
+	defer docs.mutex.Unlock()
+	docs.touch(filename)
+	entry, ok := docs.entries[filename]
+	if !ok {
+		entry = &catalogEntry{Filename: filename}
+		docs.entries[filename] = entry
+	}
+	change(entry)
+	docs.dirty = true
+	docs.journalChange(filename)
+}
+
+// rename moves an entry to another file name.
+func (docs *catalog) rename(from, to string) {
+	docs.mutex.Lock()
+	defer docs.mutex.Unlock()
+	entry, ok := docs.entries[from]
+	if !ok {
+		return
+	}
+	docs.touch(from)
+	docs.touch(to)
+	delete(docs.entries, from)
+	entry.Filename = to
+	docs.entries[to] = entry
+	docs.dirty = true
+	docs.journalChange(to)
+}
+
+// reserve grows the index ahead of a run expected to list this many documents, avoiding rehashing while it fills.
+func (docs *catalog) reserve(expected int) {
+	docs.mutex.Lock()
+	defer docs.mutex.Unlock()
+	if expected <= len(docs.entries) {
+		return
+	}
+	entries := make(map[string]*catalogEntry, expected)
+	for filename, entry := range docs.entries {
+		entries[filename] = entry
+	}
+	docs.entries = entries
+}
+
+// all returns copies of every entry, sorted by file name.
+func (docs *catalog) all() []catalogEntry {
+	docs.mutex.Lock()
+	defer docs.mutex.Unlock()
+	entries := make([]catalogEntry, 0, len(docs.entries))
+	for _, entry := range docs.entries {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Filename < entries[j].Filename })
+	return entries
+}
+
+// save writes the catalog when it changed, replacing the old file atomically.
+func (docs *catalog) save() error {
+	docs.mutex.Lock()
+	defer docs.mutex.Unlock()
+	if !docs.dirty {
+		return nil
+	}
+	stored := catalogFile{Documents: make([]*catalogEntry, 0, len(docs.entries))}
+	for _, entry := range docs.entries {
+		stored.Documents = append(stored.Documents, entry)
+	}
+	sort.Slice(stored.Documents, func(i, j int) bool { return stored.Documents[i].Filename < stored.Documents[j].Filename })
+	encoded, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	// The history goes first; should the catalog write fail, the next save records the same changes again.
+	if err := docs.recordHistory(); err != nil {
+		return err
+	}
+	if err := writeFileAtomically(docs.path, encoded, 0o644); err != nil {
+		return err
+	}
+	docs.dirty = false
+	return docs.truncateJournal()
+}
+
+// writeFileAtomically writes data to a temporary file next to path and renames it into place.
+func writeFileAtomically(path string, data []byte, permission os.FileMode) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	// Clean up the temporary file on every failure path.
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(temp.Name(), permission); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+// documentSet is an in-memory set of stored file names, so a run can skip known documents without a stat per file.
+type documentSet struct {
+	mutex     sync.Mutex
+	store     documentStore
+	names     map[string]bool
+	uncertain map[string]int64 // Documents the snapshot couldn't settle, with the size the catalog expects or -1
+}
+
+// loadDocumentSet plans skips for the documents the catalog records as complete and the store lists,
+// against one snapshot of the output directory. One walk is far cheaper than a stat per document on
+// network filesystems; a document is only stat-ed when the snapshot can't tell, because its file is
+// missing from it, changed during the walk, or differs in size from what the catalog recorded.
+func loadDocumentSet(store documentStore, docs *catalog, dir string) (*documentSet, error) {
+	set := &documentSet{store: store, names: make(map[string]bool), uncertain: make(map[string]int64)}
+	// Walk the root the store joins its paths to, so they match as map keys.
+	root, err := storageRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := takeDirSnapshot(root)
+	if err != nil {
+		return nil, err
+	}
+	expected := make(map[string]int64)
+	for _, entry := range docs.all() {
+		if entry.SHA256 != "" {
+			expected[entry.Filename] = entry.Size
+		}
+	}
+	names, err := store.names()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, name := range names {
+		if _, ok := expected[name]; !ok {
+			expected[name] = -1
+		}
+	}
+	// Encrypted files are larger than the documents the catalog measured.
+	_, encrypted := store.(*encryptedStore)
+	for name, size := range expected {
+		path, ok := store.locate(name)
+		if !ok {
+			continue
+		}
+		file, ok := snapshot.lookup(path)
+		switch {
+		case !ok:
+			set.uncertain[name] = size
+		case size >= 0 && !encrypted && file.size != size:
+			set.uncertain[name] = size
+		default:
+			set.names[name] = true
+		}
+	}
+	log.Printf("planned skips against a snapshot of %d files, %d documents left to check", len(snapshot.files), len(set.uncertain))
+	return set, nil
+}
+
+// contains reports whether the file name is known to be stored, checking the file once when the
+// snapshot left it uncertain.
+func (set *documentSet) contains(filename string) bool {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	if set.names[filename] {
+		return true
+	}
+	size, uncertain := set.uncertain[filename]
+	if !uncertain {
+		return false
+	}
+	delete(set.uncertain, filename)
+	path, ok := set.store.path(filename)
+	if !ok {
+		return false
+	}
+	if _, encrypted := set.store.(*encryptedStore); size >= 0 && !encrypted {
+		info, err := os.Stat(path)
+		if err != nil || info.Size() != size {
+			// Cut short, e.g. by a crash while it was moved into place: fetch it again.
+			return false
+		}
+	}
+	set.names[filename] = true
+	return true
+}
+
+// add marks a file name as stored.
+func (set *documentSet) add(filename string) {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	set.names[filename] = true
+}
+
+// size returns the number of known file names, counting the uncertain ones.
+func (set *documentSet) size() int {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	return len(set.names) + len(set.uncertain)
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Kinds of catalog history events.
+const (
+	historyAdded   = "added"   // Entry appeared in the catalog
+	historyChanged = "changed" // Entry changed, e.g. a new revision was downloaded
+	historyRemoved = "removed" // Entry left the catalog
+)
+
+// catalogEvent is one line of the catalog history, an append-only JSONL log of every change a save
+// wrote to the catalog. Replaying it up to a point in time gives the catalog as it stood then.
+type catalogEvent struct {
+	Time     time.Time     `json:"time"`
+	Event    string        `json:"event"` // One of the history* kinds
+	Filename string        `json:"filename"`
+	Entry    *catalogEntry `json:"entry,omitempty"` // The entry after the change, nil when removed
+}
+
+// catalogHistoryPath returns the history of a catalog file.
+func catalogHistoryPath(path string) string {
+	return path + ".history"
+}
+
+// entryDigest identifies the content of an entry, to tell changed entries from ones only touched.
+func entryDigest(entry *catalogEntry) string {
+	encoded, _ := json.Marshal(entry)
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:8])
+}
+
+// touch remembers how an entry was before its first change since the last save. Called with the mutex held.
+func (docs *catalog) touch(filename string) {
+	if docs.touched == nil {
+		docs.touched = make(map[string]string)
+	}
+	if _, ok := docs.touched[filename]; ok {
+		return
+	}
+	digest := ""
+	if entry, ok := docs.entries[filename]; ok {
+		digest = entryDigest(entry)
+	}
+	docs.touched[filename] = digest
+}
+
+// recordHistory appends the changes since the last save to the history and syncs it. A catalog without
+// a history yet starts one with every entry added when it was downloaded, the best date known for
+// documents cataloged before the history existed. Called with the mutex held.
+func (docs *catalog) recordHistory() error {
+	path := catalogHistoryPath(docs.path)
+	now := time.Now().UTC()
+	var events []catalogEvent
+	if !fileExists(path) {
+		for _, entry := range docs.entries {
+			added := entry.DownloadedAt
+			if added.IsZero() {
+				added = now
+			}
+			events = append(events, catalogEvent{Time: added.UTC(), Event: historyAdded, Filename: entry.Filename, Entry: entry})
+		}
+		sort.Slice(events, func(i, j int) bool {
+			if !events[i].Time.Equal(events[j].Time) {
+				return events[i].Time.Before(events[j].Time)
+			}
+			return events[i].Filename < events[j].Filename
+		})
+	} else {
+		for _, filename := range sortedKeys(docs.touched) {
+			before := docs.touched[filename]
+			entry, exists := docs.entries[filename]
+			switch {
+			case !exists && before != "":
+				events = append(events, catalogEvent{Time: now, Event: historyRemoved, Filename: filename})
+			case exists && before == "":
+				events = append(events, catalogEvent{Time: now, Event: historyAdded, Filename: filename, Entry: entry})
+			case exists && entryDigest(entry) != before:
+				events = append(events, catalogEvent{Time: now, Event: historyChanged, Filename: filename, Entry: entry})
+			}
+		}
+	}
+	if len(events) > 0 {
+		var batch []byte
+		for _, event := range events {
+			line, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			batch = append(append(batch, line...), '\n')
+		}
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrStorage, err)
+		}
+		if _, err := file.Write(batch); err != nil {
+			file.Close()
+			return fmt.Errorf("%w: %v", ErrStorage, err)
+		}
+		if err := file.Sync(); err != nil {
+			file.Close()
+			return fmt.Errorf("%w: %v", ErrStorage, err)
+		}
+		if err := file.Close(); err != nil {
+			return fmt.Errorf("%w: %v", ErrStorage, err)
+		}
+	}
+	clear(docs.touched)
+	return nil
+}
+
+// readCatalogHistory calls visit with every event of the history of a catalog file, oldest first.
+// Unreadable events, such as a torn last line, are skipped.
+func readCatalogHistory(path string, visit func(event catalogEvent)) error {
+	file, err := os.Open(catalogHistoryPath(path))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s has no history yet; it starts with the next change to the catalog", path)
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var event catalogEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || event.Filename == "" {
+			log.Printf("%s: skipping unreadable event %d", file.Name(), line)
+			continue
+		}
+		visit(event)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %v", file.Name(), err)
+	}
+	return nil
+}
+
+// catalogAt replays the history of a catalog file up to a point in time and returns the entries the
+// catalog held then, sorted by file name.
+func catalogAt(path string, at time.Time) ([]catalogEntry, error) {
+	entries := make(map[string]*catalogEntry)
+	err := readCatalogHistory(path, func(event catalogEvent) {
+		if event.Time.After(at) {
+			return
+		}
+		if event.Event == historyRemoved || event.Entry == nil {
+			delete(entries, event.Filename)
+		} else {
+			entries[event.Filename] = event.Entry
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	sorted := make([]catalogEntry, 0, len(entries))
+	for _, filename := range sortedKeys(entries) {
+		sorted = append(sorted, *entries[filename])
+	}
+	return sorted, nil
+}
+
+// parseHistoryTime reads the point in time of a catalog at query. A date stands for the end of that
+// day in UTC, so "at 2024-01-01" includes everything cataloged on New Year's Day.
+func parseHistoryTime(text string) (time.Time, error) {
+	if day, err := time.Parse(time.DateOnly, text); err == nil {
+		return day.Add(24*time.Hour - time.Nanosecond), nil
+	}
+	if at, err := time.Parse(time.RFC3339, text); err == nil {
+		return at, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected a date such as 2024-01-01 or an RFC 3339 time", text)
+}
+
+// coverageRow counts the documents of one region and language.
+type coverageRow struct {
+	Region    string `json:"region"`
+	Language  string `json:"language"`
+	Documents int    `json:"documents"`
+	Materials int    `json:"materials"` // Distinct material numbers
+}
+
+// coverageReport is what the catalog covered at a point in time.
+type coverageReport struct {
+	At        time.Time     `json:"at"`
+	Documents int           `json:"documents"`
+	Materials int           `json:"materials"`
+	Rows      []coverageRow `json:"rows"`
+}
+
+// catalogCoverage counts the documents and materials of entries by region and language.
+func catalogCoverage(entries []catalogEntry, at time.Time) coverageReport {
+	report := coverageReport{At: at, Rows: []coverageRow{}}
+	materials := make(map[string]bool)
+	groups := make(map[[2]string]map[string]int)
+	for _, entry := range entries {
+		id := entry.documentID()
+		group := [2]string{id.region(), strings.ToUpper(id.Laiso)}
+		for i := range group {
+			if group[i] == "" {
+				group[i] = "-"
+			}
+		}
+		if groups[group] == nil {
+			groups[group] = make(map[string]int)
+		}
+		groups[group][id.Matnr] = groups[group][id.Matnr] + 1
+		materials[id.Matnr] = true
+		report.Documents = report.Documents + 1
+	}
+	report.Materials = len(materials)
+	for group, counts := range groups {
+		row := coverageRow{Region: group[0], Language: group[1], Materials: len(counts)}
+		for _, count := range counts {
+			row.Documents = row.Documents + count
+		}
+		report.Rows = append(report.Rows, row)
+	}
+	sort.Slice(report.Rows, func(i, j int) bool {
+		if report.Rows[i].Region != report.Rows[j].Region {
+			return report.Rows[i].Region < report.Rows[j].Region
+		}
+		return report.Rows[i].Language < report.Rows[j].Language
+	})
+	return report
+}
+
+// runCatalogCommand implements the catalog command. catalog at <time> rebuilds the catalog as it
+// stood at a date or time from its history and reports its coverage by region and language, or
+// exports it in the format of the catalog file for retroactive audits.
+func runCatalogCommand(args []string) error {
+	if len(args) == 0 || args[0] != "at" {
+		return fmt.Errorf("usage: catalog at [flags] <date or time>")
+	}
+	var asJSON bool
+	var export string
+	cfg, rest, err := loadConfig("catalog at", args[1:], func(flagSet *flag.FlagSet) {
+		flagSet.BoolVar(&asJSON, "json", false, "print the coverage as JSON")
+		flagSet.StringVar(&export, "export", "", "write the catalog as it stood to this file, in the format of the catalog file")
+	})
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return fmt.Errorf("usage: catalog at [flags] <date or time>")
+	}
+	at, err := parseHistoryTime(rest[0])
+	if err != nil {
+		return err
+	}
+	all, err := catalogAt(cfg.CatalogFile, at)
+	if err != nil {
+		return err
+	}
+	// -report-types narrows the answer, e.g. to SDS coverage only.
+	var entries []catalogEntry
+	for _, entry := range all {
+		if matchesReportType(cfg.ReportTypes, entry.documentID().reportType()) {
+			entries = append(entries, entry)
+		}
+	}
+	if export != "" {
+		stored := catalogFile{Documents: make([]*catalogEntry, len(entries))}
+		for i := range entries {
+			stored.Documents[i] = &entries[i]
+		}
+		encoded, err := json.MarshalIndent(stored, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomically(export, encoded, 0o644); err != nil {
+			return err
+		}
+		log.Printf("exported the %d documents cataloged at %s to %s", len(entries), at.Format(time.RFC3339), export)
+	}
+	report := catalogCoverage(entries, at)
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "REGION\tLANGUAGE\tDOCUMENTS\tMATERIALS")
+	for _, row := range report.Rows {
+		fmt.Fprintf(table, "%s\t%s\t%d\t%d\n", row.Region, row.Language, row.Documents, row.Materials)
+	}
+	fmt.Fprintf(table, "total\t\t%d\t%d\n", report.Documents, report.Materials)
+	return table.Flush()
+}
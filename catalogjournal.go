@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// catalogJournal makes catalog changes of a long run survive a crash without rewriting the whole
+// catalog per document: changed entries are appended to <catalog_file>.journal in batches, each one
+// synced to disk, and the next open replays them. Saving the catalog empties the journal.
+type catalogJournal struct {
+	file      *os.File
+	batchSize int
+	pending   map[string]bool // Changed since the last flush
+	done      chan struct{}   // Closed to stop the interval flushes
+}
+
+// catalogJournalPath returns the journal of a catalog file.
+func catalogJournalPath(path string) string {
+	return path + ".journal"
+}
+
+// replayJournal applies the entries a crashed run journaled but never saved. A torn last line is dropped.
+func (docs *catalog) replayJournal() error {
+	file, err := os.Open(catalogJournalPath(docs.path))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	replayed := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	for scanner.Scan() {
+		var entry catalogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.Filename == "" {
+			log.Printf("%s: skipping unreadable record %d", file.Name(), replayed+1)
+			continue
+		}
+		docs.touch(entry.Filename)
+		docs.entries[entry.Filename] = &entry
+		replayed = replayed + 1
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %v", file.Name(), err)
+	}
+	docs.replayed = true
+	if replayed > 0 {
+		log.Printf("recovered %d catalog entries from %s", replayed, file.Name())
+		docs.dirty = true
+	}
+	return nil
+}
+
+// startJournal journals changes from now on, flushing every batchSize changed entries and every
+// interval. A batch size below 1 leaves the journal off, so changes only reach disk on save.
+func (docs *catalog) startJournal(batchSize int, interval time.Duration) error {
+	if batchSize < 1 {
+		return nil
+	}
+	file, err := os.OpenFile(catalogJournalPath(docs.path), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrStorage, err)
+	}
+	docs.mutex.Lock()
+	docs.journal = &catalogJournal{file: file, batchSize: batchSize, pending: make(map[string]bool), done: make(chan struct{})}
+	done := docs.journal.done
+	docs.mutex.Unlock()
+	if interval > 0 {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					docs.mutex.Lock()
+					err := docs.flushJournal()
+					docs.mutex.Unlock()
+					if err != nil {
+						log.Println("Failed to journal catalog changes:", err)
+					}
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+// journalChange notes a changed entry, flushing once a batch is full. Called with the mutex held.
+func (docs *catalog) journalChange(filename string) {
+	if docs.journal == nil {
+		return
+	}
+	docs.journal.pending[filename] = true
+	if len(docs.journal.pending) >= docs.journal.batchSize {
+		if err := docs.flushJournal(); err != nil {
+			log.Println("Failed to journal catalog changes:", err)
+		}
+	}
+}
+
+// flushJournal appends the pending entries in one write and syncs it. Called with the mutex held.
+func (docs *catalog) flushJournal() error {
+	if docs.journal == nil || len(docs.journal.pending) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(docs.journal.pending))
+	for filename := range docs.journal.pending {
+		names = append(names, filename)
+	}
+	sort.Strings(names)
+	var batch []byte
+	for _, filename := range names {
+		entry, ok := docs.entries[filename]
+		if !ok {
+			continue
+		}
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		batch = append(append(batch, line...), '\n')
+	}
+	if _, err := docs.journal.file.Write(batch); err != nil {
+		return fmt.Errorf("%w: %v", ErrStorage, err)
+	}
+	if err := docs.journal.file.Sync(); err != nil {
+		return fmt.Errorf("%w: %v", ErrStorage, err)
+	}
+	clear(docs.journal.pending)
+	return nil
+}
+
+// truncateJournal empties the journal once the catalog file holds everything in it. Called with the mutex held.
+// A journal this catalog didn't open is only removed when opening replayed it and the run lock is held,
+// since otherwise it may be the live journal of a sync whose changes the save doesn't have.
+func (docs *catalog) truncateJournal() error {
+	if docs.journal != nil {
+		clear(docs.journal.pending)
+		return docs.journal.file.Truncate(0)
+	}
+	if !docs.replayed || heldRunLocks.Load() == 0 {
+		return nil
+	}
+	if err := os.Remove(catalogJournalPath(docs.path)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	docs.replayed = false
+	return nil
+}
+
+// close saves the catalog and stops journaling.
+func (docs *catalog) close() error {
+	err := docs.save()
+	docs.mutex.Lock()
+	defer docs.mutex.Unlock()
+	if docs.journal == nil {
+		return err
+	}
+	close(docs.journal.done)
+	if err != nil {
+		// Keep what the journal has, so the next run recovers it.
+		docs.flushJournal()
+		docs.journal.file.Close()
+	} else {
+		docs.journal.file.Close()
+		os.Remove(docs.journal.file.Name())
+	}
+	docs.journal = nil
+	return err
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Environments. Failure injection only runs outside production.
+const (
+	environmentProduction = "production"
+	environmentStaging    = "staging"
+	environmentTest       = "test"
+)
+
+// chaosFlags are the failure injection flags; they work but stay out of -h and completion, as they
+// exist to exercise retries and the circuit breaker in staging, not for everyday runs.
+var chaosFlags = map[string]bool{
+	"chaos-failure-rate":      true,
+	"chaos-delay-rate":        true,
+	"chaos-max-delay":         true,
+	"chaos-truncate-rate":     true,
+	"chaos-content-type-rate": true,
+	"chaos-seed":              true,
+}
+
+// registerChaosFlags registers the hidden failure injection flags.
+func registerChaosFlags(flagSet *flag.FlagSet, cfg *Config) {
+	flagSet.StringVar(&cfg.Environment, "environment", cfg.Environment, "production, staging or test; failure injection only runs outside production")
+	flagSet.Float64Var(&cfg.ChaosFailureRate, "chaos-failure-rate", cfg.ChaosFailureRate, "fraction of upstream requests failed with a reset connection or a 503")
+	flagSet.Float64Var(&cfg.ChaosDelayRate, "chaos-delay-rate", cfg.ChaosDelayRate, "fraction of upstream requests held up before they are sent")
+	flagSet.Var(&cfg.ChaosMaxDelay, "chaos-max-delay", "longest injected delay")
+	flagSet.Float64Var(&cfg.ChaosTruncateRate, "chaos-truncate-rate", cfg.ChaosTruncateRate, "fraction of response bodies cut short")
+	flagSet.Float64Var(&cfg.ChaosContentTypeRate, "chaos-content-type-rate", cfg.ChaosContentTypeRate, "fraction of responses relabelled as text/html")
+	flagSet.Uint64Var(&cfg.ChaosSeed, "chaos-seed", cfg.ChaosSeed, "seed of the injected faults, 0 picks one per run")
+}
+
+// chaosConfigured reports whether any fault is asked for.
+func chaosConfigured(cfg *Config) bool {
+	return cfg.ChaosFailureRate > 0 || cfg.ChaosDelayRate > 0 || cfg.ChaosTruncateRate > 0 || cfg.ChaosContentTypeRate > 0
+}
+
+// chaosTransport injects faults into upstream requests: failed connections and 503s, delays, bodies
+// cut short and wrong content types, each at its configured rate.
+type chaosTransport struct {
+	base  http.RoundTripper
+	cfg   *Config
+	mutex sync.Mutex
+	rng   *rand.Rand
+}
+
+// newChaosTransport wraps base when faults are configured and the environment allows them, and
+// returns base itself otherwise.
+func newChaosTransport(cfg *Config, base http.RoundTripper) http.RoundTripper {
+	if !chaosConfigured(cfg) {
+		return base
+	}
+	if cfg.Environment != environmentStaging && cfg.Environment != environmentTest {
+		log.Printf("ignoring the chaos settings in the %s environment", cfg.Environment)
+		return base
+	}
+	seed := cfg.ChaosSeed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	log.Printf("injecting upstream faults with seed %d", seed)
+	return &chaosTransport{base: base, cfg: cfg, rng: rand.New(rand.NewPCG(seed, seed))}
+}
+
+// roll reports whether a fault with the given rate happens this time.
+func (transport *chaosTransport) roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	transport.mutex.Lock()
+	defer transport.mutex.Unlock()
+	return transport.rng.Float64() < rate
+}
+
+// fraction returns a random number in [0, 1).
+func (transport *chaosTransport) fraction() float64 {
+	transport.mutex.Lock()
+	defer transport.mutex.Unlock()
+	return transport.rng.Float64()
+}
+
+// inject counts and logs an injected fault.
+func (transport *chaosTransport) inject(fault string, request *http.Request) {
+	metrics.inc("sabic_chaos_injections_total", map[string]string{"fault": fault})
+	log.Printf("chaos: injecting %s into %s", fault, activeRedactor.text(request.URL.Redacted()))
+}
+
+// RoundTrip implements http.RoundTripper.
+func (transport *chaosTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if transport.roll(transport.cfg.ChaosDelayRate) && transport.cfg.ChaosMaxDelay.Duration > 0 {
+		transport.inject("delay", request)
+		delay := time.Duration(transport.fraction() * float64(transport.cfg.ChaosMaxDelay.Duration))
+		timer := time.NewTimer(delay)
+		select {
+		case <-request.Context().Done():
+			timer.Stop()
+			return nil, request.Context().Err()
+		case <-timer.C:
+		}
+	}
+	if transport.roll(transport.cfg.ChaosFailureRate) {
+		// Half of the failures never reach the upstream, the other half look like an overloaded one.
+		if transport.fraction() < 0.5 {
+			transport.inject("connection reset", request)
+			return nil, fmt.Errorf("chaos: connection reset by peer")
+		}
+		transport.inject("503", request)
+		return &http.Response{
+			Status:     "503 Service Unavailable",
+			StatusCode: http.StatusServiceUnavailable,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{"Content-Type": {"text/plain"}, "Retry-After": {"1"}},
+			Body:       io.NopCloser(strings.NewReader("chaos: service unavailable")),
+			Request:    request,
+		}, nil
+	}
+	response, err := transport.base.RoundTrip(request)
+	if err != nil {
+		return response, err
+	}
+	if transport.roll(transport.cfg.ChaosContentTypeRate) {
+		transport.inject("wrong content type", request)
+		response.Header.Set("Content-Type", "text/html; charset=utf-8")
+	}
+	if transport.roll(transport.cfg.ChaosTruncateRate) {
+		transport.inject("truncated body", request)
+		limit := int64(0)
+		if response.ContentLength > 0 {
+			limit = int64(transport.fraction() * float64(response.ContentLength))
+		}
+		response.Body = &truncatedBody{ReadCloser: response.Body, remaining: limit}
+	}
+	return response, nil
+}
+
+// truncatedBody ends a response body early, the way a dropped connection does.
+type truncatedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+// Read implements io.Reader.
+func (body *truncatedBody) Read(buffer []byte) (int, error) {
+	if body.remaining <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if int64(len(buffer)) > body.remaining {
+		buffer = buffer[:body.remaining]
+	}
+	n, err := body.ReadCloser.Read(buffer)
+	body.remaining -= int64(n)
+	return n, err
+}
+
+func init() {
+	metrics.describe("sabic_chaos_injections_total", "Faults injected into upstream requests, by kind. Only non-zero in staging and test.")
+}
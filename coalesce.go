@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// fetchCall is one fetch in flight that later requests for the same document wait for.
+type fetchCall struct {
+	done chan struct{} // Closed once the fetch finished
+	err  error
+}
+
+// fetchGroup coalesces concurrent fetches of the same document, such as one listed by two sources or
+// queued again by a retry while its first fetch still runs, so only one of them reaches the upstream.
+type fetchGroup struct {
+	mutex sync.Mutex
+	calls map[string]*fetchCall // Fetches in flight by document file name
+}
+
+// newFetchGroup returns an empty fetch group.
+func newFetchGroup() *fetchGroup {
+	return &fetchGroup{calls: make(map[string]*fetchCall)}
+}
+
+// do runs fetch for a document unless it is being fetched already. Only the request that ran it gets
+// the staged file; the others share its outcome, as ErrAlreadyExists when it succeeded since the
+// file is then stored by the first one. A nil group runs every fetch.
+func (group *fetchGroup) do(ctx context.Context, doc documentRef, fetch func() (*stagedDocument, error)) (*stagedDocument, error) {
+	if group == nil {
+		return fetch()
+	}
+	group.mutex.Lock()
+	if call, ok := group.calls[doc.filename]; ok {
+		group.mutex.Unlock()
+		metrics.inc("sabic_coalesced_fetches_total", map[string]string{"source": doc.source})
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if call.err != nil {
+			return nil, call.err
+		}
+		return nil, fmt.Errorf("%w, fetched by a concurrent request: %s", ErrAlreadyExists, doc.filename)
+	}
+	call := &fetchCall{done: make(chan struct{})}
+	group.calls[doc.filename] = call
+	group.mutex.Unlock()
+	staged, err := fetch()
+	call.err = err
+	group.mutex.Lock()
+	delete(group.calls, doc.filename)
+	group.mutex.Unlock()
+	close(call.done)
+	return staged, err
+}
+
+func init() {
+	metrics.describe("sabic_coalesced_fetches_total", "Fetches that waited for the same document's fetch in flight instead of fetching it again.")
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// commandExamples are shown at the end of a command's -h output.
+var commandExamples = map[string][]string{
+	"sabic-com-documentation": {
+		"sabic-com-documentation -config sabic.json",
+		"sabic-com-documentation -languages \"EN > local\" -jurisdiction EU -workers 4",
+		"sabic-com-documentation -sample 0.01 -output /tmp/sds-check",
+	},
+	"browse":             {"sabic-com-documentation browse -config sabic.json"},
+	"bundle":             {"sabic-com-documentation bundle -bundle-dir shipping/", "sabic-com-documentation bundle -filter \"material=22006037\""},
+	"check-availability": {"sabic-com-documentation check-availability -config sabic.json", "sabic-com-documentation check-availability -method range -json"},
+	"catalog at":         {"sabic-com-documentation catalog at 2024-01-01", "sabic-com-documentation catalog at -report-types SDS -export catalog-2024.json 2024-01-01", "sabic-com-documentation catalog at -json 2024-06-30T12:00:00Z"},
+	"config validate":    {"sabic-com-documentation config validate -config sabic.json"},
+	"completion":         {"source <(sabic-com-documentation completion bash)", "sabic-com-documentation completion fish > ~/.config/fish/completions/sabic-com-documentation.fish"},
+	"daemon":             {"sabic-com-documentation daemon -config sabic.json -interval 24h", "sabic-com-documentation daemon -probe-address :8081 -shutdown-grace 25s", "sabic-com-documentation daemon -leader-election kubernetes -leader-lease sds/sabic-sync"},
+	"ehs-push":           {"sabic-com-documentation ehs-push -ehs-uploader rest -ehs-endpoint https://ehs.example.com/api/sds/import", "sabic-com-documentation ehs-push -ehs-uploader folder -ehs-endpoint /mnt/ehs/inbox"},
+	"expiring":           {"sabic-com-documentation expiring -within 2160h", "sabic-com-documentation expiring -json -unknown"},
+	"export-site":        {"sabic-com-documentation export-site -site-dir public/ -site-url https://sds.example.com/"},
+	"fetch":              {"sabic-com-documentation fetch -matnr 22006037 -laiso MS", "sabic-com-documentation fetch -matnr 22006037 -laiso EN -sbgvid SDS_US -out sds.pdf -json"},
+	"maintenance":        {"sabic-com-documentation maintenance -dry-run", "sabic-com-documentation maintenance -manifest-retention 2160h", "sabic-com-documentation maintenance -revision-keep 5 -revision-max-age 87600h"},
+	"list":               {"sabic-com-documentation list -locale sv", "sabic-com-documentation list -json"},
+	"mirror":             {"sabic-com-documentation mirror /mnt/nas/sds", "sabic-com-documentation mirror -verify -report mirror.json s3://sds-backup/library", "sabic-com-documentation mirror -s3-lock-mode COMPLIANCE -s3-lock-days 3650 s3://sds-archive/library", "sabic-com-documentation mirror \"sharepoint://contoso.sharepoint.com/sites/EHS/Safety Data Sheets/SABIC\"", "sabic-com-documentation mirror webdav://svc-sds@dms.example.com/remote.php/dav/files/svc-sds/SDS"},
+	"publish":            {"sabic-com-documentation publish -filter @contractor-materials.txt -dest out/contractor -archive contractor.tar.gz", "sabic-com-documentation publish -filter \"material=22006037 language=EN,DE\" -dest out/subset"},
+	"scrape":             {"sabic-com-documentation scrape -config sabic.json", "sabic-com-documentation scrape -restart"},
+	"serve":              {"sabic-com-documentation serve -listen :8080 -allow-anonymous", "sabic-com-documentation serve -access-log access.jsonl", "sabic-com-documentation serve -listen :80 -run-as-user sds -run-as-group sds"},
+	"top-documents":      {"sabic-com-documentation top-documents -limit 50", "sabic-com-documentation top-documents -json"},
+	"share-url":          {"sabic-com-documentation share-url -site-url https://sds.example.com 22006037_630000000001_sds_my_ms.pdf", "sabic-com-documentation share-url -ttl 24h 22006037_630000000001_sds_my_ms.pdf"},
+	"stamp":              {"sabic-com-documentation stamp", "sabic-com-documentation stamp -stamp-mode cover -force"},
+	"show":               {"sabic-com-documentation show 22006037_630000000001_sds_my_ms.pdf"},
+	"slo":                {"sabic-com-documentation slo -period 168h", "sabic-com-documentation slo -slo-target 12h -slo-objective 0.95 -json"},
+}
+
+// printCommandUsage writes the -h output of a command: its usage line, its flags and examples.
+func printCommandUsage(flagSet *flag.FlagSet) {
+	out := flagSet.Output()
+	name := flagSet.Name()
+	if name == "sabic-com-documentation" {
+		fmt.Fprintf(out, "Usage: %s [command] [flags]\n\nWithout a command, runs one sync. Commands:\n", name)
+		for _, command := range usageCommands {
+			fmt.Fprintf(out, "  %s\n", command)
+		}
+		fmt.Fprintln(out, "\nRun a command with -h for its flags.")
+	} else {
+		fmt.Fprintf(out, "Usage: sabic-com-documentation %s [flags]\n", name)
+	}
+	fmt.Fprintln(out, "\nFlags:")
+	visibleFlags(flagSet).PrintDefaults()
+	if examples := commandExamples[name]; len(examples) > 0 {
+		fmt.Fprintln(out, "\nExamples:")
+		for _, example := range examples {
+			fmt.Fprintf(out, "  %s\n", example)
+		}
+	}
+}
+
+// visibleFlags returns a copy of a flag set without the hidden chaos flags (see chaos.go).
+func visibleFlags(flagSet *flag.FlagSet) *flag.FlagSet {
+	visible := flag.NewFlagSet(flagSet.Name(), flag.ContinueOnError)
+	visible.SetOutput(flagSet.Output())
+	flagSet.VisitAll(func(defined *flag.Flag) {
+		if !chaosFlags[defined.Name] {
+			visible.Var(defined.Value, defined.Name, defined.Usage)
+			visible.Lookup(defined.Name).DefValue = defined.DefValue
+		}
+	})
+	return visible
+}
+
+// usageCommands lists the subcommands in the root -h output. It is filled in by init, since
+// the commands themselves print usage through loadConfig.
+var usageCommands []string
+
+func init() {
+	// The completion scripts call back into the program; the command isn't listed in -h.
+	subcommands["__complete"] = runComplete
+	usageCommands = commandNames()
+}
+
+// commandNames returns the subcommands in order, leaving out hidden ones.
+func commandNames() []string {
+	names := make([]string, 0, len(subcommands))
+	for name := range subcommands {
+		if !strings.HasPrefix(name, "__") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// errFlagsInspected ends a command right after loadConfig registered its flags, see commandFlagSet.
+var errFlagsInspected = errors.New("flags inspected")
+
+// inspectFlags, when set, receives the flag set of the command being run instead of it being parsed.
+var inspectFlags func(flagSet *flag.FlagSet)
+
+// commandFlagSet returns the flags a command understands by starting it until it calls loadConfig.
+func commandFlagSet(command string) *flag.FlagSet {
+	var found *flag.FlagSet
+	inspectFlags = func(flagSet *flag.FlagSet) { found = flagSet }
+	defer func() { inspectFlags = nil }()
+	if run, ok := subcommands[command]; ok {
+		run(nil)
+	} else {
+		loadConfig("sabic-com-documentation", nil, nil)
+	}
+	return found
+}
+
+// valueCompletions complete the values of flags whose choices are known or found in the catalog.
+var valueCompletions = map[string]func(cfg *Config) []string{
+	"laiso":     catalogLanguages,
+	"languages": func(cfg *Config) []string { return append(catalogLanguages(cfg), "local") },
+	"sbgvid":    catalogVariants,
+	"jurisdiction": func(cfg *Config) []string {
+		return catalogKeyValues(cfg, func(keys DocumentID) string { return keys.region() })
+	},
+	"profile": func(cfg *Config) []string { return sortedKeys(crawlProfiles) },
+	"ip-family": func(cfg *Config) []string {
+		return []string{ipFamilyIPv4, ipFamilyIPv6, ipFamilyPreferIPv4, ipFamilyPreferIPv6}
+	},
+	"view-mode": func(cfg *Config) []string { return []string{viewModeSymlink, viewModeCopy} },
+}
+
+// sortedKeys returns the keys of a map in order.
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// catalogKeyValues collects one URL key of every catalog entry, uppercased and without duplicates.
+func catalogKeyValues(cfg *Config, key func(keys DocumentID) string) []string {
+	docs, err := openCatalog(cfg.CatalogFile)
+	if err != nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	for _, entry := range docs.all() {
+		if value := strings.ToUpper(key(entry.documentID())); value != "" {
+			seen[value] = true
+		}
+	}
+	return sortedKeys(seen)
+}
+
+// catalogLanguages returns the language codes of the catalog.
+func catalogLanguages(cfg *Config) []string {
+	return catalogKeyValues(cfg, func(keys DocumentID) string { return keys.Laiso })
+}
+
+// catalogVariants returns the Sbgvid values of the catalog.
+func catalogVariants(cfg *Config) []string {
+	return catalogKeyValues(cfg, func(keys DocumentID) string { return keys.Sbgvid })
+}
+
+// argumentCompletions complete the positional arguments of commands.
+var argumentCompletions = map[string]func(cfg *Config) []string{
+	"completion": func(cfg *Config) []string { return sortedKeys(completionScripts) },
+	"catalog":    func(cfg *Config) []string { return []string{"at"} },
+	"config":     func(cfg *Config) []string { return []string{"validate"} },
+	"show": func(cfg *Config) []string {
+		docs, err := openCatalog(cfg.CatalogFile)
+		if err != nil {
+			return nil
+		}
+		var names []string
+		for _, entry := range docs.all() {
+			names = append(names, entry.Filename)
+		}
+		sort.Strings(names)
+		return names
+	},
+}
+
+// completeWords returns the candidates for the last of words, the command line after the program name.
+// No candidates lets the shell fall back to completing file names.
+func completeWords(words []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	current := words[len(words)-1]
+	command := ""
+	if len(words) > 1 && !strings.HasPrefix(words[0], "-") {
+		command = words[0]
+	}
+	// The first word is a command or a flag of the sync run.
+	if len(words) == 1 && !strings.HasPrefix(current, "-") {
+		return withPrefix(commandNames(), current)
+	}
+	// Commands that don't take the common flags, such as completion itself, have no flag set.
+	flagSet := commandFlagSet(command)
+	if flagSet == nil {
+		flagSet = flag.NewFlagSet(command, flag.ContinueOnError)
+	}
+	cfg := completionConfig(words)
+	// A flag's value, e.g. -laiso <tab>.
+	if len(words) > 1 {
+		previous := strings.TrimLeft(words[len(words)-2], "-")
+		if strings.HasPrefix(words[len(words)-2], "-") && !strings.Contains(previous, "=") {
+			if defined := flagSet.Lookup(previous); defined != nil && !isBoolFlag(defined) {
+				if complete, ok := valueCompletions[previous]; ok {
+					return withPrefix(complete(cfg), current)
+				}
+				return nil
+			}
+		}
+	}
+	if strings.HasPrefix(current, "-") {
+		var names []string
+		visibleFlags(flagSet).VisitAll(func(defined *flag.Flag) {
+			names = append(names, "-"+defined.Name)
+		})
+		return withPrefix(names, current)
+	}
+	if complete, ok := argumentCompletions[command]; ok {
+		return withPrefix(complete(cfg), current)
+	}
+	return nil
+}
+
+// isBoolFlag reports whether a flag takes no value.
+func isBoolFlag(defined *flag.Flag) bool {
+	boolFlag, ok := defined.Value.(interface{ IsBoolFlag() bool })
+	return ok && boolFlag.IsBoolFlag()
+}
+
+// withPrefix keeps the candidates starting with prefix, ignoring case.
+func withPrefix(candidates []string, prefix string) []string {
+	var matching []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(strings.ToLower(candidate), strings.ToLower(prefix)) {
+			matching = append(matching, candidate)
+		}
+	}
+	return matching
+}
+
+// completionConfig loads the config named by a -config flag on the command line being completed,
+// so catalog values come from the right catalog.
+func completionConfig(words []string) *Config {
+	for i, word := range words {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(word, "-"), "=")
+		if name != "config" || !strings.HasPrefix(word, "-") {
+			continue
+		}
+		if !hasValue && i+1 < len(words)-1 {
+			value = words[i+1]
+		}
+		if cfg, _, err := loadConfig("complete", []string{"-config", value}, nil); err == nil {
+			return cfg
+		}
+	}
+	return defaultConfig()
+}
+
+// runComplete implements the hidden __complete command the completion scripts call.
+func runComplete(args []string) error {
+	for _, candidate := range completeWords(args) {
+		fmt.Println(candidate)
+	}
+	return nil
+}
+
+// completionScripts are the shell scripts printed by the completion command; %[1]s is the program name.
+// Each asks the program itself for candidates, so completions follow the flags of the installed version.
+var completionScripts = map[string]string{
+	"bash": `_%[1]s_complete() {
+    local IFS=$'\n'
+    COMPREPLY=($(%[1]s __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _%[1]s_complete %[1]s
+`,
+	"zsh": `#compdef %[1]s
+_%[1]s_complete() {
+    local -a candidates
+    candidates=("${(@f)$(%[1]s __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+    if [[ -n "${candidates[1]}" ]]; then
+        compadd -a candidates
+    else
+        _files
+    fi
+}
+compdef _%[1]s_complete %[1]s
+`,
+	"fish": `function __%[1]s_complete
+    set -l tokens (commandline -opc) (commandline -ct)
+    %[1]s __complete $tokens[2..-1] 2>/dev/null
+end
+complete -c %[1]s -a '(__%[1]s_complete)'
+`,
+}
+
+// runCompletion implements the completion command, printing the completion script of a shell.
+func runCompletion(args []string) error {
+	if len(args) == 1 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+		flagSet := flag.NewFlagSet("completion", flag.ContinueOnError)
+		printCommandUsage(flagSet)
+		return flag.ErrHelp
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("usage: completion <%s>", strings.Join(sortedKeys(completionScripts), "|"))
+	}
+	script, ok := completionScripts[args[0]]
+	if !ok {
+		return fmt.Errorf("no completion for %q, expected %s", args[0], strings.Join(sortedKeys(completionScripts), ", "))
+	}
+	// Shell function names can't hold every character a file name can.
+	program := strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, filepath.Base(os.Args[0]))
+	fmt.Printf(script, program)
+	return nil
+}
@@ -0,0 +1,607 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration that reads as "90s" or "24h" in JSON and on the command line.
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON parses a duration string such as "24h".
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("duration must be a string like \"24h\": %v", err)
+	}
+	return d.Set(text)
+}
+
+// MarshalJSON writes the duration back as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Set implements flag.Value.
+func (d *Duration) Set(text string) error {
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// SizeLimits holds the smallest and largest response size, in bytes, accepted for a document.
+type SizeLimits struct {
+	MinBytes int64 `json:"min_bytes"` // Smallest accepted body, 0 disables the check
+	MaxBytes int64 `json:"max_bytes"` // Largest accepted body, 0 disables the check
+}
+
+// defaultSizeLimits applies to report types without their own entry.
+var defaultSizeLimits = SizeLimits{MinBytes: 1 << 10, MaxBytes: 256 << 20}
+
+// defaultReportTypeSizes holds the built-in limits per report type (the Sbgvid prefix, e.g. SDS).
+var defaultReportTypeSizes = map[string]SizeLimits{
+	reportTypeSDS:      {MinBytes: 10 << 10, MaxBytes: 128 << 20}, // Stub pages are a few hundred bytes, the shortest real sheets tens of KB
+	reportTypeLabel:    {MinBytes: 2 << 10, MaxBytes: 32 << 20},   // A label is a page or two
+	reportTypeTremcard: {MinBytes: 5 << 10, MaxBytes: 32 << 20},
+}
+
+// Config holds the settings for a run, loaded from an optional JSON file and overridden by flags.
+type Config struct {
+	// Sync runs.
+	Sources               []string                     `json:"sources"`                 // Supplier portals to pull from, in order
+	Plugins               map[string][]string          `json:"plugins"`                 // Commands of out-of-tree sources by name, see plugin.go
+	InputFile             string                       `json:"input_file"`              // Scraped header JSON, the latest snapshot when empty
+	SnapshotDir           string                       `json:"snapshot_dir"`            // Directory of the timestamped header snapshots written by scrape
+	KeepSnapshots         int                          `json:"keep_snapshots"`          // Snapshots kept, older ones are removed; 0 keeps all
+	RevisionDir           string                       `json:"revision_dir"`            // Archive of superseded document revisions, empty disables it
+	RevisionKeep          int                          `json:"revision_keep"`           // Newest archived revisions the maintenance command keeps per document, 0 for no count limit
+	RevisionMaxAge        Duration                     `json:"revision_max_age"`        // Age since being superseded after which the maintenance command removes a revision beyond revision_keep, 0 for no age limit
+	SnapshotCompression   string                       `json:"snapshot_compression"`    // gzip or none; reading detects compression either way
+	RawSnapshots          bool                         `json:"raw_snapshots"`           // Write scraped results as served, with __metadata and in paging order, instead of canonical for diffing
+	OutputDir             string                       `json:"output_dir"`              // Directory to store downloaded PDFs
+	StorageLayout         string                       `json:"storage_layout"`          // Layout of the output directory, flat or cas (see storage.go)
+	EncryptionKeyFile     string                       `json:"encryption_key_file"`     // Base64 AES-256 key stored PDFs are encrypted with (see encryption.go); empty disables encryption
+	EncryptionKeyCommand  []string                     `json:"encryption_key_command"`  // Command printing that key, e.g. age -d or aws kms decrypt; wins over encryption_key_file
+	MinSize               int64                        `json:"min_size"`                // Global minimum size, overrides report type defaults
+	MaxSize               int64                        `json:"max_size"`                // Global maximum size, overrides report type defaults
+	ReportTypeSizes       map[string]SizeLimits        `json:"report_type_sizes"`       // Per report type size limits
+	ReportTypes           string                       `json:"report_types"`            // Only fetch documents of these comma separated report types, e.g. "SDS,LABEL"; all when empty
+	ReportTypeDirs        map[string]string            `json:"report_type_dirs"`        // Subdirectories of output_dir by report type, labels and tremcards by default; others stay at the top (see reporttypes.go)
+	Validation            map[string][]ValidationCheck `json:"validation"`              // Checks staged documents must pass by report type, "*" for the others (see validation.go)
+	AlternateFormats      []string                     `json:"alternate_formats"`       // Representations fetched besides each PDF, docx or rtf (see formats.go); alternates are checked under DOCX or RTF in validation
+	FormatParameter       string                       `json:"format_parameter"`        // Query parameter naming the wanted format of an alternate, e.g. format; the Accept header alone when empty
+	Window                string                       `json:"window"`                  // Allowed download hours, e.g. 22:00-06:00
+	Timezone              string                       `json:"timezone"`                // Time zone of the window, local when empty
+	SyncInterval          Duration                     `json:"sync_interval"`           // Pause between daemon sync runs
+	ProbeAddress          string                       `json:"probe_address"`           // Address of the daemon's /healthz and /readyz for container orchestrators, empty disables them (see probes.go)
+	ShutdownGrace         Duration                     `json:"shutdown_grace"`          // Time a running sync or open requests get to finish after SIGTERM, within the pod's termination grace period
+	LeaderElection        string                       `json:"leader_election"`         // Lets one of several daemon replicas sync: file or kubernetes, empty when every daemon syncs (see leader.go)
+	LeaderLease           string                       `json:"leader_lease"`            // Lease file for file, <catalog_file>.leader when empty; "namespace/name" of the Lease for kubernetes
+	LeaderLeaseDuration   Duration                     `json:"leader_lease_duration"`   // Time without renewal after which a standby replica takes over
+	LockFile              string                       `json:"lock_file"`               // Lock keeping commands that change the output directory from overlapping, <output_dir>.lock when empty
+	RunAsUser             string                       `json:"run_as_user"`             // User a sync, daemon or server started as root switches to once its ports are bound (see privileges.go)
+	RunAsGroup            string                       `json:"run_as_group"`            // Group it switches to, the user's primary group when empty
+	AuditLog              string                       `json:"audit_log"`               // Hash-chained JSONL audit trail, empty disables it
+	CatalogFile           string                       `json:"catalog_file"`            // JSON index of stored documents
+	CatalogBatchSize      int                          `json:"catalog_batch_size"`      // Changed catalog entries journaled per synced write during a sync, 0 only writes the catalog at the end (see catalogjournal.go)
+	CatalogFlushInterval  Duration                     `json:"catalog_flush_interval"`  // Longest time a changed entry waits for its batch to be journaled
+	ResponseStore         string                       `json:"response_store"`          // Key-value log of the response headers of each document's last fetch, empty disables it
+	RedactPatterns        []string                     `json:"redact_patterns"`         // Regular expressions masked in logs, saved response headers and manifests on top of the built-in token patterns (see redact.go)
+	Jurisdiction          string                       `json:"jurisdiction"`            // Only fetch documents for these comma separated jurisdictions or countries, e.g. "EU,US"
+	Jurisdictions         map[string]jurisdictionInfo  `json:"jurisdictions"`           // Overrides of the built-in Sbgvid to jurisdiction mapping, by Sbgvid or region
+	LanguageFallback      string                       `json:"language_fallback"`       // Preferred languages per material, e.g. "EN > FR > local"
+	MaterialMap           string                       `json:"material_map"`            // CSV of internal_code,matnr limiting and labelling the materials
+	ManifestDir           string                       `json:"manifest_dir"`            // Directory of the per-run JSONL manifests, empty disables them
+	ManifestRetention     Duration                     `json:"manifest_retention"`      // Age after which the maintenance command removes run manifests, 0 keeps them all
+	AuditRotateSize       int64                        `json:"audit_rotate_size"`       // Size in bytes above which the maintenance command rotates the audit log, 0 never rotates it
+	CoalesceFetches       bool                         `json:"coalesce_fetches"`        // Let concurrent requests for the same document share one upstream fetch (see coalesce.go)
+	FastSkip              bool                         `json:"fast_skip"`               // Plan skips against the catalog and one snapshot of the output directory instead of a stat per file (see dirsnapshot.go)
+	Workers               int                          `json:"workers"`                 // Concurrent downloads of a sync run
+	ScrapeWorkers         int                          `json:"scrape_workers"`          // Header pages the scrape command fetches at once by $skip; 1 follows the __next links one page at a time
+	ContentTypeCheck      string                       `json:"content_type_check"`      // strict rejects PDFs not sent as application/pdf, lenient also takes generic types such as application/octet-stream when the content starts with %PDF-
+	HashWorkers           int                          `json:"hash_workers"`            // Goroutines hashing completed downloads of a sync run apart from the download workers, 0 hashes while downloading
+	PostprocessWorkers    int                          `json:"postprocess_workers"`     // Stored documents of a sync run post-processed at once: text extraction, previews, PDF/A copies and stamps
+	Profile               string                       `json:"profile"`                 // Crawl preset such as polite, applied to settings left at their defaults (see politeness.go)
+	UserAgents            []string                     `json:"user_agents"`             // User-Agent headers sent upstream in rotation, the tool's own when empty
+	HostDelay             Duration                     `json:"host_delay"`              // Least time between two requests to the same host, 0 disables it
+	QueueSize             int                          `json:"queue_size"`              // Capacity of the channels between pipeline stages
+	LanguageCheck         bool                         `json:"language_check"`          // Detect the language of each new document's text and flag ones that differ from the requested Laiso
+	AdaptiveConcurrency   bool                         `json:"adaptive_concurrency"`    // Lower the concurrent downloads from a host while it errors or slows down, and raise them back up to workers as it recovers
+	HealthWindow          int                          `json:"health_window"`           // Latest requests per host the health score covers
+	MemoryBudget          int64                        `json:"memory_budget"`           // Bytes all download workers may have in flight together, 0 is unlimited
+	RangedThreshold       int64                        `json:"ranged_threshold"`        // Documents at least this many bytes are fetched as parallel byte ranges when the server allows, 0 disables it
+	RangedSegments        int                          `json:"ranged_segments"`         // Parallel byte ranges of such a document
+	MemoryPerFile         int64                        `json:"memory_per_file"`         // Bytes reserved for a download without a Content-Length
+	StagingDir            string                       `json:"staging_dir"`             // Where workers stream downloads before moving them into place, the output directory when empty
+	RespectRetryAfter     bool                         `json:"respect_retry_after"`     // Back off as long as a throttled response's Retry-After asks
+	ThrottleDelay         Duration                     `json:"throttle_delay"`          // Back-off after a throttled response without Retry-After
+	MaxRetryAfter         Duration                     `json:"max_retry_after"`         // Longest back-off honored, 0 is unlimited
+	ThrottleRetries       int                          `json:"throttle_retries"`        // Attempts after the first for a throttled document
+	RetryPolicies         []RetryPolicy                `json:"retry_policies"`          // Retry behavior per error class or status, tried before the built-in policies (see retry.go)
+	ConnectTimeout        Duration                     `json:"connect_timeout"`         // Limit on establishing a download connection
+	TLSTimeout            Duration                     `json:"tls_timeout"`             // Limit on the TLS handshake
+	ResponseHeaderTimeout Duration                     `json:"response_header_timeout"` // Limit on waiting for response headers after sending a request
+	StallTimeout          Duration                     `json:"stall_timeout"`           // Abort a download when no bytes arrive for this long, 0 never does
+	MaxRedirects          int                          `json:"max_redirects"`           // Redirects an upstream request follows, 0 follows none
+	RedirectHosts         []string                     `json:"redirect_hosts"`          // Hosts redirects may lead to besides the request's own, e.g. *.hana.ondemand.com; any host when empty
+	CookieJar             string                       `json:"cookie_jar"`              // File the upstream's cookies are kept in between runs, encrypted with the encryption key when set (see cookiejar.go); empty keeps none
+	CookieSessionTTL      Duration                     `json:"cookie_session_ttl"`      // How long a cookie_jar keeps session cookies, which carry no expiry of their own
+	Resolve               hostOverrides                `json:"resolve"`                 // IP addresses pinned by host name, e.g. {"dispatcher.example.com": "10.0.0.5"}
+	IPFamily              string                       `json:"ip_family"`               // ipv4, ipv6, prefer-ipv4 or prefer-ipv6; dual-stack when empty
+	DNSServer             string                       `json:"dns_server"`              // Resolver used instead of the system's, e.g. 10.0.0.53 or [fd00::53]:53
+	Sample                float64                      `json:"sample"`                  // Fetch only this fraction of planned documents, e.g. 0.01 for a QA run; 0 fetches all
+	SampleCount           int                          `json:"sample_count"`            // Fetch only this many planned documents, 0 fetches all
+	SampleSeed            uint64                       `json:"sample_seed"`             // Picks a different deterministic sample
+
+	// OData service.
+	ServiceURL       string              `json:"service_url"`        // Root of the SDS OData service
+	HeaderEntitySet  string              `json:"header_entity_set"`  // Entity set listing the documents
+	ContentEntitySet string              `json:"content_entity_set"` // Entity set serving the document content
+	ContentValuePath string              `json:"content_value_path"` // Path from a content entity to its binary value
+	EntitySets       map[string][]string `json:"entity_sets"`        // Entity sets and their properties, as scaffolded by discover
+	FieldMap         map[string]string   `json:"field_map"`          // Tenant property names by ours, e.g. {"Matnr": "MaterialNo"}
+	UseMetadata      bool                `json:"use_metadata"`       // Type header properties from the service's $metadata
+	MetadataCache    string              `json:"metadata_cache"`     // Last fetched $metadata, used when the service is unreachable
+	ScrapeState      string              `json:"scrape_state"`       // Progress of the scrape command, so an interrupted one resumes from its last __next link
+	ScrapeRetries    int                 `json:"scrape_retries"`     // Times a malformed, truncated or failed header page is fetched again
+
+	// Post-processing.
+	Previews       bool     `json:"previews"`        // Render a PNG of the first page next to each new PDF
+	PreviewCommand []string `json:"preview_command"` // Renderer command using {input}, {output} and {output_base}
+	PDFA           bool     `json:"pdfa"`            // Keep a PDF/A-1b copy of each new PDF
+	PDFACommand    []string `json:"pdfa_command"`    // Converter command using {input} and {output}
+	PDFADir        string   `json:"pdfa_dir"`        // Directory of the PDF/A copies
+	Stamp          bool     `json:"stamp"`           // Keep a copy of each new PDF marked with its retrieval date, source and checksum
+	StampMode      string   `json:"stamp_mode"`      // Where the mark goes, overlay on page 1 or cover for an added first page (see stamp.go)
+	StampCommand   []string `json:"stamp_command"`   // Overlay command using {input}, {stamp} and {output}
+	StampDir       string   `json:"stamp_dir"`       // Directory of the stamped copies
+	Views          bool     `json:"views"`           // Rebuild the browsable views after each sync
+	ViewsDir       string   `json:"views_dir"`       // Directory of the by-family/, by-language/ and by-material/ views
+	ViewMode       string   `json:"view_mode"`       // How views are built, symlink or copy (see views.go)
+	SiteDir        string   `json:"site_dir"`        // Directory the export-site command writes the static site to
+	BundleCommand  []string `json:"bundle_command"`  // Merger command of the bundle command using {inputs} and {output}
+	BundleDir      string   `json:"bundle_dir"`      // Directory the bundle command writes one merged PDF per material to
+	SiteURL        string   `json:"site_url"`        // Public base URL of the exported site or the server, for sitemap.xml and robots.txt
+
+	// Mirror audits and copies.
+	MirrorState       string            `json:"mirror_state"`        // Key-value log of what the mirror command copied where
+	S3Region          string            `json:"s3_region"`           // Region of S3 destinations, AWS_REGION when empty
+	S3Endpoint        string            `json:"s3_endpoint"`         // Endpoint of an S3 compatible store such as MinIO, AWS when empty
+	S3LockMode        string            `json:"s3_lock_mode"`        // Object Lock retention of S3 uploads, GOVERNANCE or COMPLIANCE; empty sets none (see s3.go)
+	S3LockDays        int               `json:"s3_lock_days"`        // Days locked uploads are retained from the time of upload
+	S3LegalHold       bool              `json:"s3_legal_hold"`       // Place a legal hold on S3 uploads, keeping them until it is lifted
+	GraphTenantID     string            `json:"graph_tenant_id"`     // Entra ID tenant of sharepoint:// destinations
+	GraphClientID     string            `json:"graph_client_id"`     // App registration with Sites.ReadWrite.All used for them
+	GraphClientSecret string            `json:"graph_client_secret"` // Its client secret, GRAPH_CLIENT_SECRET env var when empty
+	SharePointColumns map[string]string `json:"sharepoint_columns"`  // Library columns by metadata field: material, language, revision_date, sbgvid, internal_code, sha256
+	WebDAVPassword    string            `json:"webdav_password"`     // Password of the user named in webdav:// destinations, WEBDAV_PASSWORD env var when empty
+	AuditSigningKey   string            `json:"audit_signing_key"`   // PEM ed25519 key signing audit reports, created when missing
+	AuditReportDir    string            `json:"audit_report_dir"`    // Directory of the signed audit reports
+
+	// Document expiry.
+	IssueDateFields    []string `json:"issue_date_fields"`   // Header properties holding the issue or revision date, first match wins; the PDF text is read otherwise
+	ReviewAge          Duration `json:"review_age"`          // Age after which a sheet is due for review and revalidated, 0 disables it
+	RevalidateInterval Duration `json:"revalidate_interval"` // How often an overdue document is fetched again to look for a newer revision
+
+	// Publication SLO (see slo.go).
+	PublicationFields []string `json:"publication_fields"` // Header properties holding when a document was published upstream, first match wins
+	SLOTarget         Duration `json:"slo_target"`         // Promised time from publication upstream to local availability, 0 disables tracking
+	SLOObjective      float64  `json:"slo_objective"`      // Fraction of new documents that must make slo_target, e.g. 0.99
+	SLOAlertTo        []string `json:"slo_alert_to"`       // Recipients of the daemon's breach alerts, digest_to when empty
+
+	// Product families.
+	ProductFamilyFields []string `json:"product_family_fields"` // Header properties naming the product family, first match wins
+	ProductFamily       string   `json:"product_family"`        // Only fetch documents of these comma separated product families
+
+	// Listing.
+	MaterialDescriptionFields []string `json:"material_description_fields"` // Header properties holding the material description, first match wins
+	Locale                    string   `json:"locale"`                      // BCP 47 locale whose collation sorts descriptions, e.g. ar or zh-Hant; root collation when empty
+
+	// Profiling.
+	PprofAddress string `json:"pprof_address"` // Address of a net/http/pprof endpoint, empty disables it; keep it on localhost
+	CPUProfile   string `json:"cpu_profile"`   // File to write a CPU profile of the run to
+	HeapProfile  string `json:"heap_profile"`  // File to write a heap profile to when the run ends
+
+	// Failure injection, ignored in production (see chaos.go).
+	Environment          string   `json:"environment"`             // production, staging or test
+	ChaosFailureRate     float64  `json:"chaos_failure_rate"`      // Fraction of upstream requests failed with a reset connection or a 503
+	ChaosDelayRate       float64  `json:"chaos_delay_rate"`        // Fraction of upstream requests held up by up to chaos_max_delay
+	ChaosMaxDelay        Duration `json:"chaos_max_delay"`         // Longest injected delay
+	ChaosTruncateRate    float64  `json:"chaos_truncate_rate"`     // Fraction of response bodies cut short
+	ChaosContentTypeRate float64  `json:"chaos_content_type_rate"` // Fraction of responses relabelled as text/html
+	ChaosSeed            uint64   `json:"chaos_seed"`              // Seed of the injected faults, so a failing run can be replayed; 0 picks one
+
+	// Test fixtures (see fixtures.go).
+	RecordFixtures string `json:"record_fixtures"` // Directory to save sanitized listing pages and one small PDF of the upstream into, e.g. testdata/fixtures
+	ReplayFixtures string `json:"replay_fixtures"` // Directory of recorded fixtures to answer upstream requests from, without the network
+
+	// Email digest.
+	SMTPHost       string   `json:"smtp_host"`        // Mail server for the digest
+	SMTPPort       int      `json:"smtp_port"`        // Mail server port
+	SMTPUsername   string   `json:"smtp_username"`    // Mail server login, empty sends without auth
+	SMTPPassword   string   `json:"smtp_password"`    // Mail server password, SMTP_PASSWORD env var when empty
+	DigestFrom     string   `json:"digest_from"`      // Sender address of the digest
+	DigestTo       []string `json:"digest_to"`        // Recipients of the digest
+	DigestLinkBase string   `json:"digest_link_base"` // URL prefix for document links, file:// paths when empty
+	DigestState    string   `json:"digest_state"`     // File remembering when the last digest was sent
+	DigestInterval Duration `json:"digest_interval"`  // How often the daemon sends a digest, 0 disables it
+
+	// EHS integration.
+	EHSUploader string `json:"ehs_uploader"` // How documents reach the EHS system after each sync, rest or folder (see ehs.go); empty disables it
+	EHSEndpoint string `json:"ehs_endpoint"` // Upload URL of the rest uploader, or the drop folder of the folder uploader
+	EHSToken    string `json:"ehs_token"`    // Bearer token of the rest uploader, EHS_TOKEN env var when empty
+	EHSState    string `json:"ehs_state"`    // Key-value log of what was pushed to the EHS system
+
+	// Serve mode.
+	ListenAddress          string   `json:"listen_address"`           // Address the serve command listens on
+	APIKeys                []APIKey `json:"api_keys"`                 // Keys accepted by the serve command
+	OIDCIssuer             string   `json:"oidc_issuer"`              // Issuer whose bearer tokens the serve command accepts
+	OIDCAudience           string   `json:"oidc_audience"`            // Required aud claim of bearer tokens
+	OIDCRoleClaim          string   `json:"oidc_role_claim"`          // Claim holding the caller's roles
+	OIDCAdminRole          string   `json:"oidc_admin_role"`          // Value of the role claim that grants admin
+	AllowAnonymous         bool     `json:"allow_anonymous"`          // Give unauthenticated callers read access
+	RateLimit              float64  `json:"rate_limit"`               // Requests per second per serve client, 0 disables it
+	RateBurst              int      `json:"rate_burst"`               // Requests a serve client may make at once
+	MaxDownloadsPerClient  int      `json:"max_downloads_per_client"` // Concurrent document downloads per serve client, 0 is unlimited
+	MaxConcurrentDownloads int      `json:"max_concurrent_downloads"` // Concurrent document downloads across all serve clients, 0 is unlimited
+	AccessLog              string   `json:"access_log"`               // JSONL log of every request the serve command answers, empty disables it
+	AccessCounts           string   `json:"access_counts"`            // Key-value log of how often each document was downloaded, empty disables it
+	ShareSigningKey        string   `json:"share_signing_key"`        // Secret signing expiring document links (see share.go), SHARE_SIGNING_KEY env var when empty; no links without one
+	ShareTTL               Duration `json:"share_ttl"`                // How long a signed link stays valid unless asked otherwise
+	ShareMaxTTL            Duration `json:"share_max_ttl"`            // Longest validity a link may be signed for, 0 for no limit
+}
+
+// defaultConfig returns the configuration used when nothing is overridden.
+func defaultConfig() *Config {
+	// Copy the built-in report type limits and trees so the config file can extend them.
+	reportTypeSizes := make(map[string]SizeLimits)
+	for reportType, limits := range defaultReportTypeSizes {
+		reportTypeSizes[reportType] = limits
+	}
+	reportTypeDirs := make(map[string]string)
+	for reportType, dir := range defaultReportTypeDirs {
+		reportTypeDirs[reportType] = dir
+	}
+	return &Config{
+		Sources:               []string{"sabic"},
+		SnapshotDir:           "snapshots/",
+		KeepSnapshots:         10,
+		SnapshotCompression:   compressionGzip,
+		OutputDir:             "PDFs/",
+		StorageLayout:         layoutFlat,
+		ReportTypeSizes:       reportTypeSizes,
+		ReportTypeDirs:        reportTypeDirs,
+		SyncInterval:          Duration{24 * time.Hour},
+		ShutdownGrace:         Duration{25 * time.Second},
+		LeaderLeaseDuration:   Duration{time.Minute},
+		CatalogBatchSize:      500,
+		Environment:           environmentProduction,
+		ChaosMaxDelay:         Duration{5 * time.Second},
+		CatalogFlushInterval:  Duration{10 * time.Second},
+		AuditLog:              "audit.jsonl",
+		CatalogFile:           "catalog.json",
+		ResponseStore:         "responses.jsonl",
+		ManifestDir:           "manifests/",
+		ManifestRetention:     Duration{365 * 24 * time.Hour},
+		AuditRotateSize:       64 << 20,
+		FastSkip:              true,
+		CoalesceFetches:       true,
+		Workers:               1,
+		ScrapeWorkers:         1,
+		ContentTypeCheck:      contentTypeLenient,
+		PostprocessWorkers:    1,
+		QueueSize:             64,
+		RangedThreshold:       64 << 20,
+		RangedSegments:        4,
+		AdaptiveConcurrency:   true,
+		LanguageCheck:         true,
+		HealthWindow:          defaultHealthWindow,
+		MemoryPerFile:         8 << 20,
+		RespectRetryAfter:     true,
+		ThrottleDelay:         Duration{30 * time.Second},
+		MaxRetryAfter:         Duration{10 * time.Minute},
+		ThrottleRetries:       3,
+		ConnectTimeout:        Duration{10 * time.Second},
+		TLSTimeout:            Duration{10 * time.Second},
+		ResponseHeaderTimeout: Duration{30 * time.Second},
+		StallTimeout:          Duration{60 * time.Second},
+		MaxRedirects:          10,
+		CookieSessionTTL:      Duration{8 * time.Hour},
+
+		ServiceURL:       "https://zehsonesdsext-tjd0i1flxa.dispatcher.sa1.hana.ondemand.com/v1/SDS",
+		HeaderEntitySet:  "DocHeaderSet",
+		ContentEntitySet: "DocContentSet",
+		ContentValuePath: "DocContentData/$value",
+		UseMetadata:      true,
+		MetadataCache:    "metadata.xml",
+		ScrapeState:      "scrape-state.json",
+		ScrapeRetries:    3,
+
+		PreviewCommand: []string{"pdftoppm", "-png", "-f", "1", "-l", "1", "-singlefile", "-scale-to", "512", "{input}", "{output_base}"},
+		PDFACommand: []string{"gs", "-dPDFA=1", "-dBATCH", "-dNOPAUSE", "-dNOOUTERSAVE", "-dPDFACompatibilityPolicy=1",
+			"-sColorConversionStrategy=UseDeviceIndependentColor", "-sDEVICE=pdfwrite", "-sOutputFile={output}", "{input}"},
+		PDFADir:           "PDFA/",
+		StampMode:         stampModeOverlay,
+		StampCommand:      []string{"qpdf", "{input}", "--overlay", "{stamp}", "--to=1", "--", "{output}"},
+		StampDir:          "stamped/",
+		ViewsDir:          "views/",
+		ViewMode:          viewModeSymlink,
+		SiteDir:           "site/",
+		BundleCommand:     []string{"qpdf", "--empty", "--pages", "{inputs}", "--", "{output}"},
+		BundleDir:         "bundles/",
+		MirrorState:       "mirror-state.jsonl",
+		SharePointColumns: map[string]string{"material": "Material", "language": "Language", "revision_date": "RevisionDate"},
+
+		AuditSigningKey: "audit-signing-key.pem",
+		AuditReportDir:  "audit-reports/",
+
+		ProductFamilyFields:       []string{"ProductFamily", "Prodh", "Matkl"},
+		MaterialDescriptionFields: []string{"Maktx", "MaterialDescription", "Description"},
+		IssueDateFields:           []string{"Revdat", "RevisionDate", "Valdat", "IssueDate", "Aedat"},
+		ReviewAge:                 Duration{3 * 365 * 24 * time.Hour},
+		RevalidateInterval:        Duration{30 * 24 * time.Hour},
+		PublicationFields:         []string{"Aedat", "Erdat"},
+		SLOTarget:                 Duration{24 * time.Hour},
+		SLOObjective:              0.99,
+
+		SMTPPort:    587,
+		DigestState: "digest-state.json",
+
+		EHSState: "ehs-state.jsonl",
+
+		ListenAddress:          ":8080",
+		OIDCRoleClaim:          "roles",
+		OIDCAdminRole:          "sds-admin",
+		RateLimit:              10,
+		RateBurst:              20,
+		MaxDownloadsPerClient:  4,
+		MaxConcurrentDownloads: 64,
+		AccessCounts:           "access-counts.jsonl",
+		ShareTTL:               Duration{72 * time.Hour},
+		ShareMaxTTL:            Duration{30 * 24 * time.Hour},
+	}
+}
+
+// registerFlags binds the command line flags to the fields of the config.
+func registerFlags(flagSet *flag.FlagSet, cfg *Config) {
+	flagSet.StringVar(&cfg.InputFile, "input", cfg.InputFile, "scraped header JSON file, the latest snapshot in -snapshot-dir when empty")
+	flagSet.StringVar(&cfg.SnapshotDir, "snapshot-dir", cfg.SnapshotDir, "directory of the timestamped header snapshots")
+	flagSet.StringVar(&cfg.RevisionDir, "revision-dir", cfg.RevisionDir, "archive superseded document revisions here, empty disables the archive")
+	flagSet.IntVar(&cfg.RevisionKeep, "revision-keep", cfg.RevisionKeep, "newest archived revisions kept per document by the maintenance command, 0 for no count limit")
+	flagSet.Var(&cfg.RevisionMaxAge, "revision-max-age", "age since being superseded after which the maintenance command removes revisions beyond -revision-keep, e.g. 87600h for ten years; 0 for no age limit")
+	flagSet.StringVar(&cfg.OutputDir, "output", cfg.OutputDir, "directory to store downloaded PDFs")
+	flagSet.StringVar(&cfg.StorageLayout, "layout", cfg.StorageLayout, "layout of the output directory: flat, or cas for content-addressed objects with an index")
+	flagSet.StringVar(&cfg.EncryptionKeyFile, "encryption-key-file", cfg.EncryptionKeyFile, "encrypt stored PDFs with the base64 AES-256 key in this file")
+	flagSet.Int64Var(&cfg.MinSize, "min-size", cfg.MinSize, "reject documents smaller than this many bytes (0 uses the report type default)")
+	flagSet.Int64Var(&cfg.MaxSize, "max-size", cfg.MaxSize, "reject documents larger than this many bytes (0 uses the report type default)")
+	flagSet.StringVar(&cfg.Window, "window", cfg.Window, "only dispatch downloads during these hours, e.g. 22:00-06:00")
+	flagSet.StringVar(&cfg.Timezone, "timezone", cfg.Timezone, "IANA time zone of -window, local time when empty")
+	flagSet.Var(&cfg.SyncInterval, "interval", "pause between daemon sync runs")
+	flagSet.StringVar(&cfg.ProbeAddress, "probe-address", cfg.ProbeAddress, "serve the daemon's /healthz and /readyz on this address, e.g. :8081")
+	flagSet.Var(&cfg.ShutdownGrace, "shutdown-grace", "time a running sync or open requests get to finish after SIGTERM")
+	flagSet.StringVar(&cfg.LeaderElection, "leader-election", cfg.LeaderElection, "let one daemon replica sync at a time: file or kubernetes")
+	flagSet.StringVar(&cfg.LeaderLease, "leader-lease", cfg.LeaderLease, "lease file, or namespace/name of the Kubernetes Lease")
+	flagSet.StringVar(&cfg.RunAsUser, "run-as-user", cfg.RunAsUser, "user to switch to when started as root, once ports are bound")
+	flagSet.StringVar(&cfg.RunAsGroup, "run-as-group", cfg.RunAsGroup, "group to switch to with -run-as-user, the user's primary group when empty")
+	flagSet.IntVar(&cfg.CatalogBatchSize, "catalog-batch-size", cfg.CatalogBatchSize, "changed catalog entries journaled per synced write, 0 writes the catalog only at the end")
+	flagSet.StringVar(&cfg.LockFile, "lock-file", cfg.LockFile, "lock file keeping syncs from overlapping, <output dir>.lock when empty")
+	flagSet.StringVar(&cfg.AuditLog, "audit-log", cfg.AuditLog, "append-only audit log of document retrievals, empty disables it")
+	flagSet.StringVar(&cfg.ReportTypes, "report-types", cfg.ReportTypes, `only fetch documents of these comma separated report types, e.g. "SDS" or "LABEL,TREMCARD"`)
+	flagSet.StringVar(&cfg.Jurisdiction, "jurisdiction", cfg.Jurisdiction, `only fetch documents for these comma separated jurisdictions or countries, e.g. "EU" or "US,CA"`)
+	flagSet.StringVar(&cfg.ProductFamily, "product-family", cfg.ProductFamily, `only fetch documents of these comma separated product families, e.g. "Polypropylene,Polyethylene"`)
+	flagSet.StringVar(&cfg.Locale, "locale", cfg.Locale, `sort material descriptions in this locale's collation, e.g. "ar", "zh-Hans" or "zh-Hant-u-co-stroke"`)
+	flagSet.StringVar(&cfg.LanguageFallback, "languages", cfg.LanguageFallback, `download one document per material, preferring languages in this order, e.g. "EN > FR > local"`)
+	flagSet.StringVar(&cfg.MaterialMap, "material-map", cfg.MaterialMap, "CSV of internal_code,matnr; only mapped materials are fetched and their files carry the internal code")
+	flagSet.StringVar(&cfg.ManifestDir, "manifest-dir", cfg.ManifestDir, "directory of the per-run JSONL manifests, empty disables them")
+	flagSet.Var(&cfg.ManifestRetention, "manifest-retention", "age after which the maintenance command removes run manifests, 0 keeps them all")
+	flagSet.Int64Var(&cfg.AuditRotateSize, "audit-rotate-size", cfg.AuditRotateSize, "bytes above which the maintenance command rotates the audit log, 0 never rotates it")
+	flagSet.BoolVar(&cfg.CoalesceFetches, "coalesce-fetches", cfg.CoalesceFetches, "let concurrent requests for the same document share one upstream fetch")
+	flagSet.BoolVar(&cfg.FastSkip, "fast-skip", cfg.FastSkip, "plan skips against the catalog and one snapshot of the output directory; -fast-skip=false stats every file")
+	flagSet.IntVar(&cfg.Workers, "workers", cfg.Workers, "concurrent downloads of a sync run")
+	flagSet.IntVar(&cfg.ScrapeWorkers, "scrape-workers", cfg.ScrapeWorkers, "header pages the scrape command fetches at once by $skip, 1 follows the __next links one page at a time")
+	flagSet.StringVar(&cfg.ContentTypeCheck, "content-type-check", cfg.ContentTypeCheck, "strict only takes PDFs sent as application/pdf, lenient also takes application/octet-stream and other generic types when the content starts with %PDF-")
+	flagSet.IntVar(&cfg.HashWorkers, "hash-workers", cfg.HashWorkers, "goroutines hashing completed downloads of a sync run, 0 hashes while downloading")
+	flagSet.IntVar(&cfg.PostprocessWorkers, "postprocess-workers", cfg.PostprocessWorkers, "stored documents of a sync run post-processed at once")
+	flagSet.StringVar(&cfg.Profile, "profile", cfg.Profile, `crawl preset: "polite" for one worker, a pause between requests and honoring Retry-After`)
+	flagSet.Var(&cfg.HostDelay, "host-delay", "least time between two requests to the same host")
+	flagSet.IntVar(&cfg.QueueSize, "queue-size", cfg.QueueSize, "documents buffered between pipeline stages")
+	flagSet.BoolVar(&cfg.LanguageCheck, "language-check", cfg.LanguageCheck, "detect the language of each new document and flag ones that differ from the requested Laiso")
+	flagSet.BoolVar(&cfg.AdaptiveConcurrency, "adaptive-concurrency", cfg.AdaptiveConcurrency, "scale concurrent downloads per host down while it errors or slows and back up to -workers as it recovers")
+	flagSet.IntVar(&cfg.HealthWindow, "health-window", cfg.HealthWindow, "latest requests per host the health score covers")
+	flagSet.Int64Var(&cfg.MemoryBudget, "memory-budget", cfg.MemoryBudget, "bytes all download workers may have in flight together, reserved by Content-Length; 0 is unlimited")
+	flagSet.Int64Var(&cfg.RangedThreshold, "ranged-threshold", cfg.RangedThreshold, "fetch documents of at least this many bytes as parallel byte ranges when the server allows; 0 disables it")
+	flagSet.IntVar(&cfg.RangedSegments, "ranged-segments", cfg.RangedSegments, "parallel byte ranges per large document")
+	flagSet.BoolVar(&cfg.RespectRetryAfter, "respect-retry-after", cfg.RespectRetryAfter, "pause all downloads for as long as a 429 or 503 response's Retry-After asks")
+	flagSet.IntVar(&cfg.ThrottleRetries, "throttle-retries", cfg.ThrottleRetries, "times a throttled document is retried after backing off")
+	flagSet.Var(&cfg.StallTimeout, "stall-timeout", "abort a download when no bytes arrive for this long, however long it has run")
+	flagSet.IntVar(&cfg.MaxRedirects, "max-redirects", cfg.MaxRedirects, "redirects an upstream request follows, 0 follows none")
+	flagSet.Func("redirect-hosts", "comma separated hosts redirects may lead to besides the request's own, *.example.com for subdomains; any host when empty", func(value string) error {
+		cfg.RedirectHosts = nil
+		for _, host := range strings.Split(value, ",") {
+			if host = strings.TrimSpace(host); host != "" {
+				cfg.RedirectHosts = append(cfg.RedirectHosts, host)
+			}
+		}
+		return nil
+	})
+	flagSet.StringVar(&cfg.CookieJar, "cookie-jar", cfg.CookieJar, "keep the upstream's cookies in this file between runs")
+	flagSet.Var(&cfg.CookieSessionTTL, "cookie-session-ttl", "how long the cookie jar keeps session cookies")
+	flagSet.Var(&cfg.Resolve, "resolve", "connect to this address for a host, as host=address; repeat for more hosts")
+	flagSet.StringVar(&cfg.IPFamily, "ip-family", cfg.IPFamily, "address family of downloads: ipv4, ipv6, prefer-ipv4 or prefer-ipv6; dual-stack when empty")
+	flagSet.StringVar(&cfg.DNSServer, "dns-server", cfg.DNSServer, "resolve host names with this DNS server instead of the system's")
+	flagSet.Var(&cfg.ResponseHeaderTimeout, "header-timeout", "abort a request when the response headers take longer than this")
+	flagSet.Float64Var(&cfg.Sample, "sample", cfg.Sample, "fetch a deterministic random fraction of the planned documents, e.g. 0.01 to check config and endpoints cheaply")
+	flagSet.IntVar(&cfg.SampleCount, "sample-count", cfg.SampleCount, "fetch a deterministic random subset of this many planned documents")
+	flagSet.Uint64Var(&cfg.SampleSeed, "sample-seed", cfg.SampleSeed, "seed of -sample and -sample-count; the same seed picks the same documents")
+	flagSet.StringVar(&cfg.StagingDir, "staging-dir", cfg.StagingDir, "directory downloads are streamed into before being moved to -output, e.g. local disk when -output is on NFS")
+	flagSet.StringVar(&cfg.ServiceURL, "service-url", cfg.ServiceURL, "root URL of the SDS OData service")
+	flagSet.StringVar(&cfg.HeaderEntitySet, "header-set", cfg.HeaderEntitySet, "entity set listing the documents")
+	flagSet.StringVar(&cfg.ContentEntitySet, "content-set", cfg.ContentEntitySet, "entity set serving the document content")
+	flagSet.BoolVar(&cfg.UseMetadata, "metadata", cfg.UseMetadata, "type header properties from the service's $metadata")
+	flagSet.BoolVar(&cfg.Previews, "previews", cfg.Previews, "render a PNG preview of the first page of each new PDF")
+	flagSet.BoolVar(&cfg.PDFA, "pdfa", cfg.PDFA, "keep a PDF/A-1b copy of each new PDF")
+	flagSet.BoolVar(&cfg.Stamp, "stamp", cfg.Stamp, "keep a copy of each new PDF stamped with its retrieval provenance")
+	flagSet.StringVar(&cfg.StampMode, "stamp-mode", cfg.StampMode, "where the stamp goes, overlay or cover")
+	flagSet.BoolVar(&cfg.Views, "views", cfg.Views, "rebuild the by-language/ and by-material/ views after each sync")
+	flagSet.StringVar(&cfg.SiteURL, "site-url", cfg.SiteURL, "public base URL of the exported site or the server, used in sitemap.xml")
+	flagSet.StringVar(&cfg.SiteDir, "site-dir", cfg.SiteDir, "directory the export-site command writes the static site to")
+	flagSet.StringVar(&cfg.BundleDir, "bundle-dir", cfg.BundleDir, "directory the bundle command writes the merged PDFs to")
+	flagSet.StringVar(&cfg.ViewMode, "view-mode", cfg.ViewMode, "how views are built: symlink, or copy for filesystems and shares that don't follow links")
+	flagSet.Var(&cfg.ReviewAge, "review-age", "age after which a sheet is due for review and fetched again to look for a newer revision, e.g. 26280h for 3 years; 0 disables it")
+	flagSet.Var(&cfg.RevalidateInterval, "revalidate-interval", "how often an overdue document is fetched again")
+	flagSet.Var(&cfg.SLOTarget, "slo-target", "promised time from a document's publication upstream to its local availability, 0 disables tracking")
+	flagSet.Float64Var(&cfg.SLOObjective, "slo-objective", cfg.SLOObjective, "fraction of new documents that must be available within -slo-target")
+	flagSet.Func("slo-alert-to", "comma separated recipients of the daemon's SLO breach alerts, the digest recipients when empty", func(value string) error {
+		cfg.SLOAlertTo = nil
+		for _, recipient := range strings.Split(value, ",") {
+			if recipient = strings.TrimSpace(recipient); recipient != "" {
+				cfg.SLOAlertTo = append(cfg.SLOAlertTo, recipient)
+			}
+		}
+		return nil
+	})
+	flagSet.StringVar(&cfg.PprofAddress, "pprof", cfg.PprofAddress, "serve net/http/pprof on this address, e.g. localhost:6060")
+	flagSet.StringVar(&cfg.CPUProfile, "cpu-profile", cfg.CPUProfile, "write a CPU profile of the run to this file")
+	flagSet.StringVar(&cfg.HeapProfile, "heap-profile", cfg.HeapProfile, "write a heap profile to this file when the run ends")
+	flagSet.StringVar(&cfg.S3LockMode, "s3-lock-mode", cfg.S3LockMode, "Object Lock retention mode of S3 uploads, GOVERNANCE or COMPLIANCE")
+	flagSet.IntVar(&cfg.S3LockDays, "s3-lock-days", cfg.S3LockDays, "days locked S3 uploads are retained")
+	flagSet.BoolVar(&cfg.S3LegalHold, "s3-legal-hold", cfg.S3LegalHold, "place a legal hold on S3 uploads")
+	flagSet.StringVar(&cfg.EHSUploader, "ehs-uploader", cfg.EHSUploader, "push new and updated documents to the EHS system after each sync, rest or folder")
+	flagSet.StringVar(&cfg.EHSEndpoint, "ehs-endpoint", cfg.EHSEndpoint, "upload URL or drop folder of the EHS uploader")
+	flagSet.Var(&cfg.DigestInterval, "digest-interval", "how often the daemon emails a digest of new documents, e.g. 168h")
+	flagSet.StringVar(&cfg.ListenAddress, "listen", cfg.ListenAddress, "address the serve command listens on")
+	flagSet.BoolVar(&cfg.AllowAnonymous, "allow-anonymous", cfg.AllowAnonymous, "give unauthenticated serve clients read-only access")
+	flagSet.StringVar(&cfg.AccessLog, "access-log", cfg.AccessLog, "JSONL access log of the serve command, empty disables it")
+	flagSet.StringVar(&cfg.AccessCounts, "access-counts", cfg.AccessCounts, "file counting document downloads of the serve command, empty disables it")
+	registerChaosFlags(flagSet, cfg)
+	flagSet.Func("alternate-formats", "comma separated representations to fetch besides each PDF, docx or rtf", func(value string) error {
+		cfg.AlternateFormats = nil
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				cfg.AlternateFormats = append(cfg.AlternateFormats, name)
+			}
+		}
+		return nil
+	})
+	flagSet.StringVar(&cfg.RecordFixtures, "record-fixtures", cfg.RecordFixtures, "save sanitized upstream responses into this directory as test fixtures")
+	flagSet.StringVar(&cfg.ReplayFixtures, "replay-fixtures", cfg.ReplayFixtures, "answer upstream requests from the fixtures in this directory instead of the network")
+	flagSet.Float64Var(&cfg.RateLimit, "rate-limit", cfg.RateLimit, "requests per second per serve client, 0 disables it")
+	flagSet.IntVar(&cfg.MaxDownloadsPerClient, "max-downloads-per-client", cfg.MaxDownloadsPerClient, "concurrent document downloads per serve client, 0 is unlimited")
+}
+
+// loadConfig builds the config from defaults, the optional -config file, and the given flags, in that order.
+// commandFlags, when not nil, registers flags only the calling command understands.
+// The remaining positional arguments are returned alongside the config.
+func loadConfig(name string, args []string, commandFlags func(flagSet *flag.FlagSet)) (*Config, []string, error) {
+	cfg := defaultConfig()
+	flagSet := flag.NewFlagSet(name, flag.ContinueOnError)
+	configPath := flagSet.String("config", "", "optional JSON config file")
+	registerFlags(flagSet, cfg)
+	if commandFlags != nil {
+		commandFlags(flagSet)
+	}
+	flagSet.Usage = func() { printCommandUsage(flagSet) }
+	// Shell completion only wants to know the flags.
+	if inspectFlags != nil {
+		inspectFlags(flagSet)
+		return nil, nil, errFlagsInspected
+	}
+	// First pass finds the config file path.
+	if err := flagSet.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if *configPath == "" {
+		return cfg, flagSet.Args(), finishConfig(cfg)
+	}
+	// Read the config file on top of the defaults.
+	fileContent, err := os.ReadFile(*configPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read config file %s: %v", *configPath, err)
+	}
+	if err := json.Unmarshal(fileContent, cfg); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse config file %s: %v", *configPath, err)
+	}
+	// Second pass lets flags given on the command line win over the file.
+	if err := flagSet.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	return cfg, flagSet.Args(), finishConfig(cfg)
+}
+
+// finishConfig applies the crawl profile and sets up redaction once the settings are final.
+func finishConfig(cfg *Config) error {
+	if err := applyCrawlProfile(cfg); err != nil {
+		return err
+	}
+	return installRedaction(cfg)
+}
+
+// sizeLimitsFor returns the size limits for the given report type.
+// Global -min-size/-max-size values win, then the report type entry, then the generic default.
+func (cfg *Config) sizeLimitsFor(reportType string) SizeLimits {
+	limits, ok := cfg.ReportTypeSizes[strings.ToUpper(reportType)]
+	if !ok {
+		limits = defaultSizeLimits
+	}
+	if cfg.MinSize > 0 {
+		limits.MinBytes = cfg.MinSize
+	}
+	if cfg.MaxSize > 0 {
+		limits.MaxBytes = cfg.MaxSize
+	}
+	return limits
+}
+
+// smtpPassword returns the configured SMTP password, falling back to the SMTP_PASSWORD environment variable.
+func (cfg *Config) smtpPassword() string {
+	if cfg.SMTPPassword != "" {
+		return cfg.SMTPPassword
+	}
+	return os.Getenv("SMTP_PASSWORD")
+}
+
+// ehsToken returns the configured EHS token, falling back to the EHS_TOKEN environment variable.
+func (cfg *Config) ehsToken() string {
+	if cfg.EHSToken != "" {
+		return cfg.EHSToken
+	}
+	return os.Getenv("EHS_TOKEN")
+}
+
+// graphClientSecret returns the configured Graph client secret, falling back to the GRAPH_CLIENT_SECRET environment variable.
+func (cfg *Config) graphClientSecret() string {
+	if cfg.GraphClientSecret != "" {
+		return cfg.GraphClientSecret
+	}
+	return os.Getenv("GRAPH_CLIENT_SECRET")
+}
+
+// webDAVPassword returns the configured WebDAV password, falling back to the WEBDAV_PASSWORD environment variable.
+func (cfg *Config) webDAVPassword() string {
+	if cfg.WebDAVPassword != "" {
+		return cfg.WebDAVPassword
+	}
+	return os.Getenv("WEBDAV_PASSWORD")
+}
+
+// shareSigningKey returns the configured link signing key, falling back to the SHARE_SIGNING_KEY environment variable.
+func (cfg *Config) shareSigningKey() string {
+	if cfg.ShareSigningKey != "" {
+		return cfg.ShareSigningKey
+	}
+	return os.Getenv("SHARE_SIGNING_KEY")
+}
+
+// entitySetURL returns the URL of an entity set of the service.
+func (cfg *Config) entitySetURL(entitySet string) string {
+	return strings.TrimSuffix(cfg.ServiceURL, "/") + "/" + entitySet
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// configIssue is one problem found in the config, with the setting at fault and how to fix it.
+type configIssue struct {
+	Setting string // JSON name of the setting, e.g. service_url
+	Problem string
+	Fix     string
+}
+
+// String renders the issue for the log and the config validate command.
+func (issue configIssue) String() string {
+	text := issue.Setting + ": " + issue.Problem
+	if issue.Fix != "" {
+		text += "; " + issue.Fix
+	}
+	return text
+}
+
+// configIssues collects the problems of the config itself, without touching the network or the disk,
+// so every run can afford to check it before starting.
+func configIssues(cfg *Config) []configIssue {
+	var issues []configIssue
+	add := func(setting string, problem string, fix string) {
+		issues = append(issues, configIssue{Setting: setting, Problem: problem, Fix: fix})
+	}
+	// Upstream.
+	if parsed, err := url.Parse(cfg.ServiceURL); cfg.ServiceURL == "" || err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		add("service_url", fmt.Sprintf("%q is not an http(s) URL", cfg.ServiceURL), "set it to the root of the OData service, e.g. https://host/sap/opu/odata/sap/ZSDS_SRV")
+	}
+	if cfg.HeaderEntitySet == "" || cfg.ContentEntitySet == "" {
+		add("header_entity_set", "the header and content entity sets must both be named", "run the discover command to find them")
+	}
+	// Choices.
+	if cfg.Workers < 1 {
+		add("workers", fmt.Sprintf("%d workers can't download anything", cfg.Workers), "use 1 or more")
+	}
+	if cfg.ScrapeWorkers < 1 {
+		add("scrape_workers", fmt.Sprintf("%d workers can't fetch any header page", cfg.ScrapeWorkers), "use 1 or more")
+	}
+	if cfg.MaxRedirects < 0 {
+		add("max_redirects", fmt.Sprintf("%d redirects is negative", cfg.MaxRedirects), "use 0 to follow none")
+	}
+	for _, host := range cfg.RedirectHosts {
+		if strings.ContainsAny(host, "/:") {
+			add("redirect_hosts", fmt.Sprintf("%q is not a host name", host), "list bare host names such as login.example.com, without scheme or port")
+		}
+	}
+	if cfg.CookieSessionTTL.Duration < 0 {
+		add("cookie_session_ttl", fmt.Sprintf("%s is negative", cfg.CookieSessionTTL.Duration), "use 0 to keep no session cookies between runs")
+	}
+	if cfg.HashWorkers < 0 {
+		add("hash_workers", fmt.Sprintf("%d hash workers is negative", cfg.HashWorkers), "use 0 to hash while downloading, or 1 or more")
+	}
+	if cfg.PostprocessWorkers < 1 {
+		add("postprocess_workers", fmt.Sprintf("%d workers can't post-process anything", cfg.PostprocessWorkers), "use 1 or more")
+	}
+	if cfg.StorageLayout != "" && cfg.StorageLayout != layoutFlat && cfg.StorageLayout != layoutCAS {
+		add("storage_layout", fmt.Sprintf("unknown layout %q", cfg.StorageLayout), fmt.Sprintf("use %s or %s", layoutFlat, layoutCAS))
+	}
+	if cfg.SnapshotCompression != "" && cfg.SnapshotCompression != compressionNone && cfg.SnapshotCompression != compressionGzip {
+		add("snapshot_compression", fmt.Sprintf("unknown compression %q", cfg.SnapshotCompression), fmt.Sprintf("use %s or %s", compressionGzip, compressionNone))
+	}
+	if cfg.ViewMode != viewModeSymlink && cfg.ViewMode != viewModeCopy {
+		add("view_mode", fmt.Sprintf("unknown view mode %q", cfg.ViewMode), fmt.Sprintf("use %s or %s", viewModeSymlink, viewModeCopy))
+	}
+	if _, err := parseDownloadWindow(cfg.Window, cfg.Timezone); err != nil {
+		add("window", err.Error(), "use HH:MM-HH:MM and an IANA time zone such as Asia/Riyadh")
+	}
+	if _, err := parseLanguageChain(cfg.LanguageFallback); err != nil {
+		add("language_fallback", err.Error(), `list languages in order of preference, e.g. "EN > FR > local"`)
+	}
+	if _, err := newCollator(cfg.Locale); err != nil {
+		add("locale", err.Error(), "use a BCP 47 tag such as sv or zh-Hant")
+	}
+	// Mutually exclusive and dependent options.
+	if cfg.Sample < 0 || cfg.Sample > 1 {
+		add("sample", fmt.Sprintf("%g is not a fraction", cfg.Sample), "use a value between 0 and 1, e.g. 0.01")
+	}
+	if cfg.Sample > 0 && cfg.SampleCount > 0 {
+		add("sample", "sample and sample_count both pick a subset", "set only one of them")
+	}
+	if cfg.MinSize > 0 && cfg.MaxSize > 0 && cfg.MinSize > cfg.MaxSize {
+		add("min_size", fmt.Sprintf("min_size %d is above max_size %d, so no document can pass", cfg.MinSize, cfg.MaxSize), "lower min_size or raise max_size")
+	}
+	if cfg.Previews && len(cfg.PreviewCommand) == 0 {
+		add("preview_command", "previews are on but no renderer is configured", "set preview_command or turn previews off")
+	}
+	if cfg.PDFA && len(cfg.PDFACommand) == 0 {
+		add("pdfa_command", "pdfa is on but no converter is configured", "set pdfa_command or turn pdfa off")
+	}
+	if cfg.Stamp {
+		switch {
+		case cfg.StampMode != stampModeOverlay && cfg.StampMode != stampModeCover:
+			add("stamp_mode", fmt.Sprintf("unknown stamp mode %q", cfg.StampMode), "use overlay or cover")
+		case cfg.StampMode == stampModeOverlay && len(cfg.StampCommand) == 0:
+			add("stamp_command", "stamping is on but no overlay command is configured", "set stamp_command or use stamp_mode cover")
+		case cfg.StampMode == stampModeCover && len(cfg.BundleCommand) == 0:
+			add("bundle_command", "cover stamps are merged with bundle_command, which is not configured", "set bundle_command or use stamp_mode overlay")
+		}
+	}
+	if key, err := loadEncryptionKey(cfg); err != nil {
+		add("encryption_key_file", err.Error(), "point encryption_key_file or encryption_key_command at a base64 AES-256 key")
+	} else if key != nil {
+		if cfg.Previews || cfg.PDFA || cfg.Stamp {
+			add("encryption_key_file", "previews, PDF/A and stamped copies are written unencrypted next to the encrypted documents", "turn them off or keep their directories off shared storage")
+		}
+		if cfg.Views && cfg.ViewMode != viewModeCopy {
+			add("view_mode", "symlinked views point at encrypted documents", "use view_mode copy for decrypted views")
+		}
+	}
+	if _, err := s3LockHeaders(cfg, time.Now()); err != nil {
+		add("s3_lock_mode", err.Error(), "use GOVERNANCE or COMPLIANCE with s3_lock_days above 0, or leave s3_lock_mode empty")
+	}
+	if _, err := newEHSUploader(cfg); err != nil {
+		add("ehs_uploader", err.Error(), "set ehs_uploader to rest or folder with an ehs_endpoint, or leave it empty")
+	}
+	if cfg.LeaderElection != "" && cfg.LeaderElection != leaderElectionFile && cfg.LeaderElection != leaderElectionKubernetes {
+		add("leader_election", fmt.Sprintf("unknown leader election %q", cfg.LeaderElection), fmt.Sprintf("use %s or %s, or leave it empty", leaderElectionFile, leaderElectionKubernetes))
+	} else if cfg.LeaderElection != "" && cfg.LeaderLeaseDuration.Duration < 3*time.Second {
+		add("leader_lease_duration", fmt.Sprintf("a lease of %s is renewed too often to be reliable", cfg.LeaderLeaseDuration), "use 15s or more")
+	}
+	if cfg.Environment != environmentProduction && cfg.Environment != environmentStaging && cfg.Environment != environmentTest {
+		add("environment", fmt.Sprintf("unknown environment %q", cfg.Environment), fmt.Sprintf("use %s, %s or %s", environmentProduction, environmentStaging, environmentTest))
+	} else if chaosConfigured(cfg) && cfg.Environment == environmentProduction {
+		add("chaos_failure_rate", "failure injection is configured but ignored in production", "set environment to staging or test, or drop the chaos settings")
+	}
+	for setting, rate := range map[string]float64{"chaos_failure_rate": cfg.ChaosFailureRate, "chaos_delay_rate": cfg.ChaosDelayRate, "chaos_truncate_rate": cfg.ChaosTruncateRate, "chaos_content_type_rate": cfg.ChaosContentTypeRate} {
+		if rate < 0 || rate > 1 {
+			add(setting, fmt.Sprintf("%g is not a fraction", rate), "use a value between 0 and 1, e.g. 0.05")
+		}
+	}
+	if cfg.RecordFixtures != "" && cfg.ReplayFixtures != "" {
+		add("record_fixtures", "recording and replaying fixtures at once would record the fixtures themselves", "set only one of record_fixtures and replay_fixtures")
+	}
+	if cfg.SLOTarget.Duration < 0 {
+		add("slo_target", fmt.Sprintf("%s is negative", cfg.SLOTarget.Duration), "use 0 to disable tracking, or a duration such as 24h")
+	}
+	if cfg.SLOObjective <= 0 || cfg.SLOObjective > 1 {
+		add("slo_objective", fmt.Sprintf("%g is not a fraction", cfg.SLOObjective), "use a value above 0 and up to 1, e.g. 0.99")
+	}
+	if len(cfg.SLOAlertTo) > 0 && (cfg.SMTPHost == "" || cfg.DigestFrom == "") {
+		add("slo_alert_to", "alerts have recipients but smtp_host or digest_from is missing", "set both to email SLO breaches")
+	}
+	if cfg.DigestInterval.Duration > 0 && (cfg.SMTPHost == "" || cfg.DigestFrom == "" || len(cfg.DigestTo) == 0) {
+		add("digest_interval", "digests are scheduled but smtp_host, digest_from or digest_to is missing", "set all three or set digest_interval to 0")
+	}
+	for i, key := range cfg.APIKeys {
+		if key.Key == "" || (key.Role != roleReader && key.Role != roleAdmin) {
+			add(fmt.Sprintf("api_keys[%d]", i), fmt.Sprintf("key %q needs a secret and the role %s or %s", key.Name, roleReader, roleAdmin), "")
+		}
+	}
+	if cfg.OIDCIssuer != "" && cfg.OIDCAudience == "" {
+		add("oidc_audience", "tokens are accepted whatever client the issuer minted them for", "set it to the aud claim tokens for this server carry")
+	}
+	// Report type trees live directly in the output directory, one per report type.
+	treeOwners := make(map[string]string)
+	for _, reportType := range sortedKeys(cfg.ReportTypeDirs) {
+		dir := cfg.ReportTypeDirs[reportType]
+		switch {
+		case dir == "" || dir != filepath.Base(dir) || dir == "." || dir == ".." || strings.HasPrefix(dir, "."):
+			add("report_type_dirs."+reportType, fmt.Sprintf("%q is not a plain directory name", dir), "name a subdirectory of output_dir, e.g. labels")
+		case treeOwners[dir] != "":
+			add("report_type_dirs."+reportType, fmt.Sprintf("%s already holds %s documents", dir, treeOwners[dir]), "give every report type its own directory")
+		default:
+			treeOwners[dir] = reportType
+		}
+	}
+	// Settings with their own validators.
+	for _, check := range []struct {
+		setting  string
+		validate func(cfg *Config) error
+	}{
+		{"ip_family", validateNetworkOptions},
+		{"validation", validateValidationChecks},
+		{"alternate_formats", validateAlternateFormats},
+		{"content_type_check", validateContentTypeCheck},
+		{"retry_policies", validateRetryPolicies},
+	} {
+		if err := check.validate(cfg); err != nil {
+			add(check.setting, err.Error(), "")
+		}
+	}
+	return issues
+}
+
+// validateConfig fails with every problem of the config at once, so a bad config stops a run up front
+// instead of surfacing as odd failures halfway through.
+func validateConfig(cfg *Config) error {
+	issues := configIssues(cfg)
+	if len(issues) == 0 {
+		return nil
+	}
+	lines := make([]string, len(issues))
+	for i, issue := range issues {
+		lines[i] = "  " + issue.String()
+	}
+	return fmt.Errorf("invalid config:\n%s", strings.Join(lines, "\n"))
+}
+
+// environmentIssues checks what the config points at: directories it writes to, files it reads,
+// credentials it needs and whether the service answers. Only config validate runs these.
+func environmentIssues(ctx context.Context, cfg *Config) []configIssue {
+	var issues []configIssue
+	// Directories written during a run.
+	writable := []struct {
+		setting string
+		dir     string
+	}{
+		{"output_dir", cfg.OutputDir},
+		{"staging_dir", cfg.StagingDir},
+		{"snapshot_dir", cfg.SnapshotDir},
+		{"manifest_dir", cfg.ManifestDir},
+		{"revision_dir", cfg.RevisionDir},
+		{"catalog_file", filepath.Dir(cfg.CatalogFile)},
+		{"lock_file", filepath.Dir(runLockPath(cfg))},
+	}
+	if cfg.AuditLog != "" {
+		writable = append(writable, struct {
+			setting string
+			dir     string
+		}{"audit_log", filepath.Dir(cfg.AuditLog)})
+	}
+	if cfg.CookieJar != "" {
+		writable = append(writable, struct {
+			setting string
+			dir     string
+		}{"cookie_jar", filepath.Dir(cfg.CookieJar)})
+	}
+	for _, target := range writable {
+		if target.dir == "" {
+			continue
+		}
+		if err := checkWritableDir(target.dir); err != nil {
+			issues = append(issues, configIssue{Setting: target.setting, Problem: err.Error(), Fix: "create the directory or grant this account write access"})
+		}
+	}
+	// Files read during a run.
+	if cfg.MaterialMap != "" && !fileExists(cfg.MaterialMap) {
+		issues = append(issues, configIssue{Setting: "material_map", Problem: cfg.MaterialMap + " does not exist", Fix: "fix the path or unset material_map"})
+	}
+	if _, err := resolveInputFile(cfg); err != nil {
+		issues = append(issues, configIssue{Setting: "input_file", Problem: err.Error(), Fix: "run the scrape command or set input_file"})
+	}
+	// Credentials.
+	if cfg.SMTPUsername != "" && cfg.smtpPassword() == "" {
+		issues = append(issues, configIssue{Setting: "smtp_password", Problem: "smtp_username is set but there is no password in the config or environment", Fix: "set smtp_password or the SMTP_PASSWORD environment variable"})
+	}
+	// The service.
+	if err := checkServiceReachable(ctx, cfg); err != nil {
+		issues = append(issues, configIssue{Setting: "service_url", Problem: err.Error(), Fix: "check the URL, proxy, resolve and dns_server settings"})
+	}
+	return issues
+}
+
+// checkWritableDir checks that dir, or the nearest parent that exists when it will be created, takes new files.
+func checkWritableDir(dir string) error {
+	existing := dir
+	for {
+		info, err := os.Stat(existing)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", existing)
+			}
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return fmt.Errorf("no part of %s exists", dir)
+		}
+		existing = parent
+	}
+	probe, err := os.CreateTemp(existing, ".config-validate-*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %v", existing, err)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// checkServiceReachable asks the service root for its service document.
+func checkServiceReachable(ctx context.Context, cfg *Config) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.ServiceURL, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	response, err := newDownloadClient(cfg).Do(request)
+	if err != nil {
+		var timeoutErr interface{ Timeout() bool }
+		if errors.As(err, &timeoutErr) && timeoutErr.Timeout() {
+			return fmt.Errorf("%s did not answer within 30s", cfg.ServiceURL)
+		}
+		return fmt.Errorf("%s is unreachable: %v", cfg.ServiceURL, err)
+	}
+	response.Body.Close()
+	switch {
+	case response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%s rejected the request with %s; credentials are missing or wrong", cfg.ServiceURL, response.Status)
+	case response.StatusCode >= 500:
+		return fmt.Errorf("%s answered %s", cfg.ServiceURL, response.Status)
+	}
+	return nil
+}
+
+// runConfigCommand implements the config command; config validate checks the config and what it points at.
+func runConfigCommand(args []string) error {
+	if len(args) == 0 || args[0] != "validate" {
+		return fmt.Errorf("usage: config validate [flags]")
+	}
+	cfg, _, err := loadConfig("config validate", args[1:], nil)
+	if err != nil {
+		return err
+	}
+	issues := append(configIssues(cfg), environmentIssues(context.Background(), cfg)...)
+	for _, issue := range issues {
+		fmt.Printf("✗ %s: %s\n", issue.Setting, issue.Problem)
+		if issue.Fix != "" {
+			fmt.Printf("    fix: %s\n", issue.Fix)
+		}
+	}
+	if len(issues) > 0 {
+		return fmt.Errorf("config has %d problems", len(issues))
+	}
+	fmt.Println("config is valid")
+	return nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// cookieJarMagic starts an encrypted cookie jar file; the GCM nonce and the sealed JSON follow it.
+const cookieJarMagic = "SDSJAR1\n"
+
+// storedCookie is one cookie of the jar file.
+type storedCookie struct {
+	URL      string    `json:"url"` // Where it was set, which the jar needs to take it back
+	Name     string    `json:"name"`
+	Value    string    `json:"value"`
+	Domain   string    `json:"domain,omitempty"`
+	Path     string    `json:"path,omitempty"`
+	Secure   bool      `json:"secure,omitempty"`
+	HTTPOnly bool      `json:"http_only,omitempty"`
+	Expires  time.Time `json:"expires"` // Its own expiry, or cookie_session_ttl after it was set for a session cookie
+}
+
+// persistentJar is a cookie jar kept in a file between runs, so a session the gateway handed out
+// outlives the run that got it instead of every run logging in again. The file is rewritten
+// whenever the upstream sets or clears a cookie, encrypted with the store's key when there is one.
+type persistentJar struct {
+	mutex      sync.Mutex
+	jar        *cookiejar.Jar
+	path       string
+	key        []byte                  // Key the file is encrypted with, nil for plain JSON
+	sessionTTL time.Duration           // How long cookies without an expiry of their own are kept
+	cookies    map[string]storedCookie // By host, domain, path and name
+}
+
+// upstreamJars are the jars opened so far by file, shared by every client of the process.
+var (
+	upstreamJarsMutex sync.Mutex
+	upstreamJars      = make(map[string]*persistentJar)
+)
+
+// upstreamCookieJar returns the cookie jar of upstream requests, nil when cookie_jar is not set. A jar
+// that can't be read, such as one encrypted with another key, is started afresh.
+func upstreamCookieJar(cfg *Config) http.CookieJar {
+	if cfg.CookieJar == "" {
+		return nil
+	}
+	upstreamJarsMutex.Lock()
+	defer upstreamJarsMutex.Unlock()
+	if jar, ok := upstreamJars[cfg.CookieJar]; ok {
+		return jar
+	}
+	inner, _ := cookiejar.New(nil)
+	jar := &persistentJar{jar: inner, path: cfg.CookieJar, sessionTTL: cfg.CookieSessionTTL.Duration, cookies: make(map[string]storedCookie)}
+	key, err := loadEncryptionKey(cfg)
+	if err != nil {
+		log.Printf("cookie jar %s: %v; keeping cookies for this run only", cfg.CookieJar, err)
+		jar.path = ""
+	} else if key == nil {
+		log.Printf("cookie jar %s is kept unencrypted; set encryption_key_file to encrypt it", cfg.CookieJar)
+	}
+	jar.key = key
+	if jar.path != "" {
+		if err := jar.load(); err != nil {
+			log.Printf("starting an empty cookie jar, failed to read %s: %v", cfg.CookieJar, err)
+		}
+	}
+	upstreamJars[cfg.CookieJar] = jar
+	return jar
+}
+
+// storedCookieKey identifies a cookie the way the jar replaces them.
+func storedCookieKey(host string, cookie *http.Cookie) string {
+	return host + "\x00" + cookie.Domain + "\x00" + cookie.Path + "\x00" + cookie.Name
+}
+
+// SetCookies implements http.CookieJar.
+func (jar *persistentJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	jar.jar.SetCookies(u, cookies)
+	now := time.Now()
+	jar.mutex.Lock()
+	defer jar.mutex.Unlock()
+	for _, cookie := range cookies {
+		key := storedCookieKey(u.Hostname(), cookie)
+		expires := cookie.Expires
+		switch {
+		case cookie.MaxAge < 0:
+			expires = now
+		case cookie.MaxAge > 0:
+			expires = now.Add(time.Duration(cookie.MaxAge) * time.Second)
+		case expires.IsZero():
+			expires = now.Add(jar.sessionTTL)
+		}
+		if !expires.After(now) {
+			delete(jar.cookies, key)
+			continue
+		}
+		jar.cookies[key] = storedCookie{URL: u.Scheme + "://" + u.Host + "/", Name: cookie.Name, Value: cookie.Value, Domain: cookie.Domain,
+			Path: cookie.Path, Secure: cookie.Secure, HTTPOnly: cookie.HttpOnly, Expires: expires.UTC()}
+	}
+	if err := jar.save(); err != nil {
+		log.Printf("Failed to save cookie jar %s: %v", jar.path, err)
+	}
+}
+
+// Cookies implements http.CookieJar.
+func (jar *persistentJar) Cookies(u *url.URL) []*http.Cookie {
+	return jar.jar.Cookies(u)
+}
+
+// load fills the jar from its file, leaving out expired cookies. A missing file is an empty jar.
+func (jar *persistentJar) load() error {
+	content, err := os.ReadFile(jar.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if encrypted, ok := bytes.CutPrefix(content, []byte(cookieJarMagic)); ok {
+		if jar.key == nil {
+			return fmt.Errorf("the jar is encrypted and no encryption key is configured")
+		}
+		gcm, err := newGCM(jar.key)
+		if err != nil {
+			return err
+		}
+		if len(encrypted) < gcm.NonceSize() {
+			return fmt.Errorf("the jar is truncated")
+		}
+		content, err = gcm.Open(nil, encrypted[:gcm.NonceSize()], encrypted[gcm.NonceSize():], []byte(cookieJarMagic))
+		if err != nil {
+			return fmt.Errorf("the jar doesn't decrypt with the configured key")
+		}
+	}
+	var stored []storedCookie
+	if err := json.Unmarshal(content, &stored); err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, cookie := range stored {
+		origin, err := url.Parse(cookie.URL)
+		if err != nil || !cookie.Expires.After(now) {
+			continue
+		}
+		restored := &http.Cookie{Name: cookie.Name, Value: cookie.Value, Domain: cookie.Domain, Path: cookie.Path,
+			Secure: cookie.Secure, HttpOnly: cookie.HTTPOnly, Expires: cookie.Expires}
+		jar.jar.SetCookies(origin, []*http.Cookie{restored})
+		jar.cookies[storedCookieKey(origin.Hostname(), restored)] = cookie
+	}
+	return nil
+}
+
+// save writes the unexpired cookies to the jar file, readable by this account only. Called with the mutex held.
+func (jar *persistentJar) save() error {
+	if jar.path == "" {
+		return nil
+	}
+	now := time.Now()
+	stored := make([]storedCookie, 0, len(jar.cookies))
+	for _, key := range sortedKeys(jar.cookies) {
+		if cookie := jar.cookies[key]; cookie.Expires.After(now) {
+			stored = append(stored, cookie)
+		}
+	}
+	content, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	if jar.key != nil {
+		gcm, err := newGCM(jar.key)
+		if err != nil {
+			return err
+		}
+		nonce := make([]byte, gcm.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		content = gcm.Seal(append([]byte(cookieJarMagic), nonce...), nonce, content, []byte(cookieJarMagic))
+	}
+	return writeFileAtomically(jar.path, content, 0o600)
+}
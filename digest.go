@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// digestState remembers when the last digest went out.
+type digestState struct {
+	LastSent time.Time `json:"last_sent"`
+}
+
+// digestReport lists what changed in the corpus between two digests.
+type digestReport struct {
+	Since        time.Time
+	Until        time.Time
+	NewMaterials []string // Materials downloaded for the first time
+	NewDocuments []string // First download of a document for a known material
+	Revised      []string // Documents downloaded again with different content
+}
+
+// readDigestState loads the digest state, a missing file means no digest was sent yet.
+func readDigestState(path string) digestState {
+	var state digestState
+	fileContent, err := os.ReadFile(path)
+	if err != nil {
+		return state
+	}
+	if err := json.Unmarshal(fileContent, &state); err != nil {
+		log.Println("Failed to parse digest state:", err)
+	}
+	return state
+}
+
+// writeDigestState saves the digest state.
+func writeDigestState(path string, state digestState) error {
+	encoded, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, encoded, 0o644)
+}
+
+// materialFromFilename returns the Matnr part of a document file name.
+func materialFromFilename(filename string) string {
+	_, filename = splitInternalCode(filename)
+	material, _, _ := strings.Cut(filename, "_")
+	return material
+}
+
+// buildDigest walks the audit log, with the segments rotated out of it, and collects the downloads that happened after since.
+func buildDigest(auditPath string, since time.Time) (*digestReport, error) {
+	report := &digestReport{Since: since, Until: time.Now().UTC()}
+	knownMaterials := make(map[string]bool)   // Materials seen before since
+	knownDocuments := make(map[string]string) // Document to last hash seen before since
+	newMaterials := make(map[string]bool)
+	err := readAuditHistory(auditPath, func(entry auditEntry) error {
+		if entry.Action != auditDownloaded {
+			return nil
+		}
+		material := materialFromFilename(entry.Document)
+		// Entries before the window only build up what was already known.
+		if !entry.Time.After(since) {
+			knownMaterials[material] = true
+			knownDocuments[entry.Document] = entry.SHA256
+			return nil
+		}
+		previousHash, seen := knownDocuments[entry.Document]
+		switch {
+		case seen && previousHash != entry.SHA256:
+			report.Revised = append(report.Revised, entry.Document)
+		case seen:
+			// Same content downloaded again, nothing to report.
+		case !knownMaterials[material]:
+			newMaterials[material] = true
+			report.NewDocuments = append(report.NewDocuments, entry.Document)
+		default:
+			report.NewDocuments = append(report.NewDocuments, entry.Document)
+		}
+		knownDocuments[entry.Document] = entry.SHA256
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for material := range newMaterials {
+		report.NewMaterials = append(report.NewMaterials, material)
+	}
+	sort.Strings(report.NewMaterials)
+	sort.Strings(report.NewDocuments)
+	sort.Strings(report.Revised)
+	return report, nil
+}
+
+// documentLink returns the link used for a document in the digest.
+func documentLink(cfg *Config, store documentStore, filename string) string {
+	if cfg.DigestLinkBase != "" {
+		return strings.TrimSuffix(cfg.DigestLinkBase, "/") + "/" + filename
+	}
+	// Fall back to the absolute path of the local copy.
+	localPath, ok := store.path(filename)
+	if !ok {
+		localPath = filepath.Join(cfg.OutputDir, filename)
+	}
+	absolute, err := filepath.Abs(localPath)
+	if err != nil {
+		return localPath
+	}
+	return "file://" + filepath.ToSlash(absolute)
+}
+
+// formatDigest renders the report as a plain text email body.
+func formatDigest(cfg *Config, store documentStore, report *digestReport) string {
+	var body strings.Builder
+	fmt.Fprintf(&body, "SABIC SDS digest for %s to %s\n\n", report.Since.Format("2006-01-02"), report.Until.Format("2006-01-02"))
+	fmt.Fprintf(&body, "New materials: %d\n", len(report.NewMaterials))
+	for _, material := range report.NewMaterials {
+		fmt.Fprintf(&body, "  %s\n", material)
+	}
+	fmt.Fprintf(&body, "\nNew documents: %d\n", len(report.NewDocuments))
+	for _, document := range report.NewDocuments {
+		fmt.Fprintf(&body, "  %s\n", documentLink(cfg, store, document))
+	}
+	fmt.Fprintf(&body, "\nRevised documents: %d\n", len(report.Revised))
+	for _, document := range report.Revised {
+		fmt.Fprintf(&body, "  %s\n", documentLink(cfg, store, document))
+	}
+	return body.String()
+}
+
+// sendDigestEmail sends the digest through the configured SMTP server.
+func sendDigestEmail(cfg *Config, subject, body string) error {
+	if cfg.SMTPHost == "" || len(cfg.DigestTo) == 0 {
+		return fmt.Errorf("smtp_host and digest_to must be configured to send the digest")
+	}
+	return sendEmail(cfg, cfg.DigestTo, subject, body)
+}
+
+// sendEmail sends a plain text email from digest_from through the configured SMTP server.
+func sendEmail(cfg *Config, recipients []string, subject, body string) error {
+	// Authenticate only when a user name is configured.
+	var auth smtp.Auth
+	if cfg.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.smtpPassword(), cfg.SMTPHost)
+	}
+	message := "From: " + cfg.DigestFrom + "\r\n" +
+		"To: " + strings.Join(recipients, ", ") + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + strings.ReplaceAll(body, "\n", "\r\n")
+	address := fmt.Sprintf("%s:%d", cfg.SMTPHost, cfg.SMTPPort)
+	return smtp.SendMail(address, auth, cfg.DigestFrom, recipients, []byte(message))
+}
+
+// sendDigest builds the digest since the last one, emails it (or prints it on a dry run) and records the time.
+func sendDigest(cfg *Config, dryRun bool) error {
+	state := readDigestState(cfg.DigestState)
+	report, err := buildDigest(cfg.AuditLog, state.LastSent)
+	if err != nil {
+		return err
+	}
+	store, err := openDocumentStore(cfg)
+	if err != nil {
+		return err
+	}
+	body := formatDigest(cfg, store, report)
+	if dryRun {
+		fmt.Print(body)
+		return nil
+	}
+	subject := fmt.Sprintf("SABIC SDS digest: %d new materials, %d new and %d revised documents",
+		len(report.NewMaterials), len(report.NewDocuments), len(report.Revised))
+	if err := sendDigestEmail(cfg, subject, body); err != nil {
+		return fmt.Errorf("failed to send digest: %v", err)
+	}
+	log.Printf("digest sent to %s", strings.Join(cfg.DigestTo, ", "))
+	return writeDigestState(cfg.DigestState, digestState{LastSent: report.Until})
+}
+
+// digestDue reports whether the daemon should send a digest now.
+func digestDue(cfg *Config) bool {
+	if cfg.DigestInterval.Duration <= 0 || cfg.SMTPHost == "" {
+		return false
+	}
+	state := readDigestState(cfg.DigestState)
+	return time.Since(state.LastSent) >= cfg.DigestInterval.Duration
+}
+
+// runDigest implements the digest command.
+func runDigest(args []string) error {
+	var dryRun bool
+	cfg, _, err := loadConfig("digest", args, func(flagSet *flag.FlagSet) {
+		flagSet.BoolVar(&dryRun, "dry-run", false, "print the digest instead of emailing it")
+	})
+	if err != nil {
+		return err
+	}
+	return sendDigest(cfg, dryRun)
+}
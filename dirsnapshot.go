@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// snapshotFile is what a directory snapshot knows about one file.
+type snapshotFile struct {
+	size    int64
+	modTime time.Time
+}
+
+// dirSnapshot is the output directory tree read once into memory, so skip planning for a large run
+// looks files up in a map instead of asking the filesystem about each document.
+type dirSnapshot struct {
+	taken time.Time               // When the walk started
+	files map[string]snapshotFile // Regular files by path, as filepath.Join builds them
+}
+
+// takeDirSnapshot walks dir once, recording the size and modification time of every regular file.
+// Hidden directories hold temporary files and are not entered. A missing dir is an empty snapshot.
+func takeDirSnapshot(dir string) (*dirSnapshot, error) {
+	snapshot := &dirSnapshot{taken: time.Now(), files: make(map[string]snapshotFile)}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if entry.IsDir() {
+			if path != dir && strings.HasPrefix(entry.Name(), ".") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// Removed while walking; planning falls back to a stat for it.
+			return nil
+		}
+		snapshot.files[path] = snapshotFile{size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// lookup returns the snapshot of the file at path. A file changed after the walk started is reported
+// as unknown, as its size may be that of a write in progress.
+func (snapshot *dirSnapshot) lookup(path string) (snapshotFile, bool) {
+	file, ok := snapshot.files[path]
+	if !ok || file.modTime.After(snapshot.taken) {
+		return snapshotFile{}, false
+	}
+	return file, true
+}
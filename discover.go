@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// edmxDocument is the part of an OData v2 $metadata document the tool reads.
+type edmxDocument struct {
+	Schemas []edmxSchema `xml:"DataServices>Schema"`
+}
+
+// edmxSchema is one schema of the service.
+type edmxSchema struct {
+	Namespace   string `xml:"Namespace,attr"`
+	EntityTypes []struct {
+		Name string `xml:"Name,attr"`
+		Keys []struct {
+			Name string `xml:"Name,attr"`
+		} `xml:"Key>PropertyRef"`
+		Properties []edmxProperty `xml:"Property"`
+	} `xml:"EntityType"`
+	EntitySets []struct {
+		Name       string `xml:"Name,attr"`
+		EntityType string `xml:"EntityType,attr"`
+	} `xml:"EntityContainer>EntitySet"`
+}
+
+// edmxProperty is one property of an entity type.
+type edmxProperty struct {
+	Name     string `xml:"Name,attr"`
+	Type     string `xml:"Type,attr"`
+	Nullable string `xml:"Nullable,attr"`
+}
+
+// entitySetInfo describes an entity set the service exposes.
+type entitySetInfo struct {
+	Name       string         `json:"name"`        // Entity set name, e.g. DocHeaderSet
+	EntityType string         `json:"entity_type"` // Qualified entity type
+	Keys       []string       `json:"keys"`        // Key properties
+	Properties []edmxProperty `json:"properties"`  // Every property of the type
+}
+
+// parseMetadata lists the entity sets of a $metadata document with their keys and properties.
+func parseMetadata(data []byte) ([]entitySetInfo, error) {
+	var document edmxDocument
+	if err := xml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("failed to parse $metadata: %v", err)
+	}
+	// Index the entity types by qualified and plain name.
+	type typeInfo struct {
+		keys       []string
+		properties []edmxProperty
+	}
+	types := make(map[string]typeInfo)
+	for _, schema := range document.Schemas {
+		for _, entityType := range schema.EntityTypes {
+			info := typeInfo{properties: entityType.Properties}
+			for _, key := range entityType.Keys {
+				info.keys = append(info.keys, key.Name)
+			}
+			types[schema.Namespace+"."+entityType.Name] = info
+			types[entityType.Name] = info
+		}
+	}
+	var sets []entitySetInfo
+	for _, schema := range document.Schemas {
+		for _, set := range schema.EntitySets {
+			info := types[set.EntityType]
+			sets = append(sets, entitySetInfo{Name: set.Name, EntityType: set.EntityType, Keys: info.keys, Properties: info.properties})
+		}
+	}
+	if len(sets) == 0 {
+		return nil, fmt.Errorf("$metadata lists no entity sets")
+	}
+	sort.Slice(sets, func(i, j int) bool { return sets[i].Name < sets[j].Name })
+	return sets, nil
+}
+
+// fetchMetadata downloads the $metadata document of the service.
+func fetchMetadata(serviceURL string) ([]byte, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	metadataURL := strings.TrimSuffix(serviceURL, "/") + "/$metadata"
+	resp, err := client.Get(metadataURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %v", metadataURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: %s", metadataURL, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// scaffoldConfig returns a config file fragment for the discovered sets.
+// The header and content sets are guessed from their names and should be checked by hand.
+func scaffoldConfig(serviceURL string, sets []entitySetInfo) map[string]any {
+	scaffold := map[string]any{"service_url": serviceURL}
+	entitySets := make(map[string][]string)
+	for _, set := range sets {
+		var names []string
+		for _, property := range set.Properties {
+			names = append(names, property.Name)
+		}
+		entitySets[set.Name] = names
+		switch {
+		case strings.HasSuffix(set.Name, "HeaderSet"):
+			scaffold["header_entity_set"] = set.Name
+		case strings.HasSuffix(set.Name, "ContentSet"):
+			scaffold["content_entity_set"] = set.Name
+		}
+	}
+	scaffold["entity_sets"] = entitySets
+	return scaffold
+}
+
+// runDiscover implements the discover command: it lists the entity sets of the service
+// and, with -scaffold, prints a config fragment for them.
+func runDiscover(args []string) error {
+	var scaffold bool
+	var metadataFile string
+	cfg, _, err := loadConfig("discover", args, func(flagSet *flag.FlagSet) {
+		flagSet.BoolVar(&scaffold, "scaffold", false, "print a config file fragment instead of the listing")
+		flagSet.StringVar(&metadataFile, "metadata-file", "", "read $metadata from this file instead of the service")
+	})
+	if err != nil {
+		return err
+	}
+	// Read the metadata from disk when the service isn't reachable.
+	var data []byte
+	if metadataFile != "" {
+		data, err = os.ReadFile(metadataFile)
+	} else {
+		data, err = fetchMetadata(cfg.ServiceURL)
+	}
+	if err != nil {
+		return err
+	}
+	sets, err := parseMetadata(data)
+	if err != nil {
+		return err
+	}
+	if scaffold {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(scaffoldConfig(cfg.ServiceURL, sets))
+	}
+	for _, set := range sets {
+		fmt.Printf("%s (%s), keys %s\n", set.Name, set.EntityType, strings.Join(set.Keys, ", "))
+		for _, property := range set.Properties {
+			fmt.Printf("  %-24s %s\n", property.Name, property.Type)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Address families the download client connects over, see Config.IPFamily.
+const (
+	ipFamilyAny        = ""            // Dual-stack, racing both families (Happy Eyeballs)
+	ipFamilyIPv4       = "ipv4"        // IPv4 only
+	ipFamilyIPv6       = "ipv6"        // IPv6 only
+	ipFamilyPreferIPv4 = "prefer-ipv4" // IPv4 first, IPv6 when no IPv4 address answers
+	ipFamilyPreferIPv6 = "prefer-ipv6" // IPv6 first, IPv4 when no IPv6 address answers
+)
+
+// hostOverrides pins host names to addresses, like an /etc/hosts entry only the tool sees.
+// The request keeps its host name, so TLS still checks the certificate against it.
+type hostOverrides map[string]string
+
+// String implements flag.Value.
+func (overrides *hostOverrides) String() string {
+	if overrides == nil {
+		return ""
+	}
+	pairs := make([]string, 0, len(*overrides))
+	for host, addr := range *overrides {
+		pairs = append(pairs, host+"="+addr)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Set implements flag.Value, adding one host=address pair.
+func (overrides *hostOverrides) Set(text string) error {
+	host, addr, ok := strings.Cut(text, "=")
+	if !ok || host == "" || net.ParseIP(addr) == nil {
+		return fmt.Errorf("expected host=address with an IP address, got %q", text)
+	}
+	if *overrides == nil {
+		*overrides = make(hostOverrides)
+	}
+	(*overrides)[strings.ToLower(host)] = addr
+	return nil
+}
+
+// validateNetworkOptions reports a bad address family, override or DNS server up front.
+func validateNetworkOptions(cfg *Config) error {
+	switch cfg.IPFamily {
+	case ipFamilyAny, ipFamilyIPv4, ipFamilyIPv6, ipFamilyPreferIPv4, ipFamilyPreferIPv6:
+	default:
+		return fmt.Errorf("unknown ip_family %q, expected %s, %s, %s or %s", cfg.IPFamily, ipFamilyIPv4, ipFamilyIPv6, ipFamilyPreferIPv4, ipFamilyPreferIPv6)
+	}
+	for host, addr := range cfg.Resolve {
+		if net.ParseIP(addr) == nil {
+			return fmt.Errorf("resolve: %s maps to %q, which is not an IP address", host, addr)
+		}
+	}
+	if cfg.DNSServer != "" {
+		if _, _, err := net.SplitHostPort(dnsServerAddress(cfg.DNSServer)); err != nil {
+			return fmt.Errorf("invalid dns_server %q: %v", cfg.DNSServer, err)
+		}
+	}
+	return nil
+}
+
+// dnsServerAddress adds the DNS port to a server given without one.
+func dnsServerAddress(server string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(strings.Trim(server, "[]"), "53")
+}
+
+// networkDialer dials with the host overrides, address family and DNS server of the config.
+type networkDialer struct {
+	dialer    *net.Dialer
+	overrides hostOverrides
+	family    string
+}
+
+// newNetworkDialer wraps dialer; a configured DNS server replaces the system resolver for every lookup.
+func newNetworkDialer(cfg *Config, dialer *net.Dialer) *networkDialer {
+	if cfg.DNSServer != "" {
+		server := dnsServerAddress(cfg.DNSServer)
+		dnsDialer := &net.Dialer{Timeout: dialer.Timeout}
+		dialer.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+				return dnsDialer.DialContext(ctx, network, server)
+			},
+		}
+	}
+	return &networkDialer{dialer: dialer, overrides: cfg.Resolve, family: cfg.IPFamily}
+}
+
+// DialContext has the signature of http.Transport.DialContext.
+func (dialer *networkDialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if pinned, ok := dialer.overrides[strings.ToLower(host)]; ok {
+		host = pinned
+	}
+	switch dialer.family {
+	case ipFamilyIPv4:
+		return dialer.dialer.DialContext(ctx, "tcp4", net.JoinHostPort(host, port))
+	case ipFamilyIPv6:
+		return dialer.dialer.DialContext(ctx, "tcp6", net.JoinHostPort(host, port))
+	case ipFamilyPreferIPv4, ipFamilyPreferIPv6:
+		return dialer.dialPreferred(ctx, host, port)
+	default:
+		return dialer.dialer.DialContext(ctx, network, net.JoinHostPort(host, port))
+	}
+}
+
+// dialPreferred tries the addresses of host one by one, the preferred family first.
+func (dialer *networkDialer) dialPreferred(ctx context.Context, host string, port string) (net.Conn, error) {
+	resolver := dialer.dialer.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	preferIPv4 := dialer.family == ipFamilyPreferIPv4
+	sort.SliceStable(addrs, func(i, j int) bool {
+		return (addrs[i].IP.To4() != nil) == preferIPv4 && (addrs[j].IP.To4() != nil) != preferIPv4
+	})
+	var lastErr error
+	for _, addr := range addrs {
+		conn, err := dialer.dialer.DialContext(ctx, "tcp", net.JoinHostPort(addr.IP.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, fmt.Errorf("dial %s: %w", host, lastErr)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// DocumentID identifies a document by the keys of the service, independent of the URL it was listed
+// or fetched under: the same document reached through another host, a redirect, percent-encoded
+// quotes or keys in another order has the same ID. Planning, dedupe, file names and the catalog key
+// on it. The zero DocumentID stands for a document that isn't keyed like SABIC's, such as a plugin's.
+type DocumentID struct {
+	Matnr  string // Material number
+	Subid  string // Specification ID
+	Sbgvid string // Regional generation variant, e.g. SDS_FR
+	Laiso  string // Language ISO code
+	Vkorg  string // Sales organization, usually empty
+}
+
+// documentIDKeys are the key names of a DocumentID, in the order the service and String write them.
+var documentIDKeys = []string{"Matnr", "Subid", "Sbgvid", "Laiso", "Vkorg"}
+
+// keyValueEscaper doubles quotes and encodes percent signs in the key values of String.
+var keyValueEscaper = strings.NewReplacer("%", "%25", "'", "''")
+
+// values returns the key values in documentIDKeys order.
+func (id DocumentID) values() []string {
+	return []string{id.Matnr, id.Subid, id.Sbgvid, id.Laiso, id.Vkorg}
+}
+
+// isZero reports whether the ID is the zero DocumentID.
+func (id DocumentID) isZero() bool {
+	return id == DocumentID{}
+}
+
+// String returns the canonical form of the ID, a key predicate such as
+// Matnr='290031915',Subid='630000000001',Sbgvid='SDS_FR',Laiso='FR',Vkorg=”. The code keys are
+// upper-cased, quotes doubled and percent signs encoded, so two IDs are equal exactly when their strings are
+// and parseDocumentID reads the string back. The zero ID is the empty string.
+func (id DocumentID) String() string {
+	if id.isZero() {
+		return ""
+	}
+	values := id.values()
+	for i := 2; i < len(values); i++ {
+		values[i] = strings.ToUpper(values[i])
+	}
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = documentIDKeys[i] + "='" + keyValueEscaper.Replace(strings.TrimSpace(value)) + "'"
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseDocumentID reads a DocumentID back from its canonical form. The empty string is the zero ID.
+func parseDocumentID(text string) (DocumentID, error) {
+	if text == "" {
+		return DocumentID{}, nil
+	}
+	id, err := documentIDFromPairs(text, "("+text+")")
+	if err != nil {
+		return DocumentID{}, fmt.Errorf("invalid document ID %q: %v", text, err)
+	}
+	return id, nil
+}
+
+// documentIDOf returns the ID of a document URL, false when it has none.
+func documentIDOf(sdsURL string) (DocumentID, bool) {
+	id, err := documentIDFromURL(sdsURL)
+	return id, err == nil
+}
+
+// documentIDOrEmpty returns the ID of a document URL, the zero ID when it has none.
+func documentIDOrEmpty(sdsURL string) DocumentID {
+	id, _ := documentIDOf(sdsURL)
+	return id
+}
+
+// documentIDFromURL extracts the ID from the key predicate of a document URL, saying what is wrong
+// with one it can't use. Keys are found by name in any order; when the tenant renamed them (see
+// Config.FieldMap) they are taken by position, the order documentURL writes them in.
+func documentIDFromURL(sdsURL string) (DocumentID, error) {
+	return documentIDFromPairs(sdsURL, sdsURL)
+}
+
+// documentIDFromPairs builds an ID from the first key predicate in text; what names text in errors.
+func documentIDFromPairs(what, text string) (DocumentID, error) {
+	pairs, err := parseKeyPredicate(text)
+	if err != nil {
+		return DocumentID{}, err
+	}
+	named := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		named[strings.ToLower(pair[0])] = pair[1]
+	}
+	var id DocumentID
+	fields := []*string{&id.Matnr, &id.Subid, &id.Sbgvid, &id.Laiso, &id.Vkorg}
+	byName := true
+	for _, name := range documentIDKeys[:4] {
+		if _, ok := named[strings.ToLower(name)]; !ok {
+			byName = false
+		}
+	}
+	for i, field := range fields {
+		switch {
+		case byName:
+			*field = named[strings.ToLower(documentIDKeys[i])]
+		case i < len(pairs):
+			*field = pairs[i][1]
+		case i == 4:
+			// Older listings leave the sales organization out.
+		default:
+			return DocumentID{}, fmt.Errorf("the key predicate of %s has %d keys, expected at least 4", what, len(pairs))
+		}
+	}
+	for _, i := range []int{0, 2, 3} {
+		if strings.TrimSpace(id.values()[i]) == "" {
+			return DocumentID{}, fmt.Errorf("the key predicate of %s has no %s", what, documentIDKeys[i])
+		}
+	}
+	return id, nil
+}
+
+// filename returns the stored file name of the document, without any internal code prefix.
+// The sales organization is left out, so names stay what they were before it was part of the ID.
+func (id DocumentID) filename() string {
+	// The keys come from the service; none of them may add a directory to the name. The other keys
+	// never hold an underscore, so the one between them stays unambiguous while Sbgvid keeps its own,
+	// as in sds_fr, and names stay what they always were.
+	filename := fmt.Sprintf("%s_%s_%s_%s.pdf", distinctNamePart(id.Matnr, true), distinctNamePart(id.Subid, true), distinctNamePart(id.Sbgvid, false), distinctNamePart(id.Laiso, true))
+	return strings.ToLower(filename)
+}
+
+// distinctNamePart makes a key value fit for a file name. A value that had to change, or that holds
+// the ~ hashes are marked with, an underscore when it is a separator, or anything but ASCII, which
+// lower-casing the name could merge with another value, gets a short hash of the original so two
+// different values can't end up with the same file name.
+func distinctNamePart(value string, separator bool) string {
+	safe := safeNamePart(value)
+	plain := strings.IndexFunc(value, func(r rune) bool { return (separator && r == '_') || r == '~' || r >= utf8.RuneSelf }) < 0
+	if safe == value && plain {
+		return safe
+	}
+	sum := sha256.Sum256([]byte(value))
+	return strings.ReplaceAll(safe, "_", "-") + "~" + hex.EncodeToString(sum[:3])
+}
+
+// parseKeyPredicate splits the first key predicate of a URL, e.g. (Matnr='1',Laiso='EN'), into
+// name and value pairs. Literals end at the first quote that isn't doubled; quotes may also be
+// percent-encoded as %27, as proxies and spreadsheets sometimes rewrite them.
+func parseKeyPredicate(sdsURL string) ([][2]string, error) {
+	start := strings.Index(sdsURL, "(")
+	if start < 0 {
+		return nil, fmt.Errorf("%s has no key predicate", sdsURL)
+	}
+	text := sdsURL[start+1:]
+	// quoteAt returns the length of a quote at the start of s, 0 when there is none.
+	quoteAt := func(s string) int {
+		switch {
+		case strings.HasPrefix(s, "'"):
+			return 1
+		case len(s) >= 3 && strings.EqualFold(s[:3], "%27"):
+			return 3
+		}
+		return 0
+	}
+	var pairs [][2]string
+	for {
+		equals := strings.IndexAny(text, "=,)")
+		if equals < 0 || text[equals] != '=' {
+			return nil, fmt.Errorf("the key predicate of %s is cut short", sdsURL)
+		}
+		name := strings.TrimSpace(text[:equals])
+		text = text[equals+1:]
+		var value strings.Builder
+		if width := quoteAt(text); width > 0 {
+			text = text[width:]
+			closed := false
+			for text != "" {
+				if width := quoteAt(text); width > 0 {
+					if next := quoteAt(text[width:]); next > 0 {
+						value.WriteString("''")
+						text = text[width+next:]
+						continue
+					}
+					text = text[width:]
+					closed = true
+					break
+				}
+				value.WriteByte(text[0])
+				text = text[1:]
+			}
+			if !closed {
+				return nil, fmt.Errorf("the key %s of %s has no closing quote", name, sdsURL)
+			}
+		} else {
+			end := strings.IndexAny(text, ",)")
+			if end < 0 {
+				return nil, fmt.Errorf("the key predicate of %s is cut short", sdsURL)
+			}
+			value.WriteString(strings.TrimSpace(text[:end]))
+			text = text[end:]
+		}
+		pairs = append(pairs, [2]string{name, parseKeyLiteral(value.String())})
+		if text == "" {
+			return nil, fmt.Errorf("the key predicate of %s is cut short", sdsURL)
+		}
+		separator := text[0]
+		text = text[1:]
+		if separator == ')' {
+			return pairs, nil
+		}
+		if separator != ',' {
+			return nil, fmt.Errorf("unexpected %q after the key %s of %s", separator, name, sdsURL)
+		}
+	}
+}
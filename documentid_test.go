@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// FuzzDocumentFilename checks that documents with different IDs never share a file name, and that
+// every file name stays a single plain path element. File names are lower-cased, so IDs differing
+// only in the case of ASCII letters are the same document to it.
+func FuzzDocumentFilename(f *testing.F) {
+	f.Add("22006037", "630000000001", "SDS_FR", "FR", "22006037", "630000000001", "SDS", "FR_FR")
+	f.Add("1_2", "3", "SDS_FR", "FR", "1", "2_3", "SDS_FR", "FR")
+	f.Add("a/b", "1", "SDS", "EN", "a-b", "1", "SDS", "EN")
+	f.Add("Ä", "1", "SDS", "EN", "ä", "1", "SDS", "EN")
+	f.Add("İ", "1", "SDS", "EN", "I", "1", "SDS", "EN")
+	f.Add("a-b~123456", "1", "SDS", "EN", "a/b", "1", "SDS", "EN")
+	f.Fuzz(func(t *testing.T, matnr1, subid1, sbgvid1, laiso1, matnr2, subid2, sbgvid2, laiso2 string) {
+		first := DocumentID{Matnr: matnr1, Subid: subid1, Sbgvid: sbgvid1, Laiso: laiso1}
+		second := DocumentID{Matnr: matnr2, Subid: subid2, Sbgvid: sbgvid2, Laiso: laiso2}
+		for _, id := range []DocumentID{first, second} {
+			if name := id.filename(); !isSafeName(name) {
+				t.Fatalf("%v gets the file name %q, which isn't a plain name", id, name)
+			}
+		}
+		if !strings.EqualFold(first.String(), second.String()) && first.filename() == second.filename() {
+			t.Fatalf("%v and %v share the file name %q", first, second, first.filename())
+		}
+	})
+}
+
+// TestDocumentFilename checks the names of documents keyed the way the service keys them.
+func TestDocumentFilename(t *testing.T) {
+	tests := []struct {
+		id   DocumentID
+		want string
+	}{
+		{DocumentID{Matnr: "22006037", Subid: "630000000001", Sbgvid: "SDS_MY", Laiso: "MS"}, "22006037_630000000001_sds_my_ms.pdf"},
+		{DocumentID{Matnr: "22006037", Subid: "630000000001", Sbgvid: "SDS", Laiso: "EN"}, "22006037_630000000001_sds_en.pdf"},
+		{DocumentID{Matnr: "../etc", Subid: "1", Sbgvid: "SDS", Laiso: "EN"}, "..-etc~f7f912_1_sds_en.pdf"},
+	}
+	for _, test := range tests {
+		if got := test.id.filename(); got != test.want {
+			t.Errorf("%v: got %q, want %q", test.id, got, test.want)
+		}
+	}
+}
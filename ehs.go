@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// EHS uploaders, see newEHSUploader.
+const (
+	ehsUploaderREST   = "rest"   // Multipart POST of the PDF and its metadata to an import API
+	ehsUploaderFolder = "folder" // PDF plus a JSON sidecar in a drop folder the EHS system watches
+)
+
+// ehsDocument is the metadata sent along with a document, in the terms EHS systems index SDS by.
+type ehsDocument struct {
+	Filename      string    `json:"filename"`
+	Material      string    `json:"material"`
+	InternalCode  string    `json:"internal_code,omitempty"`
+	Description   string    `json:"description,omitempty"`
+	ProductFamily string    `json:"product_family,omitempty"`
+	Language      string    `json:"language"`
+	Sbgvid        string    `json:"sbgvid"`
+	Country       string    `json:"country,omitempty"`
+	Regulation    string    `json:"regulation,omitempty"`
+	IssueDate     string    `json:"issue_date,omitempty"`
+	SHA256        string    `json:"sha256"`
+	SourceURL     string    `json:"source_url,omitempty"`
+	RetrievedAt   time.Time `json:"retrieved_at"`
+	Replaces      string    `json:"replaces,omitempty"` // Id the EHS system gave the previous revision, when it gave one
+}
+
+// ehsUploader hands one document to an EHS system. It returns the id the system gave the
+// document, or an empty one when it gives none.
+type ehsUploader interface {
+	upload(ctx context.Context, document ehsDocument, localPath string) (string, error)
+}
+
+// newEHSUploader returns the configured uploader, or nil when the integration is off.
+func newEHSUploader(cfg *Config) (ehsUploader, error) {
+	switch cfg.EHSUploader {
+	case "":
+		return nil, nil
+	case ehsUploaderREST:
+		if cfg.EHSEndpoint == "" {
+			return nil, fmt.Errorf("the rest EHS uploader needs ehs_endpoint")
+		}
+		return &restEHSUploader{endpoint: cfg.EHSEndpoint, token: cfg.ehsToken(), client: &http.Client{Timeout: 5 * time.Minute}}, nil
+	case ehsUploaderFolder:
+		if cfg.EHSEndpoint == "" {
+			return nil, fmt.Errorf("the folder EHS uploader needs ehs_endpoint")
+		}
+		return &folderEHSUploader{dir: cfg.EHSEndpoint}, nil
+	default:
+		return nil, fmt.Errorf("unknown EHS uploader %q, expected %s or %s", cfg.EHSUploader, ehsUploaderREST, ehsUploaderFolder)
+	}
+}
+
+// restEHSUploader posts documents to an import API such as the document endpoints of Sphera or Intelex,
+// as multipart/form-data with a "metadata" JSON part and a "file" PDF part.
+type restEHSUploader struct {
+	endpoint string
+	token    string
+	client   *http.Client
+}
+
+// upload implements ehsUploader. The content hash goes along as Idempotency-Key, so a retried
+// upload of the same revision can't create a second record.
+func (uploader *restEHSUploader) upload(ctx context.Context, document ehsDocument, localPath string) (string, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	metadata, err := json.Marshal(document)
+	if err != nil {
+		return "", err
+	}
+	// Stream the form, so large documents aren't held in memory.
+	reader, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		writer.CloseWithError(writeEHSForm(form, metadata, document.Filename, file))
+	}()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, uploader.endpoint, reader)
+	if err != nil {
+		reader.Close()
+		return "", fmt.Errorf("failed to build request for %s: %v", uploader.endpoint, err)
+	}
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	request.Header.Set("Accept", "application/json")
+	request.Header.Set("Idempotency-Key", document.SHA256)
+	if uploader.token != "" {
+		request.Header.Set("Authorization", "Bearer "+uploader.token)
+	}
+	resp, err := uploader.client.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to upload %s: %w", ErrNetwork, document.Filename, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", responseError(resp, uploader.endpoint)
+	}
+	// The id is optional; systems that answer without one are fine too.
+	var reply struct {
+		ID string `json:"id"`
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if len(bytes.TrimSpace(body)) > 0 && json.Unmarshal(body, &reply) != nil {
+		log.Printf("EHS upload of %s succeeded with a reply that isn't JSON", document.Filename)
+	}
+	return reply.ID, nil
+}
+
+// writeEHSForm writes the metadata and file parts of an upload.
+func writeEHSForm(form *multipart.Writer, metadata []byte, filename string, file io.Reader) error {
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="metadata"`)
+	header.Set("Content-Type", "application/json")
+	part, err := form.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(metadata); err != nil {
+		return err
+	}
+	header = make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename=%q`, filename))
+	header.Set("Content-Type", "application/pdf")
+	if part, err = form.CreatePart(header); err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return err
+	}
+	return form.Close()
+}
+
+// folderEHSUploader drops documents into a folder an EHS import job watches, each PDF followed by
+// a <name>.json sidecar with its metadata. The sidecar is written last, so its presence means the PDF is complete.
+type folderEHSUploader struct {
+	dir string
+}
+
+// upload implements ehsUploader.
+func (uploader *folderEHSUploader) upload(ctx context.Context, document ehsDocument, localPath string) (string, error) {
+	if err := os.MkdirAll(uploader.dir, 0o755); err != nil {
+		return "", err
+	}
+	target, err := joinWithin(uploader.dir, document.Filename)
+	if err != nil {
+		return "", err
+	}
+	if err := copyFileAtomically(localPath, target); err != nil {
+		return "", err
+	}
+	metadata, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return "", writeFileAtomically(target+".json", metadata, 0o644)
+}
+
+// ehsRecord is what the EHS state remembers about a pushed document.
+type ehsRecord struct {
+	SHA256   string    `json:"sha256"`
+	RemoteID string    `json:"remote_id,omitempty"`
+	PushedAt time.Time `json:"pushed_at"`
+}
+
+// ehsDocumentFor gathers the metadata of a stored document from its name and the catalog.
+func ehsDocumentFor(cfg *Config, entry catalogEntry, info documentInfo, sum string) ehsDocument {
+	jurisdiction := jurisdictionFor(cfg, info.Sbgvid)
+	return ehsDocument{
+		Filename:      info.Name,
+		Material:      info.Material,
+		InternalCode:  info.InternalCode,
+		Description:   materialDescription(cfg, entry.Properties),
+		ProductFamily: entry.ProductFamily,
+		Language:      info.Language,
+		Sbgvid:        info.Sbgvid,
+		Country:       jurisdiction.Country,
+		Regulation:    jurisdiction.Regulation,
+		IssueDate:     entry.IssueDate,
+		SHA256:        sum,
+		SourceURL:     activeRedactor.text(entry.SourceURL),
+		RetrievedAt:   entry.DownloadedAt.UTC(),
+	}
+}
+
+// pushToEHS uploads every stored document the EHS system doesn't have in its current revision.
+// The EHS state remembers what was pushed, so unchanged documents are skipped. It returns how many
+// documents were pushed and how many failed.
+func pushToEHS(ctx context.Context, cfg *Config, uploader ehsUploader, store documentStore, docs *catalog) (int, int, error) {
+	state, err := openKVStore(cfg.EHSState)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer state.close()
+	names, err := store.names()
+	if err != nil {
+		return 0, 0, err
+	}
+	var pushed, failed int
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return pushed, failed, ctx.Err()
+		}
+		info, ok := parseDocumentFilename(name)
+		if !ok || !strings.HasSuffix(name, ".pdf") {
+			continue
+		}
+		localPath, ok := store.path(name)
+		if !ok {
+			continue
+		}
+		entry, _ := docs.get(name)
+		sum := entry.SHA256
+		if sum == "" {
+			if sum, err = readableSHA256(storeKey(store), localPath); err != nil {
+				log.Printf("EHS push of %s: %v", name, err)
+				failed = failed + 1
+				continue
+			}
+		}
+		var previous ehsRecord
+		state.get(name, &previous)
+		if previous.SHA256 == sum {
+			continue
+		}
+		info.Name = name
+		document := ehsDocumentFor(cfg, entry, info, sum)
+		document.Replaces = previous.RemoteID
+		// The EHS system gets the plaintext; the store's encryption is for the store.
+		plainPath, release, err := plaintextPath(store, name)
+		if err != nil {
+			log.Printf("EHS push of %s: %v", name, err)
+			failed = failed + 1
+			continue
+		}
+		remoteID, err := uploader.upload(ctx, document, plainPath)
+		release()
+		if err != nil {
+			log.Printf("EHS push of %s: %v", name, err)
+			failed = failed + 1
+			continue
+		}
+		if err := state.put(name, ehsRecord{SHA256: sum, RemoteID: remoteID, PushedAt: time.Now().UTC()}); err != nil {
+			log.Println("Failed to record EHS push:", err)
+		}
+		pushed = pushed + 1
+	}
+	return pushed, failed, nil
+}
+
+// runEHSPush implements the ehs-push command, pushing what the EHS system is missing without a sync.
+func runEHSPush(args []string) error {
+	cfg, _, err := loadConfig("ehs-push", args, nil)
+	if err != nil {
+		return err
+	}
+	uploader, err := newEHSUploader(cfg)
+	if err != nil {
+		return err
+	}
+	if uploader == nil {
+		return fmt.Errorf("ehs_uploader is not configured")
+	}
+	store, err := openDocumentStore(cfg)
+	if err != nil {
+		return err
+	}
+	docs, err := openCatalog(cfg.CatalogFile)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	pushed, failed, err := pushToEHS(ctx, cfg, uploader, store, docs)
+	if err != nil {
+		return err
+	}
+	log.Printf("pushed %d documents to the EHS system at %s, %d failed", pushed, cfg.EHSEndpoint, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d documents failed to reach the EHS system", failed)
+	}
+	return nil
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Encrypted documents are AES-256-GCM in chunks, so they can be decrypted from any offset for range
+// requests: a header of magic, version, key id and nonce prefix, then chunks of up to
+// encryptionChunkSize plaintext bytes each sealed with its own nonce (prefix and chunk index).
+// The header and whether a chunk is the last one are authenticated with every chunk, so
+// reordered, truncated or extended files fail to decrypt instead of reading as another document.
+const (
+	encryptionMagic     = "SDSCRYPT"
+	encryptionVersion   = 1
+	encryptionChunkSize = 64 << 10
+	encryptionHeaderLen = len(encryptionMagic) + 1 + 8 + 8 // Magic, version, key id, nonce prefix
+	encryptionTagLen    = 16
+)
+
+// loadEncryptionKey returns the AES-256 key of the store, or nil when encryption is off. The key
+// is base64, printed by encryption_key_command (e.g. age -d or aws kms decrypt) or kept in
+// encryption_key_file; the command wins, so the key need never sit on disk unwrapped.
+func loadEncryptionKey(cfg *Config) ([]byte, error) {
+	var encoded []byte
+	switch {
+	case len(cfg.EncryptionKeyCommand) > 0:
+		output, err := exec.Command(cfg.EncryptionKeyCommand[0], cfg.EncryptionKeyCommand[1:]...).Output()
+		if err != nil {
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				return nil, fmt.Errorf("encryption key command failed: %v: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+			}
+			return nil, fmt.Errorf("encryption key command failed: %v", err)
+		}
+		encoded = output
+	case cfg.EncryptionKeyFile != "":
+		content, err := os.ReadFile(cfg.EncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the encryption key: %v", err)
+		}
+		encoded = content
+	default:
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(encoded)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("the encryption key must be 32 bytes in base64, e.g. from openssl rand -base64 32")
+	}
+	return key, nil
+}
+
+// encryptionKeyID identifies a key in file headers without revealing it, so a wrong key is
+// reported as such rather than as corrupt files.
+func encryptionKeyID(key []byte) []byte {
+	sum := sha256.Sum256(append([]byte("sds key id\x00"), key...))
+	return sum[:8]
+}
+
+// chunkNonce returns the nonce of a chunk.
+func chunkNonce(prefix []byte, index uint32) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[8:], index)
+	return nonce
+}
+
+// chunkAAD returns the additional data of a chunk: the file header and whether the chunk is the last.
+func chunkAAD(header []byte, last bool) []byte {
+	aad := append([]byte{}, header...)
+	if last {
+		return append(aad, 1)
+	}
+	return append(aad, 0)
+}
+
+// newGCM returns the AEAD of a key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptFile writes the encrypted form of src to dst.
+func encryptFile(key []byte, src string, dst string) error {
+	source, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	info, err := source.Stat()
+	if err != nil {
+		return err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, 0, encryptionHeaderLen)
+	header = append(header, encryptionMagic...)
+	header = append(header, encryptionVersion)
+	header = append(header, encryptionKeyID(key)...)
+	prefix := make([]byte, 8)
+	if _, err := rand.Read(prefix); err != nil {
+		return err
+	}
+	header = append(header, prefix...)
+	target, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+	if _, err := target.Write(header); err != nil {
+		return err
+	}
+	// An empty document is still one (empty) final chunk, so truncation to the header is detected.
+	chunks := max(1, (info.Size()+encryptionChunkSize-1)/encryptionChunkSize)
+	plaintext := make([]byte, encryptionChunkSize)
+	sealed := make([]byte, 0, encryptionChunkSize+encryptionTagLen)
+	for index := int64(0); index < chunks; index++ {
+		read, err := io.ReadFull(source, plaintext)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return err
+		}
+		sealed = aead.Seal(sealed[:0], chunkNonce(prefix, uint32(index)), plaintext[:read], chunkAAD(header, index == chunks-1))
+		if _, err := target.Write(sealed); err != nil {
+			return err
+		}
+	}
+	if err := target.Sync(); err != nil {
+		return err
+	}
+	return target.Close()
+}
+
+// isEncryptedFile reports whether a file starts with the encryption header.
+func isEncryptedFile(file io.ReaderAt) bool {
+	magic := make([]byte, len(encryptionMagic))
+	_, err := file.ReadAt(magic, 0)
+	return err == nil && string(magic) == encryptionMagic
+}
+
+// readableFile is a stored document opened for reading its plaintext.
+type readableFile interface {
+	io.ReadSeekCloser
+	// Size returns the plaintext size.
+	Size() int64
+}
+
+// plainFile is an unencrypted document.
+type plainFile struct {
+	*os.File
+	size int64
+}
+
+// Size implements readableFile.
+func (file *plainFile) Size() int64 {
+	return file.size
+}
+
+// decryptedFile reads an encrypted document, decrypting the chunk under the offset.
+type decryptedFile struct {
+	file   *os.File
+	aead   cipher.AEAD
+	header []byte
+	size   int64 // Plaintext size
+	chunks int64
+	offset int64
+	index  int64  // Chunk held in plain, -1 for none
+	plain  []byte // Plaintext of that chunk
+}
+
+// openReadable opens a stored file for reading its plaintext. Files without the encryption header
+// are read as they are, so documents stored before encryption was turned on stay readable.
+func openReadable(key []byte, path string) (readableFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !isEncryptedFile(file) {
+		// With a key, an unencrypted file is only what the catalog stored before encryption was
+		// turned on; anything else was swapped in behind the store's back.
+		if key != nil && !isLegacyPlaintext(path) {
+			file.Close()
+			return nil, fmt.Errorf("%s is not encrypted, and the catalog doesn't record it as stored before encryption was turned on", path)
+		}
+		return &plainFile{File: file, size: info.Size()}, nil
+	}
+	header := make([]byte, encryptionHeaderLen)
+	if _, err := file.ReadAt(header, 0); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: truncated encryption header", path)
+	}
+	if header[len(encryptionMagic)] != encryptionVersion {
+		file.Close()
+		return nil, fmt.Errorf("%s: unsupported encryption version %d", path, header[len(encryptionMagic)])
+	}
+	if key == nil {
+		file.Close()
+		return nil, fmt.Errorf("%s is encrypted but no encryption key is configured", path)
+	}
+	if keyID := header[len(encryptionMagic)+1 : len(encryptionMagic)+9]; !bytes.Equal(keyID, encryptionKeyID(key)) {
+		file.Close()
+		return nil, fmt.Errorf("%s is encrypted with another key (id %s)", path, hex.EncodeToString(keyID))
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	body := info.Size() - int64(encryptionHeaderLen)
+	chunks := (body + encryptionChunkSize + encryptionTagLen - 1) / (encryptionChunkSize + encryptionTagLen)
+	if chunks == 0 {
+		file.Close()
+		return nil, fmt.Errorf("%w: %s has no encrypted content", ErrTruncated, path)
+	}
+	return &decryptedFile{file: file, aead: aead, header: header, size: body - chunks*encryptionTagLen, chunks: chunks, index: -1}, nil
+}
+
+// Size implements readableFile.
+func (file *decryptedFile) Size() int64 {
+	return file.size
+}
+
+// Read implements io.Reader.
+func (file *decryptedFile) Read(buffer []byte) (int, error) {
+	if file.offset >= file.size {
+		return 0, io.EOF
+	}
+	index := file.offset / encryptionChunkSize
+	if index != file.index {
+		if err := file.load(index); err != nil {
+			return 0, err
+		}
+	}
+	read := copy(buffer, file.plain[file.offset-index*encryptionChunkSize:])
+	file.offset = file.offset + int64(read)
+	return read, nil
+}
+
+// load decrypts one chunk.
+func (file *decryptedFile) load(index int64) error {
+	sealed := make([]byte, encryptionChunkSize+encryptionTagLen)
+	read, err := file.file.ReadAt(sealed, int64(encryptionHeaderLen)+index*int64(len(sealed)))
+	if err != nil && err != io.EOF {
+		return err
+	}
+	plain, err := file.aead.Open(file.plain[:0], chunkNonce(file.header[len(file.header)-8:], uint32(index)), sealed[:read], chunkAAD(file.header, index == file.chunks-1))
+	if err != nil {
+		file.index = -1
+		return fmt.Errorf("%w: %s fails authentication at chunk %d", ErrChecksumMismatch, file.file.Name(), index)
+	}
+	file.plain, file.index = plain, index
+	return nil
+}
+
+// Seek implements io.Seeker.
+func (file *decryptedFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset = offset + file.offset
+	case io.SeekEnd:
+		offset = offset + file.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset %d", offset)
+	}
+	file.offset = offset
+	return offset, nil
+}
+
+// Close implements io.Closer.
+func (file *decryptedFile) Close() error {
+	return file.file.Close()
+}
+
+// readableSHA256 returns the hex SHA-256 of a stored file's plaintext.
+func readableSHA256(key []byte, path string) (string, error) {
+	file, err := openReadable(key, path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// encryptedStore encrypts documents before they reach the underlying layout, for output
+// directories on shared storage. path still returns the file on disk; whatever needs the
+// plaintext goes through plaintextPath or openReadable.
+type encryptedStore struct {
+	documentStore
+	key []byte
+}
+
+// put implements documentStore, encrypting the finished download beside itself first.
+func (store *encryptedStore) put(tempPath string, filename string, sha256 string) (string, error) {
+	encrypted, err := os.CreateTemp(filepath.Dir(tempPath), "."+filepath.Base(filename)+".part-*")
+	if err != nil {
+		os.Remove(tempPath)
+		return "", err
+	}
+	encrypted.Close()
+	err = encryptFile(store.key, tempPath, encrypted.Name())
+	// The plaintext must not outlive the encrypted copy.
+	os.Remove(tempPath)
+	if err != nil {
+		os.Remove(encrypted.Name())
+		return "", fmt.Errorf("%w: failed to encrypt %s: %v", ErrStorage, filename, err)
+	}
+	path, err := store.documentStore.put(encrypted.Name(), filename, sha256)
+	if err == nil {
+		forgetLegacyPlaintext(path)
+	}
+	return path, err
+}
+
+// legacyPlaintext holds the paths of the unencrypted documents the catalog records as stored before
+// encryption was turned on, the only unencrypted files openReadable reads when a key is configured.
+var legacyPlaintext = struct {
+	sync.Mutex
+	paths map[string]bool
+}{paths: make(map[string]bool)}
+
+// allowLegacyPlaintext lets openReadable read the catalogued documents that weren't stored encrypted
+// and still aren't, so a corpus stays readable while encryption is rolled out over it.
+func allowLegacyPlaintext(store documentStore, docs *catalog) {
+	legacyPlaintext.Lock()
+	defer legacyPlaintext.Unlock()
+	for _, entry := range docs.all() {
+		if entry.Encrypted {
+			continue
+		}
+		path, ok := store.path(entry.Filename)
+		if !ok {
+			continue
+		}
+		if encrypted, err := fileIsEncrypted(path); err == nil && !encrypted {
+			legacyPlaintext.paths[filepath.Clean(path)] = true
+		}
+	}
+}
+
+// isLegacyPlaintext reports whether allowLegacyPlaintext let the file be read unencrypted.
+func isLegacyPlaintext(path string) bool {
+	legacyPlaintext.Lock()
+	defer legacyPlaintext.Unlock()
+	return legacyPlaintext.paths[filepath.Clean(path)]
+}
+
+// forgetLegacyPlaintext stops reading a file unencrypted once an encrypted copy took its place.
+func forgetLegacyPlaintext(path string) {
+	legacyPlaintext.Lock()
+	defer legacyPlaintext.Unlock()
+	delete(legacyPlaintext.paths, filepath.Clean(path))
+}
+
+// fileIsEncrypted reports whether the file at path starts with the encryption header.
+func fileIsEncrypted(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	return isEncryptedFile(file), nil
+}
+
+// markEncryptedDocuments records the catalogued documents found encrypted on disk as such, so
+// documents encrypted before the catalog kept track can't be swapped for plaintext later.
+func markEncryptedDocuments(docs *catalog, store documentStore) error {
+	if storeKey(store) == nil {
+		return nil
+	}
+	var marked int
+	for _, entry := range docs.all() {
+		if entry.Encrypted {
+			continue
+		}
+		path, ok := store.path(entry.Filename)
+		if !ok {
+			continue
+		}
+		if encrypted, err := fileIsEncrypted(path); err == nil && encrypted {
+			docs.update(entry.Filename, func(entry *catalogEntry) {
+				entry.Encrypted = true
+			})
+			marked = marked + 1
+		}
+	}
+	if marked == 0 {
+		return nil
+	}
+	log.Printf("recorded %d stored documents as encrypted", marked)
+	return docs.save()
+}
+
+// storeKey returns the encryption key of a store, nil for an unencrypted one.
+func storeKey(store documentStore) []byte {
+	if encrypted, ok := store.(*encryptedStore); ok {
+		return encrypted.key
+	}
+	return nil
+}
+
+// plaintextPath returns a path where external tools can read a stored document, and a release
+// function to call when done. Unencrypted documents are read in place; encrypted ones are
+// decrypted to a private temporary file, which release removes.
+func plaintextPath(store documentStore, filename string) (string, func(), error) {
+	filePath, ok := store.path(filename)
+	if !ok {
+		return "", nil, fmt.Errorf("%s is not stored: %w", filename, os.ErrNotExist)
+	}
+	key := storeKey(store)
+	if key == nil {
+		return filePath, func() {}, nil
+	}
+	source, err := openReadable(key, filePath)
+	if err != nil {
+		return "", nil, err
+	}
+	defer source.Close()
+	if plain, ok := source.(*plainFile); ok {
+		return plain.Name(), func() {}, nil
+	}
+	// CreateTemp makes the file readable by its owner only.
+	temp, err := os.CreateTemp("", "sds-*-"+filepath.Base(filename))
+	if err != nil {
+		return "", nil, err
+	}
+	release := func() { os.Remove(temp.Name()) }
+	_, err = io.Copy(temp, source)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		release()
+		return "", nil, fmt.Errorf("failed to decrypt %s: %w", filename, err)
+	}
+	return temp.Name(), release, nil
+}
+
+// copyStoredDocument writes the plaintext of a stored document to dst atomically.
+func copyStoredDocument(store documentStore, filename string, dst string) error {
+	filePath, ok := store.path(filename)
+	if !ok {
+		return fmt.Errorf("%s is not stored: %w", filename, os.ErrNotExist)
+	}
+	if storeKey(store) == nil {
+		return copyFileAtomically(filePath, dst)
+	}
+	source, err := openReadable(storeKey(store), filePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	temp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := io.Copy(temp, source); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(temp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), dst)
+}
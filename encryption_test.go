@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestPlaintextFallback checks that with a key configured, an unencrypted file is only read when the
+// catalog records it as stored before encryption was turned on, not when it was swapped in for an
+// encrypted copy or never catalogued.
+func TestPlaintextFallback(t *testing.T) {
+	dir := t.TempDir()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	cfg := defaultConfig()
+	cfg.OutputDir = filepath.Join(dir, "PDFs")
+	cfg.CatalogFile = filepath.Join(dir, "catalog.json")
+	cfg.EncryptionKeyFile = filepath.Join(dir, "key")
+	if err := os.WriteFile(cfg.EncryptionKeyFile, []byte(base64.StdEncoding.EncodeToString(key)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(cfg.OutputDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	const legacy, swapped, stray = "1_2_sds_en.pdf", "3_4_sds_de.pdf", "5_6_sds_fr.pdf"
+	content := []byte("%PDF-1.4 plaintext")
+	for _, name := range []string{legacy, stray} {
+		if err := os.WriteFile(filepath.Join(cfg.OutputDir, name), content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The legacy document was catalogued before encryption; the other was stored encrypted.
+	docs, err := openCatalog(cfg.CatalogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs.update(legacy, func(entry *catalogEntry) {})
+	docs.update(swapped, func(entry *catalogEntry) { entry.Encrypted = true })
+	if err := docs.save(); err != nil {
+		t.Fatal(err)
+	}
+	store, err := openDocumentStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	temp := filepath.Join(cfg.OutputDir, ".staged")
+	if err := os.WriteFile(temp, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	swappedPath, err := store.put(temp, swapped, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readStored(key, swappedPath); err != nil {
+		t.Fatalf("encrypted copy: %v", err)
+	}
+	// Replace the encrypted copy with plaintext, as someone with access to the share could.
+	if err := os.WriteFile(swappedPath, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readStored(key, filepath.Join(cfg.OutputDir, legacy)); err != nil || got != string(content) {
+		t.Errorf("legacy plaintext: got %q, %v", got, err)
+	}
+	if _, err := readStored(key, swappedPath); err == nil {
+		t.Error("plaintext swapped in for an encrypted copy was read")
+	}
+	if _, err := readStored(key, filepath.Join(cfg.OutputDir, stray)); err == nil {
+		t.Error("uncatalogued plaintext was read")
+	}
+}
+
+// readStored returns the plaintext of a stored file.
+func readStored(key []byte, path string) (string, error) {
+	file, err := openReadable(key, path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	return string(content), err
+}
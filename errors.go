@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// Errors a download can fail with; callers branch on them with errors.Is.
+var (
+	ErrNotPDF            = errors.New("response is not a PDF")                   // Wrong content type or body
+	ErrUpstreamThrottled = errors.New("upstream throttled the request")          // HTTP 429 or 503
+	ErrNotFound          = errors.New("document not found upstream")             // HTTP 404 or 410
+	ErrChecksumMismatch  = errors.New("checksum mismatch")                       // Content differs from the expected hash
+	ErrSizeOutOfRange    = errors.New("response size outside the allowed range") // Empty, stub or oversized body
+	ErrValidationFailed  = errors.New("document failed validation")              // A configured check such as page count or text presence
+	ErrUpstreamStatus    = errors.New("unexpected upstream status")              // Any other non-200 status
+	ErrNetwork           = errors.New("network error")                           // Connection, TLS or read failure
+	ErrTruncated         = errors.New("truncated download")                      // Body shorter or longer than its Content-Length
+	ErrStorage           = errors.New("storage error")                           // Writing the local copy failed
+	ErrAlreadyExists     = errors.New("document already stored")                 // Skipped, the file is on disk
+	ErrNotModified       = errors.New("document not modified upstream")          // Skipped, a conditional request answered 304
+	ErrWrongFormat       = errors.New("response is not in the requested format") // An alternate format arrived as something else
+	ErrRedirectRefused   = errors.New("redirect refused")                        // Too many redirects, or one to a host outside redirect_hosts
+)
+
+// errorClasses maps each typed error to the short label used in manifests and metrics.
+var errorClasses = []struct {
+	err   error
+	class string
+}{
+	{ErrNotPDF, "not_pdf"},
+	{ErrWrongFormat, "wrong_format"},
+	{ErrUpstreamThrottled, "throttled"},
+	{ErrNotFound, "not_found"},
+	{ErrChecksumMismatch, "checksum_mismatch"},
+	{ErrSizeOutOfRange, "size_out_of_range"},
+	{ErrValidationFailed, "validation_failed"},
+	{ErrTruncated, "truncated"},
+	{ErrUpstreamStatus, "upstream_status"},
+	{ErrRedirectRefused, "redirect"},
+	{ErrNetwork, "network"},
+	{ErrStorage, "storage"},
+	{ErrAlreadyExists, "already_exists"},
+	{ErrNotModified, "not_modified"},
+}
+
+// errorClass returns the label of a download error: empty for nil, "other" for untyped errors.
+func errorClass(err error) string {
+	if err == nil {
+		return ""
+	}
+	for _, candidate := range errorClasses {
+		if errors.Is(err, candidate.err) {
+			return candidate.class
+		}
+	}
+	// Cancelled and timed out requests are network failures too.
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) {
+		return "network"
+	}
+	return "other"
+}
+
+// errorForClass returns the typed error with the given label, nil for unknown labels.
+func errorForClass(class string) error {
+	for _, candidate := range errorClasses {
+		if candidate.class == class {
+			return candidate.err
+		}
+	}
+	return nil
+}
+
+// statusError returns the typed error for a non-200 upstream status.
+func statusError(statusCode int) error {
+	switch statusCode {
+	case http.StatusNotFound, http.StatusGone:
+		return ErrNotFound
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return ErrUpstreamThrottled
+	default:
+		return ErrUpstreamStatus
+	}
+}
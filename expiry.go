@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/text/collate"
+)
+
+// issueDateLayout is how issue dates are kept in the catalog; they are calendar dates, not instants.
+const issueDateLayout = "2006-01-02"
+
+// Where an issue date was found.
+const (
+	issueDateFromHeader = "header" // A header property listed upstream
+	issueDateFromPDF    = "pdf"    // The text of the document itself
+)
+
+// issueDateLayouts are the date formats accepted in header properties and document text.
+// Slashed dates are read day first, as on the European sheets that make up most of the corpus.
+var issueDateLayouts = []string{"2006-01-02", "20060102", "02.01.2006", "2.1.2006", "02/01/2006", "2/1/2006", "2006/01/02", "2006.01.02", time.RFC3339}
+
+// pdfIssueDatePattern finds the revision or issue date printed on a sheet, in the languages we receive.
+var pdfIssueDatePattern = regexp.MustCompile(`(?i)(?:revision date|date of revision|revised on|revised|issue date|date of issue|version date|date prepared|überarbeitet am|überarbeitungsdatum|date de révision|date d'émission|fecha de revisión|data di revisione|data revisione)\s*[:.]?\s*(\d{1,4}[./-]\d{1,2}[./-]\d{1,4})`)
+
+// parseIssueDate reads a date in any of the accepted formats.
+// OData dates in header properties already arrive as RFC 3339, see propertyValue.
+func parseIssueDate(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	for _, layout := range issueDateLayouts {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// documentIssueDate returns when a document was issued or last revised, from the configured header
+// properties first and the document text otherwise, with where it was found.
+func documentIssueDate(cfg *Config, properties map[string]string, pdfPath string) (time.Time, string, bool) {
+	for _, field := range cfg.IssueDateFields {
+		if issued, ok := parseIssueDate(properties[field]); ok {
+			return issued, issueDateFromHeader, true
+		}
+	}
+	if pdfPath == "" {
+		return time.Time{}, "", false
+	}
+	text, err := extractPDFText(pdfPath)
+	if err != nil {
+		log.Printf("failed to read %s for its issue date: %v", pdfPath, err)
+		return time.Time{}, "", false
+	}
+	// The first date a sheet labels is the one on its cover.
+	for _, match := range pdfIssueDatePattern.FindAllStringSubmatch(text, -1) {
+		if issued, ok := parseIssueDate(match[1]); ok {
+			return issued, issueDateFromPDF, true
+		}
+	}
+	return time.Time{}, "", false
+}
+
+// recordIssueDate stores the issue date of a document in its catalog entry, when one can be found.
+func recordIssueDate(cfg *Config, docs *catalog, filename string, properties map[string]string, pdfPath string) {
+	issued, from, ok := documentIssueDate(cfg, properties, pdfPath)
+	if !ok {
+		return
+	}
+	setIssueDate(docs, filename, issued, from)
+}
+
+// setIssueDate stores a found issue date in the catalog entry of a document.
+func setIssueDate(docs *catalog, filename string, issued time.Time, from string) {
+	docs.update(filename, func(entry *catalogEntry) {
+		entry.IssueDate = issued.Format(issueDateLayout)
+		entry.IssueDateFrom = from
+	})
+}
+
+// reviewDue returns when a document falls due for review, false when its issue date is unknown.
+func reviewDue(cfg *Config, entry catalogEntry) (time.Time, bool) {
+	issued, err := time.Parse(issueDateLayout, entry.IssueDate)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return issued.Add(cfg.ReviewAge.Duration), true
+}
+
+// needsRevalidation reports whether a stored document is past its review date and hasn't been
+// checked upstream for a newer revision within the revalidation interval.
+func needsRevalidation(cfg *Config, entry catalogEntry, now time.Time) bool {
+	if cfg.ReviewAge.Duration <= 0 {
+		return false
+	}
+	due, ok := reviewDue(cfg, entry)
+	if !ok || now.Before(due) {
+		return false
+	}
+	lastChecked := entry.DownloadedAt
+	if entry.RevalidatedAt != nil && entry.RevalidatedAt.After(lastChecked) {
+		lastChecked = *entry.RevalidatedAt
+	}
+	return now.Sub(lastChecked) >= cfg.RevalidateInterval.Duration
+}
+
+// Review states of the expiring report.
+const (
+	expiryExpired  = "expired"  // Past its review date
+	expiryExpiring = "expiring" // Due within the reporting window
+	expiryUnknown  = "unknown"  // No issue date found
+)
+
+// expiryItem is one line of the expiring report.
+type expiryItem struct {
+	Filename    string `json:"filename"`
+	Description string `json:"description,omitempty"` // Material description, see locale.go
+	IssueDate   string `json:"issue_date,omitempty"`
+	DueDate     string `json:"due_date,omitempty"`
+	DaysLeft    int    `json:"days_left"` // Negative once overdue
+	Status      string `json:"status"`
+}
+
+// expiryReport lists the documents due for review within the window, most overdue first.
+// Documents without an issue date are listed last when includeUnknown is set. Documents due on
+// the same day are ordered by material description under the collation.
+func expiryReport(cfg *Config, docs *catalog, collator *collate.Collator, within time.Duration, includeUnknown bool, now time.Time) []expiryItem {
+	var items, unknown []expiryItem
+	for _, entry := range docs.all() {
+		due, ok := reviewDue(cfg, entry)
+		if !ok {
+			if includeUnknown {
+				unknown = append(unknown, expiryItem{Filename: entry.Filename, Description: materialDescription(cfg, entry.Properties), Status: expiryUnknown})
+			}
+			continue
+		}
+		if due.Sub(now) > within {
+			continue
+		}
+		item := expiryItem{Filename: entry.Filename, Description: materialDescription(cfg, entry.Properties), IssueDate: entry.IssueDate, DueDate: due.Format(issueDateLayout), DaysLeft: int(due.Sub(now).Hours() / 24), Status: expiryExpiring}
+		if !now.Before(due) {
+			item.Status = expiryExpired
+		}
+		items = append(items, item)
+	}
+	// A stable sort keeps equal days and descriptions in file name order.
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].DaysLeft != items[j].DaysLeft {
+			return items[i].DaysLeft < items[j].DaysLeft
+		}
+		return lessByDescription(collator, items[i].Description, items[j].Description)
+	})
+	sort.SliceStable(unknown, func(i, j int) bool {
+		return lessByDescription(collator, unknown[i].Description, unknown[j].Description)
+	})
+	return append(items, unknown...)
+}
+
+// runExpiring implements the expiring command: it reports the documents past or near their review date.
+func runExpiring(args []string) error {
+	within := Duration{90 * 24 * time.Hour}
+	var asJSON, includeUnknown bool
+	scan := true
+	cfg, _, err := loadConfig("expiring", args, func(flagSet *flag.FlagSet) {
+		flagSet.Var(&within, "within", "also list documents falling due within this long, e.g. 2160h for 90 days")
+		flagSet.BoolVar(&asJSON, "json", false, "print the report as JSON")
+		flagSet.BoolVar(&includeUnknown, "unknown", false, "also list documents without a known issue date")
+		flagSet.BoolVar(&scan, "scan", scan, "read the issue date of stored documents the catalog has none for")
+	})
+	if err != nil {
+		return err
+	}
+	if cfg.ReviewAge.Duration <= 0 {
+		return fmt.Errorf("review_age is not set, nothing can expire")
+	}
+	collator, err := newCollator(cfg.Locale)
+	if err != nil {
+		return err
+	}
+	// Scanning writes the dates it learns to the catalog.
+	if scan {
+		lock, err := acquireRunLock(cfg)
+		if err != nil {
+			return err
+		}
+		defer lock.release()
+	}
+	docs, err := openCatalog(cfg.CatalogFile)
+	if err != nil {
+		return err
+	}
+	// Catalogs older than issue date tracking learn the dates from the stored files.
+	if scan {
+		store, err := openDocumentStore(cfg)
+		if err != nil {
+			return err
+		}
+		for _, entry := range docs.all() {
+			if entry.IssueDate != "" {
+				continue
+			}
+			filePath, release, err := plaintextPath(store, entry.Filename)
+			if err != nil {
+				if !errors.Is(err, os.ErrNotExist) {
+					log.Println(err)
+				}
+				continue
+			}
+			recordIssueDate(cfg, docs, entry.Filename, entry.Properties, filePath)
+			release()
+		}
+		if err := docs.save(); err != nil {
+			return err
+		}
+	}
+	items := expiryReport(cfg, docs, collator, within.Duration, includeUnknown, time.Now().UTC())
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(items)
+	}
+	table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "STATUS\tDUE\tDAYS\tISSUED\tDOCUMENT\tDESCRIPTION")
+	for _, item := range items {
+		fmt.Fprintf(table, "%s\t%s\t%d\t%s\t%s\t%s\n", item.Status, item.DueDate, item.DaysLeft, item.IssueDate, item.Filename, item.Description)
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+	log.Printf("%d documents listed", len(items))
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// unsafeFolderCharacters are runs of characters kept out of view folder names.
+var unsafeFolderCharacters = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// productFamily returns the product family a document's header properties place it in,
+// from the first of cfg.ProductFamilyFields that is set; empty when the service exposes none.
+func productFamily(cfg *Config, properties map[string]string) string {
+	for _, field := range cfg.ProductFamilyFields {
+		if family := strings.TrimSpace(properties[field]); family != "" {
+			return family
+		}
+	}
+	return ""
+}
+
+// matchesProductFamily reports whether a family is one of the comma separated families in filter,
+// ignoring case. An empty filter matches everything; a document without a family matches no filter.
+func matchesProductFamily(filter string, family string) bool {
+	if strings.TrimSpace(filter) == "" {
+		return true
+	}
+	for _, wanted := range strings.Split(filter, ",") {
+		if wanted = strings.TrimSpace(wanted); wanted != "" && strings.EqualFold(wanted, family) {
+			return true
+		}
+	}
+	return false
+}
+
+// familyFolder turns a product family into a single folder name for the by-family view.
+func familyFolder(family string) string {
+	return strings.Trim(unsafeFolderCharacters.ReplaceAllString(family, "-"), "-.")
+}
+
+// withProductFamilies fills in the product family of each document from the catalog.
+func withProductFamilies(docs *catalog, documents []documentInfo) {
+	for i := range documents {
+		if entry, ok := docs.get(documents[i].Name); ok {
+			documents[i].ProductFamily = entry.ProductFamily
+		}
+	}
+}
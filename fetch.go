@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// fetchQuery names the one document the fetch command retrieves.
+type fetchQuery struct {
+	matnr  string // Material number; leading zeros don't matter
+	laiso  string // Language ISO code, any case
+	sbgvid string // Generation variant such as SDS_US, any when empty
+}
+
+// matches reports whether a document URL carries the keys of the query.
+func (query fetchQuery) matches(documentURL string) bool {
+	keys, ok := documentIDOf(documentURL)
+	if !ok {
+		return false
+	}
+	return strings.TrimLeft(keys.Matnr, "0") == strings.TrimLeft(query.matnr, "0") &&
+		strings.EqualFold(keys.Laiso, query.laiso) &&
+		(query.sbgvid == "" || strings.EqualFold(keys.Sbgvid, query.sbgvid))
+}
+
+// findSnapshotHeader looks the document up in the header snapshot, false when it isn't listed there.
+func findSnapshotHeader(ctx context.Context, source *sabicSource, query fetchQuery) (documentRef, bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	documents := make(chan documentRef)
+	listed := make(chan error, 1)
+	go func() {
+		listed <- source.List(ctx, documents, nil)
+		close(documents)
+	}()
+	for doc := range documents {
+		if query.matches(doc.url) {
+			// Stop the listing; the rest of the snapshot isn't needed.
+			cancel()
+			for range documents {
+			}
+			return doc, true, nil
+		}
+	}
+	return documentRef{}, false, <-listed
+}
+
+// queryUpstreamHeader asks the header entity set for the document with an OData $filter.
+func queryUpstreamHeader(ctx context.Context, cfg *Config, source *sabicSource, query fetchQuery) (documentRef, bool, error) {
+	conditions := []string{
+		fmt.Sprintf("%s eq '%s'", fieldName(cfg.FieldMap, "Matnr"), strings.ReplaceAll(query.matnr, "'", "''")),
+		fmt.Sprintf("%s eq '%s'", fieldName(cfg.FieldMap, "Laiso"), strings.ReplaceAll(strings.ToUpper(query.laiso), "'", "''")),
+	}
+	if query.sbgvid != "" {
+		conditions = append(conditions, fmt.Sprintf("%s eq '%s'", fieldName(cfg.FieldMap, "Sbgvid"), strings.ReplaceAll(query.sbgvid, "'", "''")))
+	}
+	// SAP gateways want %20 rather than + between the words of a $filter.
+	filter := strings.ReplaceAll(url.QueryEscape(strings.Join(conditions, " and ")), "+", "%20")
+	pageURL := cfg.entitySetURL(cfg.HeaderEntitySet) + "?$filter=" + filter
+	page, err := fetchScrapePage(ctx, cfg, source.client, pageURL)
+	if err != nil {
+		return documentRef{}, false, err
+	}
+	for _, result := range page.D.Results {
+		var raw map[string]any
+		if err := json.Unmarshal(result, &raw); err != nil {
+			return documentRef{}, false, fmt.Errorf("failed to parse %s: %v", pageURL, err)
+		}
+		item, properties := source.schema.mapResult(raw)
+		doc := documentRef{source: source.Name(), url: documentURL(cfg, item), properties: properties}
+		// The service may match more loosely than asked, e.g. ignoring the variant.
+		if query.matches(doc.url) {
+			id, err := documentIDFromURL(doc.url)
+			if err != nil {
+				return documentRef{}, false, err
+			}
+			doc.id = id
+			doc.filename = documentFilename(source.materials, id)
+			return doc, true, nil
+		}
+	}
+	return documentRef{}, false, nil
+}
+
+// fetchSingleDocument downloads doc to target with every check of a sync run, but leaves the store
+// and catalog alone.
+func fetchSingleDocument(ctx context.Context, cfg *Config, source Source, doc documentRef, target string) (*stagedDocument, error) {
+	// An empty document set makes the downloader fetch the document even when it is stored already.
+	fetcher := &downloader{cfg: cfg, budget: newMemoryBudget(cfg.MemoryBudget), existing: &documentSet{names: make(map[string]bool)}, sources: map[string]Source{source.Name(): source}}
+	dir := filepath.Dir(target)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	// Stage beside the target so the final rename never crosses filesystems.
+	staged, err := fetcher.fetchPDF(ctx, doc, dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Rename(staged.tempPath, target); err != nil {
+		os.Remove(staged.tempPath)
+		return nil, fmt.Errorf("%w: failed to move %s into place: %w", ErrStorage, target, err)
+	}
+	return staged, nil
+}
+
+// fetchResult is what the fetch command prints with -json.
+type fetchResult struct {
+	Path       string            `json:"path"`
+	URL        string            `json:"url"`
+	Filename   string            `json:"filename"`
+	SHA256     string            `json:"sha256"`
+	Size       int64             `json:"size"`
+	Found      string            `json:"found"` // snapshot or upstream
+	Properties map[string]string `json:"properties"`
+}
+
+// runFetch implements the fetch command, which downloads a single document for ad-hoc use.
+func runFetch(args []string) error {
+	var query fetchQuery
+	var outPath string
+	var upstream, asJSON bool
+	cfg, _, err := loadConfig("fetch", args, func(flagSet *flag.FlagSet) {
+		flagSet.StringVar(&query.matnr, "matnr", "", "material number of the document (required)")
+		flagSet.StringVar(&query.laiso, "laiso", "", "language ISO code of the document, e.g. MS (required)")
+		flagSet.StringVar(&query.sbgvid, "sbgvid", "", "generation variant such as SDS_US, the first listed when empty")
+		flagSet.StringVar(&outPath, "out", "", "file to write the document to, its stored file name in the current directory when empty")
+		flagSet.BoolVar(&upstream, "upstream", false, "query the service for the header instead of the header snapshot")
+		flagSet.BoolVar(&asJSON, "json", false, "print the document's metadata as JSON")
+	})
+	if err != nil {
+		return err
+	}
+	if query.matnr == "" || query.laiso == "" {
+		return fmt.Errorf("usage: fetch -matnr <material number> -laiso <language> [flags]")
+	}
+	materials, err := loadMaterialMap(cfg.MaterialMap)
+	if err != nil {
+		return err
+	}
+	opened, err := newSABICSource(cfg, materials)
+	if err != nil {
+		return err
+	}
+	source := opened.(*sabicSource)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// The snapshot is cheaper to ask; the service knows about documents published since.
+	found := "snapshot"
+	var doc documentRef
+	var ok bool
+	if !upstream {
+		if doc, ok, err = findSnapshotHeader(ctx, source, query); err != nil {
+			log.Printf("header snapshot unavailable, asking the service: %v", err)
+		}
+	}
+	if !ok {
+		found = "upstream"
+		if doc, ok, err = queryUpstreamHeader(ctx, cfg, source, query); err != nil {
+			return err
+		}
+	}
+	if !ok {
+		return fmt.Errorf("no document for material %s in language %s", query.matnr, query.laiso)
+	}
+	if outPath == "" {
+		outPath = doc.filename
+	}
+	staged, err := fetchSingleDocument(ctx, cfg, source, doc, outPath)
+	if err != nil {
+		return err
+	}
+	result := fetchResult{Path: outPath, URL: doc.url, Filename: doc.filename, SHA256: staged.sha256, Size: staged.size, Found: found, Properties: doc.properties}
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+	fmt.Printf("path:      %s\n", result.Path)
+	fmt.Printf("url:       %s\n", result.URL)
+	fmt.Printf("found in:  %s\n", result.Found)
+	fmt.Printf("sha256:    %s\n", result.SHA256)
+	fmt.Printf("size:      %d\n", result.Size)
+	names := make([]string, 0, len(result.Properties))
+	for name := range result.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) > 0 {
+		fmt.Println("\nproperties:")
+	}
+	for _, name := range names {
+		fmt.Printf("  %s: %s\n", name, result.Properties[name])
+	}
+	return nil
+}
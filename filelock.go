@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// withFileLock runs fn while holding an exclusive lock on the file at path, created when missing.
+// Processes locking the same path take turns; the file itself only carries the lock.
+func withFileLock(path string, fn func() error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := lockFile(file, true); err != nil {
+		return err
+	}
+	defer unlockFile(file)
+	return fn()
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockedRange is where lockFile locks: one byte far past the end of any lock file, as Windows locks
+// keep other processes from reading the bytes they cover.
+var lockedRange = windows.Overlapped{OffsetHigh: 0x7fffffff}
+
+// lockFile takes an exclusive lock on file, waiting for it when wait is set. It reports false when
+// another process holds the lock and wait isn't set. The lock goes with the process.
+func lockFile(file *os.File, wait bool) (bool, error) {
+	flags := uint32(windows.LOCKFILE_EXCLUSIVE_LOCK)
+	if !wait {
+		flags = flags | windows.LOCKFILE_FAIL_IMMEDIATELY
+	}
+	overlapped := lockedRange
+	err := windows.LockFileEx(windows.Handle(file.Fd()), flags, 0, 1, 0, &overlapped)
+	if !wait && errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// unlockFile releases a lock taken by lockFile.
+func unlockFile(file *os.File) error {
+	overlapped := lockedRange
+	return windows.UnlockFileEx(windows.Handle(file.Fd()), 0, 1, 0, &overlapped)
+}
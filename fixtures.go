@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// maxFixturePDF is the largest PDF a recording keeps; fixtures belong in the repository.
+const maxFixturePDF = 1 << 20
+
+// fixtureHeaders are the response headers a fixture keeps. Cookies, server banners and dates are
+// left out, as they are either secret or make every recording differ.
+var fixtureHeaders = []string{"Content-Type", "Content-Disposition", "ETag", "Last-Modified", "Retry-After", "Location"}
+
+// fixture is one recorded upstream exchange, stored as <key>.json beside its body in <key>.body.
+type fixture struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"` // Redacted, as it is matched on replay
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+}
+
+// fixtureKey names the fixture of a request after its method and redacted URL.
+func fixtureKey(request *http.Request) (string, string) {
+	target := activeRedactor.text(request.URL.String())
+	sum := sha256.Sum256([]byte(request.Method + " " + target))
+	return hex.EncodeToString(sum[:8]), target
+}
+
+// recordingTransport saves sanitized copies of real upstream responses into a fixture directory:
+// every listing page, with secrets masked, and the first small PDF. A later run with
+// replay_fixtures answers from them without the network, so parser and downloader behaviour can be
+// checked against known answers.
+type recordingTransport struct {
+	base  http.RoundTripper
+	dir   string
+	mutex sync.Mutex
+	pdfs  int // PDFs recorded so far
+}
+
+// newRecordingTransport wraps base when a fixture directory is configured.
+func newRecordingTransport(cfg *Config, base http.RoundTripper) http.RoundTripper {
+	if cfg.RecordFixtures == "" {
+		return base
+	}
+	log.Printf("recording upstream responses into %s", cfg.RecordFixtures)
+	return &recordingTransport{base: base, dir: cfg.RecordFixtures}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (transport *recordingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := transport.base.RoundTrip(request)
+	if err != nil {
+		return response, err
+	}
+	mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	isPDF := mediaType == "application/pdf" || mediaType == "application/octet-stream"
+	if isPDF {
+		transport.mutex.Lock()
+		wanted := transport.pdfs == 0 && response.ContentLength >= 0 && response.ContentLength <= maxFixturePDF
+		if wanted {
+			transport.pdfs = transport.pdfs + 1
+		}
+		transport.mutex.Unlock()
+		if !wanted {
+			return response, nil
+		}
+	}
+	limit := int64(maxFixturePDF)
+	if !isPDF {
+		limit = 64 << 20
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, limit+1))
+	response.Body.Close()
+	response.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		response.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), errorReader{err}))
+		return response, nil
+	}
+	if int64(len(body)) > limit {
+		return response, nil
+	}
+	saved := body
+	if !isPDF {
+		// Listing pages may echo tokens and user names; PDFs are kept byte for byte so hashes hold.
+		saved = []byte(activeRedactor.text(string(body)))
+	}
+	if err := transport.save(request, response, saved); err != nil {
+		log.Println("Failed to record fixture:", err)
+	}
+	return response, nil
+}
+
+// save writes the fixture of one exchange.
+func (transport *recordingTransport) save(request *http.Request, response *http.Response, body []byte) error {
+	if err := os.MkdirAll(transport.dir, 0o755); err != nil {
+		return err
+	}
+	key, target := fixtureKey(request)
+	recorded := fixture{Method: request.Method, URL: target, Status: response.StatusCode, Header: make(http.Header)}
+	for _, name := range fixtureHeaders {
+		if value := response.Header.Get(name); value != "" {
+			recorded.Header.Set(name, activeRedactor.text(value))
+		}
+	}
+	encoded, err := json.MarshalIndent(recorded, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomically(filepath.Join(transport.dir, key+".body"), body, 0o644); err != nil {
+		return err
+	}
+	return writeFileAtomically(filepath.Join(transport.dir, key+".json"), append(encoded, '\n'), 0o644)
+}
+
+// errorReader returns its error on every read, ending a body that failed part way.
+type errorReader struct {
+	err error
+}
+
+// Read implements io.Reader.
+func (reader errorReader) Read([]byte) (int, error) {
+	return 0, reader.err
+}
+
+// replayTransport answers upstream requests from recorded fixtures and never touches the network.
+type replayTransport struct {
+	dir string
+}
+
+// RoundTrip implements http.RoundTripper. A request nothing was recorded for fails like an
+// unreachable host, so the run reports it instead of silently skipping it.
+func (transport *replayTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	key, target := fixtureKey(request)
+	content, err := os.ReadFile(filepath.Join(transport.dir, key+".json"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no recorded fixture for %s %s in %s", request.Method, target, transport.dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var recorded fixture
+	if err := json.Unmarshal(content, &recorded); err != nil {
+		return nil, fmt.Errorf("fixture %s.json: %v", key, err)
+	}
+	body, err := os.ReadFile(filepath.Join(transport.dir, key+".body"))
+	if err != nil {
+		return nil, err
+	}
+	if request.Body != nil {
+		request.Body.Close()
+	}
+	header := recorded.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.Status, http.StatusText(recorded.Status)),
+		StatusCode:    recorded.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       request,
+	}, nil
+}
+
+// fixtureBaseTransport returns the transport closest to the network: fixtures when replaying,
+// otherwise base, recorded when recording.
+func fixtureBaseTransport(cfg *Config, base http.RoundTripper) http.RoundTripper {
+	if cfg.ReplayFixtures != "" {
+		log.Printf("answering upstream requests from the fixtures in %s", cfg.ReplayFixtures)
+		return &replayTransport{dir: strings.TrimSuffix(cfg.ReplayFixtures, string(filepath.Separator))}
+	}
+	return newRecordingTransport(cfg, base)
+}
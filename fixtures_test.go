@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// updateGolden rewrites the golden files from what the code produces now: go test -run Golden -update.
+// The fixtures themselves are recorded with -record-fixtures testdata/fixtures.
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// goldenConfig returns a config answering upstream requests from testdata/fixtures, with every file
+// a run writes kept in a temporary directory.
+func goldenConfig(t *testing.T) *Config {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := defaultConfig()
+	cfg.ServiceURL = "https://sds.example.com/v1/SDS"
+	cfg.ReplayFixtures = filepath.Join("testdata", "fixtures")
+	cfg.UseMetadata = false
+	cfg.ScrapeWorkers = 1
+	cfg.HostDelay = Duration{}
+	cfg.InputFile = filepath.Join(dir, "headers.json")
+	cfg.ScrapeState = filepath.Join(dir, "scrape-state.json")
+	cfg.SnapshotDir = filepath.Join(dir, "snapshots")
+	cfg.OutputDir = filepath.Join(dir, "out")
+	return cfg
+}
+
+// checkGolden compares got with testdata/golden/name, or rewrites the file with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", "golden", name)
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v; run go test -run %s -update to create it", err, t.Name())
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from %s:\n%s\nrun go test -run %s -update if the change is intended", t.Name(), path, got, t.Name())
+	}
+}
+
+// scrapeGolden scrapes the recorded header pages into cfg.InputFile.
+func scrapeGolden(t *testing.T, cfg *Config) {
+	t.Helper()
+	if err := scrapeHeaders(context.Background(), cfg, true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// listGolden lists the documents of the scraped headers.
+func listGolden(t *testing.T, cfg *Config) []documentRef {
+	t.Helper()
+	source, err := newSABICSource(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(chan documentRef)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(out)
+		errs <- source.List(context.Background(), out, nil)
+	}()
+	var docs []documentRef
+	for doc := range out {
+		docs = append(docs, doc)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	return docs
+}
+
+// TestGoldenScrape checks the header file a scrape of the recorded pages merges, following __next.
+func TestGoldenScrape(t *testing.T) {
+	cfg := goldenConfig(t)
+	scrapeGolden(t, cfg)
+	merged, err := os.ReadFile(cfg.InputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "headers.json", merged)
+}
+
+// TestGoldenListing checks the documents, file names and properties parsed from the recorded pages.
+func TestGoldenListing(t *testing.T) {
+	cfg := goldenConfig(t)
+	scrapeGolden(t, cfg)
+	var listing strings.Builder
+	for _, doc := range listGolden(t, cfg) {
+		fmt.Fprintf(&listing, "%s\n  id %s\n  url %s\n", doc.filename, doc.id, doc.url)
+		names := make([]string, 0, len(doc.properties))
+		for name := range doc.properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&listing, "  %s=%s\n", name, doc.properties[name])
+		}
+	}
+	checkGolden(t, "listing.txt", []byte(listing.String()))
+}
+
+// TestGoldenDownload checks what the downloader makes of the recorded PDF, and that documents
+// nothing was recorded for fail instead of being skipped.
+func TestGoldenDownload(t *testing.T) {
+	cfg := goldenConfig(t)
+	scrapeGolden(t, cfg)
+	source, err := newSABICSource(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report strings.Builder
+	for _, doc := range listGolden(t, cfg) {
+		target := filepath.Join(cfg.OutputDir, doc.filename)
+		staged, err := fetchSingleDocument(context.Background(), cfg, source, doc, target)
+		if err != nil {
+			fmt.Fprintf(&report, "%s failed\n", doc.filename)
+			continue
+		}
+		fmt.Fprintf(&report, "%s %d bytes sha256 %s\n", doc.filename, staged.size, staged.sha256)
+	}
+	checkGolden(t, "download.txt", []byte(report.String()))
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"sort"
+	"strings"
+)
+
+// formatPDF is the representation every document is fetched in.
+const formatPDF = "pdf"
+
+// documentFormat is a representation of a report the upstream may serve besides the PDF.
+type documentFormat struct {
+	extension  string   // File extension of stored copies, with the dot
+	mediaTypes []string // Content types accepted for it, the first one is asked for
+	magic      []string // Leading bytes of a valid file, any one of them
+}
+
+// documentFormats are the known representations by name. DOCX files are zip archives, so the
+// magic only tells them from HTML error pages, not from other archives.
+var documentFormats = map[string]documentFormat{
+	formatPDF: {extension: ".pdf", mediaTypes: []string{"application/pdf"}, magic: []string{"%PDF-"}},
+	"docx":    {extension: ".docx", mediaTypes: []string{"application/vnd.openxmlformats-officedocument.wordprocessingml.document", "application/octet-stream"}, magic: []string{"PK\x03\x04"}},
+	"rtf":     {extension: ".rtf", mediaTypes: []string{"application/rtf", "text/rtf"}, magic: []string{`{\rtf`}},
+}
+
+// alternateFormatNames returns the names of the known representations besides the PDF, sorted.
+func alternateFormatNames() []string {
+	names := make([]string, 0, len(documentFormats))
+	for name := range documentFormats {
+		if name != formatPDF {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// formatOf returns the representation a document is fetched in.
+func (doc documentRef) formatOf() string {
+	if doc.format == "" {
+		return formatPDF
+	}
+	return doc.format
+}
+
+// formatOfFilename returns the representation of a stored file by its extension, pdf for anything unknown.
+func formatOfFilename(filename string) string {
+	for name, format := range documentFormats {
+		if strings.HasSuffix(filename, format.extension) {
+			return name
+		}
+	}
+	return formatPDF
+}
+
+// isDocumentFile reports whether a file name carries the extension of a known representation.
+func isDocumentFile(filename string) bool {
+	for _, format := range documentFormats {
+		if strings.HasSuffix(filename, format.extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// alternateDocuments returns the alternate representations of a planned PDF, one per configured
+// format, stored beside it under the same name with the format's extension. Only documents keyed
+// like SABIC's have them, as only SAP EHS systems serve them.
+func alternateDocuments(cfg *Config, doc documentRef) []documentRef {
+	if doc.format != "" || doc.filename == "" || doc.id.isZero() || len(cfg.AlternateFormats) == 0 {
+		return nil
+	}
+	alternates := make([]documentRef, 0, len(cfg.AlternateFormats))
+	for _, name := range cfg.AlternateFormats {
+		format := documentFormats[name]
+		alternate := doc
+		alternate.format = name
+		alternate.filename = strings.TrimSuffix(doc.filename, documentFormats[formatPDF].extension) + format.extension
+		alternates = append(alternates, alternate)
+	}
+	return alternates
+}
+
+// Content type checks of fetched documents.
+const (
+	contentTypeStrict  = "strict"  // Only the format's own content types pass
+	contentTypeLenient = "lenient" // Generic content types pass too when the content starts like the format
+)
+
+// genericContentTypes are what gateways send for content they don't know the type of.
+var genericContentTypes = []string{"application/octet-stream", "binary/octet-stream", "application/download", "application/x-download", "application/force-download", "application/unknown"}
+
+// pdfSniffLength is how far into a body the PDF header is looked for; readers accept up to 1024
+// bytes of junk before it.
+const pdfSniffLength = 1024
+
+// isGenericContentType reports whether a content type says nothing about the content, a missing one included.
+func isGenericContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.TrimSpace(contentType) == ""
+	}
+	for _, generic := range genericContentTypes {
+		if strings.EqualFold(mediaType, generic) {
+			return true
+		}
+	}
+	return false
+}
+
+// sniffPDF reports whether a body starts like a PDF, without consuming anything of it.
+func sniffPDF(body *bufio.Reader) bool {
+	head, _ := body.Peek(pdfSniffLength)
+	return bytes.Contains(head, []byte("%PDF-"))
+}
+
+// validateContentTypeCheck reports a content type check that is neither strict nor lenient.
+func validateContentTypeCheck(cfg *Config) error {
+	if cfg.ContentTypeCheck != contentTypeStrict && cfg.ContentTypeCheck != contentTypeLenient {
+		return fmt.Errorf("unknown content type check %q, expected %s or %s", cfg.ContentTypeCheck, contentTypeStrict, contentTypeLenient)
+	}
+	return nil
+}
+
+// checkFormatContentType rejects a response announced as something other than the requested alternate format.
+func checkFormatContentType(name string, contentType string) error {
+	format := documentFormats[name]
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil {
+		for _, accepted := range format.mediaTypes {
+			if strings.EqualFold(mediaType, accepted) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: invalid content type %s for %s (expected %s)", ErrWrongFormat, contentType, name, format.mediaTypes[0])
+}
+
+// checkFormatMagic rejects a staged alternate whose content doesn't start like the format. PDFs are
+// left to the validation checks, which read them properly.
+func checkFormatMagic(name string, path string) error {
+	if name == formatPDF {
+		return nil
+	}
+	format := documentFormats[name]
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrStorage, err)
+	}
+	defer file.Close()
+	head := make([]byte, 8)
+	n, _ := io.ReadFull(file, head)
+	for _, magic := range format.magic {
+		if bytes.HasPrefix(head[:n], []byte(magic)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: content doesn't start with the %s signature", ErrWrongFormat, strings.ToUpper(name))
+}
+
+// validateAlternateStaged runs the checks configured for an alternate format, under its upper-cased
+// name in Config.Validation. The report type's checks read PDFs and don't apply.
+func validateAlternateStaged(cfg *Config, name string, path string, size int64) error {
+	if err := checkFormatMagic(name, path); err != nil {
+		return err
+	}
+	file := &stagedFile{path: path, size: size}
+	for _, check := range cfg.Validation[strings.ToUpper(name)] {
+		if err := validators[check.Check](check, file); err != nil {
+			return fmt.Errorf("%s check failed: %w", check.Check, err)
+		}
+	}
+	return nil
+}
+
+// validateAlternateFormats reports the first configured alternate format that isn't known.
+func validateAlternateFormats(cfg *Config) error {
+	for _, name := range cfg.AlternateFormats {
+		if _, ok := documentFormats[name]; !ok || name == formatPDF {
+			return fmt.Errorf("unknown alternate format %q, known formats are %s", name, strings.Join(alternateFormatNames(), ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+// collectGarbage removes the files in the output directory that no catalog entry refers to,
+// such as renamed, evicted or superseded documents, and returns how many there were and their size.
+// Every document removed is recorded as evicted in the audit log. A dry run only reports them.
+func collectGarbage(store documentStore, docs *catalog, audit *auditLog, dryRun bool) (int, int64, error) {
+	referenced := make(map[string]bool)
+	for _, entry := range docs.all() {
+		referenced[entry.Filename] = true
+	}
+	// Without a catalog everything would look orphaned.
+	if len(referenced) == 0 {
+		return 0, 0, fmt.Errorf("the catalog is empty, refusing to treat every stored file as orphaned")
+	}
+	// Names the catalog dropped leave the index first, so their content becomes unreferenced.
+	names, err := store.names()
+	if err != nil && !os.IsNotExist(err) {
+		return 0, 0, err
+	}
+	for _, name := range names {
+		if !referenced[name] {
+			log.Printf("unreferenced document: %s", name)
+			if !dryRun {
+				store.forget(name)
+				audit.record(auditEvicted, name, "", "")
+			}
+		}
+	}
+	orphans, err := store.orphans(referenced)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, 0, err
+	}
+	sort.Strings(orphans)
+	var reclaimed int64
+	for _, orphan := range orphans {
+		info, err := os.Stat(orphan)
+		if err != nil {
+			continue
+		}
+		if dryRun {
+			log.Printf("would remove %s (%d bytes)", orphan, info.Size())
+		} else {
+			if err := os.Remove(orphan); err != nil {
+				log.Println(err)
+				continue
+			}
+			log.Printf("removed %s (%d bytes)", orphan, info.Size())
+		}
+		reclaimed = reclaimed + info.Size()
+	}
+	if dryRun {
+		return len(orphans), reclaimed, nil
+	}
+	return len(orphans), reclaimed, store.flush()
+}
+
+// runGC implements the gc command.
+func runGC(args []string) error {
+	var dryRun bool
+	cfg, _, err := loadConfig("gc", args, func(flagSet *flag.FlagSet) {
+		flagSet.BoolVar(&dryRun, "dry-run", false, "report orphaned files without removing them")
+	})
+	if err != nil {
+		return err
+	}
+	lock, err := acquireRunLock(cfg)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+	docs, err := openCatalog(cfg.CatalogFile)
+	if err != nil {
+		return err
+	}
+	store, err := openDocumentStore(cfg)
+	if err != nil {
+		return err
+	}
+	audit, err := openAuditLog(cfg.AuditLog)
+	if err != nil {
+		return err
+	}
+	count, reclaimed, err := collectGarbage(store, docs, audit, dryRun)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		log.Printf("%d orphaned files, %d bytes could be reclaimed", count, reclaimed)
+		return nil
+	}
+	log.Printf("removed %d orphaned files, reclaimed %d bytes", count, reclaimed)
+	return nil
+}
@@ -1,3 +1,9 @@
 module github.com/Strong-Foundation/sabic-com-documentation
 
 go 1.24.4
+
+require (
+	golang.org/x/sys v0.36.0
+	golang.org/x/term v0.35.0
+	golang.org/x/text v0.29.0
+)
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// hashJob is a completed download on its way to the writer, done once its checksum is known.
+type hashJob struct {
+	outcome fetchOutcome
+	done    chan struct{} // Closed once outcome is hashed or needs no hash
+}
+
+// hashPool hashes completed downloads on goroutines of its own, so the download workers go back to
+// the network as soon as the last byte is on disk. Downloads are handed to the writer in the order
+// they completed however long each one takes to hash, so the catalog and the audit log see the
+// same order as without the pool. Validation stays with the download workers, whose retry
+// policies cover rejected responses.
+type hashPool struct {
+	jobs    chan *hashJob // Downloads waiting for a hash worker
+	ordered chan *hashJob // Every download in completion order, waiting for the writer
+}
+
+// newHashPool returns a pool whose queues hold queueSize downloads each.
+func newHashPool(queueSize int) *hashPool {
+	return &hashPool{jobs: make(chan *hashJob, queueSize), ordered: make(chan *hashJob, queueSize)}
+}
+
+// run hashes the downloads from in with workers goroutines and returns them, hashed, in the order
+// they arrived. The returned channel is closed after the last one.
+func (pool *hashPool) run(fetcher *downloader, in <-chan fetchOutcome, workers int) <-chan fetchOutcome {
+	for range max(workers, 1) {
+		go func() {
+			for job := range pool.jobs {
+				start := time.Now()
+				if err := hashStaged(job.outcome.staged); err != nil {
+					os.Remove(job.outcome.staged.tempPath)
+					job.outcome.staged, job.outcome.err = nil, err
+				}
+				fetcher.timings.track(stageHash, start)
+				close(job.done)
+			}
+		}()
+	}
+	go func() {
+		defer close(pool.jobs)
+		defer close(pool.ordered)
+		for outcome := range in {
+			job := &hashJob{outcome: outcome, done: make(chan struct{})}
+			if outcome.staged != nil && outcome.staged.sha256 == "" {
+				pool.jobs <- job
+			} else {
+				close(job.done)
+			}
+			pool.ordered <- job
+		}
+	}()
+	hashed := make(chan fetchOutcome)
+	go func() {
+		defer close(hashed)
+		for job := range pool.ordered {
+			<-job.done
+			hashed <- job.outcome
+		}
+	}()
+	return hashed
+}
+
+// hashStaged computes the checksum of a staged document from its temporary file.
+func hashStaged(staged *stagedDocument) error {
+	file, err := os.Open(staged.tempPath)
+	if err != nil {
+		return fmt.Errorf("%w: failed to hash %s: %w", ErrStorage, staged.url, err)
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return fmt.Errorf("%w: failed to hash %s: %w", ErrStorage, staged.url, err)
+	}
+	staged.sha256 = hex.EncodeToString(hasher.Sum(nil))
+	return nil
+}
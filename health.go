@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Thresholds of the upstream health score, see endpointHealth.evaluate.
+const (
+	degradedErrorRate   = 0.2  // Share of failed requests that halves the concurrency
+	degradedLatency     = 3.0  // Median latency over the endpoint's best, as a factor, that halves it
+	recoveredErrorRate  = 0.05 // Share of failed requests below which the concurrency grows again
+	recoveredLatency    = 1.5  // Latency factor below which it grows again
+	defaultHealthWindow = 20   // Requests the statistics cover when health_window isn't set
+)
+
+// healthSample is the outcome of one upstream request.
+type healthSample struct {
+	latency time.Duration // Time until the response headers arrived
+	failed  bool          // Transport error, 5xx or 429
+}
+
+// endpointHealth keeps rolling statistics of one upstream host and the concurrency they allow.
+// The limit follows the health like TCP congestion control: halved when the host degrades,
+// grown by one when it has recovered, between 1 and the configured workers.
+type endpointHealth struct {
+	samples  []healthSample // Ring of the latest requests
+	next     int            // Position of the next sample in the ring
+	filled   bool           // Whether the ring has wrapped
+	since    int            // Samples since the limit last changed
+	best     time.Duration  // Best median latency seen, the host's healthy baseline
+	limit    int            // Concurrent downloads currently allowed
+	inFlight int            // Downloads holding a slot
+}
+
+// healthTracker scores every upstream host and limits the concurrent downloads from each.
+// It is shared by the runs of a process, so a daemon starts where the last run left off.
+type healthTracker struct {
+	mutex     sync.Mutex
+	endpoints map[string]*endpointHealth
+	window    int
+	maxLimit  int
+	adaptive  bool          // Whether limits follow the health or stay at maxLimit
+	changed   chan struct{} // Closed and replaced whenever a slot frees up or a limit grows
+}
+
+// upstreamHealth is the tracker of the running process, configured by configureHealth.
+var upstreamHealth = &healthTracker{endpoints: make(map[string]*endpointHealth), window: defaultHealthWindow, maxLimit: 1, changed: make(chan struct{})}
+
+// configureHealth sets the window and the concurrency ceiling of the tracker for a run.
+// With adaptive concurrency off, every host gets the full worker count.
+func configureHealth(cfg *Config) {
+	upstreamHealth.mutex.Lock()
+	defer upstreamHealth.mutex.Unlock()
+	upstreamHealth.window = cfg.HealthWindow
+	if upstreamHealth.window <= 0 {
+		upstreamHealth.window = defaultHealthWindow
+	}
+	upstreamHealth.maxLimit = max(cfg.Workers, 1)
+	for _, endpoint := range upstreamHealth.endpoints {
+		if !cfg.AdaptiveConcurrency || endpoint.limit > upstreamHealth.maxLimit {
+			endpoint.limit = upstreamHealth.maxLimit
+		}
+	}
+	upstreamHealth.adaptive = cfg.AdaptiveConcurrency
+}
+
+// endpoint returns the statistics of a host, starting it at the full worker count; the caller holds the mutex.
+func (tracker *healthTracker) endpoint(host string) *endpointHealth {
+	endpoint, ok := tracker.endpoints[host]
+	if !ok {
+		endpoint = &endpointHealth{samples: make([]healthSample, tracker.window), limit: tracker.maxLimit}
+		tracker.endpoints[host] = endpoint
+	}
+	return endpoint
+}
+
+// wake lets waiting workers check for a free slot; the caller holds the mutex.
+func (tracker *healthTracker) wake() {
+	close(tracker.changed)
+	tracker.changed = make(chan struct{})
+}
+
+// acquire takes a download slot for the host of documentURL, blocking while the host is at its limit.
+func (tracker *healthTracker) acquire(ctx context.Context, documentURL string) (func(), error) {
+	host := hostOf(documentURL)
+	for {
+		tracker.mutex.Lock()
+		endpoint := tracker.endpoint(host)
+		if endpoint.inFlight < endpoint.limit {
+			endpoint.inFlight = endpoint.inFlight + 1
+			tracker.mutex.Unlock()
+			return func() { tracker.release(host) }, nil
+		}
+		changed := tracker.changed
+		tracker.mutex.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// release hands a slot back.
+func (tracker *healthTracker) release(host string) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	endpoint := tracker.endpoint(host)
+	endpoint.inFlight = endpoint.inFlight - 1
+	tracker.wake()
+}
+
+// observe records the outcome of a request to host and adjusts the host's limit when its health changed.
+func (tracker *healthTracker) observe(host string, sample healthSample) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	endpoint := tracker.endpoint(host)
+	endpoint.samples[endpoint.next] = sample
+	endpoint.next = (endpoint.next + 1) % len(endpoint.samples)
+	endpoint.filled = endpoint.filled || endpoint.next == 0
+	endpoint.since = endpoint.since + 1
+	score, errorRate, latencyFactor := endpoint.evaluate()
+	metrics.gauge("sabic_upstream_health", map[string]string{"host": host}, score)
+	// Give each change half a window of requests to take effect before judging again.
+	if !tracker.adaptive || endpoint.since < len(endpoint.samples)/2 {
+		return
+	}
+	switch {
+	case (errorRate >= degradedErrorRate || latencyFactor >= degradedLatency) && endpoint.limit > 1:
+		endpoint.limit = max(endpoint.limit/2, 1)
+		endpoint.since = 0
+		log.Printf("%s is degrading (%.0f%% errors, latency %.1fx its best), lowering concurrency to %d", host, 100*errorRate, latencyFactor, endpoint.limit)
+	case errorRate < recoveredErrorRate && latencyFactor < recoveredLatency && endpoint.limit < tracker.maxLimit:
+		endpoint.limit = endpoint.limit + 1
+		endpoint.since = 0
+		log.Printf("%s is healthy, raising concurrency to %d", host, endpoint.limit)
+		tracker.wake()
+	}
+	metrics.gauge("sabic_upstream_concurrency", map[string]string{"host": host}, float64(endpoint.limit))
+}
+
+// evaluate returns the health score of the endpoint between 0 and 1, its error rate and its median
+// latency as a factor of the best seen.
+func (endpoint *endpointHealth) evaluate() (float64, float64, float64) {
+	count := endpoint.next
+	if endpoint.filled {
+		count = len(endpoint.samples)
+	}
+	if count == 0 {
+		return 1, 0, 1
+	}
+	failures := 0
+	latencies := make([]time.Duration, 0, count)
+	for _, sample := range endpoint.samples[:count] {
+		if sample.failed {
+			failures = failures + 1
+			continue
+		}
+		latencies = append(latencies, sample.latency)
+	}
+	errorRate := float64(failures) / float64(count)
+	latencyFactor := 1.0
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		median := latencies[len(latencies)/2]
+		if endpoint.best == 0 || median < endpoint.best {
+			endpoint.best = median
+		} else {
+			// Let the baseline creep up, so a host that settled at a slower pace isn't held down for good.
+			endpoint.best = endpoint.best + (median-endpoint.best)/100
+		}
+		if endpoint.best > 0 {
+			latencyFactor = float64(median) / float64(endpoint.best)
+		}
+	}
+	return (1 - errorRate) * min(1, recoveredLatency/latencyFactor), errorRate, latencyFactor
+}
+
+// hostOf returns the host of a URL, empty when it has none.
+func hostOf(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return parsed.Host
+}
+
+// healthTransport feeds the outcome of every upstream request to the tracker.
+type healthTransport struct {
+	base    http.RoundTripper
+	tracker *healthTracker
+}
+
+// RoundTrip implements http.RoundTripper.
+func (transport *healthTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	start := time.Now()
+	response, err := transport.base.RoundTrip(request)
+	// A cancelled run says nothing about the upstream.
+	if request.Context().Err() != nil {
+		return response, err
+	}
+	failed := err != nil || response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests
+	transport.tracker.observe(request.URL.Host, healthSample{latency: time.Since(start), failed: failed})
+	return response, err
+}
+
+func init() {
+	metrics.describe("sabic_upstream_health", "Health score of an upstream host between 0 and 1, from its recent error rate and latency.")
+	metrics.describe("sabic_upstream_concurrency", "Concurrent downloads currently allowed from an upstream host.")
+}
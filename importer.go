@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// looseIdentityPattern finds Matnr, Subid, Sbgvid and Laiso in text following our file name convention
+// with any separator, e.g. "290031915 630000000001 SDS-FR fr" in a renamed file or a PDF title.
+var looseIdentityPattern = regexp.MustCompile(`(?i)(\d{6,18})[ _.-]+(\d{6,18})[ _.-]+([a-z]{2,4})[ _.-]([a-z]{2,3})[ _.-]+([a-z]{2})\b`)
+
+// pdfInfoPattern matches the text entries of a PDF's document information dictionary.
+var pdfInfoPattern = regexp.MustCompile(`/(?:Title|Subject|Keywords)\s*\(([^)]*)\)`)
+
+// identifyImportedFile works out the document identity of a PDF from its name, then from its
+// uncompressed Title, Subject and Keywords. The identity is only accepted when all keys are present,
+// since sync recognizes documents by their full stored name.
+func identifyImportedFile(path string, content []byte) (headerResult, bool) {
+	name := strings.ToLower(filepath.Base(path))
+	_, rest := splitInternalCode(name)
+	if document, ok := parseDocumentFilename(rest); ok && strings.HasSuffix(rest, ".pdf") {
+		return headerResult{MaterialNumber: document.Material, SubID: document.SubID, StorageLocation: document.Sbgvid, LanguageISO: document.Language}, true
+	}
+	candidates := []string{name}
+	for _, match := range pdfInfoPattern.FindAllSubmatch(content, -1) {
+		candidates = append(candidates, string(match[1]))
+	}
+	for _, candidate := range candidates {
+		if match := looseIdentityPattern.FindStringSubmatch(candidate); match != nil {
+			return headerResult{
+				MaterialNumber:  match[1],
+				SubID:           match[2],
+				StorageLocation: strings.ToUpper(match[3] + "_" + match[4]),
+				LanguageISO:     strings.ToUpper(match[5]),
+			}, true
+		}
+	}
+	return headerResult{}, false
+}
+
+// importer adopts existing PDFs into the store and the catalog.
+type importer struct {
+	cfg       *Config
+	audit     *auditLog
+	catalog   *catalog
+	materials materialMap
+	store     documentStore
+	move      bool // Remove the originals once stored
+	dryRun    bool // Only report what would be imported
+}
+
+// importFile stores one PDF under the name sync would give it and records it in the catalog.
+// It returns the stored name, or "" when the file was left alone.
+func (adopter *importer) importFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(string(content), "%PDF-") {
+		return "", fmt.Errorf("%w: %s", ErrNotPDF, path)
+	}
+	identity, ok := identifyImportedFile(path, content)
+	if !ok {
+		return "", fmt.Errorf("no document identity in the name or metadata of %s", path)
+	}
+	sourceURL := documentURL(adopter.cfg, identity)
+	id, err := documentIDFromURL(sourceURL)
+	if err != nil {
+		return "", err
+	}
+	filename := documentFilename(adopter.materials, id)
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	// Never overwrite what sync or an earlier import stored.
+	if entry, ok := adopter.catalog.get(filename); ok && entry.SHA256 != "" {
+		if entry.SHA256 != hash {
+			log.Printf("%s: %s is already stored with different content, keeping the stored copy", path, filename)
+		}
+		return "", nil
+	}
+	if adopter.dryRun {
+		log.Printf("would import %s as %s", path, filename)
+		return filename, nil
+	}
+	// A file already in the store, e.g. when importing the output directory itself, only needs cataloging.
+	if storedPath, ok := adopter.store.path(filename); ok {
+		if storedHash, err := readableSHA256(storeKey(adopter.store), storedPath); err != nil || storedHash != hash {
+			log.Printf("%s: %s is already stored with different content, keeping the stored copy", path, filename)
+			return "", nil
+		}
+		adopter.register(filename, path, sourceURL, id, identity, hash, int64(len(content)))
+		return filename, nil
+	}
+	// Stage a copy beside the store so the original stays untouched until the copy is in place.
+	if !directoryExists(adopter.cfg.OutputDir) {
+		createDirectory(adopter.cfg.OutputDir, 0o755)
+	}
+	temp, err := os.CreateTemp(adopter.cfg.OutputDir, "."+filename+".part-*")
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrStorage, err)
+	}
+	if _, err := temp.Write(content); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return "", fmt.Errorf("%w: %w", ErrStorage, err)
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return "", fmt.Errorf("%w: %w", ErrStorage, err)
+	}
+	os.Chmod(temp.Name(), 0o644)
+	if _, err := adopter.store.put(temp.Name(), filename, hash); err != nil {
+		os.Remove(temp.Name())
+		return "", fmt.Errorf("%w: failed to store %s: %w", ErrStorage, filename, err)
+	}
+	adopter.register(filename, path, sourceURL, id, identity, hash, int64(len(content)))
+	if adopter.move {
+		if err := os.Remove(path); err != nil {
+			log.Println(err)
+		}
+	}
+	return filename, nil
+}
+
+// register records an imported document in the audit log and the catalog.
+func (adopter *importer) register(filename, path, sourceURL string, id DocumentID, identity headerResult, hash string, size int64) {
+	// Keep the time the file was downloaded by hand, as far as the file system remembers it.
+	downloadedAt := time.Now().UTC()
+	if info, err := os.Stat(path); err == nil {
+		downloadedAt = info.ModTime().UTC()
+	}
+	adopter.audit.record(auditImported, filename, path, hash)
+	adopter.catalog.update(filename, func(entry *catalogEntry) {
+		entry.Source = "import"
+		entry.DocumentID = id.String()
+		entry.SourceURL = sourceURL
+		entry.InternalCode = adopter.materials.internalCodeFor(identity.MaterialNumber)
+		entry.SHA256 = hash
+		entry.Size = size
+		entry.DownloadedAt = downloadedAt
+		entry.Encrypted = storeKey(adopter.store) != nil
+	})
+	log.Printf("imported %s as %s", path, filename)
+}
+
+// fileSHA256 returns the hex SHA-256 of a file's content.
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// runImport implements the import command, adopting every PDF below the given directories.
+func runImport(args []string) error {
+	var move, dryRun bool
+	cfg, dirs, err := loadConfig("import", args, func(flagSet *flag.FlagSet) {
+		flagSet.BoolVar(&move, "move", false, "remove the original files once they are stored")
+		flagSet.BoolVar(&dryRun, "dry-run", false, "report what would be imported without storing anything")
+	})
+	if err != nil {
+		return err
+	}
+	if len(dirs) == 0 {
+		return fmt.Errorf("usage: import [flags] <dir>...")
+	}
+	lock, err := acquireRunLock(cfg)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+	audit, err := openAuditLog(cfg.AuditLog)
+	if err != nil {
+		return err
+	}
+	docs, err := openCatalog(cfg.CatalogFile)
+	if err != nil {
+		return err
+	}
+	materials, err := loadMaterialMap(cfg.MaterialMap)
+	if err != nil {
+		return err
+	}
+	store, err := openDocumentStore(cfg)
+	if err != nil {
+		return err
+	}
+	adopter := &importer{cfg: cfg, audit: audit, catalog: docs, materials: materials, store: store, move: move, dryRun: dryRun}
+	var imported, skipped, failed int
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() || !strings.EqualFold(filepath.Ext(path), ".pdf") {
+				return nil
+			}
+			filename, err := adopter.importFile(path)
+			switch {
+			case err != nil:
+				log.Println(err)
+				failed = failed + 1
+			case filename == "":
+				skipped = skipped + 1
+			default:
+				imported = imported + 1
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	log.Printf("imported %d documents, %d already stored, %d failed", imported, skipped, failed)
+	if dryRun {
+		return nil
+	}
+	if err := store.flush(); err != nil {
+		return err
+	}
+	return docs.save()
+}
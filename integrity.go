@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// subresourceIntegrity renders a hex SHA-256 as an SRI integrity value, e.g. sha256-47DEQpj8...=,
+// the form browsers check in <a integrity> and fetch(); empty when the hash isn't valid.
+func subresourceIntegrity(sha256Hex string) string {
+	sum, err := hex.DecodeString(sha256Hex)
+	if err != nil || len(sum) != 32 {
+		return ""
+	}
+	return "sha256-" + base64.StdEncoding.EncodeToString(sum)
+}
+
+// withChecksums fills in the checksum and integrity value of each document from the catalog.
+// Entries whose size no longer matches the file are left out rather than advertising a stale hash.
+func withChecksums(docs *catalog, documents []documentInfo) {
+	for i := range documents {
+		entry, ok := docs.get(documents[i].Name)
+		if !ok || entry.Size != documents[i].Size {
+			continue
+		}
+		documents[i].SHA256 = entry.SHA256
+		documents[i].Integrity = subresourceIntegrity(entry.SHA256)
+	}
+}
+
+// fileDigest is a hash computed for a served file, valid while its size and modification time stay the same.
+type fileDigest struct {
+	size     int64
+	modified time.Time
+	sha256   string
+}
+
+// digestCache remembers the hashes of served files so each is read only once per change.
+type digestCache struct {
+	mutex   sync.Mutex
+	digests map[string]fileDigest
+	key     []byte // Key of an encrypted store, whose files are hashed as plaintext
+}
+
+// sha256 returns the hex SHA-256 of the file at path, hashing it again only when it changed.
+func (cache *digestCache) sha256(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	cache.mutex.Lock()
+	cached, ok := cache.digests[path]
+	cache.mutex.Unlock()
+	if ok && cached.size == info.Size() && cached.modified.Equal(info.ModTime()) {
+		return cached.sha256, nil
+	}
+	sum, err := readableSHA256(cache.key, path)
+	if err != nil {
+		return "", err
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.digests == nil {
+		cache.digests = make(map[string]fileDigest)
+	}
+	cache.digests[path] = fileDigest{size: info.Size(), modified: info.ModTime(), sha256: sum}
+	return sum, nil
+}
+
+// setDigestHeaders announces the SHA-256 of a served file, in Repr-Digest (RFC 9530) and in the
+// older Digest header (RFC 3230) for clients that only know that one. Both cover the whole file,
+// also when a range of it is sent.
+func setDigestHeaders(header http.Header, sha256Hex string) {
+	sum, err := hex.DecodeString(sha256Hex)
+	if err != nil {
+		return
+	}
+	encoded := base64.StdEncoding.EncodeToString(sum)
+	header.Set("Repr-Digest", "sha-256=:"+encoded+":")
+	header.Set("Digest", "SHA-256="+encoded)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+)
+
+// jurisdictionInfo is the regulatory context an SDS was generated for.
+type jurisdictionInfo struct {
+	Country      string `json:"country"`      // ISO 3166 country code
+	Jurisdiction string `json:"jurisdiction"` // Regulatory area, e.g. EU for every REACH country
+	Regulation   string `json:"regulation"`   // SDS regulation of the area
+}
+
+// euRegulation is the SDS regulation of the EU and EEA.
+const euRegulation = "REACH (EC) 1907/2006 Annex II, CLP (EC) 1272/2008"
+
+// builtinJurisdictions maps the region part of Sbgvid values to their regulatory context.
+// Config.Jurisdictions overrides or extends it.
+var builtinJurisdictions = map[string]jurisdictionInfo{
+	"AT": {"AT", "EU", euRegulation}, "BE": {"BE", "EU", euRegulation}, "BG": {"BG", "EU", euRegulation},
+	"CY": {"CY", "EU", euRegulation}, "CZ": {"CZ", "EU", euRegulation}, "DE": {"DE", "EU", euRegulation},
+	"DK": {"DK", "EU", euRegulation}, "EE": {"EE", "EU", euRegulation}, "ES": {"ES", "EU", euRegulation},
+	"FI": {"FI", "EU", euRegulation}, "FR": {"FR", "EU", euRegulation}, "GR": {"GR", "EU", euRegulation},
+	"HR": {"HR", "EU", euRegulation}, "HU": {"HU", "EU", euRegulation}, "IE": {"IE", "EU", euRegulation},
+	"IT": {"IT", "EU", euRegulation}, "LT": {"LT", "EU", euRegulation}, "LU": {"LU", "EU", euRegulation},
+	"LV": {"LV", "EU", euRegulation}, "MT": {"MT", "EU", euRegulation}, "NL": {"NL", "EU", euRegulation},
+	"PL": {"PL", "EU", euRegulation}, "PT": {"PT", "EU", euRegulation}, "RO": {"RO", "EU", euRegulation},
+	"SE": {"SE", "EU", euRegulation}, "SI": {"SI", "EU", euRegulation}, "SK": {"SK", "EU", euRegulation},
+	"IS": {"IS", "EU", euRegulation}, "LI": {"LI", "EU", euRegulation}, "NO": {"NO", "EU", euRegulation},
+	"GB": {"GB", "GB", "UK REACH, GB CLP"},
+	"CH": {"CH", "CH", "Chemicals Ordinance (ChemO)"},
+	"TR": {"TR", "TR", "KKDIK"},
+	"RU": {"RU", "EAEU", "GOST 30333-2007"}, "BY": {"BY", "EAEU", "GOST 30333-2007"}, "KZ": {"KZ", "EAEU", "GOST 30333-2007"},
+	"US": {"US", "US", "OSHA HazCom 2012 (29 CFR 1910.1200)"},
+	"CA": {"CA", "CA", "WHMIS 2015 (Hazardous Products Regulations)"},
+	"MX": {"MX", "MX", "NOM-018-STPS-2015"},
+	"BR": {"BR", "BR", "ABNT NBR 14725"},
+	"CL": {"CL", "CL", "NCh 2245"},
+	"CN": {"CN", "CN", "GB/T 16483, GB 30000"},
+	"HK": {"HK", "HK", "GHS"},
+	"TW": {"TW", "TW", "CNS 15030"},
+	"JP": {"JP", "JP", "JIS Z 7253"},
+	"KR": {"KR", "KR", "OSHA Korea, K-REACH"},
+	"IN": {"IN", "IN", "MSIHC Rules"},
+	"MY": {"MY", "MY", "CLASS Regulations 2013"},
+	"SG": {"SG", "SG", "SS 586"},
+	"TH": {"TH", "TH", "Notification of the Ministry of Industry (GHS)"},
+	"VN": {"VN", "VN", "Circular 32/2017/TT-BCT"},
+	"ID": {"ID", "ID", "Permenperin 23/2013"},
+	"PH": {"PH", "PH", "DOLE DO 136-14"},
+	"AU": {"AU", "AU", "WHS Regulations"},
+	"NZ": {"NZ", "NZ", "HSNO"},
+	"SA": {"SA", "GCC", "GSO GHS"}, "AE": {"AE", "GCC", "GSO GHS"}, "BH": {"BH", "GCC", "GSO GHS"},
+	"KW": {"KW", "GCC", "GSO GHS"}, "OM": {"OM", "GCC", "GSO GHS"}, "QA": {"QA", "GCC", "GSO GHS"},
+	"ZA": {"ZA", "ZA", "SANS 10234"},
+}
+
+// jurisdictionFor returns the regulatory context of an Sbgvid such as SDS_FR.
+// Config overrides match the full Sbgvid first, then its region; unknown regions are their own jurisdiction.
+func jurisdictionFor(cfg *Config, sbgvid string) jurisdictionInfo {
+	sbgvid = strings.ToUpper(sbgvid)
+	_, region, _ := strings.Cut(sbgvid, "_")
+	if info, ok := cfg.Jurisdictions[sbgvid]; ok {
+		return info
+	}
+	if info, ok := cfg.Jurisdictions[region]; ok {
+		return info
+	}
+	if info, ok := builtinJurisdictions[region]; ok {
+		return info
+	}
+	return jurisdictionInfo{Country: region, Jurisdiction: region}
+}
+
+// matchesJurisdiction reports whether an Sbgvid falls under one of the comma separated
+// jurisdictions or countries in filter, e.g. "EU,US". An empty filter matches everything.
+func matchesJurisdiction(cfg *Config, filter string, sbgvid string) bool {
+	if strings.TrimSpace(filter) == "" {
+		return true
+	}
+	info := jurisdictionFor(cfg, sbgvid)
+	for _, wanted := range strings.Split(filter, ",") {
+		wanted = strings.TrimSpace(wanted)
+		if strings.EqualFold(wanted, info.Jurisdiction) || strings.EqualFold(wanted, info.Country) {
+			return true
+		}
+	}
+	return false
+}
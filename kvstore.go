@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+)
+
+// kvRecord is one line of a kvStore log. A record without a value deletes the key.
+type kvRecord struct {
+	Key   string          `json:"k"`
+	Value json.RawMessage `json:"v,omitempty"`
+}
+
+// kvStore is a small embedded key-value store: an append-only JSON lines log that is replayed into
+// memory on open and compacted on close once superseded records outnumber live ones.
+// Values are JSON encoded; it suits per-document debugging data, not bulk content.
+// Several processes may have the same log open, such as serve and maintenance: appends and rewrites
+// take turns on a lock file beside the log, and a process finding the log rewritten under it reopens it.
+type kvStore struct {
+	mutex   sync.Mutex
+	path    string
+	file    *os.File                   // Log opened for appending
+	lock    *os.File                   // Lock file held while the log is written
+	values  map[string]json.RawMessage // Live values by key
+	records int                        // Records in the log, live or superseded
+}
+
+// kvLockPath returns the lock file of a key-value log.
+func kvLockPath(path string) string {
+	return path + ".lock"
+}
+
+// openKVStore replays the log at path, creating it when it doesn't exist yet.
+// A torn last line from a crash is dropped.
+func openKVStore(path string) (*kvStore, error) {
+	lock, err := os.OpenFile(kvLockPath(path), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	store := &kvStore{path: path, lock: lock}
+	err = store.locked(func() error {
+		if err := store.load(); err != nil {
+			return err
+		}
+		store.file, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		return err
+	})
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// locked runs fn holding the lock file, waiting for other processes using the log.
+func (store *kvStore) locked(fn func() error) error {
+	if _, err := lockFile(store.lock, true); err != nil {
+		return err
+	}
+	defer unlockFile(store.lock)
+	return fn()
+}
+
+// load replays the log into memory, replacing what was there. Called with the lock file held.
+func (store *kvStore) load() error {
+	store.values = make(map[string]json.RawMessage)
+	store.records = 0
+	file, err := os.Open(store.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	for scanner.Scan() {
+		var record kvRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			log.Printf("%s: skipping unreadable record %d: %v", store.path, store.records+1, err)
+			continue
+		}
+		store.records = store.records + 1
+		if len(record.Value) == 0 {
+			delete(store.values, record.Key)
+			continue
+		}
+		store.values[record.Key] = record.Value
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %v", store.path, err)
+	}
+	return nil
+}
+
+// reopenIfRewritten picks up the log another process rewrote since it was opened, which holds every
+// record appended before. Called with the mutex and the lock file held.
+func (store *kvStore) reopenIfRewritten() error {
+	opened, err := store.file.Stat()
+	if err != nil {
+		return err
+	}
+	if current, err := os.Stat(store.path); err == nil && os.SameFile(opened, current) {
+		return nil
+	}
+	store.file.Close()
+	if err := store.load(); err != nil {
+		return err
+	}
+	store.file, err = os.OpenFile(store.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	return err
+}
+
+// get decodes the value of a key into value, reporting whether the key exists.
+func (store *kvStore) get(key string, value any) (bool, error) {
+	store.mutex.Lock()
+	raw, ok := store.values[key]
+	store.mutex.Unlock()
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, value)
+}
+
+// put stores the JSON encoding of value under key.
+func (store *kvStore) put(key string, value any) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return store.append(kvRecord{Key: key, Value: encoded})
+}
+
+// delete removes a key.
+func (store *kvStore) delete(key string) error {
+	store.mutex.Lock()
+	_, ok := store.values[key]
+	store.mutex.Unlock()
+	if !ok {
+		return nil
+	}
+	return store.append(kvRecord{Key: key})
+}
+
+// append writes one record to the log and applies it in memory.
+func (store *kvStore) append(record kvRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	err = store.locked(func() error {
+		if err := store.reopenIfRewritten(); err != nil {
+			return err
+		}
+		_, err := store.file.Write(append(line, '\n'))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	store.records = store.records + 1
+	if len(record.Value) == 0 {
+		delete(store.values, record.Key)
+	} else {
+		store.values[record.Key] = record.Value
+	}
+	return nil
+}
+
+// keys returns every live key, sorted.
+func (store *kvStore) keys() []string {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	keys := make([]string, 0, len(store.values))
+	for key := range store.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// close flushes the log, rewriting it with only the live values when most of it is superseded.
+func (store *kvStore) close() error {
+	return store.closeAndRewrite(false)
+}
+
+// compact closes the store and rewrites its log with only the live values, however little is superseded.
+// It returns how many records the log held before and after.
+func (store *kvStore) compact() (int, int, error) {
+	before := store.records
+	err := store.closeAndRewrite(true)
+	return before, store.records, err
+}
+
+// closeAndRewrite flushes and closes the log, then rewrites it when forced or when most of it is superseded.
+func (store *kvStore) closeAndRewrite(force bool) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	defer store.lock.Close()
+	return store.locked(func() error {
+		if err := store.file.Sync(); err != nil {
+			store.file.Close()
+			return err
+		}
+		if err := store.file.Close(); err != nil {
+			return err
+		}
+		if !force && store.records <= 2*len(store.values)+100 {
+			return nil
+		}
+		// The rewrite keeps what other processes appended too.
+		if err := store.load(); err != nil {
+			return err
+		}
+		return store.rewrite()
+	})
+}
+
+// rewrite replaces the log with one record per live value. Called with the lock file held.
+func (store *kvStore) rewrite() error {
+	keys := make([]string, 0, len(store.values))
+	for key := range store.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var compacted []byte
+	for _, key := range keys {
+		line, err := json.Marshal(kvRecord{Key: key, Value: store.values[key]})
+		if err != nil {
+			return err
+		}
+		compacted = append(append(compacted, line...), '\n')
+	}
+	if err := writeFileAtomically(store.path, compacted, 0o644); err != nil {
+		return err
+	}
+	store.records = len(keys)
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"unicode"
+)
+
+// languageStopwords are frequent short words of the Latin-script languages SDS come in, lowercase ASCII
+// since extracted PDF text rarely keeps its accents intact. They are scored against a document's words.
+var languageStopwords = map[string][]string{
+	"EN": {"the", "and", "of", "to", "with", "is", "or", "not", "be", "this", "are", "from", "may", "if", "should"},
+	"FR": {"le", "les", "des", "et", "du", "une", "est", "pour", "dans", "pas", "avec", "sur", "aux", "ou", "peut"},
+	"DE": {"der", "die", "das", "und", "ist", "nicht", "mit", "von", "zu", "den", "dem", "bei", "oder", "auf", "werden"},
+	"ES": {"el", "los", "las", "y", "del", "para", "con", "por", "una", "es", "se", "puede", "como", "sus", "al"},
+	"IT": {"il", "gli", "di", "per", "con", "non", "della", "delle", "sono", "dei", "nel", "essere", "questo", "che", "alla"},
+	"PT": {"os", "do", "da", "dos", "das", "com", "em", "uma", "pelo", "pela", "ser", "pode", "ao", "como", "produto"},
+	"NL": {"het", "een", "en", "van", "niet", "met", "voor", "op", "zijn", "te", "worden", "bij", "dit", "kan", "naar"},
+	"SV": {"och", "att", "inte", "till", "eller", "med", "som", "av", "enligt", "vid", "kan", "ska", "det", "produkten", "har"},
+	"DA": {"og", "ikke", "til", "eller", "af", "med", "som", "ved", "skal", "kan", "det", "produktet", "er", "fra", "efter"},
+	"FI": {"ja", "on", "ei", "tai", "kanssa", "mukaan", "tuote", "tuotteen", "voi", "jos", "myos", "ole", "kun", "tulee", "aineen"},
+	"PL": {"oraz", "lub", "dla", "przez", "nie", "jest", "na", "do", "od", "w", "z", "po", "jak", "przy", "produkt"},
+	"TR": {"ve", "bir", "bu", "ile", "olarak", "veya", "gibi", "daha", "olan", "kadar", "sonra", "urun", "icin", "degil", "ise"},
+	"MS": {"dan", "yang", "untuk", "dengan", "tidak", "ini", "atau", "pada", "dalam", "produk", "boleh", "jika", "akan", "oleh", "daripada"},
+	"RO": {"si", "cu", "sau", "pentru", "nu", "este", "din", "care", "la", "produsul", "fi", "poate", "prin", "sunt", "acest"},
+}
+
+// scriptLanguages name the language of text written mostly in one script.
+var scriptLanguages = []struct {
+	table    *unicode.RangeTable
+	language string
+}{
+	{unicode.Arabic, "AR"}, {unicode.Hebrew, "HE"}, {unicode.Greek, "EL"}, {unicode.Thai, "TH"},
+	{unicode.Hangul, "KO"}, {unicode.Hiragana, "JA"}, {unicode.Katakana, "JA"}, {unicode.Han, "ZH"}, {unicode.Cyrillic, "RU"},
+}
+
+// sameLanguages groups Laiso codes one detector can't tell apart, such as simplified and traditional Chinese.
+var sameLanguages = [][]string{{"ZH", "ZF"}, {"DA", "NO", "NB"}, {"MS", "ID"}, {"RU", "UK", "BG", "SR", "BE"}}
+
+// Thresholds of language detection.
+const (
+	minLanguageEvidence = 20  // Stopword or script hits needed before guessing at all
+	minLanguageMargin   = 2.0 // How many times the runner-up's score the winner needs
+)
+
+// detectLanguage guesses the language of text as a Laiso code, empty when there is too little text
+// or no clear winner. Scripts other than Latin decide on their own; Latin text is scored by stopwords.
+func detectLanguage(text string) string {
+	scriptCounts := make(map[string]int)
+	letters := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters = letters + 1
+		for _, script := range scriptLanguages {
+			if unicode.Is(script.table, r) {
+				scriptCounts[script.language] = scriptCounts[script.language] + 1
+				break
+			}
+		}
+	}
+	// Kana mark Japanese even among Han characters.
+	if scriptCounts["JA"] > minLanguageEvidence {
+		return "JA"
+	}
+	for language, count := range scriptCounts {
+		if count > minLanguageEvidence && count*2 > letters {
+			return language
+		}
+	}
+	scores := make(map[string]int)
+	index := make(map[string][]string)
+	for language, words := range languageStopwords {
+		for _, word := range words {
+			index[word] = append(index[word], language)
+		}
+	}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		for _, language := range index[word] {
+			scores[language] = scores[language] + 1
+		}
+	}
+	best, bestScore, runnerUp := "", 0, 0
+	for language, score := range scores {
+		if score > bestScore {
+			best, bestScore, runnerUp = language, score, bestScore
+		} else if score > runnerUp {
+			runnerUp = score
+		}
+	}
+	// A tie never clears the margin, so the order of the map doesn't matter.
+	if bestScore < minLanguageEvidence || float64(bestScore) < minLanguageMargin*float64(runnerUp) {
+		return ""
+	}
+	return best
+}
+
+// detectableLanguage reports whether detectLanguage can recognize a Laiso code at all, so documents in
+// other languages aren't flagged for what the detector simply doesn't know.
+func detectableLanguage(laiso string) bool {
+	if _, ok := languageStopwords[laiso]; ok {
+		return true
+	}
+	for _, script := range scriptLanguages {
+		if sameLanguage(script.language, laiso) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameLanguage reports whether two Laiso codes name the same language as far as detection goes.
+func sameLanguage(a string, b string) bool {
+	a, b = strings.ToUpper(a), strings.ToUpper(b)
+	if a == b {
+		return true
+	}
+	for _, group := range sameLanguages {
+		inA, inB := false, false
+		for _, code := range group {
+			inA = inA || code == a
+			inB = inB || code == b
+		}
+		if inA && inB {
+			return true
+		}
+	}
+	return false
+}
+
+// checkLanguage detects the language of a stored document and records in the catalog whether it
+// matches the Laiso it was requested in. Mismatches are flagged, not rejected: bilingual sheets exist.
+func checkLanguage(cfg *Config, docs *catalog, filename string, pdfPath string) {
+	if !cfg.LanguageCheck {
+		return
+	}
+	entry, ok := docs.get(filename)
+	if !ok {
+		return
+	}
+	requested := strings.ToUpper(entry.documentID().Laiso)
+	if requested == "" || !detectableLanguage(requested) {
+		return
+	}
+	text, err := extractPDFText(pdfPath)
+	if err != nil {
+		log.Printf("failed to read the text of %s for its language: %v", pdfPath, err)
+		return
+	}
+	detected := detectLanguage(text)
+	mismatch := detected != "" && !sameLanguage(detected, requested)
+	docs.update(filename, func(entry *catalogEntry) {
+		entry.DetectedLanguage = detected
+		entry.LanguageMismatch = mismatch
+	})
+	if mismatch {
+		metrics.inc("sabic_language_mismatches_total", map[string]string{"requested": requested, "detected": detected})
+		log.Printf("language mismatch: %s was requested in %s but reads as %s", filename, requested, detected)
+	}
+}
+
+func init() {
+	metrics.describe("sabic_language_mismatches_total", "Stored documents whose text reads in another language than the Laiso they were requested in.")
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Leader election backends.
+const (
+	leaderElectionFile       = "file"       // A lease file on storage every replica shares
+	leaderElectionKubernetes = "kubernetes" // A coordination.k8s.io Lease in the pod's namespace
+)
+
+// kubernetesServiceAccount is where a pod finds its API credentials.
+const kubernetesServiceAccount = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// leaseBackend takes and renews a lease for identity. It returns the lease's holder after trying,
+// identity itself when this replica leads.
+type leaseBackend interface {
+	acquire(ctx context.Context, identity string, duration time.Duration) (string, error)
+	release(ctx context.Context, identity string) error
+}
+
+// leaderElector lets one daemon replica of several sync while the others stand by, taking over when
+// the leader stops renewing its lease.
+type leaderElector struct {
+	backend  leaseBackend
+	identity string
+	duration time.Duration
+	mutex    sync.Mutex
+	holder   string        // Last known holder of the lease
+	renewed  time.Time     // When this replica last renewed the lease
+	lost     chan struct{} // Closed when this replica stops leading
+	elected  chan struct{} // Signalled when this replica starts leading
+}
+
+// newLeaderElector returns the elector of a config, nil when leader election is off.
+func newLeaderElector(cfg *Config) (*leaderElector, error) {
+	host, _ := os.Hostname()
+	elector := &leaderElector{
+		identity: fmt.Sprintf("%s/%d", host, os.Getpid()),
+		duration: cfg.LeaderLeaseDuration.Duration,
+		elected:  make(chan struct{}, 1),
+	}
+	if elector.duration <= 0 {
+		elector.duration = time.Minute
+	}
+	switch cfg.LeaderElection {
+	case "":
+		return nil, nil
+	case leaderElectionFile:
+		path := cfg.LeaderLease
+		if path == "" {
+			path = cfg.CatalogFile + ".leader"
+		}
+		elector.backend = &fileLease{path: path}
+	case leaderElectionKubernetes:
+		backend, err := newKubernetesLease(cfg.LeaderLease)
+		if err != nil {
+			return nil, err
+		}
+		elector.backend = backend
+	default:
+		return nil, fmt.Errorf("unknown leader_election %q, expected %s or %s", cfg.LeaderElection, leaderElectionFile, leaderElectionKubernetes)
+	}
+	return elector, nil
+}
+
+// run takes and renews the lease every third of its duration until ctx ends, then gives it up.
+func (elector *leaderElector) run(ctx context.Context) {
+	ticker := time.NewTicker(elector.duration / 3)
+	defer ticker.Stop()
+	for {
+		elector.tryAcquire(ctx)
+		select {
+		case <-ctx.Done():
+			if elector.leading() {
+				releaseCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				if err := elector.backend.release(releaseCtx, elector.identity); err != nil {
+					log.Println("Failed to release the leader lease:", err)
+				}
+				cancel()
+				elector.setLeading(false, "")
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tryAcquire takes or renews the lease once. A leader that can't renew steps down before the lease
+// could expire for the others, so two replicas never sync at once.
+func (elector *leaderElector) tryAcquire(ctx context.Context) {
+	holder, err := elector.backend.acquire(ctx, elector.identity, elector.duration)
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		log.Println("Failed to renew the leader lease:", err)
+		elector.mutex.Lock()
+		expiring := elector.lost != nil && time.Since(elector.renewed) > elector.duration-elector.duration/3
+		elector.mutex.Unlock()
+		if expiring {
+			elector.setLeading(false, "")
+		}
+		return
+	}
+	elector.setLeading(holder == elector.identity, holder)
+}
+
+// setLeading records the outcome of an election, logging and signalling changes of leadership.
+func (elector *leaderElector) setLeading(leading bool, holder string) {
+	elector.mutex.Lock()
+	defer elector.mutex.Unlock()
+	elector.holder = holder
+	if leading {
+		elector.renewed = time.Now()
+	}
+	switch {
+	case leading && elector.lost == nil:
+		log.Printf("elected leader as %s, this replica syncs", elector.identity)
+		elector.lost = make(chan struct{})
+		select {
+		case elector.elected <- struct{}{}:
+		default:
+		}
+	case !leading && elector.lost != nil:
+		log.Printf("no longer the leader, standing by")
+		close(elector.lost)
+		elector.lost = nil
+	}
+}
+
+// leading reports whether this replica holds the lease. A nil elector always leads.
+func (elector *leaderElector) leading() bool {
+	if elector == nil {
+		return true
+	}
+	elector.mutex.Lock()
+	defer elector.mutex.Unlock()
+	return elector.lost != nil
+}
+
+// leader names the replica holding the lease, for the log.
+func (elector *leaderElector) leader() string {
+	elector.mutex.Lock()
+	defer elector.mutex.Unlock()
+	if elector.holder == "" {
+		return "no replica"
+	}
+	return elector.holder
+}
+
+// onElected returns a channel signalled when this replica becomes the leader; nil, which never
+// delivers, for a nil elector.
+func (elector *leaderElector) onElected() <-chan struct{} {
+	if elector == nil {
+		return nil
+	}
+	return elector.elected
+}
+
+// leaderContext returns a context that also ends when this replica loses the lease, so a sync stops
+// as soon as another replica may start one.
+func (elector *leaderElector) leaderContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	if elector == nil {
+		return ctx, cancel
+	}
+	elector.mutex.Lock()
+	lost := elector.lost
+	elector.mutex.Unlock()
+	if lost == nil {
+		cancel()
+		return ctx, cancel
+	}
+	go func() {
+		select {
+		case <-lost:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// leaseRecord is the content of a lease file.
+type leaseRecord struct {
+	Holder   string    `json:"holder"`
+	Acquired time.Time `json:"acquired"`
+	Renewed  time.Time `json:"renewed"`
+	Duration Duration  `json:"duration"`
+}
+
+// fileLease keeps the lease in a file beside the shared catalog. Replicas change it under a lock
+// file next to it, so only one of them can take an expired lease over. Expiry goes by the renewal time
+// in the file, so replicas on different hosts need reasonably synchronised clocks.
+type fileLease struct {
+	path string
+}
+
+// leaseLockPath returns the lock file taken by replicas changing a lease file.
+func leaseLockPath(path string) string {
+	return path + ".lock"
+}
+
+// read returns the lease in the file, nil when there is none.
+func (lease *fileLease) read() (*leaseRecord, error) {
+	content, err := os.ReadFile(lease.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrStorage, err)
+	}
+	var record leaseRecord
+	if err := json.Unmarshal(content, &record); err != nil {
+		// A holder that crashed while writing it; treat it as expired.
+		return &leaseRecord{}, nil
+	}
+	return &record, nil
+}
+
+// acquire implements leaseBackend. The lease is read and written under the lock, so of several
+// replicas finding it free or expired, the first one takes it and the others see it taken.
+func (lease *fileLease) acquire(ctx context.Context, identity string, duration time.Duration) (string, error) {
+	holder := ""
+	err := withFileLock(leaseLockPath(lease.path), func() error {
+		now := time.Now().UTC()
+		record := leaseRecord{Holder: identity, Acquired: now, Renewed: now, Duration: Duration{duration}}
+		current, err := lease.read()
+		if err != nil {
+			return err
+		}
+		if current != nil && current.Holder != identity && !now.After(current.Renewed.Add(current.Duration.Duration)) {
+			holder = current.Holder
+			return nil
+		}
+		if current != nil && current.Holder == identity {
+			record.Acquired = current.Acquired
+		}
+		content, _ := json.Marshal(record)
+		if err := writeFileAtomically(lease.path, content, 0o644); err != nil {
+			return fmt.Errorf("%w: %v", ErrStorage, err)
+		}
+		holder = identity
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return holder, nil
+}
+
+// release implements leaseBackend, removing the lease file when this replica still holds it.
+func (lease *fileLease) release(ctx context.Context, identity string) error {
+	return withFileLock(leaseLockPath(lease.path), func() error {
+		current, err := lease.read()
+		if err != nil || current == nil || current.Holder != identity {
+			return err
+		}
+		if err := os.Remove(lease.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+}
+
+// kubernetesLease keeps the lease in a coordination.k8s.io/v1 Lease object, updated with the API
+// server's optimistic concurrency so only one replica's update of a given version goes through.
+type kubernetesLease struct {
+	url    string // Lease collection URL of the namespace
+	name   string
+	token  string
+	client *http.Client
+}
+
+// kubernetesLeaseObject is the part of a Lease this daemon reads and writes.
+type kubernetesLeaseObject struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace,omitempty"`
+		ResourceVersion string `json:"resourceVersion,omitempty"`
+	} `json:"metadata"`
+	Spec struct {
+		HolderIdentity       string `json:"holderIdentity,omitempty"`
+		LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+		AcquireTime          string `json:"acquireTime,omitempty"`
+		RenewTime            string `json:"renewTime,omitempty"`
+		LeaseTransitions     int    `json:"leaseTransitions"`
+	} `json:"spec"`
+}
+
+// kubernetesMicroTime is the timestamp format of Lease times.
+const kubernetesMicroTime = "2006-01-02T15:04:05.000000Z07:00"
+
+// newKubernetesLease uses the pod's service account. lease is "namespace/name" or "name", the
+// pod's own namespace and sabic-com-documentation by default.
+func newKubernetesLease(lease string) (*kubernetesLease, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("kubernetes leader election needs to run in a pod: KUBERNETES_SERVICE_HOST is not set")
+	}
+	token, err := os.ReadFile(filepath.Join(kubernetesServiceAccount, "token"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the service account token: %v", err)
+	}
+	roots := x509.NewCertPool()
+	if ca, err := os.ReadFile(filepath.Join(kubernetesServiceAccount, "ca.crt")); err == nil {
+		roots.AppendCertsFromPEM(ca)
+	}
+	namespace, name := "", lease
+	if before, after, found := strings.Cut(lease, "/"); found {
+		namespace, name = before, after
+	}
+	if namespace == "" {
+		content, _ := os.ReadFile(filepath.Join(kubernetesServiceAccount, "namespace"))
+		namespace = strings.TrimSpace(string(content))
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+	if name == "" {
+		name = "sabic-com-documentation"
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+	return &kubernetesLease{
+		url:    fmt.Sprintf("https://%s/apis/coordination.k8s.io/v1/namespaces/%s/leases", net.JoinHostPort(host, port), namespace),
+		name:   name,
+		token:  strings.TrimSpace(string(token)),
+		client: &http.Client{Transport: transport, Timeout: 10 * time.Second},
+	}, nil
+}
+
+// do sends a request to the API server, decoding a successful answer into out.
+func (lease *kubernetesLease) do(ctx context.Context, method string, resource string, in any, out any) (int, error) {
+	var body io.Reader
+	if in != nil {
+		content, err := json.Marshal(in)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(content)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, resource, body)
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Authorization", "Bearer "+lease.token)
+	request.Header.Set("Accept", "application/json")
+	if in != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	response, err := lease.client.Do(request)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrNetwork, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(response.Body, 4<<10))
+		return response.StatusCode, fmt.Errorf("%s %s: %s %s", method, resource, response.Status, strings.TrimSpace(string(detail)))
+	}
+	if out != nil {
+		if err := json.NewDecoder(response.Body).Decode(out); err != nil {
+			return response.StatusCode, err
+		}
+	}
+	return response.StatusCode, nil
+}
+
+// acquire implements leaseBackend. A 409 Conflict means another replica updated the Lease first.
+func (lease *kubernetesLease) acquire(ctx context.Context, identity string, duration time.Duration) (string, error) {
+	now := time.Now().UTC()
+	var current kubernetesLeaseObject
+	status, err := lease.do(ctx, http.MethodGet, lease.url+"/"+lease.name, nil, &current)
+	if status == http.StatusNotFound {
+		created := kubernetesLeaseObject{APIVersion: "coordination.k8s.io/v1", Kind: "Lease"}
+		created.Metadata.Name = lease.name
+		created.Spec.HolderIdentity = identity
+		created.Spec.LeaseDurationSeconds = int(duration.Seconds())
+		created.Spec.AcquireTime = now.Format(kubernetesMicroTime)
+		created.Spec.RenewTime = created.Spec.AcquireTime
+		status, err = lease.do(ctx, http.MethodPost, lease.url, created, nil)
+		if status == http.StatusConflict {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		return identity, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	holder := current.Spec.HolderIdentity
+	renewed, _ := time.Parse(kubernetesMicroTime, current.Spec.RenewTime)
+	expired := holder == "" || now.After(renewed.Add(time.Duration(current.Spec.LeaseDurationSeconds)*time.Second))
+	if holder != identity && !expired {
+		return holder, nil
+	}
+	if holder != identity {
+		current.Spec.HolderIdentity = identity
+		current.Spec.AcquireTime = now.Format(kubernetesMicroTime)
+		current.Spec.LeaseTransitions++
+	}
+	current.Spec.LeaseDurationSeconds = int(duration.Seconds())
+	current.Spec.RenewTime = now.Format(kubernetesMicroTime)
+	status, err = lease.do(ctx, http.MethodPut, lease.url+"/"+lease.name, current, nil)
+	if status == http.StatusConflict {
+		return holder, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return identity, nil
+}
+
+// release implements leaseBackend by clearing the holder, so a standby replica takes over at once.
+func (lease *kubernetesLease) release(ctx context.Context, identity string) error {
+	var current kubernetesLeaseObject
+	if _, err := lease.do(ctx, http.MethodGet, lease.url+"/"+lease.name, nil, &current); err != nil {
+		return err
+	}
+	if current.Spec.HolderIdentity != identity {
+		return nil
+	}
+	current.Spec.HolderIdentity = ""
+	current.Spec.RenewTime = ""
+	_, err := lease.do(ctx, http.MethodPut, lease.url+"/"+lease.name, current, nil)
+	return err
+}
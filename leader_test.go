@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestFileLeaseTakeover checks that of many replicas taking over an expired lease at once, exactly
+// one holds it and every other one is told so.
+func TestFileLeaseTakeover(t *testing.T) {
+	lease := &fileLease{path: filepath.Join(t.TempDir(), "catalog.json.leader")}
+	expired := time.Now().UTC().Add(-time.Hour)
+	content, err := json.Marshal(leaseRecord{Holder: "crashed", Acquired: expired, Renewed: expired, Duration: Duration{time.Minute}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(lease.path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	const replicas = 16
+	holders := make([]string, replicas)
+	var wait sync.WaitGroup
+	for i := range replicas {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			holder, err := lease.acquire(context.Background(), fmt.Sprintf("replica-%d", i), time.Minute)
+			if err != nil {
+				t.Error(err)
+			}
+			holders[i] = holder
+		}()
+	}
+	wait.Wait()
+	current, err := lease.read()
+	if err != nil || current == nil {
+		t.Fatalf("no lease after the takeover: %v", err)
+	}
+	leaders := 0
+	for i, holder := range holders {
+		if holder != current.Holder {
+			t.Errorf("replica-%d was told %s holds the lease, the file says %s", i, holder, current.Holder)
+		}
+		if holder == fmt.Sprintf("replica-%d", i) {
+			leaders = leaders + 1
+		}
+	}
+	if leaders != 1 {
+		t.Errorf("%d replicas hold the lease, want 1", leaders)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// newCollator returns the collation of a BCP 47 locale such as "ar", "zh-Hans" or "zh-Hant-u-co-stroke".
+// An empty locale uses the root collation, which already orders accented, Arabic and CJK text sensibly.
+func newCollator(locale string) (*collate.Collator, error) {
+	tag := language.Und
+	if strings.TrimSpace(locale) != "" {
+		parsed, err := language.Parse(locale)
+		if err != nil {
+			return nil, fmt.Errorf("invalid locale %q: %v", locale, err)
+		}
+		tag = parsed
+	}
+	// Case and full-width forms are ignored so "ＡＢＳ" and "abs" sort together; digits sort by value.
+	return collate.New(tag, collate.IgnoreCase, collate.IgnoreWidth, collate.Numeric), nil
+}
+
+// materialDescription returns a document's material description from the first of
+// cfg.MaterialDescriptionFields that is set; empty when the service exposes none.
+func materialDescription(cfg *Config, properties map[string]string) string {
+	for _, field := range cfg.MaterialDescriptionFields {
+		if description := strings.TrimSpace(properties[field]); description != "" {
+			return description
+		}
+	}
+	return ""
+}
+
+// lessByDescription orders two descriptions under a collation, those without one last.
+func lessByDescription(collator *collate.Collator, a, b string) bool {
+	if a == "" || b == "" {
+		return a != "" && b == ""
+	}
+	return collator.CompareString(a, b) < 0
+}
+
+// listItem is one line of the list command.
+type listItem struct {
+	Filename      string `json:"filename"`
+	Material      string `json:"material"`
+	Language      string `json:"language"`
+	Description   string `json:"description,omitempty"`
+	ProductFamily string `json:"product_family,omitempty"`
+}
+
+// runList implements the list command: it prints the catalog's documents sorted by material
+// description in the collation of the -locale, then by file name.
+func runList(args []string) error {
+	var asJSON bool
+	cfg, _, err := loadConfig("list", args, func(flagSet *flag.FlagSet) {
+		flagSet.BoolVar(&asJSON, "json", false, "print the list as JSON")
+	})
+	if err != nil {
+		return err
+	}
+	collator, err := newCollator(cfg.Locale)
+	if err != nil {
+		return err
+	}
+	docs, err := openCatalog(cfg.CatalogFile)
+	if err != nil {
+		return err
+	}
+	items := []listItem{}
+	for _, entry := range docs.all() {
+		family := entry.ProductFamily
+		if !matchesProductFamily(cfg.ProductFamily, family) {
+			continue
+		}
+		keys := entry.documentID()
+		items = append(items, listItem{
+			Filename:      entry.Filename,
+			Material:      keys.Matnr,
+			Language:      keys.Laiso,
+			Description:   materialDescription(cfg, entry.Properties),
+			ProductFamily: family,
+		})
+	}
+	// all() is in file name order, so a stable sort keeps it for equal descriptions.
+	sort.SliceStable(items, func(i, j int) bool {
+		return lessByDescription(collator, items[i].Description, items[j].Description)
+	})
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(items)
+	}
+	// The description goes last: wide CJK characters would misalign any column after it.
+	table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "MATERIAL\tLANGUAGE\tDOCUMENT\tDESCRIPTION")
+	for _, item := range items {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", item.Material, item.Language, item.Filename, item.Description)
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+	log.Printf("%d documents listed", len(items))
+	return nil
+}
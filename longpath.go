@@ -0,0 +1,44 @@
+package main
+
+import (
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// storageRoot returns the directory documents are stored under in the form the OS can use for deep paths.
+// On Windows that is the extended-length form (\\?\C:\... or \\?\UNC\server\share\...), which lifts the
+// 260 character MAX_PATH limit for everything joined onto it, including relative output directories and
+// deep UNC shares. Elsewhere the directory is returned as it is.
+func storageRoot(dir string) (string, error) {
+	if runtime.GOOS != "windows" || dir == "" {
+		return dir, nil
+	}
+	absolute, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	return windowsLongPath(absolute), nil
+}
+
+// windowsLongPath turns an absolute Windows path, with either slash, into its extended-length form.
+// Extended-length paths are passed to the file system as they are, so they are cleaned here:
+// forward slashes, . and .. would otherwise reach it literally. Paths already in that form are kept.
+func windowsLongPath(name string) string {
+	slashed := strings.ReplaceAll(name, `\`, "/")
+	backslashed := func(name string) string { return strings.ReplaceAll(name, "/", `\`) }
+	switch {
+	case strings.HasPrefix(slashed, "//?/") || strings.HasPrefix(slashed, "//./"):
+		return backslashed(slashed)
+	case strings.HasPrefix(slashed, "//"):
+		// \\server\share\dir becomes \\?\UNC\server\share\dir.
+		return `\\?\UNC` + backslashed(path.Clean("/"+strings.TrimPrefix(slashed, "//")))
+	case len(slashed) >= 2 && slashed[1] == ':':
+		// C:\dir becomes \\?\C:\dir.
+		return `\\?\` + slashed[:2] + backslashed(path.Clean("/"+slashed[2:]))
+	default:
+		// Relative paths have no extended-length form; storageRoot makes them absolute first.
+		return backslashed(slashed)
+	}
+}
@@ -1,56 +1,363 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
+	"hash"
 	"io"
 	"log"
-	"net/http"
 	"os"
-	"path/filepath"
-	"regexp"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 )
 
+// subcommands maps a first argument to the command it runs; anything else is a plain sync run.
+var subcommands = map[string]func(args []string) error{
+	"audit":              runMirrorAudit,
+	"backup":             runBackup,
+	"browse":             runBrowse,
+	"bundle":             runBundle,
+	"catalog":            runCatalogCommand,
+	"check-availability": runCheckAvailability,
+	"completion":         runCompletion,
+	"config":             runConfigCommand,
+	"daemon":             runDaemon,
+	"discover":           runDiscover,
+	"digest":             runDigest,
+	"expiring":           runExpiring,
+	"ehs-push":           runEHSPush,
+	"export-site":        runExportSite,
+	"fetch":              runFetch,
+	"gc":                 runGC,
+	"import":             runImport,
+	"install-service":    runInstallService,
+	"serve":              runServe,
+	"list":               runList,
+	"maintenance":        runMaintenanceCommand,
+	"mirror":             runMirror,
+	"share-url":          runShareURL,
+	"show":               runShow,
+	"slo":                runSLOCommand,
+	"stamp":              runStamp,
+	"top-documents":      runTopDocuments,
+	"previews":           runPreviews,
+	"pdfa":               runPDFA,
+	"publish":            runPublish,
+	"restore":            runRestore,
+	"scrape":             runScrape,
+	"uninstall-service":  runUninstallService,
+	"views":              runViews,
+	"verify-audit-log":   runVerifyAuditLog,
+}
+
 func main() {
-	// scrapeJSONAndSaveLocally()
-	parsedURLs := convertJSONToSlice()
-	// Remove duplicates from slice.
-	parsedURLs = removeDuplicatesFromSlice(parsedURLs)
-	outputDir := "PDFs/" // Directory to store downloaded PDFs
+	// Hand over to a subcommand when one is named.
+	if len(os.Args) > 1 {
+		if command, ok := subcommands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+				log.Fatalln(err)
+			}
+			return
+		}
+	}
+	// Load the settings from the command line and the optional config file.
+	cfg, _, err := loadConfig("sabic-com-documentation", os.Args[1:], nil)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+	// Profile the run when asked to.
+	stopProfiling, err := startProfiling(cfg)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := dropPrivileges(cfg); err != nil {
+		log.Fatalln(err)
+	}
+	// Run a single sync.
+	err = runSync(context.Background(), cfg, nil)
+	stopProfiling()
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// runSync downloads every document listed in the scraped header JSON that isn't on disk yet. It records
+// to audit, the audit log of a process that already has one open such as serve; nil opens audit_log.
+func runSync(ctx context.Context, cfg *Config, audit *auditLog) error {
+	// Tag everything this run logs, counts and records with one ID.
+	runID := newRunID()
+	defer startRunLogging(runID)()
+	recordRunID(runID)
+	sdNotify("STATUS=syncing, run " + runID)
+	log.Printf("sync run %s started", runID)
+	// Parse the allowed download hours.
+	window, err := parseDownloadWindow(cfg.Window, cfg.Timezone)
+	if err != nil {
+		return err
+	}
+	// Catch config mistakes before the first download.
+	if err := validateConfig(cfg); err != nil {
+		return err
+	}
+	configureHealth(cfg)
+	configureSLO(cfg)
+	// Only one sync at a time may touch the output directory and catalog.
+	lock, err := acquireRunLock(cfg)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+	// Continue the audit trail of earlier runs.
+	if audit == nil {
+		if audit, err = openAuditLog(cfg.AuditLog); err != nil {
+			return err
+		}
+	}
+	// Load what earlier runs stored.
+	docs, err := openCatalog(cfg.CatalogFile)
+	if err != nil {
+		return err
+	}
+	// Journal changes in batches, so a crash loses at most the last batch.
+	if err := docs.startJournal(cfg.CatalogBatchSize, cfg.CatalogFlushInterval.Duration); err != nil {
+		return err
+	}
+	// Save the catalog however the run ends.
+	defer func() {
+		if err := docs.close(); err != nil {
+			log.Println("Failed to save catalog:", err)
+		}
+	}()
+	// Load the ERP material codes.
+	materials, err := loadMaterialMap(cfg.MaterialMap)
+	if err != nil {
+		return err
+	}
+	// Learn the pace of the previous run before this run's manifest becomes the newest.
+	progress := newRunProgress(estimateDocumentDuration(cfg.ManifestDir))
+	// Record every document's outcome for this run.
+	manifest, err := openRunManifest(cfg.ManifestDir, time.Now(), runID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		log.Printf("sync results: %s", manifest.summary())
+		if err := manifest.close(); err != nil {
+			log.Println("Failed to close run manifest:", err)
+		}
+	}()
+	// Set up the supplier portals to pull from.
+	sources, err := openSources(cfg, materials)
+	if err != nil {
+		return err
+	}
+	defer closeSources(sources)
+	// Open the output directory in its configured layout.
+	store, err := openDocumentStore(cfg)
+	if err != nil {
+		return err
+	}
+	// Persist the storage index however the run ends.
+	defer func() {
+		if err := store.flush(); err != nil {
+			log.Println("Failed to save storage index:", err)
+		}
+	}()
+	// Documents stored under an earlier naming move to their current names.
+	if err := migrateDocumentNames(docs, store, materials, audit); err != nil {
+		return err
+	}
+	// Documents encrypted before the catalog recorded it are recorded now.
+	if err := markEncryptedDocuments(docs, store); err != nil {
+		return err
+	}
+	// Keep the response headers of every fetch for debugging.
+	responses, err := openResponseStore(cfg)
+	if err != nil {
+		return err
+	}
+	if responses != nil {
+		defer func() {
+			if err := responses.close(); err != nil {
+				log.Println("Failed to save response headers:", err)
+			}
+		}()
+	}
+	fetcher := &downloader{cfg: cfg, audit: audit, catalog: docs, materials: materials, store: store, throttle: &throttleGate{}, responses: responses, budget: newMemoryBudget(cfg.MemoryBudget), sources: make(map[string]Source)}
+	for _, source := range sources {
+		fetcher.sources[source.Name()] = source
+	}
+	if cfg.CoalesceFetches {
+		fetcher.inflight = newFetchGroup()
+	}
+	// Learn what is stored once instead of asking the filesystem for every document.
+	if cfg.FastSkip {
+		fetcher.existing, err = loadDocumentSet(store, docs, cfg.OutputDir)
+		if err != nil {
+			return err
+		}
+	}
+	outputDir := cfg.OutputDir // Directory to store downloaded PDFs
 	// Check if its exists.
 	if !directoryExists(outputDir) {
 		// Create the dir
 		createDirectory(outputDir, 0o755)
 	}
-	// Download Counter.
-	var downloadCounter int
-	// Loop over the parsed URL.
-	for _, urls := range parsedURLs {
-		// Download the file and if its sucessful than add 1 to the counter.
-		sucessCode, err := downloadPDF(urls, outputDir)
-		if sucessCode {
-			downloadCounter = downloadCounter + 1
+	// The scrape command refreshes the input file, see scrape.go.
+	// Stream the scraped documents through planning, downloading and storing.
+	if err := runPipeline(ctx, cfg, fetcher, sources, window, manifest, progress); err != nil {
+		return err
+	}
+	if summary := publicationSLO.summary(); summary != "" {
+		log.Printf("publication SLO: %s", summary)
+	}
+	// Keep the browsable views in step with the store.
+	if cfg.Views {
+		if err := buildViews(cfg, store); err != nil {
+			log.Println("Failed to build views:", err)
 		}
+	}
+	// Hand new and updated documents to the EHS system.
+	if uploader, err := newEHSUploader(cfg); err != nil {
+		log.Println("Failed to set up the EHS upload:", err)
+	} else if uploader != nil {
+		pushed, failed, err := pushToEHS(ctx, cfg, uploader, store, docs)
 		if err != nil {
-			log.Println(err)
+			log.Println("Failed to push to the EHS system:", err)
 		}
+		log.Printf("pushed %d documents to the EHS system, %d failed", pushed, failed)
 	}
+	return nil
 }
 
-// removeDuplicatesFromSlice removes duplicate strings from a slice
-func removeDuplicatesFromSlice(slice []string) []string {
-	check := make(map[string]bool)  // Map to track seen values
-	var newReturnSlice []string     // Result slice
-	for _, content := range slice { // Iterate over input slice
-		if !check[content] { // If string hasn't been seen before
-			check[content] = true                            // Mark it as seen
-			newReturnSlice = append(newReturnSlice, content) // Append to result
+// runDaemon repeats the sync every -interval until it receives SIGINT or SIGTERM, or a stop request of
+// the Windows Service Control Manager when that started it.
+func runDaemon(args []string) error {
+	if isWindowsService() {
+		return runWindowsService(args)
+	}
+	return serveDaemon(context.Background(), args)
+}
+
+// serveDaemon runs the daemon until parent is cancelled or the process receives SIGINT or SIGTERM.
+// SIGHUP reloads the config and starts a run straight away.
+func serveDaemon(parent context.Context, args []string) error {
+	cfg, _, err := loadConfig("daemon", args, nil)
+	if err != nil {
+		return err
+	}
+	// Fail fast on a bad window instead of retrying it every interval.
+	if _, err := parseDownloadWindow(cfg.Window, cfg.Timezone); err != nil {
+		return err
+	}
+	// Profile across runs when asked to; the profiles are written on shutdown.
+	stopProfiling, err := startProfiling(cfg)
+	if err != nil {
+		return err
+	}
+	defer stopProfiling()
+	probe := newProbes(cfg)
+	probeServer, err := startProbeServer(cfg, probe)
+	if err != nil {
+		return err
+	}
+	if probeServer != nil {
+		defer probeServer.Close()
+	}
+	if err := dropPrivileges(cfg); err != nil {
+		return err
+	}
+	elector, err := newLeaderElector(cfg)
+	if err != nil {
+		return err
+	}
+	probe.elector = elector
+	// Stop cleanly when the process is asked to: readiness fails at once, and a sync in progress
+	// gets shutdown_grace to finish before it is cancelled.
+	stopping, stop := signal.NotifyContext(parent, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := graceContext(stopping, cfg.ShutdownGrace.Duration)
+	defer cancel()
+	go func() {
+		<-stopping.Done()
+		probe.drain()
+	}()
+	// Stand for election until stopping; the lease is given up on the way out.
+	if elector != nil {
+		electing, stopElecting := context.WithCancel(context.Background())
+		elected := make(chan struct{})
+		go func() {
+			elector.run(electing)
+			close(elected)
+		}()
+		defer func() {
+			stopElecting()
+			<-elected
+		}()
+	}
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+	// Tell systemd we are up, and that we are going when we return.
+	sdNotify("READY=1")
+	defer sdNotify("STOPPING=1")
+	for {
+		if elector.leading() {
+			// Run one sync, a failed run is retried on the next tick. Losing the lease stops it.
+			syncCtx, cancelSync := elector.leaderContext(ctx)
+			err := runSync(syncCtx, cfg, nil)
+			cancelSync()
+			if err != nil {
+				if stopping.Err() != nil {
+					return nil
+				}
+				log.Println(err)
+			}
+			if stopping.Err() != nil {
+				return nil
+			}
+			// Email the SLO breaches of the run.
+			if err := alertSLOBreaches(cfg); err != nil {
+				log.Println(err)
+			}
+			// Send the periodic digest when one is due.
+			if digestDue(cfg) {
+				if err := sendDigest(cfg, false); err != nil {
+					log.Println(err)
+				}
+			}
+			// Sleep until the next run.
+			log.Printf("sync finished, next run in %s", cfg.SyncInterval)
+		} else {
+			log.Printf("standing by while %s leads", elector.leader())
+		}
+		sdNotify("STATUS=idle, next run at " + time.Now().Add(cfg.SyncInterval.Duration).Format(time.RFC3339))
+		select {
+		case <-stopping.Done():
+			return nil
+		case <-elector.onElected():
+		case <-reload:
+			// Keep running on the old settings when the new ones don't load.
+			reloaded, _, err := loadConfig("daemon", args, nil)
+			if err != nil {
+				log.Println("Failed to reload config, keeping the old one:", err)
+				continue
+			}
+			cfg = reloaded
+			log.Println("config reloaded")
+		case <-time.After(cfg.SyncInterval.Duration):
 		}
 	}
-	return newReturnSlice // Return deduplicated slice
 }
 
 // Checks if the directory exists
@@ -74,16 +381,12 @@ func createDirectory(path string, permission os.FileMode) {
 	}
 }
 
-// Response represents the structure of the JSON input file
-type Response struct {
-	Data struct {
-		Results []struct {
-			MaterialNumber  string `json:"Matnr"`  // Material number
-			SubID           string `json:"Subid"`  // Sub ID
-			StorageLocation string `json:"Sbgvid"` // Storage location or similar
-			LanguageISO     string `json:"Laiso"`  // Language ISO code
-		} `json:"results"`
-	} `json:"d"`
+// headerResult is one document in the scraped header JSON.
+type headerResult struct {
+	MaterialNumber  string `json:"Matnr"`  // Material number
+	SubID           string `json:"Subid"`  // Sub ID
+	StorageLocation string `json:"Sbgvid"` // Storage location or similar
+	LanguageISO     string `json:"Laiso"`  // Language ISO code
 }
 
 // fileExists checks whether a file exists and is not a directory
@@ -95,182 +398,316 @@ func fileExists(filename string) bool {
 	return !info.IsDir() // Return true if it's a file, not a directory
 }
 
-// downloadPDF downloads a PDF from the given URL and saves it in the specified output directory.
-// It uses a WaitGroup to support concurrent execution and returns true if the download succeeded.
-func downloadPDF(finalURL, outputDir string) (bool, error) {
-	// Sanitize the URL to generate a safe file name
-	filename := strings.ToLower(convertURLToFilename(finalURL))
+// downloader holds what every download of a sync run shares.
+type downloader struct {
+	cfg       *Config
+	audit     *auditLog
+	catalog   *catalog
+	materials materialMap       // Internal codes of our ERP, nil when not configured
+	store     documentStore     // Where stored documents live
+	throttle  *throttleGate     // Shared back-off after throttled responses
+	existing  *documentSet      // Documents already stored, nil to stat each file instead
+	timings   *pipelineTimings  // Per-stage timings of the running pipeline
+	responses *kvStore          // Response headers of each document's last fetch, nil when not kept
+	budget    *memoryBudget     // Bytes in flight across workers, nil when unlimited
+	sources   map[string]Source // Configured sources by name
+	inflight  *fetchGroup       // Fetches in flight, shared by concurrent requests for a document; nil when not coalescing
+	hashLater bool              // Leave the checksums of staged documents to the hash pool, see hashpool.go
+}
+
+// exists reports whether a document is already stored, from the in-memory set when there is one.
+func (fetcher *downloader) exists(filename string) bool {
+	if fetcher.existing != nil {
+		return fetcher.existing.contains(filename)
+	}
+	_, ok := fetcher.store.path(filename)
+	return ok
+}
+
+// stagedDocument is a downloaded document waiting in a temporary file to be stored.
+type stagedDocument struct {
+	source   string     // Name of the source it came from
+	id       DocumentID // Identity of the document
+	format   string     // Alternate format, empty for the PDF
+	url      string     // Document URL
+	filename string     // Final file name in the output directory
+	tempPath string     // Temporary file holding the content
+	sha256   string     // Hash of the content
+	size     int64      // Size in bytes
+	// Header properties the document was listed with.
+	properties map[string]string
+	response   *responseRecord // Upstream response, nil when the source isn't HTTP
+}
 
-	// Construct the full file path in the output directory
-	filePath := filepath.Join(outputDir, filename)
+// fetchPDF fetches a document into stagingDir with fetchStaged. A failure after redirects names the
+// URLs it went through, as a redirect to a login page otherwise only shows up as an HTML response.
+func (fetcher *downloader) fetchPDF(ctx context.Context, doc documentRef, stagingDir string) (*stagedDocument, error) {
+	ctx, trail := withRedirectTrail(ctx)
+	staged, err := fetcher.fetchStaged(ctx, doc, stagingDir)
+	if chain := trail.chain(); err != nil && len(chain) > 0 {
+		return nil, &redirectedError{chain: chain, err: err}
+	}
+	return staged, err
+}
 
-	// Skip if the file already exists
-	if fileExists(filePath) {
-		return false, fmt.Errorf("file already exists, skipping: %s", filePath)
+// fetchStaged streams a document from its source into a temporary file in stagingDir,
+// hashing it on the way so the document is never held in memory, unless the hash pool hashes it later.
+// Responses outside the report type's size limits are rejected and their temporary file removed.
+// Failures wrap one of the typed errors in errors.go.
+func (fetcher *downloader) fetchStaged(ctx context.Context, doc documentRef, stagingDir string) (*stagedDocument, error) {
+	finalURL := doc.url
+	limits := fetcher.cfg.sizeLimitsFor(reportTypeFromURL(finalURL))
+	// The source picked a safe file name when listing it.
+	filename := doc.filename
+	if filename == "" {
+		return nil, fmt.Errorf("%w: no file name for %s", ErrStorage, finalURL)
 	}
 
-	// Create an HTTP client with a timeout
-	client := &http.Client{Timeout: 30 * time.Second}
+	// Skip if the file already exists, unless it is overdue for review and may have a newer revision upstream.
+	revalidating := fetcher.revalidating(filename)
+	if fetcher.exists(filename) && !revalidating {
+		reason := skipExistsUnverified
+		if entry, ok := fetcher.catalog.get(filename); ok && entry.SHA256 != "" {
+			reason = skipExistsVerified
+		}
+		return nil, skipped(reason, fmt.Errorf("%w, skipping: %s", ErrAlreadyExists, filename))
+	}
+	// A revalidation asks the upstream whether the copy of the last fetch is still current.
+	if revalidating {
+		doc.validators = fetcher.validators(filename)
+	}
 
-	// Send GET request
-	resp, err := client.Get(finalURL)
+	// Ask the source for the content.
+	source, ok := fetcher.sources[doc.source]
+	if !ok {
+		return nil, fmt.Errorf("unknown source %q for %s", doc.source, finalURL)
+	}
+	content, err := source.Fetch(ctx, doc)
+	if errors.Is(err, ErrNotModified) {
+		fetcher.recordNotModified(filename, finalURL)
+		return nil, skipped(skipNotModified, err)
+	}
 	if err != nil {
-		return false, fmt.Errorf("failed to download %s: %v", finalURL, err)
+		return nil, err
 	}
-	defer resp.Body.Close()
+	defer content.Body.Close()
 
-	// Check HTTP response status
-	if resp.StatusCode != http.StatusOK {
-		// Print the error since its not valid.
-		return false, fmt.Errorf("download failed for %s: %s", finalURL, resp.Status)
-	}
 	// Check Content-Type header
-	contentType := resp.Header.Get("Content-Type")
-	// Check if its pdf content type and if not than print a error.
-	if !strings.Contains(contentType, "application/pdf") {
+	contentType := content.ContentType
+	// In lenient mode a generic content type is judged by the content itself.
+	var sniffed *bufio.Reader
+	if fetcher.cfg.ContentTypeCheck == contentTypeLenient && isGenericContentType(contentType) {
+		sniffed = bufio.NewReaderSize(content.Body, pdfSniffLength)
+	}
+	// Alternate formats have their own content types; their magic is checked once staged.
+	if doc.format != "" {
+		if err := checkFormatContentType(doc.format, contentType); err != nil && sniffed == nil {
+			return nil, fmt.Errorf("%s: %w", finalURL, err)
+		}
+	} else if !strings.Contains(contentType, "application/pdf") {
+		// Check if its pdf content type and if not than print a error.
 		// Print a error if the content type is invalid.
-		return false, fmt.Errorf("invalid content type for %s: %s (expected application/pdf)", finalURL, contentType)
+		if sniffed == nil || !sniffPDF(sniffed) {
+			return nil, fmt.Errorf("%w: invalid content type for %s: %s (expected application/pdf)", ErrNotPDF, finalURL, contentType)
+		}
+		metrics.inc("sabic_sniffed_pdfs_total", map[string]string{"source": doc.source})
+	}
+	// Reject oversized documents early when the server announces the length.
+	if limits.MaxBytes > 0 && content.Length > limits.MaxBytes {
+		return nil, fmt.Errorf("%w: response for %s is %d bytes, above the %d byte maximum", ErrSizeOutOfRange, finalURL, content.Length, limits.MaxBytes)
+	}
+	// Keep the bytes in flight across all workers within the memory budget.
+	reserved := fetcher.budget.weight(content.Length, fetcher.cfg.MemoryPerFile)
+	if err := fetcher.budget.acquire(ctx, reserved); err != nil {
+		return nil, err
+	}
+	defer fetcher.budget.release(reserved)
+	// Never read more than one byte past the maximum.
+	body := io.Reader(content.Body)
+	if sniffed != nil {
+		body = sniffed
+	}
+	if limits.MaxBytes > 0 {
+		body = io.LimitReader(body, limits.MaxBytes+1)
+	}
+	// Stream into a hidden temporary file; it only gets its real name once it passed every check.
+	temp, err := os.CreateTemp(stagingDir, "."+filename+".part-*")
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to create file for %s: %w", ErrStorage, finalURL, err)
+	}
+	staged := &stagedDocument{source: doc.source, id: doc.id, format: doc.format, url: finalURL, filename: filename, tempPath: temp.Name(), properties: doc.properties}
+	if content.Header != nil {
+		staged.response = &responseRecord{URL: finalURL, Status: content.Status, FetchedAt: time.Now().UTC(), Header: content.Header}
+	}
+	// Remove the temporary file on every failure path.
+	keep := false
+	defer func() {
+		if !keep {
+			os.Remove(staged.tempPath)
+		}
+	}()
+	// Hash the content for the audit trail while writing it.
+	var hasher hash.Hash
+	destination := io.Writer(temp)
+	if !fetcher.hashLater {
+		hasher = sha256.New()
+		destination = io.MultiWriter(temp, hasher)
+	}
+	var written int64
+	if content.Ranges != nil {
+		// The ranges are written at their offsets, so the file is hashed once it is whole. The body
+		// of the first response isn't needed for them and would only hold its connection.
+		content.Body.Close()
+		if err := content.Ranges(ctx, temp); err != nil {
+			temp.Close()
+			return nil, err
+		}
+		written = content.Length
+		if hasher != nil {
+			if _, err = temp.Seek(0, io.SeekStart); err == nil {
+				_, err = io.Copy(hasher, temp)
+			}
+		}
+	} else {
+		written, err = io.Copy(destination, body)
+	}
+	closeErr := temp.Close()
+	// A connection cut short of the announced length is a truncated download, not a network failure.
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		metrics.inc("sabic_truncated_downloads_total", map[string]string{"source": doc.source})
+		return nil, fmt.Errorf("%w: %s ended after %d of %d bytes: %w", ErrTruncated, finalURL, written, content.Length, err)
 	}
-	// Read the response body into memory first
-	var buf bytes.Buffer
-	// Copy it from the buffer to the file.
-	written, err := io.Copy(&buf, resp.Body)
 	// Print the error if errors are there.
 	if err != nil {
-		return false, fmt.Errorf("failed to read PDF data from %s: %v", finalURL, err)
+		return nil, fmt.Errorf("%w: failed to read PDF data from %s: %w", ErrNetwork, finalURL, err)
+	}
+	if closeErr != nil {
+		return nil, fmt.Errorf("%w: failed to write PDF to file for %s: %w", ErrStorage, finalURL, closeErr)
+	}
+	// Some proxies and plugin sources end the body early without an error; never keep such a file.
+	// An oversized body is reported below as such instead.
+	if content.Length >= 0 && written != content.Length && (limits.MaxBytes <= 0 || written <= limits.MaxBytes) {
+		metrics.inc("sabic_truncated_downloads_total", map[string]string{"source": doc.source})
+		return nil, fmt.Errorf("%w: read %d bytes of %s, Content-Length announced %d", ErrTruncated, written, finalURL, content.Length)
 	}
 	// If 0 bytes are written than show an error and return it.
 	if written == 0 {
-		return false, fmt.Errorf("downloaded 0 bytes for %s; not creating file", finalURL)
+		return nil, fmt.Errorf("%w: downloaded 0 bytes for %s; not creating file", ErrSizeOutOfRange, finalURL)
 	}
-	// Only now create the file and write to disk
-	out, err := os.Create(filePath)
-	// Failed to create the file.
-	if err != nil {
-		return false, fmt.Errorf("failed to create file for %s: %v", finalURL, err)
+	// Stub pages are tiny, reject anything below the minimum.
+	if written < limits.MinBytes {
+		return nil, fmt.Errorf("%w: response for %s is %d bytes, below the %d byte minimum", ErrSizeOutOfRange, finalURL, written, limits.MinBytes)
 	}
-	// Close the file.
-	defer out.Close()
-	// Write the buffer and if there is an error print it.
-	_, err = buf.WriteTo(out)
-	if err != nil {
-		return false, fmt.Errorf("failed to write PDF to file for %s: %v", finalURL, err)
+	// Anything past the limit reader's extra byte is too large.
+	if limits.MaxBytes > 0 && written > limits.MaxBytes {
+		return nil, fmt.Errorf("%w: response for %s exceeds the %d byte maximum", ErrSizeOutOfRange, finalURL, limits.MaxBytes)
 	}
-	// Return a true since everything went correctly.
-	return true, fmt.Errorf("successfully downloaded %d bytes: %s → %s", written, finalURL, filePath)
-}
-
-func convertJSONToSlice() []string {
-	// Create a return slice.
-	var returnSlice []string
-	// Read the JSON file containing the data (replace "input.json" with your actual file name)
-	fileContent, err := os.ReadFile("main.json")
-	// Print the error
-	if err != nil {
-		log.Println("Failed to read input JSON file:", err)
+	// Run the checks configured for the report type, or for the alternate format.
+	if doc.format != "" {
+		if err := validateAlternateStaged(fetcher.cfg, doc.format, staged.tempPath, written); err != nil {
+			return nil, fmt.Errorf("%s: %w", finalURL, err)
+		}
+	} else if err := validateStaged(fetcher.cfg, reportTypeFromURL(finalURL), staged.tempPath, written); err != nil {
+		return nil, fmt.Errorf("%s: %w", finalURL, err)
 	}
-	// Parse the JSON data into the Response struct
-	var response Response
-	// Umarash the json stuff.
-	err = json.Unmarshal(fileContent, &response)
-	// Print the errors.
-	if err != nil {
-		log.Println("Failed to parse JSON data:", err)
-	}
-	// Base URL to which parameters will be appended
-	baseURL := "https://zehsonesdsext-tjd0i1flxa.dispatcher.sa1.hana.ondemand.com/v1/SDS//DocContentSet"
-	// Loop through each result and construct a URL
-	for _, item := range response.Data.Results {
-		// Format the URL with the values from JSON fields
-		url := fmt.Sprintf("%s(Matnr='%s',Subid='%s',Sbgvid='%s',Laiso='%s',Vkorg='')/DocContentData/$value",
-			baseURL, item.MaterialNumber, item.SubID, item.StorageLocation, item.LanguageISO)
-		// Append to slice
-		returnSlice = appendToSlice(returnSlice, url)
-	}
-	// Return the slice.
-	return returnSlice
-}
-
-// Append some string to a slice and than return the slice.
-func appendToSlice(slice []string, content string) []string {
-	// Append the content to the slice
-	slice = append(slice, content)
-	// Return the slice
-	return slice
+	if hasher != nil {
+		staged.sha256 = hex.EncodeToString(hasher.Sum(nil))
+	}
+	staged.size = written
+	keep = true
+	return staged, nil
 }
 
-// convertURLToFilename extracts values from the URL and returns a formatted filename
-func convertURLToFilename(sdsURL string) string {
-	// Example input: https://.../DocContentSet(Matnr='290031915',Subid='630000000001',Sbgvid='SDS_FR',Laiso='FR',Vkorg='')/DocContentData/$value
-
-	re := regexp.MustCompile(`Matnr='(.*?)',Subid='(.*?)',Sbgvid='(.*?)',Laiso='(.*?)'`)
-	matches := re.FindStringSubmatch(sdsURL)
-
-	if len(matches) != 5 {
-		return ""
+// revalidating reports whether a stored document should be fetched again to look for a newer revision.
+// A downloader without a catalog, such as the fetch command's, has nothing to revalidate.
+func (fetcher *downloader) revalidating(filename string) bool {
+	if fetcher.catalog == nil {
+		return false
 	}
-
-	matnr := matches[1]
-	subid := matches[2]
-	sbgvid := matches[3]
-	laiso := matches[4]
-
-	filename := fmt.Sprintf("%s_%s_%s_%s.pdf", matnr, subid, sbgvid, laiso)
-	return strings.ToLower(filename)
+	entry, ok := fetcher.catalog.get(filename)
+	return ok && needsRevalidation(fetcher.cfg, entry, time.Now())
 }
 
-// Scrape the JSON and save it to the file.
-func scrapeJSONAndSaveLocally() {
-	url := "https://zehsonesdsext-tjd0i1flxa.dispatcher.sa1.hana.ondemand.com/v1/SDS/DocHeaderSet"
-	// url := "https://zehsonesdsext-tjd0i1flxa.dispatcher.sa1.hana.ondemand.com/v1/SDS/DocHeaderSet?$skip=1&$top=100"
-	method := "GET"
-
-	client := &http.Client{}
-	req, err := http.NewRequest(method, url, nil)
-
-	if err != nil {
-		log.Println(err)
-		return
+// storePDF moves a staged document to its final name and records it in the audit log and the catalog.
+// A revalidated document that is unchanged upstream only has its check recorded.
+func (fetcher *downloader) storePDF(staged *stagedDocument) error {
+	entry, known := fetcher.catalog.get(staged.filename)
+	if known && entry.SHA256 == staged.sha256 {
+		os.Remove(staged.tempPath)
+		fetcher.audit.record(auditVerified, staged.filename, staged.url, staged.sha256)
+		now := time.Now().UTC()
+		fetcher.catalog.update(staged.filename, func(entry *catalogEntry) {
+			entry.RevalidatedAt = &now
+		})
+		fetcher.recordResponse(staged)
+		log.Printf("no newer revision upstream: %s (issued %s)", staged.filename, entry.IssueDate)
+		return nil
 	}
-	req.Header.Add("Accept", "application/json")
-
-	res, err := client.Do(req)
-	if err != nil {
-		log.Println(err)
-		return
+	// Keep the revision being replaced when there is an archive for them.
+	if known && fetcher.cfg.RevisionDir != "" {
+		archivePath, err := archiveRevision(fetcher.cfg, fetcher.store, entry, time.Now())
+		if err != nil {
+			os.Remove(staged.tempPath)
+			return fmt.Errorf("%w: failed to archive the previous revision of %s: %w", ErrStorage, staged.filename, err)
+		}
+		if archivePath != "" {
+			log.Printf("archived the previous revision of %s to %s", staged.filename, archivePath)
+		}
 	}
-	if res.StatusCode != http.StatusOK { // Check for 200 OK
-		log.Printf("Download failed for %s: %s", url, res.Status)
+	// Read the issue date while the staged copy is plaintext, as the store may encrypt it.
+	// Only PDFs are read; alternates take the date from the header properties.
+	textPath := staged.tempPath
+	if staged.format != "" {
+		textPath = ""
 	}
-	// Read the body.
-	body, err := io.ReadAll(res.Body)
-	// Print any errors
+	issued, issuedFrom, issuedFound := documentIssueDate(fetcher.cfg, staged.properties, textPath)
+	published, publishedFound := documentPublishedAt(fetcher.cfg, staged.properties)
+	filePath, err := fetcher.store.put(staged.tempPath, staged.filename, staged.sha256)
 	if err != nil {
-		log.Println(err)
-		return
+		os.Remove(staged.tempPath)
+		return fmt.Errorf("%w: failed to store %s: %w", ErrStorage, staged.filename, err)
 	}
-	// Close the body
-	err = res.Body.Close()
-	// Log any errors
-	if err != nil {
-		log.Println(err) // Log error
+	// Record the retrieval.
+	fetcher.audit.record(auditDownloaded, staged.filename, staged.url, staged.sha256)
+	fetcher.catalog.update(staged.filename, func(entry *catalogEntry) {
+		entry.Source = staged.source
+		entry.DocumentID = staged.id.String()
+		entry.Format = staged.format
+		entry.SourceURL = staged.url
+		entry.InternalCode = fetcher.materials.internalCodeFor(staged.id.Matnr)
+		entry.SHA256 = staged.sha256
+		entry.Size = staged.size
+		entry.DownloadedAt = time.Now().UTC()
+		entry.Properties = staged.properties
+		entry.ProductFamily = productFamily(fetcher.cfg, staged.properties)
+		entry.RevalidatedAt = nil
+		entry.PublishedAt = nil
+		entry.Encrypted = storeKey(fetcher.store) != nil
+		if publishedFound {
+			entry.PublishedAt = &published
+		}
+	})
+	if issuedFound {
+		setIssueDate(fetcher.catalog, staged.filename, issued, issuedFrom)
 	}
-	// Save it to the file.
-	appendAndWriteToFile("main.json", string(body))
-}
-
-// Append and write to file
-func appendAndWriteToFile(path string, content string) {
-	filePath, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Println(err)
+	// Measure the publication SLO on revisions that weren't held already, not on content that
+	// changed without being published anew.
+	if publishedFound && (!known || !holdsPublication(entry, published)) {
+		publicationSLO.stored(staged.filename, staged.url, published, time.Now().UTC())
 	}
-	_, err = filePath.WriteString(content + "\n")
-	if err != nil {
-		log.Println(err)
+	fetcher.recordResponse(staged)
+	if fetcher.existing != nil {
+		fetcher.existing.add(staged.filename)
 	}
-	err = filePath.Close()
-	if err != nil {
-		log.Println(err)
+	log.Printf("successfully downloaded %d bytes: %s → %s", staged.size, staged.url, filePath)
+	return nil
+}
+
+// reportTypeFromURL returns the report type of a document URL, the Sbgvid prefix such as SDS.
+func reportTypeFromURL(sdsURL string) string {
+	keys, ok := documentIDOf(sdsURL)
+	if !ok {
+		return ""
 	}
+	return keys.reportType()
 }
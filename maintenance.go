@@ -0,0 +1,215 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maintenanceReport sums up what a maintenance run removed, compacted and rotated.
+type maintenanceReport struct {
+	Manifests      []string         // Run manifests past manifest_retention
+	Snapshots      []string         // Header snapshots beyond keep_snapshots
+	CatalogEntries []string         // Catalog entries of documents no longer stored
+	Compacted      []string         // Key-value logs rewritten, with their record counts
+	Rotated        string           // Segment the audit log was moved to, empty when it wasn't rotated
+	Revisions      []purgedRevision // Archived revisions outside the retention policy
+}
+
+// pruneManifests returns the run manifests older than the retention, removing them unless dryRun.
+// The age is read from the file name, which carries the start of the run.
+func pruneManifests(dir string, retention time.Duration, now time.Time, dryRun bool) ([]string, error) {
+	if dir == "" || retention <= 0 {
+		return nil, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "run-*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	var pruned []string
+	for _, path := range paths {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "run-"), ".jsonl")
+		started, err := time.Parse("20060102T150405Z", stamp)
+		if err != nil || now.Sub(started) <= retention {
+			continue
+		}
+		if !dryRun {
+			if err := os.Remove(path); err != nil {
+				return pruned, err
+			}
+		}
+		pruned = append(pruned, path)
+	}
+	return pruned, nil
+}
+
+// compactCatalog drops the entries of documents the store no longer holds, e.g. after a gc or a manual
+// cleanup, and rewrites the catalog unless dryRun. Each dropped document is recorded as evicted.
+func compactCatalog(docs *catalog, store documentStore, audit *auditLog, dryRun bool) ([]string, error) {
+	var dropped []string
+	entries := docs.all()
+	for _, entry := range entries {
+		if _, ok := store.path(entry.Filename); !ok {
+			dropped = append(dropped, entry.Filename)
+		}
+	}
+	// A wrong output_dir would make every document look gone.
+	if len(entries) > 0 && len(dropped) == len(entries) {
+		return nil, fmt.Errorf("none of the %d cataloged documents is stored, refusing to empty the catalog; check output_dir", len(entries))
+	}
+	if dryRun {
+		return dropped, nil
+	}
+	docs.mutex.Lock()
+	for _, filename := range dropped {
+		docs.touch(filename)
+		delete(docs.entries, filename)
+	}
+	for _, filename := range dropped {
+		audit.record(auditEvicted, filename, "", "")
+	}
+	// Rewrite even when nothing was dropped, which also tidies a hand-edited file.
+	docs.dirty = true
+	docs.mutex.Unlock()
+	return dropped, docs.save()
+}
+
+// compactKVStoreFile rewrites a key-value log with only its live values; a missing log is left alone.
+func compactKVStoreFile(path string) (string, bool, error) {
+	if path == "" || !fileExists(path) {
+		return "", false, nil
+	}
+	store, err := openKVStore(path)
+	if err != nil {
+		return "", false, err
+	}
+	before, after, err := store.compact()
+	if err != nil {
+		return "", false, err
+	}
+	return fmt.Sprintf("%s (%d records to %d)", path, before, after), true, nil
+}
+
+// runMaintenance prunes, compacts and rotates the state that grows with every run. It holds the run lock,
+// so it never rewrites files a sync is using.
+func runMaintenance(cfg *Config, now time.Time, dryRun bool) (*maintenanceReport, error) {
+	lock, err := acquireRunLock(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.release()
+	audit, err := openAuditLog(cfg.AuditLog)
+	if err != nil {
+		return nil, err
+	}
+	report := &maintenanceReport{}
+	// Run manifests and header snapshots.
+	if report.Manifests, err = pruneManifests(cfg.ManifestDir, cfg.ManifestRetention.Duration, now, dryRun); err != nil {
+		return report, fmt.Errorf("failed to prune manifests: %v", err)
+	}
+	snapshots, err := listSnapshots(cfg)
+	if err != nil {
+		return report, err
+	}
+	if cfg.KeepSnapshots > 0 && len(snapshots) > cfg.KeepSnapshots {
+		report.Snapshots = snapshots[:len(snapshots)-cfg.KeepSnapshots]
+		if !dryRun {
+			if err := pruneSnapshots(cfg); err != nil {
+				return report, fmt.Errorf("failed to prune snapshots: %v", err)
+			}
+		}
+	}
+	// Superseded revisions.
+	if report.Revisions, err = pruneRevisions(cfg, now, dryRun); err != nil {
+		return report, err
+	}
+	if !dryRun {
+		for _, revision := range report.Revisions {
+			audit.record(auditEvicted, revision.Document, revision.Path, "")
+		}
+	}
+	// The catalog and the key-value logs.
+	docs, err := openCatalog(cfg.CatalogFile)
+	if err != nil {
+		return report, err
+	}
+	store, err := openDocumentStore(cfg)
+	if err != nil {
+		return report, err
+	}
+	if report.CatalogEntries, err = compactCatalog(docs, store, audit, dryRun); err != nil {
+		return report, fmt.Errorf("failed to compact the catalog: %v", err)
+	}
+	if !dryRun {
+		for _, path := range []string{cfg.ResponseStore, cfg.MirrorState, cfg.AccessCounts, cfg.EHSState} {
+			compacted, ok, err := compactKVStoreFile(path)
+			if err != nil {
+				return report, fmt.Errorf("failed to compact %s: %v", path, err)
+			}
+			if ok {
+				report.Compacted = append(report.Compacted, compacted)
+			}
+		}
+	}
+	// The audit log is never pruned, only rotated into segments that stay chained together.
+	if cfg.AuditLog != "" && cfg.AuditRotateSize > 0 {
+		if info, err := os.Stat(cfg.AuditLog); err == nil && info.Size() > cfg.AuditRotateSize {
+			report.Rotated = cfg.AuditLog
+			if !dryRun {
+				if report.Rotated, err = rotateAuditLog(cfg.AuditLog, now); err != nil {
+					return report, fmt.Errorf("failed to rotate the audit log: %v", err)
+				}
+			}
+		}
+	}
+	return report, nil
+}
+
+// runMaintenanceCommand implements the maintenance command.
+func runMaintenanceCommand(args []string) error {
+	var dryRun bool
+	cfg, _, err := loadConfig("maintenance", args, func(flagSet *flag.FlagSet) {
+		flagSet.BoolVar(&dryRun, "dry-run", false, "report what would be pruned, compacted and rotated without changing anything")
+	})
+	if err != nil {
+		return err
+	}
+	report, err := runMaintenance(cfg, time.Now(), dryRun)
+	if err != nil {
+		return err
+	}
+	verb := "removed"
+	if dryRun {
+		verb = "would remove"
+	}
+	for _, path := range report.Manifests {
+		log.Printf("%s manifest %s", verb, path)
+	}
+	for _, path := range report.Snapshots {
+		log.Printf("%s snapshot %s", verb, path)
+	}
+	for _, filename := range report.CatalogEntries {
+		log.Printf("%s catalog entry %s, the document is no longer stored", verb, filename)
+	}
+	var purgedBytes int64
+	for _, revision := range report.Revisions {
+		log.Printf("%s revision of %s superseded %s: %s (%d bytes)", verb, revision.Document, revision.SupersededAt.Format("2006-01-02"), revision.Path, revision.Size)
+		purgedBytes = purgedBytes + revision.Size
+	}
+	for _, compacted := range report.Compacted {
+		log.Printf("compacted %s", compacted)
+	}
+	if report.Rotated != "" {
+		if dryRun {
+			log.Printf("would rotate the audit log %s", report.Rotated)
+		} else {
+			log.Printf("rotated the audit log to %s", report.Rotated)
+		}
+	}
+	log.Printf("maintenance done: %d manifests, %d snapshots, %d revisions (%d bytes), %d catalog entries, %d logs compacted", len(report.Manifests), len(report.Snapshots), len(report.Revisions), purgedBytes, len(report.CatalogEntries), len(report.Compacted))
+	return nil
+}
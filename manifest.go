@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Statuses of a document in a sync run.
+const (
+	resultDownloaded = "downloaded" // Fetched and stored
+	resultSkipped    = "skipped"    // Already on disk
+	resultFailed     = "failed"     // Fetching or storing failed
+)
+
+// Reasons a document was skipped, in manifests and the run summary.
+const (
+	skipExistsVerified   = "exists-verified"      // Stored, with the hash the catalog recorded when it was fetched
+	skipExistsUnverified = "exists-unverified"    // On disk, but not known to the catalog, or being fetched by another request
+	skipLanguage         = "filtered-by-language" // Another language of the material ranks higher in language_fallback
+	skipNotModified      = "not-modified-304"     // Revalidated with a conditional request the upstream answered 304
+	skipPolicy           = "excluded-by-policy"   // Left out by the material map, jurisdiction or product family filters
+	skipUnnamed          = "no-file-name"         // Listed with keys no file name can be made from
+)
+
+// skipError is a skip with its reason; it unwraps to ErrAlreadyExists or ErrNotModified.
+type skipError struct {
+	reason string
+	err    error
+}
+
+// Error implements error.
+func (skip *skipError) Error() string {
+	return skip.err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (skip *skipError) Unwrap() error {
+	return skip.err
+}
+
+// skipped attaches a skip reason to an error.
+func skipped(reason string, err error) error {
+	return &skipError{reason: reason, err: err}
+}
+
+// downloadResult is the outcome of one document in a sync run.
+type downloadResult struct {
+	RunID      string    `json:"run_id"`                // Sync run the attempt belongs to, see newRunID
+	Time       time.Time `json:"time"`                  // When the attempt finished
+	URL        string    `json:"url"`                   // Document URL
+	Filename   string    `json:"filename"`              // Stored file name
+	Status     string    `json:"status"`                // downloaded, skipped or failed
+	SkipReason string    `json:"skip_reason,omitempty"` // Why a skipped document was skipped, e.g. exists-verified
+	Error      string    `json:"error,omitempty"`       // Error message of a failed attempt
+	ErrorClass string    `json:"error_class,omitempty"` // Label of the typed error, see errorClass
+	Size       int64     `json:"size,omitempty"`        // Stored size in bytes
+	SHA256     string    `json:"sha256,omitempty"`      // Hash of the stored content
+	// Language the text reads in when it differs from the requested Laiso, see checkLanguage.
+	LanguageMismatch string `json:"language_mismatch,omitempty"`
+	// URLs a failed download was redirected through, starting with its own, see redirects.go.
+	Redirects []string `json:"redirects,omitempty"`
+}
+
+// newDownloadResult turns the return values of downloadPDF into a result.
+func newDownloadResult(sdsURL string, filename string, downloaded bool, err error) downloadResult {
+	result := downloadResult{Time: time.Now().UTC(), URL: sdsURL, Filename: filename, Status: resultDownloaded}
+	var skip *skipError
+	switch {
+	case errors.As(err, &skip):
+		result.Status, result.SkipReason = resultSkipped, skip.reason
+	case errors.Is(err, ErrAlreadyExists):
+		result.Status, result.SkipReason = resultSkipped, skipExistsUnverified
+	case errors.Is(err, ErrNotModified):
+		result.Status, result.SkipReason = resultSkipped, skipNotModified
+	case err != nil || !downloaded:
+		result.Status = resultFailed
+	}
+	if err != nil {
+		result.Error = err.Error()
+		result.ErrorClass = errorClass(err)
+	}
+	var redirected *redirectedError
+	if errors.As(err, &redirected) {
+		result.Redirects = redirected.chain
+	}
+	return result
+}
+
+// newSkippedResult is the result of a document the planner left out.
+func newSkippedResult(doc documentRef, reason string) downloadResult {
+	return downloadResult{Time: time.Now().UTC(), URL: doc.url, Filename: doc.filename, Status: resultSkipped, SkipReason: reason}
+}
+
+// runManifest writes the results of one sync run as JSON lines.
+type runManifest struct {
+	mutex  sync.Mutex
+	runID  string
+	file   *os.File
+	counts map[string]int // Results by status and error class or skip reason
+}
+
+// openRunManifest creates the manifest of a run started at the given time; an empty directory disables it.
+func openRunManifest(dir string, started time.Time, runID string) (*runManifest, error) {
+	manifest := &runManifest{runID: runID, counts: make(map[string]int)}
+	if dir == "" {
+		return manifest, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create manifest directory: %v", err)
+	}
+	path := filepath.Join(dir, "run-"+started.UTC().Format("20060102T150405Z")+".jsonl")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create run manifest: %v", err)
+	}
+	manifest.file = file
+	return manifest, nil
+}
+
+// record adds a result to the manifest and the metrics.
+func (manifest *runManifest) record(result downloadResult) {
+	manifest.mutex.Lock()
+	defer manifest.mutex.Unlock()
+	result.RunID = manifest.runID
+	// Error messages quote URLs and responses, which may carry tokens.
+	result.URL = activeRedactor.text(result.URL)
+	result.Error = activeRedactor.text(result.Error)
+	for i, hop := range result.Redirects {
+		result.Redirects[i] = activeRedactor.text(hop)
+	}
+	metrics.inc("sabic_documents_total", map[string]string{"status": result.Status, "error_class": result.ErrorClass})
+	key := result.Status
+	if result.ErrorClass != "" && result.Status == resultFailed {
+		key = key + "/" + result.ErrorClass
+	}
+	if result.SkipReason != "" {
+		key = key + "/" + result.SkipReason
+		metrics.inc("sabic_skips_total", map[string]string{"reason": result.SkipReason})
+	}
+	manifest.counts[key] = manifest.counts[key] + 1
+	if manifest.file == nil {
+		return
+	}
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		log.Println("Failed to encode manifest record:", err)
+		return
+	}
+	if _, err := manifest.file.Write(append(encoded, '\n')); err != nil {
+		log.Println("Failed to write manifest record:", err)
+	}
+}
+
+// summary returns the result counts, e.g. "downloaded=3 failed/not_found=1 skipped/exists-verified=12".
+func (manifest *runManifest) summary() string {
+	manifest.mutex.Lock()
+	defer manifest.mutex.Unlock()
+	keys := make([]string, 0, len(manifest.counts))
+	for key := range manifest.counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%d", key, manifest.counts[key]))
+	}
+	if len(parts) == 0 {
+		return "nothing to do"
+	}
+	return strings.Join(parts, " ")
+}
+
+// close flushes the manifest to disk.
+func (manifest *runManifest) close() error {
+	if manifest.file == nil {
+		return nil
+	}
+	if err := manifest.file.Sync(); err != nil {
+		manifest.file.Close()
+		return err
+	}
+	return manifest.file.Close()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// materialMap maps SABIC material numbers (Matnr) to the internal codes of our ERP.
+type materialMap map[string]string
+
+// unsafeCodeCharacters matches anything that shouldn't end up in a file name,
+// including repeated dashes which would clash with the "--" separator.
+var unsafeCodeCharacters = regexp.MustCompile(`[^a-z0-9.-]+|-{2,}`)
+
+// loadMaterialMap reads a CSV of internal_code,matnr rows; an empty path means no mapping.
+// A first row with "matnr" in the second column is treated as a header.
+func loadMaterialMap(path string) (materialMap, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open material map: %v", err)
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	materials := make(materialMap)
+	line := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read material map: %v", err)
+		}
+		line = line + 1
+		if len(record) < 2 {
+			return nil, fmt.Errorf("material map line %d: expected internal_code,matnr", line)
+		}
+		internalCode := strings.TrimSpace(record[0])
+		matnr := strings.TrimSpace(record[1])
+		// Skip a header row.
+		if line == 1 && strings.EqualFold(matnr, "matnr") {
+			continue
+		}
+		if existing, ok := materials[matnr]; ok && existing != internalCode {
+			log.Printf("material map line %d: %s is already mapped to %s, ignoring %s", line, matnr, existing, internalCode)
+			continue
+		}
+		materials[matnr] = internalCode
+	}
+	return materials, nil
+}
+
+// accepts reports whether a document belongs to a mapped material; without a map every document is accepted.
+func (materials materialMap) accepts(id DocumentID) bool {
+	if materials == nil {
+		return true
+	}
+	_, mapped := materials[id.Matnr]
+	return mapped
+}
+
+// internalCodeFor returns the internal code of a material, empty when unmapped.
+func (materials materialMap) internalCodeFor(matnr string) string {
+	return materials[matnr]
+}
+
+// documentFilename returns the stored file name of a document.
+// Mapped materials are prefixed with their internal code, e.g. erp-123--22006037_..._sds_my_ms.pdf.
+func documentFilename(materials materialMap, id DocumentID) string {
+	filename := id.filename()
+	internalCode := unsafeCodeCharacters.ReplaceAllString(strings.ToLower(materials.internalCodeFor(id.Matnr)), "-")
+	internalCode = strings.Trim(internalCode, "-")
+	if internalCode == "" {
+		return filename
+	}
+	return internalCode + "--" + filename
+}
+
+// splitInternalCode separates the internal code prefix from a stored file name.
+func splitInternalCode(filename string) (string, string) {
+	if internalCode, rest, found := strings.Cut(filename, "--"); found {
+		return internalCode, rest
+	}
+	return "", filename
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// metricsRegistry holds counters and histograms keyed by name and label set, exported in the Prometheus text format.
+type metricsRegistry struct {
+	mutex      sync.Mutex
+	counters   map[string]map[string]float64    // Metric name to rendered label set to value
+	histograms map[string]map[string]*histogram // Metric name to rendered label set to its buckets
+	help       map[string]string                // Metric name to its HELP text
+}
+
+// histogram is one series of a histogram metric.
+type histogram struct {
+	labels map[string]string
+	bounds []float64 // Upper bounds of the buckets, ascending
+	counts []float64 // Observations at or below each bound
+	count  float64   // All observations, the +Inf bucket
+	sum    float64
+}
+
+// metrics is the process-wide registry; sync runs and the server share it.
+var metrics = &metricsRegistry{
+	counters:   make(map[string]map[string]float64),
+	histograms: make(map[string]map[string]*histogram),
+	help:       make(map[string]string),
+}
+
+// renderLabels formats labels as {a="1",b="2"} in a stable order.
+func renderLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[name])
+		parts = append(parts, fmt.Sprintf("%s=%q", name, value))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// describe sets the HELP text of a metric.
+func (registry *metricsRegistry) describe(name, help string) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.help[name] = help
+}
+
+// add increases a counter by value.
+func (registry *metricsRegistry) add(name string, labels map[string]string, value float64) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	series, ok := registry.counters[name]
+	if !ok {
+		series = make(map[string]float64)
+		registry.counters[name] = series
+	}
+	series[renderLabels(labels)] = series[renderLabels(labels)] + value
+}
+
+// set replaces every series of a metric with one, for info metrics that must stay a single series.
+func (registry *metricsRegistry) set(name string, labels map[string]string, value float64) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.counters[name] = map[string]float64{renderLabels(labels): value}
+}
+
+// gauge replaces one series of a metric, for values that go up and down.
+func (registry *metricsRegistry) gauge(name string, labels map[string]string, value float64) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	series, ok := registry.counters[name]
+	if !ok {
+		series = make(map[string]float64)
+		registry.counters[name] = series
+	}
+	series[renderLabels(labels)] = value
+}
+
+// inc increases a counter by one.
+func (registry *metricsRegistry) inc(name string, labels map[string]string) {
+	registry.add(name, labels, 1)
+}
+
+// observe adds a value to a histogram whose buckets end at bounds, which must be ascending and
+// the same for every observation of the metric.
+func (registry *metricsRegistry) observe(name string, labels map[string]string, bounds []float64, value float64) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	series, ok := registry.histograms[name]
+	if !ok {
+		series = make(map[string]*histogram)
+		registry.histograms[name] = series
+	}
+	labelSet := renderLabels(labels)
+	buckets, ok := series[labelSet]
+	if !ok {
+		buckets = &histogram{labels: labels, bounds: bounds, counts: make([]float64, len(bounds))}
+		series[labelSet] = buckets
+	}
+	for i, bound := range buckets.bounds {
+		if value <= bound {
+			buckets.counts[i] = buckets.counts[i] + 1
+		}
+	}
+	buckets.count = buckets.count + 1
+	buckets.sum = buckets.sum + value
+}
+
+// writePrometheus writes every counter and histogram in the Prometheus text exposition format.
+func (registry *metricsRegistry) writePrometheus(writer io.Writer) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	names := make([]string, 0, len(registry.counters)+len(registry.histograms))
+	for name := range registry.counters {
+		names = append(names, name)
+	}
+	for name := range registry.histograms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if help, ok := registry.help[name]; ok {
+			fmt.Fprintf(writer, "# HELP %s %s\n", name, help)
+		}
+		if series, ok := registry.histograms[name]; ok {
+			fmt.Fprintf(writer, "# TYPE %s histogram\n", name)
+			writeHistogram(writer, name, series)
+			continue
+		}
+		fmt.Fprintf(writer, "# TYPE %s counter\n", name)
+		series := registry.counters[name]
+		labelSets := make([]string, 0, len(series))
+		for labelSet := range series {
+			labelSets = append(labelSets, labelSet)
+		}
+		sort.Strings(labelSets)
+		for _, labelSet := range labelSets {
+			fmt.Fprintf(writer, "%s%s %g\n", name, labelSet, series[labelSet])
+		}
+	}
+}
+
+// writeHistogram writes the bucket, sum and count lines of every series of a histogram.
+func writeHistogram(writer io.Writer, name string, series map[string]*histogram) {
+	for _, labelSet := range sortedKeys(series) {
+		buckets := series[labelSet]
+		withBound := func(bound string) string {
+			labels := map[string]string{"le": bound}
+			for label, value := range buckets.labels {
+				labels[label] = value
+			}
+			return renderLabels(labels)
+		}
+		for i, bound := range buckets.bounds {
+			fmt.Fprintf(writer, "%s_bucket%s %g\n", name, withBound(fmt.Sprintf("%g", bound)), buckets.counts[i])
+		}
+		fmt.Fprintf(writer, "%s_bucket%s %g\n", name, withBound("+Inf"), buckets.count)
+		fmt.Fprintf(writer, "%s_sum%s %g\n", name, labelSet, buckets.sum)
+		fmt.Fprintf(writer, "%s_count%s %g\n", name, labelSet, buckets.count)
+	}
+}
+
+// handleMetrics serves GET /metrics.
+func (srv *corpusServer) handleMetrics() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		metrics.writePrometheus(writer)
+	}
+}
+
+func init() {
+	metrics.describe("sabic_documents_total", "Documents processed by sync runs, by status and error class.")
+	metrics.describe("sabic_skips_total", "Documents skipped by sync runs, by reason.")
+	metrics.describe("sabic_run_info", "Always 1, labelled with the ID of the current or last sync run.")
+	metrics.describe("sabic_truncated_downloads_total", "Downloads whose body didn't match the announced Content-Length, by source.")
+	metrics.describe("sabic_sniffed_pdfs_total", "PDFs accepted by their content despite a generic Content-Type, by source.")
+}
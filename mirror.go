@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// mirrorTarget is a secondary location documents are copied to for disaster recovery.
+type mirrorTarget interface {
+	// put copies a local file to name, replacing what is there only once the copy is complete.
+	put(ctx context.Context, name string, localPath string, sha256 string) error
+	// hash returns the SHA-256 of the copy at name, false when there is none.
+	hash(ctx context.Context, name string) (string, bool, error)
+}
+
+// openMirrorTarget opens a destination given as a local path, sftp://[user@]host[:port]/path, s3://bucket/prefix,
+// sharepoint://host/sites/<site>/<library>/folder or webdav://[user@]host/path (webdav+http:// without TLS).
+// The catalog supplies the metadata some targets store.
+func openMirrorTarget(cfg *Config, docs *catalog, destination string) (mirrorTarget, error) {
+	parsed, err := url.Parse(destination)
+	if err != nil || parsed.Scheme == "" || len(parsed.Scheme) == 1 {
+		// Plain paths, including Windows drive letters.
+		return &localMirror{dir: destination}, nil
+	}
+	switch parsed.Scheme {
+	case "file":
+		return &localMirror{dir: parsed.Path}, nil
+	case "sftp":
+		return &sftpMirror{host: parsed.Host, user: parsed.User.Username(), dir: parsed.Path}, nil
+	case "s3":
+		client, err := newS3Client(cfg, parsed.Host)
+		if err != nil {
+			return nil, err
+		}
+		lock, err := s3LockHeaders(cfg, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		return &s3Mirror{client: client, prefix: strings.Trim(parsed.Path, "/"), lock: lock}, nil
+	case "sharepoint":
+		return newSharePointMirror(cfg, docs, parsed)
+	case "webdav", "webdav+http":
+		return newWebDAVMirror(cfg, parsed), nil
+	default:
+		return nil, fmt.Errorf("unsupported mirror destination %q, expected a path, sftp://, s3://, sharepoint:// or webdav://", destination)
+	}
+}
+
+// localMirror mirrors to a directory, such as a mounted NAS share.
+type localMirror struct {
+	dir string
+}
+
+// put implements mirrorTarget.
+func (target *localMirror) put(ctx context.Context, name string, localPath string, sha256 string) error {
+	destination, err := joinWithin(target.dir, name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(destination), 0o755); err != nil {
+		return err
+	}
+	return copyFileAtomically(localPath, destination)
+}
+
+// hash implements mirrorTarget by reading the copy back.
+func (target *localMirror) hash(ctx context.Context, name string) (string, bool, error) {
+	sum, err := fileSHA256(filepath.Join(target.dir, name))
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	return sum, err == nil, err
+}
+
+// sftpMirror mirrors over SFTP with the system's sftp client, so keys, agents and known hosts
+// work as they do for the operator. Copies are read back to be verified, which works on
+// accounts that allow nothing but SFTP.
+type sftpMirror struct {
+	host string // host or host:port
+	user string
+	dir  string
+}
+
+// run executes an sftp batch, failing on the first failed command.
+func (target *sftpMirror) run(ctx context.Context, batch string) error {
+	host, port, hasPort := strings.Cut(target.host, ":")
+	args := []string{"-b", "-", "-o", "BatchMode=yes"}
+	if hasPort {
+		args = append(args, "-P", port)
+	}
+	if target.user != "" {
+		host = target.user + "@" + host
+	}
+	command := exec.CommandContext(ctx, "sftp", append(args, host)...)
+	command.Stdin = strings.NewReader(batch)
+	var output bytes.Buffer
+	command.Stdout = &output
+	command.Stderr = &output
+	if err := command.Run(); err != nil {
+		return fmt.Errorf("sftp %s: %v: %s", target.host, err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+// sftpQuote quotes a path for an sftp batch file.
+func sftpQuote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// put implements mirrorTarget: it uploads beside the destination and renames over it.
+func (target *sftpMirror) put(ctx context.Context, name string, localPath string, sha256 string) error {
+	remote := path.Join(target.dir, name)
+	temp := path.Join(path.Dir(remote), "."+path.Base(remote)+".part")
+	var batch strings.Builder
+	// A leading - lets a command fail without ending the batch, for directories that exist already.
+	for _, dir := range parentDirs(path.Dir(remote)) {
+		fmt.Fprintf(&batch, "-mkdir %s\n", sftpQuote(dir))
+	}
+	fmt.Fprintf(&batch, "put %s %s\n", sftpQuote(localPath), sftpQuote(temp))
+	fmt.Fprintf(&batch, "-rm %s\n", sftpQuote(remote))
+	fmt.Fprintf(&batch, "rename %s %s\n", sftpQuote(temp), sftpQuote(remote))
+	return target.run(ctx, batch.String())
+}
+
+// parentDirs returns dir and its parents, outermost first.
+func parentDirs(dir string) []string {
+	var dirs []string
+	for ; dir != "/" && dir != "." && dir != ""; dir = path.Dir(dir) {
+		dirs = append([]string{dir}, dirs...)
+	}
+	return dirs
+}
+
+// hash implements mirrorTarget by downloading the copy and hashing it.
+func (target *sftpMirror) hash(ctx context.Context, name string) (string, bool, error) {
+	temp, err := os.CreateTemp("", "mirror-verify-*")
+	if err != nil {
+		return "", false, err
+	}
+	temp.Close()
+	defer os.Remove(temp.Name())
+	remote := path.Join(target.dir, name)
+	if err := target.run(ctx, fmt.Sprintf("get %s %s\n", sftpQuote(remote), sftpQuote(temp.Name()))); err != nil {
+		if strings.Contains(err.Error(), "not found") || strings.Contains(err.Error(), "No such file") {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	sum, err := fileSHA256(temp.Name())
+	return sum, err == nil, err
+}
+
+// s3Mirror mirrors to an S3 bucket; S3 checks the SHA-256 of every upload itself.
+type s3Mirror struct {
+	client *s3Client
+	prefix string
+	lock   http.Header // Object Lock headers of every upload, nil when uploads aren't locked
+}
+
+// key returns the object key of a document.
+func (target *s3Mirror) key(name string) string {
+	if target.prefix == "" {
+		return name
+	}
+	return target.prefix + "/" + name
+}
+
+// put implements mirrorTarget.
+func (target *s3Mirror) put(ctx context.Context, name string, localPath string, sha256 string) error {
+	return target.putLocked(ctx, target.key(name), localPath, sha256, nil)
+}
+
+// putLocked uploads a file with the run's Object Lock settings.
+func (target *s3Mirror) putLocked(ctx context.Context, key string, localPath string, sha256 string, header http.Header) error {
+	if target.lock != nil {
+		sum, err := fileMD5(localPath)
+		if err != nil {
+			return err
+		}
+		header = header.Clone()
+		if header == nil {
+			header = make(http.Header)
+		}
+		for name, values := range target.lock {
+			header[name] = values
+		}
+		header.Set("Content-MD5", sum)
+	}
+	return target.client.putObject(ctx, key, localPath, sha256, header)
+}
+
+// putManifest uploads the report of a mirror run next to the documents, under manifests/, so a
+// locked archive carries its own record of what it held and when.
+func (target *s3Mirror) putManifest(ctx context.Context, report *mirrorReport) error {
+	encoded, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp("", "mirror-manifest-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	_, err = temp.Write(encoded)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(encoded)
+	key := target.key("manifests/mirror-" + report.StartedAt.Format("20060102T150405Z") + ".json")
+	return target.putLocked(ctx, key, temp.Name(), hex.EncodeToString(sum[:]), http.Header{"Content-Type": {"application/json"}})
+}
+
+// hash implements mirrorTarget from the checksum S3 keeps.
+func (target *s3Mirror) hash(ctx context.Context, name string) (string, bool, error) {
+	return target.client.headObject(ctx, target.key(name))
+}
+
+// Outcomes of mirroring one document.
+const (
+	mirrorCopied    = "copied"    // Copied and verified
+	mirrorUnchanged = "unchanged" // Already mirrored at this hash
+	mirrorFailed    = "failed"    // Copy or verification failed
+)
+
+// mirrorResult is one line of the verification report.
+type mirrorResult struct {
+	Document string `json:"document"`
+	SHA256   string `json:"sha256"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// mirrorReport is the verification report of a mirror run.
+type mirrorReport struct {
+	Destination string         `json:"destination"`
+	StartedAt   time.Time      `json:"started_at"`
+	FinishedAt  time.Time      `json:"finished_at"`
+	Counts      map[string]int `json:"counts"`
+	Results     []mirrorResult `json:"results"`
+}
+
+// mirrorStore copies new and changed documents of the store to a target and verifies every copy by hash.
+// What was mirrored is kept in the mirror state, so unchanged documents cost nothing on later runs;
+// verifyAll hashes every copy at the destination instead of trusting that state.
+func mirrorStore(ctx context.Context, cfg *Config, store documentStore, docs *catalog, destination string, verifyAll bool) (*mirrorReport, error) {
+	target, err := openMirrorTarget(cfg, docs, destination)
+	if err != nil {
+		return nil, err
+	}
+	state, err := openKVStore(cfg.MirrorState)
+	if err != nil {
+		return nil, err
+	}
+	defer state.close()
+	names, err := store.names()
+	if err != nil {
+		return nil, err
+	}
+	report := &mirrorReport{Destination: destination, StartedAt: time.Now().UTC(), Counts: make(map[string]int)}
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		result := mirrorDocument(ctx, target, state, store, docs, destination, name, verifyAll)
+		if result.Status == mirrorFailed {
+			log.Printf("mirror %s: %s", name, result.Error)
+		}
+		report.Counts[result.Status] = report.Counts[result.Status] + 1
+		report.Results = append(report.Results, result)
+	}
+	report.FinishedAt = time.Now().UTC()
+	if s3, ok := target.(*s3Mirror); ok {
+		if err := s3.putManifest(ctx, report); err != nil {
+			return nil, fmt.Errorf("failed to upload the mirror manifest: %v", err)
+		}
+	}
+	return report, nil
+}
+
+// mirrorDocument mirrors one document and verifies the copy.
+func mirrorDocument(ctx context.Context, target mirrorTarget, state *kvStore, store documentStore, docs *catalog, destination string, name string, verifyAll bool) mirrorResult {
+	localPath, ok := store.path(name)
+	if !ok {
+		return mirrorResult{Document: name, Status: mirrorFailed, Error: "not in the store"}
+	}
+	// The catalog knows the hash of complete documents; anything else is hashed here. Encrypted
+	// documents are mirrored as they are stored, so the catalog's plaintext hash doesn't apply.
+	entry, _ := docs.get(name)
+	sum := entry.SHA256
+	if sum == "" || storeKey(store) != nil {
+		var err error
+		if sum, err = fileSHA256(localPath); err != nil {
+			return mirrorResult{Document: name, Status: mirrorFailed, Error: err.Error()}
+		}
+	}
+	result := mirrorResult{Document: name, SHA256: sum}
+	stateKey := destination + "\x00" + name
+	var mirrored string
+	state.get(stateKey, &mirrored)
+	if verifyAll {
+		remote, exists, err := target.hash(ctx, name)
+		if err != nil {
+			result.Status, result.Error = mirrorFailed, err.Error()
+			return result
+		}
+		if exists && remote == sum {
+			result.Status = mirrorUnchanged
+			state.put(stateKey, sum)
+			return result
+		}
+	} else if mirrored == sum {
+		result.Status = mirrorUnchanged
+		return result
+	}
+	if err := target.put(ctx, name, localPath, sum); err != nil {
+		result.Status, result.Error = mirrorFailed, err.Error()
+		return result
+	}
+	remote, exists, err := target.hash(ctx, name)
+	switch {
+	case err != nil:
+		result.Status, result.Error = mirrorFailed, "verification failed: "+err.Error()
+	case !exists || remote != sum:
+		result.Status, result.Error = mirrorFailed, fmt.Sprintf("verification failed: copy has sha256 %q", remote)
+	default:
+		result.Status = mirrorCopied
+		if err := state.put(stateKey, sum); err != nil {
+			log.Println("Failed to record mirrored document:", err)
+		}
+	}
+	return result
+}
+
+// runMirror implements the mirror command.
+func runMirror(args []string) error {
+	var verifyAll bool
+	var reportPath string
+	cfg, rest, err := loadConfig("mirror", args, func(flagSet *flag.FlagSet) {
+		flagSet.BoolVar(&verifyAll, "verify", false, "hash every copy at the destination instead of trusting the mirror state")
+		flagSet.StringVar(&reportPath, "report", "", "write the verification report as JSON to this file")
+	})
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return fmt.Errorf("usage: mirror [flags] <destination directory, sftp://host/path, s3://bucket/prefix, sharepoint://host/sites/site/library or webdav://host/path>")
+	}
+	store, err := openDocumentStore(cfg)
+	if err != nil {
+		return err
+	}
+	docs, err := openCatalog(cfg.CatalogFile)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	report, err := mirrorStore(ctx, cfg, store, docs, rest[0], verifyAll)
+	if err != nil {
+		return err
+	}
+	if reportPath != "" {
+		encoded, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomically(reportPath, encoded, 0o644); err != nil {
+			return err
+		}
+	}
+	log.Printf("mirrored to %s: %d copied, %d unchanged, %d failed", rest[0], report.Counts[mirrorCopied], report.Counts[mirrorUnchanged], report.Counts[mirrorFailed])
+	if report.Counts[mirrorFailed] > 0 {
+		return fmt.Errorf("%d documents failed to mirror", report.Counts[mirrorFailed])
+	}
+	return nil
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	mathrand "math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ways of comparing a stored document with upstream.
+const (
+	mirrorAuditFull   = "full"   // Download the whole document and compare hashes
+	mirrorAuditRanged = "ranged" // Compare the total size and a hash of the first bytes only
+)
+
+// mirrorAuditPrefix is how many leading bytes a ranged audit compares.
+const mirrorAuditPrefix = 64 * 1024
+
+// Outcomes of auditing one document.
+const (
+	mirrorMatch         = "match"          // Upstream serves what we store
+	mirrorMismatch      = "mismatch"       // Upstream serves something else
+	mirrorMissingLocal  = "missing_local"  // The catalog lists it but the store doesn't have it
+	mirrorUpstreamError = "upstream_error" // Upstream couldn't be asked
+)
+
+// mirrorAuditResult is the finding for one document.
+type mirrorAuditResult struct {
+	Filename       string `json:"filename"`
+	SourceURL      string `json:"source_url"`
+	Status         string `json:"status"`                    // One of the mirror* outcomes
+	LocalSHA256    string `json:"local_sha256,omitempty"`    // Hash of what is compared locally
+	UpstreamSHA256 string `json:"upstream_sha256,omitempty"` // Hash of what upstream served
+	LocalSize      int64  `json:"local_size,omitempty"`
+	UpstreamSize   int64  `json:"upstream_size,omitempty"` // -1 when upstream didn't say
+	Error          string `json:"error,omitempty"`
+	ErrorClass     string `json:"error_class,omitempty"`
+}
+
+// mirrorAuditReport certifies how faithful the mirror was at the time of the audit.
+type mirrorAuditReport struct {
+	StartedAt  time.Time           `json:"started_at"`
+	FinishedAt time.Time           `json:"finished_at"`
+	Actor      string              `json:"actor"`
+	Mode       string              `json:"mode"`
+	Cataloged  int                 `json:"cataloged"`      // Documents in the catalog
+	Audited    int                 `json:"audited"`        // Documents in the sample
+	Seed       uint64              `json:"seed,omitempty"` // Seed of the sample, to repeat it
+	Counts     map[string]int      `json:"counts"`         // Results by status
+	Results    []mirrorAuditResult `json:"results"`
+	PublicKey  string              `json:"public_key"` // Base64 ed25519 key that verifies the .sig file
+}
+
+// loadSigningKey reads the ed25519 key that signs audit reports, creating one on first use.
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		encoded, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomically(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: encoded}), 0o600); err != nil {
+			return nil, err
+		}
+		log.Printf("created audit signing key %s", path)
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s holds no PEM key", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 key", path)
+	}
+	return key, nil
+}
+
+// hashPrefix returns the hex SHA-256 and length of at most limit bytes of reader; a negative limit reads everything.
+func hashPrefix(reader io.Reader, limit int64) (string, int64, error) {
+	if limit >= 0 {
+		reader = io.LimitReader(reader, limit)
+	}
+	hash := sha256.New()
+	n, err := io.Copy(hash, reader)
+	return hex.EncodeToString(hash.Sum(nil)), n, err
+}
+
+// mirrorAuditor compares stored documents with what upstream serves now.
+type mirrorAuditor struct {
+	cfg     *Config
+	store   documentStore
+	mode    string
+	client  *http.Client      // For ranged reads
+	sources map[string]Source // For full fetches
+}
+
+// auditDocument compares one catalog entry with upstream.
+func (auditor *mirrorAuditor) auditDocument(ctx context.Context, entry catalogEntry) mirrorAuditResult {
+	result := mirrorAuditResult{Filename: entry.Filename, SourceURL: entry.SourceURL}
+	localPath, ok := auditor.store.path(entry.Filename)
+	if !ok {
+		result.Status = mirrorMissingLocal
+		return result
+	}
+	limit := int64(-1)
+	if auditor.mode == mirrorAuditRanged {
+		limit = mirrorAuditPrefix
+	}
+	localFile, err := openReadable(storeKey(auditor.store), localPath)
+	if err != nil {
+		result.Status = mirrorMissingLocal
+		result.Error = err.Error()
+		return result
+	}
+	defer localFile.Close()
+	result.LocalSize = localFile.Size()
+	result.LocalSHA256, _, err = hashPrefix(localFile, limit)
+	if err != nil {
+		result.Status = mirrorMissingLocal
+		result.Error = err.Error()
+		return result
+	}
+	body, size, err := auditor.openUpstream(ctx, entry, limit)
+	if err != nil {
+		result.Status = mirrorUpstreamError
+		result.Error = err.Error()
+		result.ErrorClass = errorClass(err)
+		return result
+	}
+	defer body.Close()
+	var read int64
+	result.UpstreamSHA256, read, err = hashPrefix(body, limit)
+	if err != nil {
+		result.Status = mirrorUpstreamError
+		result.Error = err.Error()
+		result.ErrorClass = errorClass(err)
+		return result
+	}
+	result.UpstreamSize = size
+	if auditor.mode == mirrorAuditFull {
+		result.UpstreamSize = read
+	}
+	result.Status = mirrorMatch
+	// Sizes are only compared when upstream announced one.
+	if result.LocalSHA256 != result.UpstreamSHA256 || (result.UpstreamSize >= 0 && result.UpstreamSize != result.LocalSize) {
+		result.Status = mirrorMismatch
+	}
+	return result
+}
+
+// openUpstream opens the upstream content of a document and returns its total size, -1 when unknown.
+// Ranged audits ask for the first limit bytes only; servers that ignore Range are read up to limit.
+func (auditor *mirrorAuditor) openUpstream(ctx context.Context, entry catalogEntry, limit int64) (io.ReadCloser, int64, error) {
+	if auditor.mode == mirrorAuditFull {
+		// Imported documents carry SABIC URLs.
+		sourceName := entry.Source
+		if sourceName == "" || sourceName == "import" {
+			sourceName = "sabic"
+		}
+		source, ok := auditor.sources[sourceName]
+		if !ok {
+			return nil, 0, fmt.Errorf("source %s is not configured", sourceName)
+		}
+		content, err := source.Fetch(ctx, documentRef{source: sourceName, url: entry.SourceURL, filename: entry.Filename, properties: entry.Properties})
+		if err != nil {
+			return nil, 0, err
+		}
+		return content.Body, content.Length, nil
+	}
+	if !strings.HasPrefix(entry.SourceURL, "http://") && !strings.HasPrefix(entry.SourceURL, "https://") {
+		return nil, 0, fmt.Errorf("ranged audits need an HTTP source URL, got %q", entry.SourceURL)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, entry.SourceURL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	request.Header.Set("Range", "bytes=0-"+strconv.FormatInt(limit-1, 10))
+	resp, err := openDownload(ctx, auditor.cfg, auditor.client, request)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", ErrNetwork, err)
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		// Content-Range: bytes 0-65535/123456
+		size := int64(-1)
+		if _, total, found := strings.Cut(resp.Header.Get("Content-Range"), "/"); found {
+			if parsed, err := strconv.ParseInt(total, 10, 64); err == nil {
+				size = parsed
+			}
+		}
+		return resp.Body, size, nil
+	case http.StatusOK:
+		return resp.Body, resp.ContentLength, nil
+	default:
+		resp.Body.Close()
+		return nil, 0, responseError(resp, entry.SourceURL)
+	}
+}
+
+// writeSignedReport writes the report as JSON with a detached base64 ed25519 signature beside it.
+func writeSignedReport(report *mirrorAuditReport, key ed25519.PrivateKey, dir string) (string, error) {
+	report.PublicKey = base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+	encoded, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	reportPath := filepath.Join(dir, "mirror-audit-"+report.StartedAt.UTC().Format("20060102T150405Z")+".json")
+	if err := writeFileAtomically(reportPath, encoded, 0o644); err != nil {
+		return "", err
+	}
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, encoded)) + "\n"
+	return reportPath, writeFileAtomically(reportPath+".sig", []byte(signature), 0o644)
+}
+
+// runMirrorAudit implements the audit command, certifying that stored documents match upstream.
+func runMirrorAudit(args []string) error {
+	var mode string
+	var sample int
+	var seed uint64
+	cfg, _, err := loadConfig("audit", args, func(flagSet *flag.FlagSet) {
+		flagSet.StringVar(&mode, "mode", mirrorAuditRanged, "full re-downloads every audited document, ranged compares sizes and the first 64 KiB")
+		flagSet.IntVar(&sample, "sample", 0, "audit this many randomly chosen documents, 0 audits all")
+		flagSet.Uint64Var(&seed, "seed", 0, "seed of the random sample, random when 0")
+	})
+	if err != nil {
+		return err
+	}
+	if mode != mirrorAuditFull && mode != mirrorAuditRanged {
+		return fmt.Errorf("unknown audit mode %q, expected %s or %s", mode, mirrorAuditFull, mirrorAuditRanged)
+	}
+	key, err := loadSigningKey(cfg.AuditSigningKey)
+	if err != nil {
+		return fmt.Errorf("audit signing key: %v", err)
+	}
+	audit, err := openAuditLog(cfg.AuditLog)
+	if err != nil {
+		return err
+	}
+	docs, err := openCatalog(cfg.CatalogFile)
+	if err != nil {
+		return err
+	}
+	store, err := openDocumentStore(cfg)
+	if err != nil {
+		return err
+	}
+	auditor := &mirrorAuditor{cfg: cfg, store: store, mode: mode, client: newDownloadClient(cfg), sources: make(map[string]Source)}
+	if mode == mirrorAuditFull {
+		materials, err := loadMaterialMap(cfg.MaterialMap)
+		if err != nil {
+			return err
+		}
+		sources, err := openSources(cfg, materials)
+		if err != nil {
+			return err
+		}
+		defer closeSources(sources)
+		for _, source := range sources {
+			auditor.sources[source.Name()] = source
+		}
+	}
+	// Only complete entries have something to compare against.
+	var entries []catalogEntry
+	for _, entry := range docs.all() {
+		if entry.SHA256 != "" && entry.SourceURL != "" {
+			entries = append(entries, entry)
+		}
+	}
+	report := &mirrorAuditReport{StartedAt: time.Now().UTC(), Actor: currentActor(), Mode: mode, Cataloged: len(entries), Counts: make(map[string]int)}
+	if sample > 0 && sample < len(entries) {
+		if seed == 0 {
+			seed = mathrand.Uint64()
+		}
+		random := mathrand.New(mathrand.NewPCG(seed, seed))
+		random.Shuffle(len(entries), func(i, j int) { entries[i], entries[j] = entries[j], entries[i] })
+		entries = entries[:sample]
+		report.Seed = seed
+	}
+	ctx := context.Background()
+	for _, entry := range entries {
+		result := auditor.auditDocument(ctx, entry)
+		report.Results = append(report.Results, result)
+		report.Counts[result.Status] = report.Counts[result.Status] + 1
+		if result.Status == mirrorMatch {
+			audit.record(auditVerified, entry.Filename, entry.SourceURL, entry.SHA256)
+		} else {
+			log.Printf("%s: %s %s", entry.Filename, result.Status, result.Error)
+		}
+	}
+	report.Audited = len(report.Results)
+	report.FinishedAt = time.Now().UTC()
+	reportPath, err := writeSignedReport(report, key, cfg.AuditReportDir)
+	if err != nil {
+		return err
+	}
+	log.Printf("audited %d of %d documents: %d match, %d mismatch, %d missing locally, %d upstream errors; report %s",
+		report.Audited, report.Cataloged, report.Counts[mirrorMatch], report.Counts[mirrorMismatch],
+		report.Counts[mirrorMissingLocal], report.Counts[mirrorUpstreamError], reportPath)
+	if report.Counts[mirrorMismatch] > 0 || report.Counts[mirrorMissingLocal] > 0 {
+		return errors.New("the mirror is not faithful to upstream")
+	}
+	return nil
+}
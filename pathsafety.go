@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// unsafeNameCharacter reports whether r must not appear in a file name: path separators, drive and
+// stream colons and control characters. A header field such as Matnr='../../etc' would otherwise
+// reach outside the output directory.
+func unsafeNameCharacter(r rune) bool {
+	return r == '/' || r == '\\' || r == ':' || r < 0x20 || r == 0x7f
+}
+
+// safeNamePart makes a key value fit for a file name by replacing unsafe characters with dashes.
+func safeNamePart(value string) string {
+	return strings.Map(func(r rune) rune {
+		if unsafeNameCharacter(r) {
+			return '-'
+		}
+		return r
+	}, value)
+}
+
+// isSafeName reports whether name is a single, plain path element: not empty, not . or ..,
+// not absolute and without separators or other unsafe characters.
+func isSafeName(name string) bool {
+	if name == "" || name == "." || name == ".." || strings.IndexFunc(name, unsafeNameCharacter) >= 0 {
+		return false
+	}
+	return filepath.IsLocal(name)
+}
+
+// joinWithin returns the path of name directly inside dir. It fails when name is not a plain file name
+// or when the entry there is a symbolic link, which could point the write or read outside dir.
+func joinWithin(dir string, name string) (string, error) {
+	if !isSafeName(name) {
+		return "", fmt.Errorf("%w: refusing unsafe file name %q", ErrStorage, name)
+	}
+	path := filepath.Join(dir, name)
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return "", fmt.Errorf("%w: refusing to follow the symbolic link %s", ErrStorage, path)
+	}
+	return path, nil
+}
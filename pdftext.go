@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// maxPDFTextBytes bounds the text extracted from one document; the facts we look for are on the first pages.
+const maxPDFTextBytes = 256 << 10
+
+// pdfStreamPattern finds the content streams of a PDF file.
+var pdfStreamPattern = regexp.MustCompile(`(?s)stream\r?\n(.*?)\r?\nendstream`)
+
+// pdfStringPattern finds the literal strings shown by text operators inside a content stream.
+var pdfStringPattern = regexp.MustCompile(`\(((?:\\.|[^\\)])*)\)`)
+
+// extractPDFText returns the literal text strings of a PDF, in file order, separated by spaces.
+// It is deliberately crude: it inflates Flate streams and collects (...) strings, which covers the
+// SDS exports we see without a PDF library. Text in hex strings or with custom encodings is missed.
+func extractPDFText(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var text strings.Builder
+	for _, match := range pdfStreamPattern.FindAllSubmatch(content, -1) {
+		stream := match[1]
+		// Most content streams are compressed; uncompressed ones are used as they are.
+		if inflated, err := io.ReadAll(io.LimitReader(zlibReader(stream), maxPDFTextBytes)); err == nil && len(inflated) > 0 {
+			stream = inflated
+		}
+		for _, literal := range pdfStringPattern.FindAllSubmatch(stream, -1) {
+			text.WriteString(unescapePDFString(literal[1]))
+			text.WriteByte(' ')
+			if text.Len() >= maxPDFTextBytes {
+				return text.String(), nil
+			}
+		}
+	}
+	return text.String(), nil
+}
+
+// zlibReader opens a Flate stream, returning an empty reader when the data isn't one.
+func zlibReader(data []byte) io.Reader {
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return bytes.NewReader(nil)
+	}
+	return reader
+}
+
+// unescapePDFString resolves the backslash escapes of a PDF literal string.
+func unescapePDFString(literal []byte) string {
+	var text strings.Builder
+	for i := 0; i < len(literal); i++ {
+		if literal[i] != '\\' || i+1 == len(literal) {
+			text.WriteByte(literal[i])
+			continue
+		}
+		i++
+		switch literal[i] {
+		case 'n', 'r', 't':
+			text.WriteByte(' ')
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			// Up to three octal digits.
+			value := 0
+			for digits := 0; digits < 3 && i < len(literal) && literal[i] >= '0' && literal[i] <= '7'; digits++ {
+				value = value*8 + int(literal[i]-'0')
+				i++
+			}
+			i--
+			text.WriteByte(byte(value))
+		default:
+			text.WriteByte(literal[i])
+		}
+	}
+	return text.String()
+}
+
+// pdfPagePattern finds page objects, leaving out the /Pages nodes of the page tree.
+var pdfPagePattern = regexp.MustCompile(`/Type\s*/Page(?:[^s]|$)`)
+
+// countPDFPages returns the number of page objects of a PDF. Like extractPDFText it is crude:
+// pages are counted in the file and in its Flate streams, which covers compressed object streams.
+func countPDFPages(path string) (int, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pages := len(pdfPagePattern.FindAll(content, -1))
+	for _, match := range pdfStreamPattern.FindAllSubmatch(content, -1) {
+		if inflated, err := io.ReadAll(zlibReader(match[1])); err == nil {
+			pages = pages + len(pdfPagePattern.FindAll(inflated, -1))
+		}
+	}
+	return pages, nil
+}
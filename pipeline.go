@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// documentRef is a document moving through the pipeline: its URL and the header properties it was listed with.
+type documentRef struct {
+	source     string            // Name of the Source that listed it
+	id         DocumentID        // Identity of the document, zero when it isn't keyed like SABIC's
+	format     string            // Alternate representation to fetch, see formats.go; empty for the PDF
+	url        string            // Where the source fetches it from
+	filename   string            // File name to store it under
+	properties map[string]string // Every scalar property of the listing, see headerSchema
+	validators http.Header       // If-None-Match and If-Modified-Since of a revalidation, nil for a plain fetch
+}
+
+// identity returns what tells the document apart from others: its ID, or its URL when it has none.
+func (doc documentRef) identity() string {
+	if doc.id.isZero() {
+		return doc.url
+	}
+	return doc.id.String()
+}
+
+// fetchOutcome is what a download worker hands to the writer.
+type fetchOutcome struct {
+	url       string
+	filename  string
+	published time.Time       // When the document was published upstream, zero when unknown
+	staged    *stagedDocument // Fetched content, nil on failure
+	err       error
+}
+
+// sendDocument sends a document to the next stage unless the run was cancelled.
+func sendDocument(ctx context.Context, out chan<- documentRef, doc documentRef) error {
+	select {
+	case out <- doc:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// downloadStage is one download worker: it waits for the download window and any throttling, fetches each planned
+// document into a temporary file in its staging directory and hands the outcome to the writer.
+func (fetcher *downloader) downloadStage(ctx context.Context, worker int, window *downloadWindow, in <-chan documentRef, out chan<- fetchOutcome) error {
+	stagingDir, err := workerStagingDir(fetcher.cfg, worker)
+	if err != nil {
+		return err
+	}
+	for doc := range in {
+		// A document fetched by another worker already waits for that fetch, without taking a host slot.
+		staged, err := fetcher.inflight.do(ctx, doc, func() (*stagedDocument, error) {
+			// Wait for a slot of the document's host, fewer while it is unhealthy.
+			release, err := upstreamHealth.acquire(ctx, doc.url)
+			if err != nil {
+				return nil, err
+			}
+			defer release()
+			start := time.Now()
+			defer fetcher.timings.track(stageDownload, start)
+			return fetcher.fetchWithRetry(ctx, doc, window, stagingDir)
+		})
+		// A cancelled run isn't a failed download.
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		published, _ := documentPublishedAt(fetcher.cfg, doc.properties)
+		outcome := fetchOutcome{url: doc.url, filename: doc.filename, published: published, staged: staged, err: err}
+		select {
+		case out <- outcome:
+		case <-ctx.Done():
+			// Nobody will store it any more.
+			if staged != nil {
+				os.Remove(staged.tempPath)
+			}
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// storedOutcome is what the writer hands to the post-processing workers.
+type storedOutcome struct {
+	result downloadResult
+	stored bool // Whether the document was stored and is to be post-processed
+}
+
+// writeStage stores one fetched document and returns its result.
+func (fetcher *downloader) writeStage(outcome fetchOutcome) storedOutcome {
+	err := outcome.err
+	if err == nil {
+		start := time.Now()
+		err = fetcher.storePDF(outcome.staged)
+		fetcher.timings.track(stageWrite, start)
+	}
+	result := newDownloadResult(outcome.url, outcome.filename, err == nil, err)
+	if err != nil {
+		log.Println(err)
+		// A revision published upstream and missing here may be overdue.
+		if entry, known := fetcher.catalog.get(outcome.filename); !known || !holdsPublication(entry, outcome.published) {
+			publicationSLO.failed(outcome.filename, outcome.url, outcome.published)
+		}
+		return storedOutcome{result: result}
+	}
+	result.Size, result.SHA256 = outcome.staged.size, outcome.staged.sha256
+	return storedOutcome{result: result, stored: true}
+}
+
+// postprocessStage is one post-processing worker: it runs the optional steps on each stored
+// document, such as text extraction, previews and PDF/A copies, and records the results.
+func (fetcher *downloader) postprocessStage(ctx context.Context, manifest *runManifest, progress *runProgress, in <-chan storedOutcome) {
+	for outcome := range in {
+		result := outcome.result
+		if outcome.stored {
+			start := time.Now()
+			postProcess(ctx, fetcher.cfg, fetcher.store, fetcher.catalog, result.Filename)
+			fetcher.timings.track(stagePostprocess, start)
+			if entry, ok := fetcher.catalog.get(result.Filename); ok && entry.LanguageMismatch {
+				result.LanguageMismatch = entry.DetectedLanguage
+			}
+		}
+		manifest.record(result)
+		progress.advance()
+	}
+}
+
+// runPipeline streams the documents of every source through the scraper, planner, download
+// workers, the optional hash pool, writer and post-processing workers. The stages are joined by bounded channels, so a slow
+// stage holds back the ones before it and memory stays flat however large the catalog is. Downloads
+// and post-processing each run on their own number of workers, as one is bound by the network and
+// the other by the CPU.
+func runPipeline(ctx context.Context, cfg *Config, fetcher *downloader, sources []Source, window *downloadWindow, manifest *runManifest, progress *runProgress) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	queueSize := max(cfg.QueueSize, 1)
+	workers := map[string]int{stageDownload: max(cfg.Workers, 1), stageHash: cfg.HashWorkers, stagePostprocess: max(cfg.PostprocessWorkers, 1)}
+	scraped := make(chan documentRef, queueSize)
+	planned := make(chan documentRef, queueSize)
+	fetched := make(chan fetchOutcome, queueSize)
+	stored := make(chan storedOutcome, queueSize)
+	// Time the stages and watch the queues between them to find the bottleneck.
+	timings := newPipelineTimings()
+	timings.watchQueue(stagePlan, func() int { return len(scraped) }, queueSize)
+	timings.watchQueue(stageDownload, func() int { return len(planned) }, queueSize)
+	// With the hash pool on, downloads queue for a hash worker, then for the writer in completion order.
+	var hashing *hashPool
+	writeQueue := func() int { return len(fetched) }
+	if cfg.HashWorkers > 0 {
+		hashing = newHashPool(queueSize)
+		fetcher.hashLater = true
+		timings.watchQueue(stageHash, func() int { return len(hashing.jobs) }, queueSize)
+		writeQueue = func() int { return len(hashing.ordered) }
+	}
+	timings.watchQueue(stageWrite, writeQueue, queueSize)
+	timings.watchQueue(stagePostprocess, func() int { return len(stored) }, queueSize)
+	go timings.sample(100 * time.Millisecond)
+	fetcher.timings = timings
+	defer func() {
+		timings.stop()
+		log.Println(timings.summary(workers))
+	}()
+	// The first failing stage stops the others.
+	var firstErr error
+	var failOnce sync.Once
+	fail := func(err error) {
+		if err != nil {
+			failOnce.Do(func() {
+				firstErr = err
+				cancel()
+			})
+		}
+	}
+	var stages sync.WaitGroup
+	stages.Add(2)
+	go func() {
+		defer stages.Done()
+		defer close(scraped)
+		// List the sources one after the other into the same pipeline.
+		for _, source := range sources {
+			err := source.List(ctx, scraped, func(total int) {
+				// Size the progress reports and the catalog before the first download.
+				stored := 0
+				if fetcher.existing != nil {
+					stored = fetcher.existing.size()
+				}
+				progress.setTotal(total, stored)
+				fetcher.catalog.reserve(total)
+			})
+			if err != nil {
+				fail(fmt.Errorf("source %s: %w", source.Name(), err))
+				return
+			}
+		}
+	}()
+	go func() {
+		defer stages.Done()
+		defer close(planned)
+		fail(planDocuments(ctx, cfg, fetcher.materials, scraped, planned, func(doc documentRef, reason string) {
+			manifest.record(newSkippedResult(doc, reason))
+		}))
+	}()
+	var downloaders sync.WaitGroup
+	for worker := range workers[stageDownload] {
+		downloaders.Add(1)
+		go func() {
+			defer downloaders.Done()
+			fail(fetcher.downloadStage(ctx, worker, window, planned, fetched))
+		}()
+	}
+	go func() {
+		downloaders.Wait()
+		close(fetched)
+	}()
+	var postprocessors sync.WaitGroup
+	for range workers[stagePostprocess] {
+		postprocessors.Add(1)
+		go func() {
+			defer postprocessors.Done()
+			fetcher.postprocessStage(ctx, manifest, progress, stored)
+		}()
+	}
+	writes := (<-chan fetchOutcome)(fetched)
+	if hashing != nil {
+		writes = hashing.run(fetcher, fetched, cfg.HashWorkers)
+	}
+	// The writer runs here, one document at a time, as the store and the catalog take one writer.
+	for outcome := range writes {
+		stored <- fetcher.writeStage(outcome)
+	}
+	close(stored)
+	postprocessors.Wait()
+	stages.Wait()
+	return firstErr
+}
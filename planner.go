@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// region returns the region part of the Sbgvid, e.g. FR for SDS_FR.
+func (keys DocumentID) region() string {
+	_, region, _ := strings.Cut(keys.Sbgvid, "_")
+	return strings.ToUpper(region)
+}
+
+// regionLanguages lists the local languages of each region, in SABIC's Laiso codes.
+// Regions not listed use the language with the same code as the region.
+var regionLanguages = map[string][]string{
+	"AT": {"DE"}, "AU": {"EN"}, "BE": {"NL", "FR", "DE"}, "BO": {"ES"}, "BY": {"RU"},
+	"CA": {"EN", "FR"}, "CH": {"DE", "FR", "IT"}, "CL": {"ES"}, "CN": {"ZH", "ZF"}, "CZ": {"CS"},
+	"DK": {"DA"}, "DZ": {"AR", "FR"}, "GB": {"EN"}, "GR": {"EL"}, "HK": {"ZF", "ZH"},
+	"IN": {"HI"}, "JP": {"JA"}, "KR": {"KO"}, "MD": {"RO"}, "MX": {"ES"}, "MY": {"MS"},
+	"SA": {"AR"}, "SE": {"SV"}, "SG": {"EN", "MS", "ZH"}, "SI": {"SL"}, "TW": {"ZF"},
+	"US": {"EN"}, "VN": {"VI"},
+}
+
+// isLocalLanguage reports whether the document is in a local language of its region.
+func (keys DocumentID) isLocalLanguage() bool {
+	region := keys.region()
+	languages, ok := regionLanguages[region]
+	if !ok {
+		languages = []string{region}
+	}
+	for _, language := range languages {
+		if strings.EqualFold(keys.Laiso, language) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLanguageChain parses a fallback chain such as "EN > FR > local".
+// The special entries "local" (the region's own language) and "*" (anything) are allowed.
+func parseLanguageChain(spec string) ([]string, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	var chain []string
+	for _, part := range strings.Split(spec, ">") {
+		language := strings.ToUpper(strings.TrimSpace(part))
+		if language == "" {
+			return nil, fmt.Errorf("invalid language chain %q: empty entry", spec)
+		}
+		chain = append(chain, language)
+	}
+	return chain, nil
+}
+
+// languageRank returns the position of the document in the chain, or -1 when no entry accepts it.
+func languageRank(chain []string, keys DocumentID) int {
+	for rank, language := range chain {
+		switch {
+		case language == "*":
+			return rank
+		case language == "LOCAL" && keys.isLocalLanguage():
+			return rank
+		case strings.EqualFold(keys.Laiso, language):
+			return rank
+		}
+	}
+	return -1
+}
+
+// languageSelector picks one document per material, the one ranked best by a fallback chain.
+// It only keeps the current best document of each material, so documents can be offered one at a time.
+type languageSelector struct {
+	chain     []string
+	best      map[string]documentRef // Material to its best document so far
+	bestRank  map[string]int         // Material to the rank of that document
+	materials []string               // Accepted materials in input order
+	seen      map[string]bool        // Every material offered, accepted or not
+	reject    func(doc documentRef)  // Told about every document that won't be selected, nil when nobody asks
+}
+
+// newLanguageSelector returns a selector for the chain.
+func newLanguageSelector(chain []string, reject func(doc documentRef)) *languageSelector {
+	if reject == nil {
+		reject = func(documentRef) {}
+	}
+	return &languageSelector{chain: chain, best: make(map[string]documentRef), bestRank: make(map[string]int), seen: make(map[string]bool), reject: reject}
+}
+
+// offer considers one document.
+func (selector *languageSelector) offer(doc documentRef) {
+	keys := doc.id
+	if keys.isZero() {
+		return
+	}
+	selector.seen[keys.Matnr] = true
+	rank := languageRank(selector.chain, keys)
+	if rank < 0 {
+		selector.reject(doc)
+		return
+	}
+	current, seen := selector.bestRank[keys.Matnr]
+	if !seen {
+		selector.materials = append(selector.materials, keys.Matnr)
+	}
+	// Earlier documents win ties so the choice is stable between runs.
+	if !seen || rank < current {
+		if seen {
+			selector.reject(selector.best[keys.Matnr])
+		}
+		selector.best[keys.Matnr] = doc
+		selector.bestRank[keys.Matnr] = rank
+		return
+	}
+	selector.reject(doc)
+}
+
+// selected returns the chosen document of every material, in input order.
+// Materials without any acceptable language are dropped.
+func (selector *languageSelector) selected() []documentRef {
+	planned := make([]documentRef, 0, len(selector.materials))
+	for _, material := range selector.materials {
+		planned = append(planned, selector.best[material])
+	}
+	if unmatched := len(selector.seen) - len(selector.materials); unmatched > 0 {
+		log.Printf("%d materials have no document in the language chain", unmatched)
+	}
+	return planned
+}
+
+// planDocuments is the planner stage: it dedupes the scraped URLs, applies the material map
+// and the language chain, optionally samples the result, and sends on the documents this run should fetch.
+// Without a language chain documents pass straight through; with one, the best document of each
+// material is only known once the input is exhausted, so they are sent at the end.
+// skip, when not nil, is told about every document left out and why.
+func planDocuments(ctx context.Context, cfg *Config, materials materialMap, in <-chan documentRef, out chan<- documentRef, skip func(doc documentRef, reason string)) error {
+	if skip == nil {
+		skip = func(documentRef, string) {}
+	}
+	chain, err := parseLanguageChain(cfg.LanguageFallback)
+	if err != nil {
+		return err
+	}
+	var selector *languageSelector
+	if len(chain) > 0 {
+		selector = newLanguageSelector(chain, func(doc documentRef) { skip(doc, skipLanguage) })
+	}
+	sampler, err := newDownloadSampler(cfg)
+	if err != nil {
+		return err
+	}
+	// Planned documents are followed by their alternate formats, so a sample keeps them together.
+	emit := func(doc documentRef) error {
+		if err := sendDocument(ctx, out, doc); err != nil {
+			return err
+		}
+		for _, alternate := range alternateDocuments(cfg, doc) {
+			if err := sendDocument(ctx, out, alternate); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// Planned documents go through the sampler when QA sampling is on.
+	send := func(doc documentRef) error {
+		if sampler != nil {
+			return sampler.offer(doc, emit)
+		}
+		return emit(doc)
+	}
+	seen := make(map[string]bool) // Identities already planned
+	for doc := range in {
+		// Sources other than SABIC's may still list documents by SABIC URLs.
+		if doc.id.isZero() {
+			doc.id = documentIDOrEmpty(doc.url)
+		}
+		// A listing without usable keys has nowhere to be stored.
+		if doc.filename == "" {
+			skip(doc, skipUnnamed)
+			continue
+		}
+		// Remove duplicates, including the same document listed under another URL.
+		identity := doc.identity()
+		if seen[identity] {
+			continue
+		}
+		seen[identity] = true
+		// Material and language rules only apply to documents keyed like SABIC's.
+		if doc.id.isZero() {
+			if err := send(doc); err != nil {
+				return err
+			}
+			continue
+		}
+		// Only fetch the materials our ERP knows about when a mapping is configured.
+		if !materials.accepts(doc.id) {
+			skip(doc, skipPolicy)
+			continue
+		}
+		// Only fetch the report types asked for.
+		if !matchesReportType(cfg.ReportTypes, doc.id.reportType()) {
+			skip(doc, skipPolicy)
+			continue
+		}
+		// Only fetch the regulatory areas asked for.
+		if !matchesJurisdiction(cfg, cfg.Jurisdiction, doc.id.Sbgvid) {
+			skip(doc, skipPolicy)
+			continue
+		}
+		// Only fetch the product families asked for.
+		if !matchesProductFamily(cfg.ProductFamily, productFamily(cfg, doc.properties)) {
+			skip(doc, skipPolicy)
+			continue
+		}
+		if selector != nil {
+			selector.offer(doc)
+			continue
+		}
+		if err := send(doc); err != nil {
+			return err
+		}
+	}
+	if selector != nil {
+		for _, doc := range selector.selected() {
+			if err := send(doc); err != nil {
+				return err
+			}
+		}
+	}
+	if sampler != nil {
+		return sampler.flush(emit)
+	}
+	return nil
+}
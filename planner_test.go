@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestKeyLiteralRoundTrip checks that key values survive documentURL and parsing back, whatever they hold.
+func TestKeyLiteralRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"plain", "22006037"},
+		{"quote", "O'Brien"},
+		{"doubled quotes", "''"},
+		{"quotes only", "'"},
+		{"slash", "SDS/FR"},
+		{"backslash", `a\b`},
+		{"percent", "100%"},
+		{"percent-encoded quote", "%27"},
+		{"percent sequence", "%2F%41"},
+		{"comma and parentheses", "a,b)(c"},
+		{"equals", "Matnr='1'"},
+		{"spaces", " padded value "},
+		{"non-ASCII", "Ölsäure 試験"},
+		{"question mark and hash", "a?b#c"},
+	}
+	cfg := defaultConfig()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			want := DocumentID{Matnr: "22006037", Subid: test.value, Sbgvid: "SDS_FR", Laiso: "FR"}
+			sdsURL := documentURL(cfg, headerResult{MaterialNumber: want.Matnr, SubID: want.Subid, StorageLocation: want.Sbgvid, LanguageISO: want.Laiso})
+			got, err := documentIDFromURL(sdsURL)
+			if err != nil {
+				t.Fatalf("%s: %v", sdsURL, err)
+			}
+			if got != want {
+				t.Errorf("%s: got %#v, want %#v", sdsURL, got, want)
+			}
+			if unquoted := parseKeyLiteral(strings.Trim(keyLiteral(test.value), "'")); unquoted != test.value {
+				t.Errorf("keyLiteral(%q) reads back as %q", test.value, unquoted)
+			}
+		})
+	}
+}
+
+// TestParseURLKeys checks key predicates written by hand or rewritten on the way, not by documentURL.
+func TestParseURLKeys(t *testing.T) {
+	want := DocumentID{Matnr: "1", Subid: "2", Sbgvid: "SDS_FR", Laiso: "FR"}
+	tests := []struct {
+		url     string
+		want    DocumentID
+		wantErr bool
+	}{
+		{url: "https://host/s//E(Matnr='1',Subid='2',Sbgvid='SDS_FR',Laiso='FR',Vkorg='')/$value", want: want},
+		{url: "https://host/s//E(Laiso='FR',Sbgvid='SDS_FR',Matnr='1',Subid='2')/$value", want: want},
+		{url: "https://host/s//E(Matnr=%271%27,Subid=%272%27,Sbgvid=%27SDS_FR%27,Laiso=%27FR%27)/$value", want: want},
+		{url: "https://host/s//E(Matnr='1',Subid='O''Brien',Sbgvid='SDS_FR',Laiso='FR')", want: DocumentID{Matnr: "1", Subid: "O'Brien", Sbgvid: "SDS_FR", Laiso: "FR"}},
+		{url: "https://host/s//E(Matnr='1',Subid='2',Sbgvid='SDS_FR')", wantErr: true},
+		{url: "https://host/s//E(Matnr='1',Subid='2',Sbgvid='SDS_FR',Laiso='FR", wantErr: true},
+		{url: "https://host/s//E(Matnr='',Subid='2',Sbgvid='SDS_FR',Laiso='FR')", wantErr: true},
+		{url: "https://host/s//E", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := documentIDFromURL(test.url)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want one: %v", test.url, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %#v, want %#v", test.url, got, test.want)
+		}
+	}
+}
+
+// FuzzParseURLKeys checks that any URL parses without panicking, and that the keys of one that parses
+// come back unchanged from the URL documentURL builds of them.
+func FuzzParseURLKeys(f *testing.F) {
+	f.Add("https://host/s//E(Matnr='1',Subid='2',Sbgvid='SDS_FR',Laiso='FR',Vkorg='')/$value")
+	f.Add("https://host/s//E(Laiso='FR',Sbgvid='SDS_FR',Matnr='1',Subid='2')")
+	f.Add("https://host/s//E(Matnr=%271%27,Subid='O''Brien',Sbgvid='SDS%2FFR',Laiso='FR')")
+	f.Add("(A=1,B='',C='%',D=x)")
+	f.Add("(Matnr='1'")
+	cfg := defaultConfig()
+	f.Fuzz(func(t *testing.T, sdsURL string) {
+		id, err := documentIDFromURL(sdsURL)
+		if err != nil {
+			return
+		}
+		rebuilt := documentURL(cfg, headerResult{MaterialNumber: id.Matnr, SubID: id.Subid, StorageLocation: id.Sbgvid, LanguageISO: id.Laiso})
+		again, err := documentIDFromURL(rebuilt)
+		if err != nil {
+			t.Fatalf("%s parsed as %#v, but %s doesn't parse: %v", sdsURL, id, rebuilt, err)
+		}
+		// documentURL always writes an empty sales organization.
+		id.Vkorg = ""
+		if again != id {
+			t.Fatalf("%s parsed as %#v, but %s as %#v", sdsURL, id, rebuilt, again)
+		}
+	})
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// pluginProtocol is the version a plugin announces in its first line.
+//
+// Plugins are executables speaking JSON lines on stdin and stdout:
+//
+//	plugin → {"protocol":"sabic-source/1"}
+//	us     → {"id":1,"method":"list"}
+//	plugin → {"id":1,"count":120}                                   (optional)
+//	plugin → {"id":1,"document":{"url":"…","filename":"…","properties":{…}}}  (repeated)
+//	plugin → {"id":1,"done":true}
+//	us     → {"id":2,"method":"fetch","document":{…}}
+//	plugin → {"id":2,"path":"/tmp/x.pdf","content_type":"application/pdf"}    (a file we take over)
+//	      or {"id":2,"url":"https://…","headers":{"Cookie":"…"}}              (a URL we GET)
+//	      or {"id":2,"error":"…","error_class":"not_found"}
+//
+// Requests are answered by id, so a plugin may interleave them. Anything written to stderr is logged.
+const pluginProtocol = "sabic-source/1"
+
+// pluginDocument is a document as plugins list and receive it.
+type pluginDocument struct {
+	URL        string            `json:"url"`
+	Filename   string            `json:"filename"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+// pluginMessage is one line of the plugin protocol, in either direction.
+type pluginMessage struct {
+	Protocol    string            `json:"protocol,omitempty"`     // Handshake only
+	ID          int64             `json:"id,omitempty"`           // Request the line belongs to
+	Method      string            `json:"method,omitempty"`       // list or fetch, in requests
+	Document    *pluginDocument   `json:"document,omitempty"`     // Listed or requested document
+	Count       *int              `json:"count,omitempty"`        // Number of documents a list will send
+	Done        bool              `json:"done,omitempty"`         // Ends a list
+	Error       string            `json:"error,omitempty"`        // Ends a request with a failure
+	ErrorClass  string            `json:"error_class,omitempty"`  // Label of the failure, see errorClass
+	Path        string            `json:"path,omitempty"`         // File holding fetched content
+	URL         string            `json:"url,omitempty"`          // URL to GET the content from
+	Headers     map[string]string `json:"headers,omitempty"`      // Headers for that GET
+	ContentType string            `json:"content_type,omitempty"` // Media type of a fetched file
+}
+
+// pluginSource is a Source served by an external process.
+type pluginSource struct {
+	name    string
+	cfg     *Config
+	command *exec.Cmd
+	client  *http.Client // For fetches answered with a URL
+	// Writing requests.
+	writeMutex sync.Mutex
+	encoder    *json.Encoder
+	stdin      io.WriteCloser
+	// Routing replies.
+	mutex   sync.Mutex
+	nextID  int64
+	pending map[int64]*pluginRequest
+	failure error // Set once the plugin's output ended
+}
+
+// pluginRequest is a request waiting for replies.
+type pluginRequest struct {
+	replies chan pluginMessage
+	done    chan struct{} // Closed when the caller stops listening
+}
+
+// unsafeFilenameCharacters matches what a plugin may not put in a stored file name.
+var unsafeFilenameCharacters = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// newPluginSource starts a plugin and waits for its handshake.
+func newPluginSource(cfg *Config, name string, commandLine []string) (*pluginSource, error) {
+	if len(commandLine) == 0 {
+		return nil, fmt.Errorf("plugin %s has no command", name)
+	}
+	command := exec.Command(commandLine[0], commandLine[1:]...)
+	stdin, err := command.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := command.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := command.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := command.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start plugin %s: %v", name, err)
+	}
+	plugin := &pluginSource{
+		name:    name,
+		command: command,
+		cfg:     cfg,
+		client:  newDownloadClient(cfg),
+		encoder: json.NewEncoder(stdin),
+		stdin:   stdin,
+		pending: make(map[int64]*pluginRequest),
+	}
+	// Pass the plugin's diagnostics on to our log.
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			log.Printf("plugin %s: %s", name, scanner.Text())
+		}
+	}()
+	// The first line must be the handshake.
+	reader := bufio.NewReader(stdout)
+	line, err := reader.ReadBytes('\n')
+	var hello pluginMessage
+	if err == nil {
+		err = json.Unmarshal(line, &hello)
+	}
+	if err != nil || hello.Protocol != pluginProtocol {
+		plugin.Close()
+		return nil, fmt.Errorf("plugin %s did not announce protocol %s", name, pluginProtocol)
+	}
+	go plugin.readReplies(reader)
+	return plugin, nil
+}
+
+// readReplies routes every reply line to the request waiting for it.
+func (plugin *pluginSource) readReplies(reader *bufio.Reader) {
+	decoder := json.NewDecoder(reader)
+	for {
+		var message pluginMessage
+		if err := decoder.Decode(&message); err != nil {
+			plugin.mutex.Lock()
+			plugin.failure = fmt.Errorf("plugin %s stopped answering: %v", plugin.name, err)
+			for id, waiting := range plugin.pending {
+				close(waiting.replies)
+				delete(plugin.pending, id)
+			}
+			plugin.mutex.Unlock()
+			return
+		}
+		plugin.mutex.Lock()
+		waiting, ok := plugin.pending[message.ID]
+		plugin.mutex.Unlock()
+		if !ok {
+			log.Printf("plugin %s: reply for unknown request %d", plugin.name, message.ID)
+			continue
+		}
+		// Drop replies to a request the caller gave up on.
+		select {
+		case waiting.replies <- message:
+		case <-waiting.done:
+		}
+	}
+}
+
+// request sends a request and returns the channel its replies arrive on.
+func (plugin *pluginSource) request(message pluginMessage) (int64, chan pluginMessage, error) {
+	plugin.mutex.Lock()
+	if plugin.failure != nil {
+		plugin.mutex.Unlock()
+		return 0, nil, plugin.failure
+	}
+	plugin.nextID = plugin.nextID + 1
+	message.ID = plugin.nextID
+	// Buffered so a slow consumer doesn't stall replies to other requests for long.
+	waiting := &pluginRequest{replies: make(chan pluginMessage, 16), done: make(chan struct{})}
+	plugin.pending[message.ID] = waiting
+	plugin.mutex.Unlock()
+	plugin.writeMutex.Lock()
+	defer plugin.writeMutex.Unlock()
+	if err := plugin.encoder.Encode(message); err != nil {
+		plugin.finish(message.ID)
+		return 0, nil, fmt.Errorf("failed to send request to plugin %s: %v", plugin.name, err)
+	}
+	return message.ID, waiting.replies, nil
+}
+
+// finish forgets a request.
+func (plugin *pluginSource) finish(id int64) {
+	plugin.mutex.Lock()
+	defer plugin.mutex.Unlock()
+	if waiting, ok := plugin.pending[id]; ok {
+		close(waiting.done)
+		delete(plugin.pending, id)
+	}
+}
+
+// failed returns why the plugin stopped answering.
+func (plugin *pluginSource) failed() error {
+	plugin.mutex.Lock()
+	defer plugin.mutex.Unlock()
+	return plugin.failure
+}
+
+// replyError turns a failure reply into an error, typed by its class when the plugin gave one.
+func (plugin *pluginSource) replyError(message pluginMessage) error {
+	if typed := errorForClass(message.ErrorClass); typed != nil {
+		return fmt.Errorf("%w: plugin %s: %s", typed, plugin.name, message.Error)
+	}
+	return fmt.Errorf("plugin %s: %s", plugin.name, message.Error)
+}
+
+// Name implements Source.
+func (plugin *pluginSource) Name() string {
+	return plugin.name
+}
+
+// List implements Source.
+func (plugin *pluginSource) List(ctx context.Context, out chan<- documentRef, onCount func(total int)) error {
+	id, replies, err := plugin.request(pluginMessage{Method: "list"})
+	if err != nil {
+		return err
+	}
+	defer plugin.finish(id)
+	for {
+		var message pluginMessage
+		var ok bool
+		select {
+		case message, ok = <-replies:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		switch {
+		case !ok:
+			return plugin.failed()
+		case message.Error != "":
+			return plugin.replyError(message)
+		case message.Count != nil:
+			if onCount != nil {
+				onCount(*message.Count)
+			}
+		case message.Document != nil:
+			doc := documentRef{source: plugin.name, url: message.Document.URL, properties: message.Document.Properties}
+			// Plugins don't get to pick paths, only names.
+			doc.filename = unsafeFilenameCharacters.ReplaceAllString(filepath.Base(message.Document.Filename), "_")
+			if doc.filename == "." || doc.filename == ".." {
+				log.Printf("plugin %s: no usable file name for %s, skipping", plugin.name, doc.url)
+				continue
+			}
+			if err := sendDocument(ctx, out, doc); err != nil {
+				return err
+			}
+		case message.Done:
+			return nil
+		}
+	}
+}
+
+// Fetch implements Source.
+func (plugin *pluginSource) Fetch(ctx context.Context, doc documentRef) (*fetchedContent, error) {
+	id, replies, err := plugin.request(pluginMessage{Method: "fetch", Document: &pluginDocument{URL: doc.url, Filename: doc.filename, Properties: doc.properties}})
+	if err != nil {
+		return nil, err
+	}
+	defer plugin.finish(id)
+	var message pluginMessage
+	var ok bool
+	select {
+	case message, ok = <-replies:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("%w: %v", ErrNetwork, plugin.failed())
+	case message.Error != "":
+		return nil, plugin.replyError(message)
+	case message.Path != "":
+		// The file is ours now and goes away once read.
+		file, err := os.Open(message.Path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: plugin %s: %w", ErrStorage, plugin.name, err)
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("%w: plugin %s: %w", ErrStorage, plugin.name, err)
+		}
+		return &fetchedContent{Body: &removeOnClose{File: file}, ContentType: message.ContentType, Length: info.Size()}, nil
+	case message.URL != "":
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, message.URL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("plugin %s: %v", plugin.name, err)
+		}
+		for name, value := range message.Headers {
+			request.Header.Set(name, value)
+		}
+		resp, err := openDownload(ctx, plugin.cfg, plugin.client, request)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to download %s: %w", ErrNetwork, doc.url, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, responseError(resp, doc.url)
+		}
+		return &fetchedContent{Body: resp.Body, ContentType: resp.Header.Get("Content-Type"), Length: resp.ContentLength, Status: resp.Status, Header: resp.Header}, nil
+	default:
+		return nil, fmt.Errorf("plugin %s sent an empty fetch reply", plugin.name)
+	}
+}
+
+// Close stops the plugin process.
+func (plugin *pluginSource) Close() error {
+	// Closing stdin asks the plugin to exit; a stuck one is killed.
+	plugin.stdin.Close()
+	exited := make(chan error, 1)
+	go func() { exited <- plugin.command.Wait() }()
+	select {
+	case err := <-exited:
+		return err
+	case <-time.After(5 * time.Second):
+		plugin.command.Process.Kill()
+		return <-exited
+	}
+}
+
+// removeOnClose deletes a file handed over by a plugin once it has been read.
+type removeOnClose struct {
+	*os.File
+}
+
+// Close closes and removes the file.
+func (file *removeOnClose) Close() error {
+	err := file.File.Close()
+	os.Remove(file.Name())
+	return err
+}
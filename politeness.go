@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultUserAgent names the tool and where to find it, so the supplier knows who is calling.
+const defaultUserAgent = "sabic-com-documentation (+https://github.com/Strong-Foundation/sabic-com-documentation)"
+
+// offPeakWindow is the download window suggested to polite crawls: night in the upstream's Gulf time zone.
+const offPeakWindow = "22:00-06:00"
+
+// crawlProfiles are presets of crawl settings selected with -profile. A preset only changes settings
+// still at their defaults, so anything set in the config file or on the command line wins.
+var crawlProfiles = map[string]func(cfg *Config, defaults *Config){
+	"default": func(cfg *Config, defaults *Config) {},
+	"polite": func(cfg *Config, defaults *Config) {
+		// One download at a time, a pause between requests to the same host, and Retry-After honored.
+		if cfg.Workers == defaults.Workers {
+			cfg.Workers = 1
+		}
+		if cfg.HostDelay == defaults.HostDelay {
+			cfg.HostDelay = Duration{5 * time.Second}
+		}
+		cfg.RespectRetryAfter = true
+		if cfg.ThrottleDelay == defaults.ThrottleDelay {
+			cfg.ThrottleDelay = Duration{2 * cfg.ThrottleDelay.Duration}
+		}
+		if cfg.Window == "" {
+			log.Printf("polite profile: consider scheduling runs off-peak, e.g. -window %s -timezone Asia/Riyadh", offPeakWindow)
+		}
+	},
+}
+
+// applyCrawlProfile applies the preset named by cfg.Profile.
+func applyCrawlProfile(cfg *Config) error {
+	if cfg.Profile == "" {
+		return nil
+	}
+	apply, ok := crawlProfiles[cfg.Profile]
+	if !ok {
+		known := make([]string, 0, len(crawlProfiles))
+		for name := range crawlProfiles {
+			known = append(known, name)
+		}
+		sort.Strings(known)
+		return fmt.Errorf("unknown profile %q, known profiles are %s", cfg.Profile, strings.Join(known, ", "))
+	}
+	apply(cfg, defaultConfig())
+	return nil
+}
+
+// politeTransport sets the User-Agent of every upstream request, rotating through the configured
+// ones, and spaces requests to the same host by the configured delay.
+type politeTransport struct {
+	base   http.RoundTripper
+	agents []string
+	next   atomic.Uint64 // Index of the next user agent
+	delay  time.Duration
+	mutex  sync.Mutex
+	slots  map[string]time.Time // Earliest time of the next request, by host
+}
+
+// newPoliteTransport wraps base with the user agents and host delay of the config.
+func newPoliteTransport(cfg *Config, base http.RoundTripper) *politeTransport {
+	agents := cfg.UserAgents
+	if len(agents) == 0 {
+		agents = []string{defaultUserAgent}
+	}
+	return &politeTransport{base: base, agents: agents, delay: cfg.HostDelay.Duration, slots: make(map[string]time.Time)}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (transport *politeTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if err := transport.waitForHost(request.Context(), request.URL.Host); err != nil {
+		return nil, err
+	}
+	// RoundTrippers must not modify the caller's request.
+	request = request.Clone(request.Context())
+	agent := transport.agents[(transport.next.Add(1)-1)%uint64(len(transport.agents))]
+	request.Header.Set("User-Agent", agent)
+	return transport.base.RoundTrip(request)
+}
+
+// waitForHost takes the next request slot of a host and waits for it.
+func (transport *politeTransport) waitForHost(ctx context.Context, host string) error {
+	if transport.delay <= 0 {
+		return nil
+	}
+	transport.mutex.Lock()
+	slot := time.Now()
+	if next := transport.slots[host]; next.After(slot) {
+		slot = next
+	}
+	transport.slots[host] = slot.Add(transport.delay)
+	transport.mutex.Unlock()
+	wait := time.Until(slot)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
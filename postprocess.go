@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// pageRenderer renders the first page of a PDF to a PNG image.
+type pageRenderer interface {
+	RenderFirstPage(ctx context.Context, pdfPath, pngPath string) error
+}
+
+// pdfaConverter produces a PDF/A-1b copy of a PDF.
+type pdfaConverter interface {
+	ConvertToPDFA(ctx context.Context, inputPath, outputPath string) error
+}
+
+// runTemplateCommand runs an external program whose arguments may use {input}, {output}
+// and {output_base} (the output path without its extension), and checks the output appeared.
+func runTemplateCommand(ctx context.Context, command []string, inputPath, outputPath string) error {
+	replacer := strings.NewReplacer(
+		"{input}", inputPath,
+		"{output}", outputPath,
+		"{output_base}", strings.TrimSuffix(outputPath, filepath.Ext(outputPath)),
+	)
+	args := make([]string, len(command))
+	for index, arg := range command {
+		args[index] = replacer.Replace(arg)
+	}
+	output, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %v: %s", args[0], err, strings.TrimSpace(string(output)))
+	}
+	if !fileExists(outputPath) {
+		return fmt.Errorf("%s did not produce %s", args[0], outputPath)
+	}
+	return nil
+}
+
+// commandRenderer renders pages with an external program such as pdftoppm.
+type commandRenderer struct {
+	command []string
+}
+
+// RenderFirstPage runs the configured command for one document.
+func (renderer commandRenderer) RenderFirstPage(ctx context.Context, pdfPath, pngPath string) error {
+	return runTemplateCommand(ctx, renderer.command, pdfPath, pngPath)
+}
+
+// commandConverter converts documents with an external program such as Ghostscript.
+type commandConverter struct {
+	command []string
+}
+
+// ConvertToPDFA runs the configured command for one document.
+func (converter commandConverter) ConvertToPDFA(ctx context.Context, inputPath, outputPath string) error {
+	return runTemplateCommand(ctx, converter.command, inputPath, outputPath)
+}
+
+// newPageRenderer returns the configured renderer, or nil when previews are disabled.
+func newPageRenderer(cfg *Config) pageRenderer {
+	if !cfg.Previews || len(cfg.PreviewCommand) == 0 {
+		return nil
+	}
+	return commandRenderer{command: cfg.PreviewCommand}
+}
+
+// previewPath returns where the preview of a stored PDF lives, right next to it.
+func previewPath(pdfPath string) string {
+	return strings.TrimSuffix(pdfPath, filepath.Ext(pdfPath)) + ".png"
+}
+
+// newPDFAConverter returns the configured converter, or nil when conversion is disabled.
+func newPDFAConverter(cfg *Config) pdfaConverter {
+	if !cfg.PDFA || len(cfg.PDFACommand) == 0 {
+		return nil
+	}
+	return commandConverter{command: cfg.PDFACommand}
+}
+
+// convertToPDFA writes the PDF/A copy of a stored document and records the outcome in the catalog.
+func convertToPDFA(ctx context.Context, cfg *Config, converter pdfaConverter, store documentStore, docs *catalog, filename string) error {
+	if !directoryExists(cfg.PDFADir) {
+		createDirectory(cfg.PDFADir, 0o755)
+	}
+	inputPath, release, err := plaintextPath(store, filename)
+	if err != nil {
+		return err
+	}
+	defer release()
+	outputPath, err := joinWithin(cfg.PDFADir, filename)
+	if err != nil {
+		return err
+	}
+	err = converter.ConvertToPDFA(ctx, inputPath, outputPath)
+	docs.update(filename, func(entry *catalogEntry) {
+		if err != nil {
+			entry.PDFAStatus = "failed"
+			entry.PDFAPath = ""
+			entry.PDFAError = err.Error()
+			return
+		}
+		entry.PDFAStatus = "converted"
+		entry.PDFAPath = outputPath
+		entry.PDFAError = ""
+	})
+	return err
+}
+
+// postProcess runs the optional steps on a freshly stored document.
+func postProcess(ctx context.Context, cfg *Config, store documentStore, docs *catalog, filename string) {
+	// Previews, PDF/A copies and stamps are made from PDFs only.
+	if formatOfFilename(filename) != formatPDF {
+		return
+	}
+	pdfPath, ok := store.path(filename)
+	if !ok {
+		return
+	}
+	plainPath, release, err := plaintextPath(store, filename)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	checkLanguage(cfg, docs, filename, plainPath)
+	if renderer := newPageRenderer(cfg); renderer != nil {
+		if err := renderer.RenderFirstPage(ctx, plainPath, previewPath(pdfPath)); err != nil {
+			log.Printf("failed to render preview of %s: %v", pdfPath, err)
+		}
+	}
+	release()
+	if converter := newPDFAConverter(cfg); converter != nil {
+		if err := convertToPDFA(ctx, cfg, converter, store, docs, filename); err != nil {
+			log.Printf("failed to convert %s to PDF/A: %v", pdfPath, err)
+		}
+	}
+	if cfg.Stamp {
+		if err := stampDocument(ctx, cfg, store, docs, filename); err != nil {
+			log.Printf("failed to stamp %s: %v", pdfPath, err)
+		}
+	}
+}
+
+// runPreviews implements the previews command, rendering every missing preview.
+func runPreviews(args []string) error {
+	cfg, _, err := loadConfig("previews", args, nil)
+	if err != nil {
+		return err
+	}
+	cfg.Previews = true
+	renderer := newPageRenderer(cfg)
+	if renderer == nil {
+		return fmt.Errorf("preview_command is not configured")
+	}
+	store, err := openDocumentStore(cfg)
+	if err != nil {
+		return err
+	}
+	documents, err := listDocuments(store)
+	if err != nil {
+		return err
+	}
+	var rendered int
+	for _, document := range documents {
+		pdfPath, ok := store.path(document.Name)
+		if !ok || fileExists(previewPath(pdfPath)) {
+			continue
+		}
+		plainPath, release, err := plaintextPath(store, document.Name)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		err = renderer.RenderFirstPage(context.Background(), plainPath, previewPath(pdfPath))
+		release()
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		rendered = rendered + 1
+	}
+	log.Printf("rendered %d previews", rendered)
+	return nil
+}
+
+// runPDFA implements the pdfa command, converting every document without a PDF/A copy.
+// Documents whose conversion failed before are retried.
+func runPDFA(args []string) error {
+	cfg, _, err := loadConfig("pdfa", args, nil)
+	if err != nil {
+		return err
+	}
+	cfg.PDFA = true
+	converter := newPDFAConverter(cfg)
+	if converter == nil {
+		return fmt.Errorf("pdfa_command is not configured")
+	}
+	lock, err := acquireRunLock(cfg)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+	docs, err := openCatalog(cfg.CatalogFile)
+	if err != nil {
+		return err
+	}
+	store, err := openDocumentStore(cfg)
+	if err != nil {
+		return err
+	}
+	documents, err := listDocuments(store)
+	if err != nil {
+		return err
+	}
+	var converted, failed int
+	for _, document := range documents {
+		if entry, ok := docs.get(document.Name); ok && entry.PDFAStatus == "converted" && fileExists(entry.PDFAPath) {
+			continue
+		}
+		if err := convertToPDFA(context.Background(), cfg, converter, store, docs, document.Name); err != nil {
+			log.Println(err)
+			failed = failed + 1
+			continue
+		}
+		converted = converted + 1
+	}
+	log.Printf("converted %d documents to PDF/A, %d failed", converted, failed)
+	return docs.save()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// dropPrivileges switches a process started as root to run_as_user and run_as_group, once the
+// listeners that need root are bound. Everything written afterwards, documents included, belongs to
+// that user. Nothing happens when no user is configured; a process already running as that user
+// carries on as it is.
+func dropPrivileges(cfg *Config) error {
+	if cfg.RunAsUser == "" {
+		return nil
+	}
+	account, err := lookupUser(cfg.RunAsUser)
+	if err != nil {
+		return err
+	}
+	uid, _ := strconv.Atoi(account.Uid)
+	gid, _ := strconv.Atoi(account.Gid)
+	if cfg.RunAsGroup != "" {
+		group, err := lookupGroup(cfg.RunAsGroup)
+		if err != nil {
+			return err
+		}
+		gid, _ = strconv.Atoi(group.Gid)
+	}
+	// Platforms without user ids, i.e. Windows, only need to say why this can't work.
+	if os.Geteuid() < 0 {
+		return setCredentials(uid, gid, nil)
+	}
+	if os.Geteuid() != 0 {
+		if os.Geteuid() == uid {
+			return nil
+		}
+		return fmt.Errorf("run_as_user %s needs the process to start as root, not uid %d", cfg.RunAsUser, os.Geteuid())
+	}
+	groups := []int{gid}
+	if ids, err := account.GroupIds(); err == nil {
+		for _, id := range ids {
+			if number, err := strconv.Atoi(id); err == nil && number != gid {
+				groups = append(groups, number)
+			}
+		}
+	}
+	if err := setCredentials(uid, gid, groups); err != nil {
+		return fmt.Errorf("failed to switch to %s: %v", cfg.RunAsUser, err)
+	}
+	// External tools such as qpdf and sftp look for their settings in the user's home.
+	os.Setenv("HOME", account.HomeDir)
+	os.Setenv("USER", account.Username)
+	log.Printf("running as %s (uid %d, gid %d)", account.Username, uid, gid)
+	return nil
+}
+
+// lookupUser finds a user by name or numeric id.
+func lookupUser(name string) (*user.User, error) {
+	account, err := user.Lookup(name)
+	if err == nil {
+		return account, nil
+	}
+	if _, numeric := strconv.Atoi(name); numeric == nil {
+		if account, idErr := user.LookupId(name); idErr == nil {
+			return account, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown run_as_user %q: %v", name, err)
+}
+
+// lookupGroup finds a group by name or numeric id.
+func lookupGroup(name string) (*user.Group, error) {
+	group, err := user.LookupGroup(name)
+	if err == nil {
+		return group, nil
+	}
+	if _, numeric := strconv.Atoi(name); numeric == nil {
+		if group, idErr := user.LookupGroupId(name); idErr == nil {
+			return group, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown run_as_group %q: %v", name, err)
+}
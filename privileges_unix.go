@@ -0,0 +1,27 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// setCredentials switches the process to uid and gid with the given groups. Supplementary groups go
+// first, then the group, then the user, as each step needs root.
+func setCredentials(uid int, gid int, groups []int) error {
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("setgroups: %v", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid %d: %v", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setuid %d: %v", uid, err)
+	}
+	// Make sure there is no way back.
+	if syscall.Setuid(0) == nil {
+		return fmt.Errorf("uid 0 is still reachable after setuid %d", uid)
+	}
+	return nil
+}
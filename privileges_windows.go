@@ -0,0 +1,8 @@
+package main
+
+import "fmt"
+
+// setCredentials is not available on Windows, where services and tasks name their account instead.
+func setCredentials(uid int, gid int, groups []int) error {
+	return fmt.Errorf("run_as_user is not supported on windows; set the account of the service or task instead")
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Probe timings. Results are cached so a kubelet probing every few seconds doesn't turn into load
+// on the upstream service.
+const (
+	probeTimeout  = 5 * time.Second
+	probeCacheAge = 30 * time.Second
+)
+
+// probeCheck is the outcome of one readiness check.
+type probeCheck struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// probeReport is the body of /healthz and /readyz.
+type probeReport struct {
+	Status string                `json:"status"`         // ok, unavailable or shutting down
+	Role   string                `json:"role,omitempty"` // leader or standby, with leader election on
+	Checks map[string]probeCheck `json:"checks,omitempty"`
+}
+
+// probeReportSchema is the OpenAPI schema of probeReport.
+var probeReportSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"status": map[string]any{"type": "string"},
+		"role":   map[string]any{"type": "string", "enum": []string{"leader", "standby"}},
+		"checks": map[string]any{"type": "object", "additionalProperties": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"ok":    map[string]any{"type": "boolean"},
+				"error": map[string]any{"type": "string"},
+			},
+		}},
+	},
+}
+
+// probes answers the liveness and readiness checks of container orchestrators. Liveness only says
+// the process still answers; readiness also needs the upstream service reachable, the catalog
+// readable and the output directory writable, and turns false as soon as shutdown begins so
+// traffic drains before the process exits.
+type probes struct {
+	cfg      *Config
+	client   *http.Client
+	elector  *leaderElector // Set by the daemon when leader election is on
+	draining atomic.Bool
+	mutex    sync.Mutex
+	checked  time.Time
+	checks   map[string]probeCheck
+}
+
+// newProbes returns the probes of a config.
+func newProbes(cfg *Config) *probes {
+	dialer := &net.Dialer{Timeout: probeTimeout}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = newNetworkDialer(cfg, dialer).DialContext
+	// The same user agent and host delay as the sync, so probes look like nothing new upstream.
+	return &probes{cfg: cfg, client: &http.Client{Transport: newPoliteTransport(cfg, transport), Timeout: probeTimeout}}
+}
+
+// drain marks the process as shutting down, failing readiness from now on.
+func (probe *probes) drain() {
+	if probe != nil && !probe.draining.Swap(true) {
+		log.Println("shutting down, readiness probe now fails")
+	}
+}
+
+// run returns the readiness checks, running them again once the cached ones are older than probeCacheAge.
+func (probe *probes) run(ctx context.Context) map[string]probeCheck {
+	probe.mutex.Lock()
+	defer probe.mutex.Unlock()
+	if probe.checks != nil && time.Since(probe.checked) < probeCacheAge {
+		return probe.checks
+	}
+	probe.checks = map[string]probeCheck{
+		"upstream": newProbeCheck(probe.checkUpstream(ctx)),
+		"catalog":  newProbeCheck(probe.checkCatalog()),
+		"storage":  newProbeCheck(probe.checkStorage()),
+	}
+	probe.checked = time.Now()
+	return probe.checks
+}
+
+// newProbeCheck turns an error into a check result.
+func newProbeCheck(err error) probeCheck {
+	if err != nil {
+		return probeCheck{Error: err.Error()}
+	}
+	return probeCheck{OK: true}
+}
+
+// checkUpstream asks the service root for anything at all; any answer below 500 means it is reachable,
+// as authentication and routing are the sync's business.
+func (probe *probes) checkUpstream(ctx context.Context) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodHead, probe.cfg.ServiceURL, nil)
+	if err != nil {
+		return err
+	}
+	response, err := probe.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrNetwork, activeRedactor.text(err.Error()))
+	}
+	response.Body.Close()
+	if response.StatusCode >= 500 {
+		return fmt.Errorf("%s answered %s", activeRedactor.text(probe.cfg.ServiceURL), response.Status)
+	}
+	return nil
+}
+
+// checkCatalog loads the catalog; a missing one is fine before the first sync.
+func (probe *probes) checkCatalog() error {
+	_, err := openCatalog(probe.cfg.CatalogFile)
+	return err
+}
+
+// checkStorage writes and removes a file in the output directory.
+func (probe *probes) checkStorage() error {
+	if err := os.MkdirAll(probe.cfg.OutputDir, 0o755); err != nil {
+		return fmt.Errorf("%w: %v", ErrStorage, err)
+	}
+	file, err := os.CreateTemp(probe.cfg.OutputDir, ".probe.tmp-*")
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrStorage, err)
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// handleHealthz serves GET /healthz: the process is up and answering.
+func (probe *probes) handleHealthz(writer http.ResponseWriter, request *http.Request) {
+	writeJSON(writer, http.StatusOK, probeReport{Status: "ok"})
+}
+
+// handleReadyz serves GET /readyz: 200 when every check passes, 503 otherwise or while shutting down.
+func (probe *probes) handleReadyz(writer http.ResponseWriter, request *http.Request) {
+	if probe.draining.Load() {
+		writeJSON(writer, http.StatusServiceUnavailable, probeReport{Status: "shutting down"})
+		return
+	}
+	report := probeReport{Status: "ok", Checks: probe.run(request.Context())}
+	// A standby replica is as ready as the leader: it takes over the moment the lease lapses.
+	if probe.elector != nil {
+		report.Role = "standby"
+		if probe.elector.leading() {
+			report.Role = "leader"
+		}
+	}
+	status := http.StatusOK
+	for _, check := range report.Checks {
+		if !check.OK {
+			report.Status, status = "unavailable", http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(writer, status, report)
+}
+
+// handleHealthz serves the liveness probe in serve mode.
+func (srv *corpusServer) handleHealthz() http.HandlerFunc {
+	return srv.probes.handleHealthz
+}
+
+// handleReadyz serves the readiness probe in serve mode.
+func (srv *corpusServer) handleReadyz() http.HandlerFunc {
+	return srv.probes.handleReadyz
+}
+
+// startProbeServer serves /healthz and /readyz on probe_address for the daemon, which has no other
+// listener. It binds before returning, so the port is held before privileges are dropped.
+func startProbeServer(cfg *Config, probe *probes) (*http.Server, error) {
+	if cfg.ProbeAddress == "" {
+		return nil, nil
+	}
+	listener, err := net.Listen("tcp", cfg.ProbeAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for probes: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", probe.handleHealthz)
+	mux.HandleFunc("GET /readyz", probe.handleReadyz)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		log.Printf("probes listening on %s", cfg.ProbeAddress)
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Println("probe endpoint stopped:", err)
+		}
+	}()
+	return server, nil
+}
+
+// graceContext returns a context that ends shutdownGrace after stopping ends, so work in flight
+// when SIGTERM arrives gets the time the pod's termination grace period allows.
+func graceContext(stopping context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-stopping.Done():
+		case <-ctx.Done():
+			return
+		}
+		if grace > 0 {
+			log.Printf("letting work in flight finish for up to %s", grace)
+			select {
+			case <-time.After(grace):
+			case <-ctx.Done():
+			}
+		}
+		cancel()
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	rpprof "runtime/pprof"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Pipeline stages as named in timings and metrics.
+const (
+	stageScrape      = "scrape"      // Listing the sources, judged by how full the queue after it stays
+	stagePlan        = "plan"        // Dedupe, filters, language chain and sampling, judged the same way
+	stageDownload    = "download"    // Fetching into staging, summed over workers
+	stageHash        = "hash"        // Checksums of completed downloads when the hash pool is on, summed over workers
+	stageWrite       = "write"       // Moving into the store and recording it
+	stagePostprocess = "postprocess" // Previews and PDF/A copies
+)
+
+// stageTiming is the aggregate of one stage.
+type stageTiming struct {
+	items int
+	busy  time.Duration // Time spent working, excluding waits on the neighbouring stages
+}
+
+// queueFill accumulates samples of how full one queue between stages was.
+type queueFill struct {
+	name     string // Stage draining the queue
+	length   func() int
+	capacity int
+	samples  int
+	filled   float64 // Sum of the sampled fill ratios
+}
+
+// pipelineTimings aggregates per-stage work and queue fill over one run, to tell which stage holds the others back.
+type pipelineTimings struct {
+	mutex   sync.Mutex
+	started time.Time
+	stages  map[string]*stageTiming
+	queues  []*queueFill // In pipeline order
+	done    chan struct{}
+}
+
+// newPipelineTimings starts aggregating for a run.
+func newPipelineTimings() *pipelineTimings {
+	return &pipelineTimings{started: time.Now(), stages: make(map[string]*stageTiming), done: make(chan struct{})}
+}
+
+// track adds the time since start to a stage's work. A nil receiver ignores it, for stages run outside a pipeline.
+func (timings *pipelineTimings) track(stage string, start time.Time) {
+	if timings == nil {
+		return
+	}
+	elapsed := time.Since(start)
+	timings.mutex.Lock()
+	timing, ok := timings.stages[stage]
+	if !ok {
+		timing = &stageTiming{}
+		timings.stages[stage] = timing
+	}
+	timing.items = timing.items + 1
+	timing.busy = timing.busy + elapsed
+	timings.mutex.Unlock()
+	metrics.add("sabic_stage_seconds_total", map[string]string{"stage": stage}, elapsed.Seconds())
+}
+
+// watchQueue adds a queue feeding the named stage to the sampled ones; call it in pipeline order before sample.
+// A queue that stays full means its consumer is slower than its producer.
+func (timings *pipelineTimings) watchQueue(consumer string, length func() int, capacity int) {
+	timings.queues = append(timings.queues, &queueFill{name: consumer, length: length, capacity: capacity})
+}
+
+// sample records the queue fill every interval until stop.
+func (timings *pipelineTimings) sample(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-timings.done:
+			return
+		case <-ticker.C:
+			timings.mutex.Lock()
+			for _, queue := range timings.queues {
+				queue.samples = queue.samples + 1
+				queue.filled = queue.filled + float64(queue.length())/float64(max(queue.capacity, 1))
+			}
+			timings.mutex.Unlock()
+		}
+	}
+}
+
+// stop ends queue sampling.
+func (timings *pipelineTimings) stop() {
+	close(timings.done)
+}
+
+// bottleneck names the stage holding the run back: the consumer of the last queue that was
+// mostly full, or the scraper when every queue mostly ran dry.
+func (timings *pipelineTimings) bottleneck() string {
+	timings.mutex.Lock()
+	defer timings.mutex.Unlock()
+	stage := stageScrape
+	for _, queue := range timings.queues {
+		if queue.samples > 0 && queue.filled/float64(queue.samples) >= 0.5 {
+			stage = queue.name
+		}
+	}
+	return stage
+}
+
+// summary renders the timings of the run, one line per stage and queue.
+func (timings *pipelineTimings) summary(workers map[string]int) string {
+	wall := time.Since(timings.started)
+	var lines []string
+	timings.mutex.Lock()
+	for _, stage := range []string{stageDownload, stageHash, stageWrite, stagePostprocess} {
+		timing, ok := timings.stages[stage]
+		if !ok {
+			continue
+		}
+		// Downloads, hashes and post-processing run on their workers, the writer on one goroutine.
+		parallelism := max(workers[stage], 1)
+		utilization := 100 * timing.busy.Seconds() / (wall.Seconds() * float64(parallelism))
+		lines = append(lines, fmt.Sprintf("  %-11s %6d items  %10s busy  %5.1f%% utilized", stage, timing.items, timing.busy.Round(time.Millisecond), utilization))
+	}
+	for _, queue := range timings.queues {
+		if queue.samples == 0 {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("  queue to %-11s %5.1f%% full on average", queue.name, 100*queue.filled/float64(queue.samples)))
+	}
+	timings.mutex.Unlock()
+	header := fmt.Sprintf("pipeline timings over %s, bottleneck %s", wall.Round(time.Millisecond), timings.bottleneck())
+	if len(lines) == 0 {
+		return header
+	}
+	return header + ":\n" + strings.Join(lines, "\n")
+}
+
+// startProfiling starts the optional pprof endpoint and CPU profile. The returned function stops
+// the CPU profile and writes the heap profile; it is safe to call when nothing was enabled.
+func startProfiling(cfg *Config) (func(), error) {
+	if cfg.PprofAddress != "" {
+		// A mux of its own keeps the profiler off the API listener and its auth-free paths.
+		mux := http.NewServeMux()
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		// Bind before returning, so the port is held before privileges are dropped.
+		listener, err := net.Listen("tcp", cfg.PprofAddress)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen for pprof: %v", err)
+		}
+		go func() {
+			log.Printf("pprof listening on %s", cfg.PprofAddress)
+			if err := http.Serve(listener, mux); err != nil {
+				log.Println("pprof endpoint stopped:", err)
+			}
+		}()
+	}
+	var cpuFile *os.File
+	if cfg.CPUProfile != "" {
+		file, err := os.Create(cfg.CPUProfile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create CPU profile: %v", err)
+		}
+		if err := rpprof.StartCPUProfile(file); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to start CPU profile: %v", err)
+		}
+		cpuFile = file
+	}
+	return func() {
+		if cpuFile != nil {
+			rpprof.StopCPUProfile()
+			if err := cpuFile.Close(); err != nil {
+				log.Println("Failed to write CPU profile:", err)
+			}
+		}
+		if cfg.HeapProfile != "" {
+			if err := writeHeapProfile(cfg.HeapProfile); err != nil {
+				log.Println("Failed to write heap profile:", err)
+			}
+		}
+	}, nil
+}
+
+// writeHeapProfile dumps the live heap after a collection, so the profile shows what is retained.
+func writeHeapProfile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	runtime.GC()
+	if err := rpprof.WriteHeapProfile(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func init() {
+	metrics.describe("sabic_stage_seconds_total", "Time pipeline stages spent working, by stage.")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// runProgress reports how far a sync run is, sized by the OData __count of the header JSON.
+type runProgress struct {
+	mutex       sync.Mutex
+	total       int           // Documents listed upstream, 0 while unknown
+	done        int           // Documents the writer finished
+	started     time.Time     // When the run started
+	perDocument time.Duration // Average time per downloaded document in the previous run, 0 when unknown
+	nextReport  int           // done count at which the next progress line is logged
+}
+
+// newRunProgress starts tracking a run.
+func newRunProgress(perDocument time.Duration) *runProgress {
+	return &runProgress{started: time.Now(), perDocument: perDocument}
+}
+
+// setTotal records the number of listed documents and logs an estimate before the first download.
+// stored is how many of them are already on disk and will be skipped.
+func (progress *runProgress) setTotal(total int, stored int) {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	progress.total = total
+	progress.nextReport = progress.reportStep()
+	remaining := max(total-stored, 0)
+	if progress.perDocument <= 0 {
+		log.Printf("%d documents listed upstream, about %d to fetch", total, remaining)
+		return
+	}
+	estimate := time.Duration(remaining) * progress.perDocument
+	log.Printf("%d documents listed upstream, about %d to fetch, estimated %s", total, remaining, estimate.Round(time.Minute))
+}
+
+// reportStep logs progress every 5% of the total, or every 100 documents when it's unknown.
+func (progress *runProgress) reportStep() int {
+	if progress.total <= 0 {
+		return 100
+	}
+	return max(progress.total/20, 1)
+}
+
+// advance counts one finished document and logs progress at every step.
+func (progress *runProgress) advance() {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	progress.done = progress.done + 1
+	if progress.nextReport == 0 {
+		progress.nextReport = progress.reportStep()
+	}
+	if progress.done < progress.nextReport {
+		return
+	}
+	progress.nextReport = progress.nextReport + progress.reportStep()
+	if progress.total <= 0 {
+		log.Printf("progress: %d documents", progress.done)
+		return
+	}
+	// Extrapolate from this run's pace.
+	elapsed := time.Since(progress.started)
+	left := time.Duration(float64(elapsed) / float64(progress.done) * float64(max(progress.total-progress.done, 0)))
+	log.Printf("progress: %d/%d (%.1f%%), about %s left", progress.done, progress.total,
+		100*float64(progress.done)/float64(progress.total), left.Round(time.Second))
+}
+
+// estimateDocumentDuration returns the average time per downloaded document in the newest run manifest.
+// It returns 0 when there is no earlier run with downloads to learn from.
+func estimateDocumentDuration(manifestDir string) time.Duration {
+	if manifestDir == "" {
+		return 0
+	}
+	paths, err := filepath.Glob(filepath.Join(manifestDir, "run-*.jsonl"))
+	if err != nil || len(paths) == 0 {
+		return 0
+	}
+	// The timestamped names sort chronologically.
+	sort.Strings(paths)
+	file, err := os.Open(paths[len(paths)-1])
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	var first, last time.Time
+	downloaded := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var result downloadResult
+		if json.Unmarshal(scanner.Bytes(), &result) != nil {
+			continue
+		}
+		if first.IsZero() {
+			first = result.Time
+		}
+		last = result.Time
+		if result.Status == resultDownloaded {
+			downloaded = downloaded + 1
+		}
+	}
+	if downloaded == 0 || !last.After(first) {
+		return 0
+	}
+	return last.Sub(first) / time.Duration(downloaded)
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// publishFormat identifies the layout of published subsets.
+const publishFormat = "sabic-publish/1"
+
+// publishFilter selects the documents of a published subset; every field that is set must match.
+type publishFilter struct {
+	Materials    map[string]bool // Material numbers or internal codes, without leading zeros
+	Languages    map[string]bool // Laiso codes, uppercase
+	Jurisdiction string          // Comma separated jurisdictions or countries, see matchesJurisdiction
+	Family       string          // Comma separated product families, see matchesProductFamily
+	ReportTypes  string          // Comma separated report types, see matchesReportType
+}
+
+// parsePublishFilter reads a -filter value: @path names a file listing one material per line,
+// anything else is a query of space separated terms such as material=10001,10002 language=EN,DE
+// jurisdiction=EU family=LEXAN type=LABEL.
+func parsePublishFilter(text string) (publishFilter, error) {
+	filter := publishFilter{}
+	if path, ok := strings.CutPrefix(strings.TrimSpace(text), "@"); ok {
+		materials, err := readMaterialsList(path)
+		if err != nil {
+			return filter, err
+		}
+		filter.Materials = materials
+		return filter, nil
+	}
+	for _, term := range strings.Fields(text) {
+		key, value, ok := strings.Cut(term, "=")
+		if !ok || value == "" {
+			return filter, fmt.Errorf("filter term %q is not key=value", term)
+		}
+		switch strings.ToLower(key) {
+		case "material":
+			filter.Materials = make(map[string]bool)
+			for _, material := range strings.Split(value, ",") {
+				filter.Materials[normalizeMaterial(material)] = true
+			}
+		case "language":
+			filter.Languages = make(map[string]bool)
+			for _, language := range strings.Split(value, ",") {
+				filter.Languages[strings.ToUpper(strings.TrimSpace(language))] = true
+			}
+		case "jurisdiction":
+			filter.Jurisdiction = value
+		case "family":
+			filter.Family = value
+		case "type":
+			filter.ReportTypes = value
+		default:
+			return filter, fmt.Errorf("unknown filter key %q, expected material, language, jurisdiction, family or type", key)
+		}
+	}
+	if filter.Materials == nil && filter.Languages == nil && filter.Jurisdiction == "" && filter.Family == "" && filter.ReportTypes == "" {
+		return filter, fmt.Errorf("the filter selects every document; name the materials or a query")
+	}
+	return filter, nil
+}
+
+// readMaterialsList reads a file of material numbers or internal codes, one per line or comma separated,
+// with # comments.
+func readMaterialsList(path string) (map[string]bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read materials list: %v", err)
+	}
+	defer file.Close()
+	materials := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		for _, material := range strings.Split(line, ",") {
+			if material = normalizeMaterial(material); material != "" {
+				materials[material] = true
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read materials list: %v", err)
+	}
+	if len(materials) == 0 {
+		return nil, fmt.Errorf("materials list %s is empty", path)
+	}
+	return materials, nil
+}
+
+// normalizeMaterial drops the zero padding SAP exports put in front of material numbers.
+func normalizeMaterial(material string) string {
+	material = strings.TrimSpace(material)
+	if trimmed := strings.TrimLeft(material, "0"); trimmed != "" {
+		return trimmed
+	}
+	return material
+}
+
+// matches reports whether a document belongs to the subset.
+func (filter publishFilter) matches(cfg *Config, document documentInfo) bool {
+	if filter.Materials != nil && !filter.Materials[normalizeMaterial(document.Material)] &&
+		(document.InternalCode == "" || !filter.Materials[normalizeMaterial(document.InternalCode)]) {
+		return false
+	}
+	if filter.Languages != nil && !filter.Languages[strings.ToUpper(document.Language)] {
+		return false
+	}
+	if !matchesReportType(filter.ReportTypes, reportTypeOfFilename(document.Name)) {
+		return false
+	}
+	return matchesJurisdiction(cfg, filter.Jurisdiction, document.Sbgvid) && matchesProductFamily(filter.Family, document.ProductFamily)
+}
+
+// publishedDocument is one document in the manifest of a published subset.
+type publishedDocument struct {
+	Name     string `json:"name"`
+	Material string `json:"material"`
+	Language string `json:"language"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+}
+
+// publishManifest describes a published subset, written as manifest.json next to its index.
+type publishManifest struct {
+	Format    string              `json:"format"`
+	Title     string              `json:"title"`
+	Filter    string              `json:"filter"` // The -filter value the subset was selected with
+	CreatedAt time.Time           `json:"created_at"`
+	Documents []publishedDocument `json:"documents"`
+}
+
+// publishSubset exports the documents matching filter to dest: a copy of each PDF, an index like the
+// exported site's without SABIC branding, and a manifest with their checksums. Copies of documents
+// that no longer match are removed, so dest never holds more than the subset.
+func publishSubset(cfg *Config, store documentStore, filter publishFilter, filterText string, dest string, title string) (publishManifest, error) {
+	manifest := publishManifest{Format: publishFormat, Title: title, Filter: filterText, CreatedAt: time.Now().UTC(), Documents: []publishedDocument{}}
+	// Pruning dest must never reach the corpus itself.
+	for _, protected := range []string{cfg.OutputDir, cfg.SiteDir} {
+		if protected != "" && filepath.Clean(dest) == filepath.Clean(protected) {
+			return manifest, fmt.Errorf("refusing to publish into %s, which the sync or export-site command owns", dest)
+		}
+	}
+	documents, err := listDocuments(store)
+	if err != nil && !os.IsNotExist(err) {
+		return manifest, err
+	}
+	docs, err := openCatalog(cfg.CatalogFile)
+	if err != nil {
+		return manifest, err
+	}
+	withProductFamilies(docs, documents)
+	selected := []documentInfo{}
+	for _, document := range documents {
+		if filter.matches(cfg, document) {
+			selected = append(selected, document)
+		}
+	}
+	if len(selected) == 0 {
+		return manifest, fmt.Errorf("the filter matches no documents")
+	}
+	documentsDir := filepath.Join(dest, siteDocumentsDir)
+	if err := os.MkdirAll(documentsDir, 0o755); err != nil {
+		return manifest, err
+	}
+	site := make([]siteDocument, 0, len(selected))
+	for _, document := range selected {
+		target := filepath.Join(documentsDir, document.Name)
+		if err := copySiteDocument(store, document.Name, target); err != nil {
+			return manifest, err
+		}
+		// Hash the copy, which is what the recipient gets.
+		sum, err := fileSHA256(target)
+		if err != nil {
+			return manifest, err
+		}
+		entry, _ := docs.get(document.Name)
+		site = append(site, siteDocument{documentInfo: document, Description: materialDescription(cfg, entry.Properties), Link: siteDocumentsDir + "/" + url.PathEscape(document.Name)})
+		manifest.Documents = append(manifest.Documents, publishedDocument{Name: document.Name, Material: document.Material, Language: document.Language, Size: document.Size, SHA256: sum})
+	}
+	shareDescriptions(site)
+	if err := pruneSiteDocuments(documentsDir, selected); err != nil {
+		return manifest, err
+	}
+	page := sitePage{Title: title, Generated: manifest.CreatedAt.Format(time.RFC3339), Total: len(site), Listings: siteListings}
+	if err := writeSitePage(filepath.Join(dest, "index.html"), page); err != nil {
+		return manifest, err
+	}
+	for _, listing := range siteListings {
+		page.Listing = listing.Title
+		page.Groups = groupSiteDocuments(site, listing.groupOf)
+		if err := writeSitePage(filepath.Join(dest, listing.File), page); err != nil {
+			return manifest, err
+		}
+	}
+	encoded, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	return manifest, writeFileAtomically(filepath.Join(dest, "manifest.json"), append(encoded, '\n'), 0o644)
+}
+
+// archivePublication packs a published subset into a gzipped tar, the manifest first so a recipient
+// can check the archive before unpacking it.
+func archivePublication(dest string, manifest publishManifest, archivePath string) error {
+	names := []string{"manifest.json", "index.html"}
+	for _, listing := range siteListings {
+		names = append(names, listing.File)
+	}
+	documents := make([]string, 0, len(manifest.Documents))
+	for _, document := range manifest.Documents {
+		documents = append(documents, siteDocumentsDir+"/"+document.Name)
+	}
+	sort.Strings(documents)
+	names = append(names, documents...)
+	// Write beside the destination and rename, so a failed run never leaves half an archive.
+	temp, err := os.CreateTemp(filepath.Dir(archivePath), "."+filepath.Base(archivePath)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	compressed := gzip.NewWriter(temp)
+	archive := tar.NewWriter(compressed)
+	for _, name := range names {
+		if err := addFileToTar(archive, name, filepath.Join(dest, filepath.FromSlash(name))); err != nil {
+			temp.Close()
+			return fmt.Errorf("failed to archive %s: %v", name, err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := compressed.Close(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), archivePath)
+}
+
+// runPublish implements the publish command.
+func runPublish(args []string) error {
+	var filterText, dest, archivePath, title string
+	cfg, _, err := loadConfig("publish", args, func(flagSet *flag.FlagSet) {
+		flagSet.StringVar(&filterText, "filter", "", "documents to publish: @file listing materials, or a query such as \"material=10001,10002 language=EN\"")
+		flagSet.StringVar(&dest, "dest", "", "directory to publish the subset to")
+		flagSet.StringVar(&archivePath, "archive", "", "also pack the subset into this .tar.gz")
+		flagSet.StringVar(&title, "title", "Safety data sheets", "title of the subset's index pages")
+	})
+	if err != nil {
+		return err
+	}
+	if filterText == "" || dest == "" {
+		return fmt.Errorf("publish needs -filter and -dest")
+	}
+	filter, err := parsePublishFilter(filterText)
+	if err != nil {
+		return err
+	}
+	store, err := openDocumentStore(cfg)
+	if err != nil {
+		return err
+	}
+	manifest, err := publishSubset(cfg, store, filter, filterText, dest, title)
+	if err != nil {
+		return err
+	}
+	log.Printf("published %d documents to %s", len(manifest.Documents), dest)
+	if archivePath != "" {
+		if err := archivePublication(dest, manifest, archivePath); err != nil {
+			return err
+		}
+		log.Printf("packed them into %s", archivePath)
+	}
+	return nil
+}
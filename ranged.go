@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// rangedEligible reports whether a download response is worth splitting into ranged segments:
+// large enough, of a known length, and from a server that accepts byte ranges.
+func rangedEligible(cfg *Config, resp *http.Response) bool {
+	return cfg.RangedThreshold > 0 && cfg.RangedSegments > 1 &&
+		resp.ContentLength >= cfg.RangedThreshold &&
+		strings.EqualFold(resp.Header.Get("Accept-Ranges"), "bytes")
+}
+
+// rangedDownload returns a function downloading a large document as cfg.RangedSegments byte ranges in
+// parallel into the file it is given, at their offsets. first is the plain GET that announced the
+// document; the caller checks it before any range is asked for, and its body isn't needed for them.
+// Every segment is pinned to the same revision with If-Range, and the assembled file is checked against
+// a checksum the server announced, so a document revised mid-download can't be stitched together.
+func rangedDownload(cfg *Config, client *http.Client, doc documentRef, first *http.Response) func(ctx context.Context, file *os.File) error {
+	total := first.ContentLength
+	// An ETag pins a revision exactly; a Last-Modified date is what's left otherwise.
+	validator := first.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = first.Header.Get("Last-Modified")
+	}
+	return func(ctx context.Context, file *os.File) error {
+		if err := file.Truncate(total); err != nil {
+			return fmt.Errorf("%w: failed to size file for %s: %w", ErrStorage, doc.url, err)
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		segments := int64(cfg.RangedSegments)
+		size := (total + segments - 1) / segments
+		errs := make(chan error, segments)
+		var wait sync.WaitGroup
+		for start := int64(0); start < total; start = start + size {
+			end := min(start+size, total) - 1
+			wait.Add(1)
+			go func() {
+				defer wait.Done()
+				if err := fetchSegment(ctx, cfg, client, doc.url, validator, file, start, end); err != nil {
+					errs <- err
+					// One bad segment spoils the file; stop the others.
+					cancel()
+				}
+			}()
+		}
+		wait.Wait()
+		close(errs)
+		if err := <-errs; err != nil {
+			return err
+		}
+		if err := verifyAnnouncedChecksum(first.Header, file); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrChecksumMismatch, doc.url, err)
+		}
+		return nil
+	}
+}
+
+// fetchSegment downloads bytes start to end inclusive into file at their offset.
+func fetchSegment(ctx context.Context, cfg *Config, client *http.Client, documentURL string, validator string, file *os.File, start int64, end int64) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, documentURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to build request for %s: %v", documentURL, err)
+	}
+	request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	if validator != "" {
+		request.Header.Set("If-Range", validator)
+	}
+	resp, err := openDownload(ctx, cfg, client, request)
+	if err != nil {
+		return fmt.Errorf("%w: failed to download bytes %d-%d of %s: %w", ErrNetwork, start, end, documentURL, err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// The full body instead of a range: If-Range found a newer revision, or ranges stopped working.
+		return fmt.Errorf("%w: %s changed or stopped serving ranges during the download", ErrTruncated, documentURL)
+	default:
+		return responseError(resp, documentURL)
+	}
+	if want := fmt.Sprintf("bytes %d-%d/", start, end); !strings.HasPrefix(resp.Header.Get("Content-Range"), want) {
+		return fmt.Errorf("%w: asked %s for bytes %d-%d, got %q", ErrTruncated, documentURL, start, end, resp.Header.Get("Content-Range"))
+	}
+	written, err := io.Copy(io.NewOffsetWriter(file, start), io.LimitReader(resp.Body, end-start+1))
+	if err != nil {
+		return fmt.Errorf("%w: failed to read bytes %d-%d of %s: %w", ErrNetwork, start, end, documentURL, err)
+	}
+	if written != end-start+1 {
+		return fmt.Errorf("%w: bytes %d-%d of %s ended after %d bytes", ErrTruncated, start, end, documentURL, written)
+	}
+	return nil
+}
+
+// verifyAnnouncedChecksum compares the file with a checksum from the response headers: a sha-256
+// Repr-Digest or Digest, or a Content-MD5. Without one, the per-segment checks and the pinned revision have to do.
+func verifyAnnouncedChecksum(header http.Header, file *os.File) error {
+	var hasher hash.Hash
+	var want string
+	for _, name := range []string{"Repr-Digest", "Digest"} {
+		for _, item := range strings.Split(header.Get(name), ",") {
+			algorithm, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+			if ok && strings.EqualFold(algorithm, "sha-256") {
+				hasher, want = sha256.New(), strings.Trim(value, ":")
+			}
+		}
+		if hasher != nil {
+			break
+		}
+	}
+	if hasher == nil && header.Get("Content-MD5") != "" {
+		hasher, want = md5.New(), header.Get("Content-MD5")
+	}
+	if hasher == nil {
+		return nil
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(hasher, file); err != nil {
+		return err
+	}
+	if got := base64.StdEncoding.EncodeToString(hasher.Sum(nil)); got != want {
+		return fmt.Errorf("assembled content has checksum %s, the server announced %s", got, want)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestRangedDownload checks that a large document is fetched as byte ranges into the staging file,
+// and that a response failing the checks of its first GET is rejected before any range is asked for.
+func TestRangedDownload(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "fixtures", "341c1eb39daa704b.body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		contentType string
+		maxBytes    int64
+		wantErr     error
+	}{
+		{name: "ranged", contentType: "application/pdf"},
+		{name: "oversized", contentType: "application/pdf", maxBytes: 4096, wantErr: ErrSizeOutOfRange},
+		{name: "not a PDF", contentType: "text/html", wantErr: ErrNotPDF},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var ranges atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Range") != "" {
+					ranges.Add(1)
+				}
+				w.Header().Set("Content-Type", test.contentType)
+				http.ServeContent(w, r, "", time.Unix(1735689600, 0), bytes.NewReader(content))
+			}))
+			defer server.Close()
+			dir := t.TempDir()
+			cfg := defaultConfig()
+			cfg.ServiceURL = server.URL + "/v1/SDS"
+			cfg.UseMetadata = false
+			cfg.InputFile = filepath.Join(dir, "headers.json")
+			cfg.RangedThreshold = 1024
+			cfg.RangedSegments = 4
+			cfg.MaxSize = test.maxBytes
+			cfg.ContentTypeCheck = contentTypeStrict
+			source, err := newSABICSource(cfg, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			header := headerResult{MaterialNumber: "22006037", SubID: "630000000001", StorageLocation: "SDS_MY", LanguageISO: "MS"}
+			doc := documentRef{source: source.Name(), url: documentURL(cfg, header)}
+			id, err := documentIDFromURL(doc.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc.id, doc.filename = id, id.filename()
+			target := filepath.Join(dir, "out", doc.filename)
+			staged, err := fetchSingleDocument(context.Background(), cfg, source, doc, target)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("got error %v, want %v", err, test.wantErr)
+				}
+				if n := ranges.Load(); n != 0 {
+					t.Errorf("asked for %d ranges of a rejected document", n)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n := ranges.Load(); n != int32(cfg.RangedSegments) {
+					t.Errorf("asked for %d ranges, want %d", n, cfg.RangedSegments)
+				}
+				stored, err := os.ReadFile(target)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sum := sha256.Sum256(content)
+				if !bytes.Equal(stored, content) || staged.sha256 != hex.EncodeToString(sum[:]) || staged.size != int64(len(content)) {
+					t.Errorf("stored %d bytes with sha256 %s, want the %d bytes served", len(stored), staged.sha256, len(content))
+				}
+			}
+			// Nothing is left in the staging directory either way.
+			entries, err := os.ReadDir(filepath.Dir(target))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range entries {
+				if entry.Name() != doc.filename {
+					t.Errorf("left %s behind", entry.Name())
+				}
+			}
+		})
+	}
+}
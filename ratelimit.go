@@ -0,0 +1,171 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tokenBucket refills at a fixed rate up to a burst size.
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// clientLimiter enforces per-client request rates and concurrent download caps in serve mode.
+type clientLimiter struct {
+	mutex             sync.Mutex
+	rate              float64                 // Requests per second per client, 0 disables rate limiting
+	burst             float64                 // Requests a client may make at once
+	perClientStreams  int                     // Concurrent downloads per client, 0 disables the cap
+	totalStreams      int                     // Concurrent downloads across all clients, 0 disables the cap
+	buckets           map[string]*tokenBucket // Rate limit state by client
+	activeStreams     map[string]int          // Running downloads by client
+	activeTotal       int                     // Running downloads across all clients
+	lastEviction      time.Time               // When idle buckets were last dropped
+	bucketIdleTimeout time.Duration           // Buckets unused this long are forgotten
+}
+
+// newClientLimiter creates the limiter from the config.
+func newClientLimiter(cfg *Config) *clientLimiter {
+	burst := float64(cfg.RateBurst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &clientLimiter{
+		rate:              cfg.RateLimit,
+		burst:             burst,
+		perClientStreams:  cfg.MaxDownloadsPerClient,
+		totalStreams:      cfg.MaxConcurrentDownloads,
+		buckets:           make(map[string]*tokenBucket),
+		activeStreams:     make(map[string]int),
+		lastEviction:      time.Now(),
+		bucketIdleTimeout: 10 * time.Minute,
+	}
+}
+
+// clientKey identifies the caller: the authenticated name, or the remote IP for anonymous callers.
+func clientKey(request *http.Request) string {
+	if caller := principalFromRequest(request); caller != nil && caller.Name != "anonymous" {
+		return "principal:" + caller.Name
+	}
+	return addressKey(request)
+}
+
+// addressKey identifies the caller by its remote IP alone.
+func addressKey(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return "ip:" + request.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// allow takes a token from the client's bucket, returning how long to wait when there is none.
+func (limiter *clientLimiter) allow(client string) (bool, time.Duration) {
+	return limiter.withdraw(client, true)
+}
+
+// withdraw checks the client's bucket for a token, taking it when take is set.
+func (limiter *clientLimiter) withdraw(client string, take bool) (bool, time.Duration) {
+	if limiter.rate <= 0 {
+		return true, 0
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	now := time.Now()
+	// Forget clients that went quiet so the map doesn't grow forever.
+	if now.Sub(limiter.lastEviction) > time.Minute {
+		for key, bucket := range limiter.buckets {
+			if now.Sub(bucket.lastSeen) > limiter.bucketIdleTimeout {
+				delete(limiter.buckets, key)
+			}
+		}
+		limiter.lastEviction = now
+	}
+	bucket, ok := limiter.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: limiter.burst, lastSeen: now}
+		limiter.buckets[client] = bucket
+	}
+	// Refill for the time that passed since the last request.
+	bucket.tokens = math.Min(limiter.burst, bucket.tokens+now.Sub(bucket.lastSeen).Seconds()*limiter.rate)
+	bucket.lastSeen = now
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / limiter.rate * float64(time.Second))
+		return false, wait
+	}
+	if take {
+		bucket.tokens = bucket.tokens - 1
+	}
+	return true, 0
+}
+
+// acquireStream reserves a download slot for the client.
+func (limiter *clientLimiter) acquireStream(client string) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if limiter.totalStreams > 0 && limiter.activeTotal >= limiter.totalStreams {
+		return false
+	}
+	if limiter.perClientStreams > 0 && limiter.activeStreams[client] >= limiter.perClientStreams {
+		return false
+	}
+	limiter.activeStreams[client] = limiter.activeStreams[client] + 1
+	limiter.activeTotal = limiter.activeTotal + 1
+	return true
+}
+
+// releaseStream frees a download slot.
+func (limiter *clientLimiter) releaseStream(client string) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	limiter.activeStreams[client] = limiter.activeStreams[client] - 1
+	if limiter.activeStreams[client] <= 0 {
+		delete(limiter.activeStreams, client)
+	}
+	limiter.activeTotal = limiter.activeTotal - 1
+}
+
+// limitFailures wraps the authentication of a route so every 401 or 403 takes a token from a bucket of the
+// caller's address, and an address that used its bucket up is refused before its credentials are checked
+// again. The request limit only applies once the caller is known, which would leave guessing unlimited.
+func (limiter *clientLimiter) limitFailures(next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		client := "failures:" + addressKey(request)
+		if ok, wait := limiter.withdraw(client, false); !ok {
+			writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeJSONError(writer, http.StatusTooManyRequests, "too many failed authentications")
+			return
+		}
+		recorder := &statusRecorder{ResponseWriter: writer}
+		next(recorder, request)
+		if recorder.status == http.StatusUnauthorized || recorder.status == http.StatusForbidden {
+			limiter.allow(client)
+		}
+	}
+}
+
+// limit wraps a handler with the rate limit and, for streaming routes, the download caps.
+func (limiter *clientLimiter) limit(streams bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		client := clientKey(request)
+		if ok, wait := limiter.allow(client); !ok {
+			writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeJSONError(writer, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		if streams {
+			if !limiter.acquireStream(client) {
+				writer.Header().Set("Retry-After", "1")
+				writeJSONError(writer, http.StatusTooManyRequests, "too many concurrent downloads")
+				return
+			}
+			defer limiter.releaseStream(client)
+		}
+		next(writer, request)
+	}
+}
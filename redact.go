@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// redactedMask replaces every secret in logs, saved response headers and manifests.
+const redactedMask = "[REDACTED]"
+
+// sensitiveHeaders carry credentials in requests or responses; their values are never kept.
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key", "X-Amz-Security-Token", "X-Csrf-Token"}
+
+// builtinRedactPatterns find tokens in free text: header lines, bearer tokens, credentials in URLs
+// and token query parameters such as S3 signatures. The first group is kept before the mask, a second one after it.
+var builtinRedactPatterns = []string{
+	`(?i)((?:proxy-)?authorization:\s*)[^\r\n]+`,
+	`(?i)((?:set-)?cookie:\s*)[^\r\n]+`,
+	`(Bearer\s+)[A-Za-z0-9._~+/=-]{8,}`,
+	`(://[^/:@\s]+:)[^/@\s]+(@)`,
+	`(?i)([?&](?:access_token|id_token|refresh_token|token|api_key|apikey|key|password|secret|sig|signature|x-amz-signature|x-amz-credential|x-amz-security-token)=)[^&\s"']+`,
+}
+
+// redactor masks secrets in text and headers.
+type redactor struct {
+	patterns []*regexp.Regexp
+	secrets  []string // Literal secret values known from the config and environment
+}
+
+// activeRedactor is the redactor of the running command, set up by installRedaction.
+var activeRedactor = mustRedactor(newRedactor(nil, nil))
+
+// mustRedactor panics on the built-in patterns failing to compile.
+func mustRedactor(redactor *redactor, err error) *redactor {
+	if err != nil {
+		panic(err)
+	}
+	return redactor
+}
+
+// newRedactor compiles the built-in patterns plus extra ones; secrets are masked wherever they appear.
+func newRedactor(extra []string, secrets []string) (*redactor, error) {
+	redactor := &redactor{}
+	for _, pattern := range append(append([]string{}, builtinRedactPatterns...), extra...) {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern %q: %v", pattern, err)
+		}
+		redactor.patterns = append(redactor.patterns, compiled)
+	}
+	for _, secret := range secrets {
+		// Short values would mask ordinary words all over the log.
+		if len(secret) >= 6 {
+			redactor.secrets = append(redactor.secrets, secret)
+		}
+	}
+	return redactor, nil
+}
+
+// text masks every secret in text. Patterns keep their first capture group before the mask, e.g. the header name,
+// and a second one after it.
+func (redactor *redactor) text(text string) string {
+	for _, secret := range redactor.secrets {
+		text = strings.ReplaceAll(text, secret, redactedMask)
+	}
+	for _, pattern := range redactor.patterns {
+		replacement := redactedMask
+		switch {
+		case pattern.NumSubexp() >= 2:
+			replacement = "${1}" + redactedMask + "${2}"
+		case pattern.NumSubexp() == 1:
+			replacement = "${1}" + redactedMask
+		}
+		text = pattern.ReplaceAllString(text, replacement)
+	}
+	return text
+}
+
+// header returns a copy of header with credential headers masked and secrets removed from the rest.
+func (redactor *redactor) header(header http.Header) http.Header {
+	if header == nil {
+		return nil
+	}
+	redacted := make(http.Header, len(header))
+	for name, values := range header {
+		masked := make([]string, len(values))
+		for i, value := range values {
+			masked[i] = redactor.text(value)
+		}
+		redacted[name] = masked
+	}
+	for _, name := range sensitiveHeaders {
+		if values, ok := redacted[http.CanonicalHeaderKey(name)]; ok {
+			for i := range values {
+				values[i] = redactedMask
+			}
+		}
+	}
+	return redacted
+}
+
+// redactingWriter masks secrets in everything written through it.
+type redactingWriter struct {
+	redactor *redactor
+	out      io.Writer
+}
+
+// Write implements io.Writer; it reports the length of the unmasked input as written.
+func (writer redactingWriter) Write(data []byte) (int, error) {
+	if _, err := writer.out.Write([]byte(writer.redactor.text(string(data)))); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// configSecrets returns the secret values of the config and environment.
+func configSecrets(cfg *Config) []string {
+	secrets := []string{cfg.smtpPassword(), cfg.ehsToken(), cfg.graphClientSecret(), cfg.webDAVPassword(), cfg.shareSigningKey(), os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN")}
+	for _, key := range cfg.APIKeys {
+		secrets = append(secrets, key.Key)
+	}
+	return secrets
+}
+
+// installRedaction sets up the redactor of the config and sends the log through it.
+func installRedaction(cfg *Config) error {
+	redactor, err := newRedactor(cfg.RedactPatterns, configSecrets(cfg))
+	if err != nil {
+		return err
+	}
+	activeRedactor = redactor
+	log.SetOutput(redactingWriter{redactor: redactor, out: os.Stderr})
+	return nil
+}
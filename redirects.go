@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// redirectTrail collects the URLs one download went through, starting with its own.
+type redirectTrail struct {
+	mutex sync.Mutex
+	hops  []string
+}
+
+// redirectTrailKey carries a redirectTrail in a request context.
+type redirectTrailKey struct{}
+
+// withRedirectTrail returns a context whose requests note their redirects in the returned trail.
+func withRedirectTrail(ctx context.Context) (context.Context, *redirectTrail) {
+	trail := &redirectTrail{}
+	return context.WithValue(ctx, redirectTrailKey{}, trail), trail
+}
+
+// add notes one hop.
+func (trail *redirectTrail) add(hop string) {
+	trail.mutex.Lock()
+	defer trail.mutex.Unlock()
+	trail.hops = append(trail.hops, hop)
+}
+
+// chain returns the URLs gone through, nil when nothing was redirected.
+func (trail *redirectTrail) chain() []string {
+	trail.mutex.Lock()
+	defer trail.mutex.Unlock()
+	return append([]string(nil), trail.hops...)
+}
+
+// redirectedError is a failed download that was redirected on its way, such as one that ended on an
+// SAP dispatcher's login page. It reads like the error it wraps, followed by the chain.
+type redirectedError struct {
+	chain []string
+	err   error
+}
+
+// Error implements error.
+func (redirected *redirectedError) Error() string {
+	return fmt.Sprintf("%v (redirected %s)", redirected.err, strings.Join(redirected.chain, " -> "))
+}
+
+// Unwrap returns the error of the download, so its class is unchanged.
+func (redirected *redirectedError) Unwrap() error {
+	return redirected.err
+}
+
+// redirectHostAllowed reports whether a redirect from the first request of a chain may lead to target:
+// always to the same host, otherwise only to the hosts of redirect_hosts, any of them when it is
+// empty. An entry such as *.example.com covers the subdomains of example.com.
+func redirectHostAllowed(cfg *Config, origin *url.URL, target *url.URL) bool {
+	host := strings.ToLower(target.Hostname())
+	if len(cfg.RedirectHosts) == 0 || host == strings.ToLower(origin.Hostname()) {
+		return true
+	}
+	for _, allowed := range cfg.RedirectHosts {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if suffix, ok := strings.CutPrefix(allowed, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRedirect returns the redirect policy of download clients: at most max_redirects hops, each to
+// an allowed host. Every hop is noted in the trail of the request's context, when it has one.
+func checkRedirect(cfg *Config) func(request *http.Request, via []*http.Request) error {
+	return func(request *http.Request, via []*http.Request) error {
+		if trail, ok := request.Context().Value(redirectTrailKey{}).(*redirectTrail); ok {
+			if len(via) == 1 {
+				trail.add(activeRedactor.text(via[0].URL.Redacted()))
+			}
+			trail.add(activeRedactor.text(request.URL.Redacted()))
+		}
+		host := request.URL.Hostname()
+		if len(via) > cfg.MaxRedirects {
+			metrics.inc("sabic_redirects_total", map[string]string{"host": host, "outcome": "refused"})
+			return fmt.Errorf("%w: more than %d redirects", ErrRedirectRefused, cfg.MaxRedirects)
+		}
+		if !redirectHostAllowed(cfg, via[0].URL, request.URL) {
+			metrics.inc("sabic_redirects_total", map[string]string{"host": host, "outcome": "refused"})
+			return fmt.Errorf("%w: %s is not in redirect_hosts", ErrRedirectRefused, host)
+		}
+		metrics.inc("sabic_redirects_total", map[string]string{"host": host, "outcome": "followed"})
+		return nil
+	}
+}
+
+func init() {
+	metrics.describe("sabic_redirects_total", "Redirects of upstream requests by target host, followed or refused by the redirect policy.")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// migrateDocumentNames moves documents stored under a name an earlier release gave them to the name
+// documentFilename gives them now, with their catalog entries, so a change of naming doesn't make the
+// next sync download the corpus again. Documents that aren't keyed like SABIC's keep their names.
+func migrateDocumentNames(docs *catalog, store documentStore, materials materialMap, audit *auditLog) error {
+	var renamed int
+	for _, entry := range docs.all() {
+		id := entry.documentID()
+		if id.isZero() {
+			continue
+		}
+		target := documentFilename(materials, id)
+		if format, ok := documentFormats[entry.Format]; ok && entry.Format != "" {
+			target = strings.TrimSuffix(target, documentFormats[formatPDF].extension) + format.extension
+		}
+		if target == entry.Filename {
+			continue
+		}
+		if _, taken := docs.get(target); taken {
+			continue
+		}
+		if err := renameStoredDocument(store, entry.Filename, target, entry.SHA256); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("failed to rename %s to %s: %w", entry.Filename, target, err)
+		}
+		docs.rename(entry.Filename, target)
+		// The content went through put again, encrypting it when a key is configured.
+		docs.update(target, func(entry *catalogEntry) {
+			entry.Encrypted = storeKey(store) != nil
+		})
+		audit.record(auditRenamed, target, entry.Filename, entry.SHA256)
+		renamed = renamed + 1
+	}
+	if renamed == 0 {
+		return nil
+	}
+	log.Printf("renamed %d stored documents to their current names", renamed)
+	if err := store.flush(); err != nil {
+		return err
+	}
+	return docs.save()
+}
+
+// renameStoredDocument stores the content of a document under another name and removes the old one.
+// The content goes through put again, so every layout, encrypted or not, files it as it would a download.
+func renameStoredDocument(store documentStore, from, to, sha256 string) error {
+	plainPath, release, err := plaintextPath(store, from)
+	if err != nil {
+		return err
+	}
+	defer release()
+	source, err := os.Open(plainPath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	temp, err := os.CreateTemp("", "sds-rename-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(temp, source)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	newPath, err := store.put(temp.Name(), to, sha256)
+	if err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	store.forget(from)
+	// Layouts sharing content between names have nothing left under the old one.
+	if oldPath, ok := store.path(from); ok && oldPath != newPath {
+		return os.Remove(oldPath)
+	}
+	return nil
+}
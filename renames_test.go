@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// TestBaselineNamesKept checks that a sync over an output directory of uncatalogued files named the
+// way the first releases named them recognizes every one of them, instead of downloading them again
+// under new names.
+func TestBaselineNamesKept(t *testing.T) {
+	baseline := map[string]headerResult{
+		"22000485_630000052598_sds_ca_en.pdf": {MaterialNumber: "22000485", SubID: "630000052598", StorageLocation: "SDS_CA", LanguageISO: "EN"},
+		"22000485_630000052598_sds_ca_fr.pdf": {MaterialNumber: "22000485", SubID: "630000052598", StorageLocation: "SDS_CA", LanguageISO: "FR"},
+		"21002536_630000061424_sds_cn_zh.pdf": {MaterialNumber: "21002536", SubID: "630000061424", StorageLocation: "SDS_CN", LanguageISO: "ZH"},
+	}
+	var downloads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads.Add(1)
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	cfg := defaultConfig()
+	cfg.ServiceURL = server.URL + "/v1/SDS"
+	cfg.UseMetadata = false
+	cfg.LanguageCheck = false
+	cfg.InputFile = filepath.Join(dir, "headers.json")
+	cfg.OutputDir = filepath.Join(dir, "PDFs")
+	cfg.CatalogFile = filepath.Join(dir, "catalog.json")
+	cfg.AuditLog = filepath.Join(dir, "audit.jsonl")
+	cfg.ResponseStore = filepath.Join(dir, "responses.jsonl")
+	cfg.ManifestDir = filepath.Join(dir, "manifests")
+	cfg.SnapshotDir = filepath.Join(dir, "snapshots")
+	cfg.ScrapeState = filepath.Join(dir, "scrape-state.json")
+	if err := os.MkdirAll(cfg.OutputDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var headers []headerResult
+	for name, header := range baseline {
+		if err := os.WriteFile(filepath.Join(cfg.OutputDir, name), []byte("%PDF-1.4\n%%EOF\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		headers = append(headers, header)
+	}
+	listing, err := json.Marshal(map[string]any{"d": map[string]any{"results": headers}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cfg.InputFile, listing, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runSync(context.Background(), cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := downloads.Load(); n != 0 {
+		t.Errorf("the sync fetched %d documents that were already stored", n)
+	}
+	for name, header := range baseline {
+		id, err := documentIDFromURL(documentURL(cfg, header))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := id.filename(); got != name {
+			t.Errorf("%v is named %s, not %s as before", id, got, name)
+		}
+		if !fileExists(filepath.Join(cfg.OutputDir, name)) {
+			t.Errorf("%s is gone after the sync", name)
+		}
+	}
+	entries, err := os.ReadDir(cfg.OutputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(baseline) {
+		t.Errorf("the output directory holds %d files, want the %d it started with", len(entries), len(baseline))
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Report types the EHS service serves besides safety data sheets.
+const (
+	reportTypeSDS      = "SDS"      // Safety data sheet
+	reportTypeLabel    = "LABEL"    // GHS label
+	reportTypeTremcard = "TREMCARD" // Transport emergency card
+)
+
+// reportTypeAliases maps the Sbgvid prefixes tenants use to the report type they stand for.
+var reportTypeAliases = map[string]string{
+	"LBL":      reportTypeLabel,
+	"GHSLABEL": reportTypeLabel,
+	"GHS":      reportTypeLabel,
+	"TREM":     reportTypeTremcard,
+	"TEC":      reportTypeTremcard,
+}
+
+// defaultReportTypeDirs are the output subdirectories of the report types besides SDS, which stay
+// at the top of the output directory where they always were.
+var defaultReportTypeDirs = map[string]string{
+	reportTypeLabel:    "labels",
+	reportTypeTremcard: "tremcards",
+}
+
+// defaultReportTypeValidation holds the built-in checks of report types without an entry in
+// Config.Validation. Labels and cards are short; a long document under their variant is a sheet
+// filed under the wrong one.
+var defaultReportTypeValidation = map[string][]ValidationCheck{
+	reportTypeLabel:    {{Check: "pages", Min: 1, Max: 4}},
+	reportTypeTremcard: {{Check: "pages", Min: 1, Max: 6}},
+}
+
+// reportTypeOf returns the report type of an Sbgvid prefix, resolving aliases, e.g. LABEL for LBL.
+func reportTypeOf(prefix string) string {
+	prefix = strings.ToUpper(strings.TrimSpace(prefix))
+	if reportType, ok := reportTypeAliases[prefix]; ok {
+		return reportType
+	}
+	return prefix
+}
+
+// reportType returns the report type of a document, the Sbgvid prefix with aliases resolved.
+func (id DocumentID) reportType() string {
+	// SDS_FR is an SDS for the French region, LBL_FR a label for it.
+	prefix, _, _ := strings.Cut(id.Sbgvid, "_")
+	return reportTypeOf(prefix)
+}
+
+// reportTypeOfFilename returns the report type of a stored file name, empty when it isn't named like SABIC's documents.
+func reportTypeOfFilename(filename string) string {
+	document, ok := parseDocumentFilename(filename)
+	if !ok {
+		return ""
+	}
+	prefix, _, _ := strings.Cut(document.Sbgvid, "_")
+	return reportTypeOf(prefix)
+}
+
+// matchesReportType reports whether a report type passes a comma separated filter such as
+// "SDS,LABEL"; an empty filter passes everything. Aliases match their report type.
+func matchesReportType(filter string, reportType string) bool {
+	if strings.TrimSpace(filter) == "" {
+		return true
+	}
+	for _, wanted := range strings.Split(filter, ",") {
+		if reportTypeOf(wanted) == reportType {
+			return true
+		}
+	}
+	return false
+}
+
+// reportTypeStore keeps the documents of some report types in their own subdirectories of the
+// output directory, each in the configured layout, and everything else in the store at its top.
+type reportTypeStore struct {
+	root  documentStore
+	trees map[string]documentStore // Stores of report types with their own tree
+	dirs  map[string]string        // Directory of each of those stores, created on first use
+}
+
+// newReportTypeStore opens the subdirectory stores of the report types in dirs; open opens the
+// store of one directory. Without any it returns root itself.
+func newReportTypeStore(root documentStore, rootDir string, dirs map[string]string, open func(dir string) (documentStore, error)) (documentStore, error) {
+	if len(dirs) == 0 {
+		return root, nil
+	}
+	store := &reportTypeStore{root: root, trees: make(map[string]documentStore), dirs: make(map[string]string)}
+	for reportType, dir := range dirs {
+		dir = filepath.Join(rootDir, dir)
+		tree, err := open(dir)
+		if err != nil {
+			return nil, err
+		}
+		store.trees[reportTypeOf(reportType)] = tree
+		store.dirs[reportTypeOf(reportType)] = dir
+	}
+	return store, nil
+}
+
+// route returns the store a document belongs in.
+func (store *reportTypeStore) route(filename string) documentStore {
+	if tree, ok := store.trees[reportTypeOfFilename(filename)]; ok {
+		return tree
+	}
+	return store.root
+}
+
+// each returns the root store and the stores of the trees created so far.
+func (store *reportTypeStore) each() []documentStore {
+	stores := []documentStore{store.root}
+	for _, reportType := range sortedKeys(store.trees) {
+		if directoryExists(store.dirs[reportType]) {
+			stores = append(stores, store.trees[reportType])
+		}
+	}
+	return stores
+}
+
+// put implements documentStore.
+func (store *reportTypeStore) put(tempPath string, filename string, sha256 string) (string, error) {
+	if dir, ok := store.dirs[reportTypeOfFilename(filename)]; ok {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return "", err
+		}
+	}
+	return store.route(filename).put(tempPath, filename, sha256)
+}
+
+// path implements documentStore. A document stored before its report type got its own tree is still
+// found at the top.
+func (store *reportTypeStore) path(filename string) (string, bool) {
+	if path, ok := store.route(filename).path(filename); ok {
+		return path, true
+	}
+	return store.root.path(filename)
+}
+
+// locate implements documentStore.
+func (store *reportTypeStore) locate(filename string) (string, bool) {
+	return store.route(filename).locate(filename)
+}
+
+// names implements documentStore.
+func (store *reportTypeStore) names() ([]string, error) {
+	seen := make(map[string]bool)
+	for _, tree := range store.each() {
+		treeNames, err := tree.names()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range treeNames {
+			seen[name] = true
+		}
+	}
+	return sortedKeys(seen), nil
+}
+
+// flush implements documentStore.
+func (store *reportTypeStore) flush() error {
+	var firstErr error
+	for _, tree := range store.each() {
+		if err := tree.flush(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// forget implements documentStore.
+func (store *reportTypeStore) forget(filename string) {
+	store.route(filename).forget(filename)
+}
+
+// orphans implements documentStore. A content-addressed store at the top walks the subdirectories
+// too, so stale temporary files may be found twice.
+func (store *reportTypeStore) orphans(referenced map[string]bool) ([]string, error) {
+	found := make(map[string]bool)
+	for _, tree := range store.each() {
+		treeOrphans, err := tree.orphans(referenced)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range treeOrphans {
+			found[path] = true
+		}
+	}
+	return sortedKeys(found), nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// responseRecord is what is kept of the upstream response of a document's last successful fetch,
+// so content type and caching problems can be debugged without fetching it again.
+type responseRecord struct {
+	URL       string      `json:"url"`
+	Status    string      `json:"status"`
+	FetchedAt time.Time   `json:"fetched_at"`
+	Header    http.Header `json:"header"`
+}
+
+// openResponseStore opens the configured response header store, nil when it is disabled.
+func openResponseStore(cfg *Config) (*kvStore, error) {
+	if cfg.ResponseStore == "" {
+		return nil, nil
+	}
+	return openKVStore(cfg.ResponseStore)
+}
+
+// recordResponse keeps the response headers of a stored document; failures only cost debugging data.
+func (fetcher *downloader) recordResponse(staged *stagedDocument) {
+	if fetcher.responses == nil || staged.response == nil {
+		return
+	}
+	// Cookies and tokens must not end up on disk.
+	record := *staged.response
+	record.URL = activeRedactor.text(record.URL)
+	record.Header = activeRedactor.header(record.Header)
+	if err := fetcher.responses.put(staged.filename, record); err != nil {
+		log.Println("Failed to record response headers:", err)
+	}
+}
+
+// validators returns the conditional request headers of a document's last fetch, nil when its
+// response headers weren't kept or carry neither an ETag nor a Last-Modified date.
+func (fetcher *downloader) validators(filename string) http.Header {
+	if fetcher.responses == nil {
+		return nil
+	}
+	var record responseRecord
+	if ok, err := fetcher.responses.get(filename, &record); err != nil || !ok {
+		return nil
+	}
+	validators := make(http.Header)
+	if etag := record.Header.Get("ETag"); etag != "" && etag != redactedMask {
+		validators.Set("If-None-Match", etag)
+	}
+	if modified := record.Header.Get("Last-Modified"); modified != "" && modified != redactedMask {
+		validators.Set("If-Modified-Since", modified)
+	}
+	if len(validators) == 0 {
+		return nil
+	}
+	return validators
+}
+
+// recordNotModified notes a revalidation the upstream answered with 304, like an unchanged download.
+func (fetcher *downloader) recordNotModified(filename string, sdsURL string) {
+	entry, _ := fetcher.catalog.get(filename)
+	fetcher.audit.record(auditVerified, filename, sdsURL, entry.SHA256)
+	now := time.Now().UTC()
+	fetcher.catalog.update(filename, func(entry *catalogEntry) {
+		entry.RevalidatedAt = &now
+	})
+	log.Printf("not modified upstream: %s (issued %s)", filename, entry.IssueDate)
+}
+
+// resolveDocumentID finds the catalog file name a document ID given on the command line refers to:
+// the file name itself, or the same without .pdf or in other case.
+func resolveDocumentID(docs *catalog, id string) (string, bool) {
+	for _, candidate := range []string{id, id + ".pdf", strings.ToLower(id), strings.ToLower(id) + ".pdf"} {
+		if _, ok := docs.get(candidate); ok {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// runShow implements the show command: it prints the catalog entry of a document and the
+// response headers of its last successful fetch.
+func runShow(args []string) error {
+	var asJSON bool
+	cfg, rest, err := loadConfig("show", args, func(flagSet *flag.FlagSet) {
+		flagSet.BoolVar(&asJSON, "json", false, "print the document as JSON")
+	})
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return fmt.Errorf("usage: show [flags] <document file name>")
+	}
+	docs, err := openCatalog(cfg.CatalogFile)
+	if err != nil {
+		return err
+	}
+	filename, ok := resolveDocumentID(docs, rest[0])
+	if !ok {
+		return fmt.Errorf("%s is not in the catalog", rest[0])
+	}
+	entry, _ := docs.get(filename)
+	var response *responseRecord
+	responses, err := openResponseStore(cfg)
+	if err != nil {
+		return err
+	}
+	if responses != nil {
+		defer responses.close()
+		var record responseRecord
+		found, err := responses.get(filename, &record)
+		if err != nil {
+			return err
+		}
+		if found {
+			response = &record
+		}
+	}
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(map[string]any{"document": entry, "response": response})
+	}
+	fmt.Printf("document:       %s\n", entry.Filename)
+	fmt.Printf("source:         %s\n", entry.Source)
+	fmt.Printf("source url:     %s\n", entry.SourceURL)
+	fmt.Printf("sha256:         %s\n", entry.SHA256)
+	fmt.Printf("size:           %d\n", entry.Size)
+	fmt.Printf("downloaded at:  %s\n", entry.DownloadedAt.Format(time.RFC3339))
+	if entry.IssueDate != "" {
+		fmt.Printf("issue date:     %s (from %s)\n", entry.IssueDate, entry.IssueDateFrom)
+	}
+	if entry.ProductFamily != "" {
+		fmt.Printf("product family: %s\n", entry.ProductFamily)
+	}
+	if response == nil {
+		fmt.Println("\nno response headers recorded")
+		return nil
+	}
+	fmt.Printf("\nlast successful fetch at %s: %s\n", response.FetchedAt.Format(time.RFC3339), response.Status)
+	fmt.Printf("  GET %s\n", response.URL)
+	names := make([]string, 0, len(response.Header))
+	for name := range response.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range response.Header[name] {
+			fmt.Printf("  %s: %s\n", name, value)
+		}
+	}
+	return nil
+}
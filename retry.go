@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy says whether and how a failed download is retried. The first policy of
+// Config.RetryPolicies whose Match fits the error applies, then the built-in ones.
+type RetryPolicy struct {
+	Match    []string `json:"match"`     // Error classes (see errors.go), "validation", status codes or ranges such as "404", "500-599" or "5xx"
+	Retries  int      `json:"retries"`   // Attempts after the first, 0 never retries
+	Delay    Duration `json:"delay"`     // Wait before the first retry
+	MaxDelay Duration `json:"max_delay"` // Cap of the growing wait, 0 is uncapped
+	Backoff  float64  `json:"backoff"`   // Factor the wait grows by with each retry, 1 or less keeps it constant
+	Pause    bool     `json:"pause"`     // Hold every worker during the wait instead of just this one, as for throttling
+}
+
+// validationClasses are the error classes "validation" stands for: the response arrived but was rejected.
+var validationClasses = []string{"not_pdf", "wrong_format", "size_out_of_range", "validation_failed", "checksum_mismatch"}
+
+// builtinRetryPolicies apply after the configured ones. Throttling keeps honoring the
+// throttle_* settings; missing documents are never retried, and truncated bodies and network blips are retried quickly.
+func builtinRetryPolicies(cfg *Config) []RetryPolicy {
+	return []RetryPolicy{
+		{Match: []string{"throttled"}, Retries: cfg.ThrottleRetries, Delay: cfg.ThrottleDelay, MaxDelay: cfg.MaxRetryAfter, Backoff: 1, Pause: true},
+		{Match: []string{"not_found"}, Retries: 0},
+		{Match: []string{"truncated"}, Retries: 3, Delay: Duration{2 * time.Second}, MaxDelay: Duration{30 * time.Second}, Backoff: 2},
+		{Match: []string{"network"}, Retries: 3, Delay: Duration{2 * time.Second}, MaxDelay: Duration{30 * time.Second}, Backoff: 2},
+		{Match: []string{"5xx"}, Retries: 2, Delay: Duration{10 * time.Second}, MaxDelay: Duration{time.Minute}, Backoff: 2},
+	}
+}
+
+// matchesRetryPattern reports whether one Match entry fits an error of the given class and HTTP status, 0 when it had none.
+func matchesRetryPattern(pattern string, class string, statusCode int) (bool, error) {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	switch {
+	case pattern == "validation":
+		for _, validation := range validationClasses {
+			if class == validation {
+				return true, nil
+			}
+		}
+		return false, nil
+	case errorForClass(pattern) != nil || pattern == "other":
+		return class == pattern, nil
+	case len(pattern) == 3 && strings.HasSuffix(pattern, "xx"):
+		hundreds, err := strconv.Atoi(pattern[:1])
+		if err != nil {
+			return false, fmt.Errorf("invalid retry match %q", pattern)
+		}
+		return statusCode/100 == hundreds, nil
+	}
+	low, high, isRange := strings.Cut(pattern, "-")
+	if !isRange {
+		high = low
+	}
+	from, err := strconv.Atoi(low)
+	if err != nil {
+		return false, fmt.Errorf("invalid retry match %q: want an error class, a status code or a range", pattern)
+	}
+	to, err := strconv.Atoi(high)
+	if err != nil || to < from {
+		return false, fmt.Errorf("invalid retry match %q: want an error class, a status code or a range", pattern)
+	}
+	return statusCode >= from && statusCode <= to, nil
+}
+
+// validateRetryPolicies reports the first malformed retry policy, so a typo fails the run up front.
+func validateRetryPolicies(cfg *Config) error {
+	for i, policy := range cfg.RetryPolicies {
+		if len(policy.Match) == 0 {
+			return fmt.Errorf("retry policy %d matches nothing", i+1)
+		}
+		for _, pattern := range policy.Match {
+			if _, err := matchesRetryPattern(pattern, "", 0); err != nil {
+				return fmt.Errorf("retry policy %d: %v", i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+// retryPolicyFor returns the policy for a download error, false when it should not be retried.
+func retryPolicyFor(cfg *Config, err error) (RetryPolicy, bool) {
+	class := errorClass(err)
+	// A document that is already stored or unchanged is a skip, not a failure.
+	if class == "already_exists" || class == "not_modified" {
+		return RetryPolicy{}, false
+	}
+	var statusErr *statusCodeError
+	var statusCode int
+	if errors.As(err, &statusErr) {
+		statusCode = statusErr.statusCode
+	}
+	// Build a new slice; appending to the config's could race between workers.
+	policies := make([]RetryPolicy, 0, len(cfg.RetryPolicies)+4)
+	policies = append(append(policies, cfg.RetryPolicies...), builtinRetryPolicies(cfg)...)
+	for _, policy := range policies {
+		for _, pattern := range policy.Match {
+			if matched, _ := matchesRetryPattern(pattern, class, statusCode); matched {
+				return policy, policy.Retries > 0
+			}
+		}
+	}
+	return RetryPolicy{}, false
+}
+
+// retryDelay returns the wait before retry number attempt (0 for the first), honoring Retry-After when configured.
+func retryDelay(cfg *Config, policy RetryPolicy, attempt int, err error) time.Duration {
+	delay := policy.Delay.Duration
+	for range attempt {
+		if policy.Backoff <= 1 {
+			break
+		}
+		delay = time.Duration(float64(delay) * policy.Backoff)
+	}
+	var throttled *throttledError
+	if cfg.RespectRetryAfter && errors.As(err, &throttled) && throttled.retryAfter > 0 {
+		delay = throttled.retryAfter
+	}
+	// A misconfigured gateway shouldn't stall the run for hours.
+	if policy.MaxDelay.Duration > 0 && delay > policy.MaxDelay.Duration {
+		delay = policy.MaxDelay.Duration
+	}
+	return delay
+}
+
+// fetchWithRetry fetches a document once the download window and the throttle gate allow it,
+// retrying failures as their retry policy says. Pausing policies close the gate for every worker.
+func (fetcher *downloader) fetchWithRetry(ctx context.Context, doc documentRef, window *downloadWindow, stagingDir string) (*stagedDocument, error) {
+	for attempt := 0; ; attempt++ {
+		// Hold off while outside the allowed download hours.
+		if err := waitForDownloadWindow(ctx, window); err != nil {
+			return nil, err
+		}
+		// Hold off while the upstream wants us to.
+		if err := fetcher.throttle.wait(ctx); err != nil {
+			return nil, err
+		}
+		staged, err := fetcher.fetchPDF(ctx, doc, stagingDir)
+		if err == nil || ctx.Err() != nil {
+			return staged, err
+		}
+		policy, retry := retryPolicyFor(fetcher.cfg, err)
+		if !retry || attempt >= policy.Retries {
+			return staged, err
+		}
+		delay := retryDelay(fetcher.cfg, policy, attempt, err)
+		class := errorClass(err)
+		metrics.inc("sabic_retries_total", map[string]string{"class": class})
+		if policy.Pause {
+			until := fetcher.throttle.hold(delay)
+			if class == "throttled" {
+				metrics.inc("sabic_throttle_events_total", map[string]string{"source": doc.source})
+			}
+			log.Printf("%s from %s, pausing all downloads until %s (attempt %d of %d): %v",
+				class, doc.source, until.Format(time.RFC3339), attempt+1, policy.Retries+1, err)
+			continue
+		}
+		log.Printf("%s, retrying in %s (attempt %d of %d): %v", class, delay, attempt+1, policy.Retries+1, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func init() {
+	metrics.describe("sabic_retries_total", "Download retries, by error class.")
+}
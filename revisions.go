@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// revisionStampLayout starts the name of an archived revision: when it was superseded.
+const revisionStampLayout = "20060102T150405Z"
+
+// archiveRevision copies the stored copy of a document into the revision archive before a newer
+// revision replaces it, as revision_dir/<document>/<superseded at>-<sha256 prefix>.pdf.
+func archiveRevision(cfg *Config, store documentStore, entry catalogEntry, now time.Time) (string, error) {
+	storedPath, ok := store.path(entry.Filename)
+	if !ok {
+		return "", nil
+	}
+	dir, err := joinWithin(cfg.RevisionDir, strings.TrimSuffix(entry.Filename, ".pdf"))
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	hash := entry.SHA256
+	if len(hash) > 12 {
+		hash = hash[:12]
+	}
+	archivePath := filepath.Join(dir, now.UTC().Format(revisionStampLayout)+"-"+hash+".pdf")
+	return archivePath, copyFileAtomically(storedPath, archivePath)
+}
+
+// purgedRevision is one archived revision removed by the retention policy.
+type purgedRevision struct {
+	Document     string    // Stored file name of the document
+	Path         string    // Path of the archived revision
+	SupersededAt time.Time // When a newer revision replaced it
+	Size         int64
+}
+
+// pruneRevisions enforces the retention of the revision archive: per document, revisions among the newest
+// revision_keep or superseded within revision_max_age are kept, and the rest removed unless dryRun.
+// With only one of the two set, that one alone decides; with neither, everything is kept.
+func pruneRevisions(cfg *Config, now time.Time, dryRun bool) ([]purgedRevision, error) {
+	if cfg.RevisionDir == "" || (cfg.RevisionKeep <= 0 && cfg.RevisionMaxAge.Duration <= 0) {
+		return nil, nil
+	}
+	documents, err := os.ReadDir(cfg.RevisionDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var purged []purgedRevision
+	for _, document := range documents {
+		if !document.IsDir() {
+			continue
+		}
+		dir := filepath.Join(cfg.RevisionDir, document.Name())
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return purged, err
+		}
+		var revisions []os.DirEntry
+		for _, entry := range entries {
+			if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".pdf") && !strings.HasPrefix(entry.Name(), ".") {
+				revisions = append(revisions, entry)
+			}
+		}
+		// Newest first; the stamp at the start of the name sorts by time.
+		sort.Slice(revisions, func(i, j int) bool { return revisions[i].Name() > revisions[j].Name() })
+		for i, revision := range revisions {
+			stamp, _, _ := strings.Cut(revision.Name(), "-")
+			supersededAt, err := time.Parse(revisionStampLayout, stamp)
+			if err != nil {
+				// Not one of ours; leave it be.
+				continue
+			}
+			keptByCount := cfg.RevisionKeep > 0 && i < cfg.RevisionKeep
+			keptByAge := cfg.RevisionMaxAge.Duration > 0 && now.Sub(supersededAt) <= cfg.RevisionMaxAge.Duration
+			if keptByCount || keptByAge {
+				continue
+			}
+			path := filepath.Join(dir, revision.Name())
+			var size int64
+			if info, err := revision.Info(); err == nil {
+				size = info.Size()
+			}
+			if !dryRun {
+				if err := os.Remove(path); err != nil {
+					return purged, fmt.Errorf("failed to remove revision %s: %v", path, err)
+				}
+			}
+			purged = append(purged, purgedRevision{Document: document.Name() + ".pdf", Path: path, SupersededAt: supersededAt, Size: size})
+		}
+		// Drop the document's directory once its last revision is gone.
+		if !dryRun {
+			os.Remove(dir)
+		}
+	}
+	return purged, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+)
+
+// newRunID returns a random (version 4) UUID identifying one sync run in logs, metrics and manifests.
+func newRunID() string {
+	var id [16]byte
+	rand.Read(id[:])
+	id[6] = id[6]&0x0f | 0x40 // Version 4
+	id[8] = id[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16])
+}
+
+// startRunLogging prefixes every log line with the run ID, so overlapping runs can be told apart
+// in a shared log. The returned function restores the previous prefix.
+func startRunLogging(runID string) func() {
+	previous, flags := log.Prefix(), log.Flags()
+	// Keep the timestamp first, where log readers expect it.
+	log.SetFlags(flags | log.Lmsgprefix)
+	log.SetPrefix(previous + "run=" + runID + " ")
+	return func() {
+		log.SetPrefix(previous)
+		log.SetFlags(flags)
+	}
+}
+
+// recordRunID publishes the ID of the current run as sabic_run_info. Only the latest run is kept,
+// so the label never grows the number of series.
+func recordRunID(runID string) {
+	metrics.set("sabic_run_info", map[string]string{"run_id": runID}, 1)
+}
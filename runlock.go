@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// lockOwner is the content of a run lock file, naming the process that holds it.
+type lockOwner struct {
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// runLock is the held run lock; only one process changes an output directory and its catalog at a
+// time. It is an advisory lock of the operating system on the lock file, so it goes away with the
+// process holding it however that ends, and a lock file left behind is never mistaken for a held one.
+type runLock struct {
+	file *os.File
+}
+
+// heldRunLocks counts the run locks this process holds.
+var heldRunLocks atomic.Int32
+
+// runLockPath returns the lock file of a config: the configured one, or one beside the output
+// directory so it never shows up among the documents.
+func runLockPath(cfg *Config) string {
+	if cfg.LockFile != "" {
+		return cfg.LockFile
+	}
+	return filepath.Clean(cfg.OutputDir) + ".lock"
+}
+
+// acquireRunLock takes the run lock. It fails when another process holds it.
+func acquireRunLock(cfg *Config) (*runLock, error) {
+	path := runLockPath(cfg)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	// The file is never removed: a process that opened it just before a removal would lock a file
+	// nobody else can see any more.
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file %s: %v", path, err)
+	}
+	locked, err := lockFile(file, false)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to lock %s: %v", path, err)
+	}
+	if !locked {
+		holder := describeRunLock(file)
+		file.Close()
+		return nil, fmt.Errorf("another process is working on %s (%s)", cfg.OutputDir, holder)
+	}
+	// Whatever the file still says is about an earlier holder; name this one for the next process to find it.
+	host, _ := os.Hostname()
+	owner, err := json.Marshal(lockOwner{PID: os.Getpid(), Host: host, StartedAt: time.Now().UTC()})
+	if err == nil {
+		if err = file.Truncate(0); err == nil {
+			_, err = file.WriteAt(owner, 0)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to write the owner of lock file %s: %v", path, err)
+	}
+	heldRunLocks.Add(1)
+	return &runLock{file: file}, nil
+}
+
+// describeRunLock names the holder of a lock from what it wrote into the lock file.
+func describeRunLock(file *os.File) string {
+	content, err := io.ReadAll(io.NewSectionReader(file, 0, 1<<16))
+	var owner lockOwner
+	if err != nil || json.Unmarshal(content, &owner) != nil {
+		// A holder that is writing it right now, or one that couldn't.
+		return "an unknown process"
+	}
+	return fmt.Sprintf("pid %d on %s since %s", owner.PID, owner.Host, owner.StartedAt.Format(time.RFC3339))
+}
+
+// release lets go of the lock, leaving the lock file in place.
+func (lock *runLock) release() {
+	if err := lock.file.Truncate(0); err != nil {
+		log.Println("Failed to clear lock file:", err)
+	}
+	if err := unlockFile(lock.file); err != nil {
+		log.Println("Failed to unlock lock file:", err)
+	}
+	lock.file.Close()
+	heldRunLocks.Add(-1)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Client talks to S3 or an S3 compatible store such as MinIO with hand-signed (SigV4) requests,
+// which is all object uploads and lookups need; credentials come from the usual AWS_* variables.
+type s3Client struct {
+	endpoint     string // Custom endpoint for compatible stores, addressed path-style; empty for AWS
+	region       string
+	bucket       string
+	accessKey    string
+	secretKey    string
+	sessionToken string // Set for temporary credentials
+	client       *http.Client
+}
+
+// newS3Client returns a client for a bucket, reading credentials from AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+func newS3Client(cfg *Config, bucket string) (*s3Client, error) {
+	region := cfg.S3Region
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set to use s3://%s", bucket)
+	}
+	return &s3Client{
+		endpoint:     strings.TrimSuffix(cfg.S3Endpoint, "/"),
+		region:       region,
+		bucket:       bucket,
+		accessKey:    accessKey,
+		secretKey:    secretKey,
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		client:       &http.Client{Timeout: 10 * time.Minute},
+	}, nil
+}
+
+// Object Lock retention modes. Governance retention can be lifted by users with
+// s3:BypassGovernanceRetention; compliance retention can't be shortened by anyone, root included.
+const (
+	s3LockGovernance = "GOVERNANCE"
+	s3LockCompliance = "COMPLIANCE"
+)
+
+// s3LockHeaders returns the Object Lock headers of an upload at now, or nil when uploads aren't locked.
+// The bucket must have been created with Object Lock enabled for S3 to accept them.
+func s3LockHeaders(cfg *Config, now time.Time) (http.Header, error) {
+	header := make(http.Header)
+	switch cfg.S3LockMode {
+	case "":
+	case s3LockGovernance, s3LockCompliance:
+		if cfg.S3LockDays <= 0 {
+			return nil, fmt.Errorf("s3_lock_mode %s needs s3_lock_days above 0", cfg.S3LockMode)
+		}
+		header.Set("x-amz-object-lock-mode", cfg.S3LockMode)
+		header.Set("x-amz-object-lock-retain-until-date", now.UTC().AddDate(0, 0, cfg.S3LockDays).Format(time.RFC3339))
+	default:
+		return nil, fmt.Errorf("unknown s3_lock_mode %q, expected %s or %s", cfg.S3LockMode, s3LockGovernance, s3LockCompliance)
+	}
+	if cfg.S3LegalHold {
+		header.Set("x-amz-object-lock-legal-hold", "ON")
+	}
+	if len(header) == 0 {
+		return nil, nil
+	}
+	return header, nil
+}
+
+// fileMD5 returns the base64 MD5 of a file for Content-MD5, which S3 asks of locked uploads.
+func fileMD5(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hasher := md5.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// objectURL returns the URL of a key: virtual-hosted on AWS, path-style on a custom endpoint.
+func (s3 *s3Client) objectURL(key string) string {
+	escaped := s3EscapePath(key)
+	if s3.endpoint != "" {
+		return s3.endpoint + "/" + s3.bucket + "/" + escaped
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s3.bucket, s3.region, escaped)
+}
+
+// s3EscapePath percent-encodes a key the way SigV4 expects, keeping the slashes.
+func s3EscapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// putObject uploads a file under key. The SHA-256 is sent as the payload hash and as the object's
+// checksum, so S3 rejects the upload if a single byte arrives changed. Extra headers such as
+// object lock settings are signed along.
+func (s3 *s3Client) putObject(ctx context.Context, key string, path string, sha256Hex string, extra http.Header) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPut, s3.objectURL(key), file)
+	if err != nil {
+		return err
+	}
+	request.ContentLength = info.Size()
+	digest, err := hex.DecodeString(sha256Hex)
+	if err != nil {
+		return fmt.Errorf("invalid sha256 %q: %v", sha256Hex, err)
+	}
+	request.Header.Set("Content-Type", "application/pdf")
+	request.Header.Set("x-amz-checksum-sha256", base64.StdEncoding.EncodeToString(digest))
+	request.Header.Set("x-amz-meta-sha256", sha256Hex)
+	for name, values := range extra {
+		request.Header[http.CanonicalHeaderKey(name)] = values
+	}
+	response, err := s3.do(request, sha256Hex)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+// headObject returns the SHA-256 of a stored object in hex, false when there is no such object.
+func (s3 *s3Client) headObject(ctx context.Context, key string) (string, bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodHead, s3.objectURL(key), nil)
+	if err != nil {
+		return "", false, err
+	}
+	request.Header.Set("x-amz-checksum-mode", "ENABLED")
+	response, err := s3.do(request, emptySHA256)
+	if err != nil {
+		var statusErr *statusCodeError
+		if errors.As(err, &statusErr) && statusErr.statusCode == http.StatusNotFound {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	response.Body.Close()
+	// The checksum S3 verified on upload wins over our own metadata.
+	if checksum := response.Header.Get("x-amz-checksum-sha256"); checksum != "" {
+		if digest, err := base64.StdEncoding.DecodeString(checksum); err == nil {
+			return hex.EncodeToString(digest), true, nil
+		}
+	}
+	return response.Header.Get("x-amz-meta-sha256"), true, nil
+}
+
+// emptySHA256 is the SHA-256 of an empty payload, signed for requests without a body.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// do signs and sends a request, turning non-2xx responses into statusCodeErrors.
+func (s3 *s3Client) do(request *http.Request, payloadSHA256 string) (*http.Response, error) {
+	s3.sign(request, payloadSHA256, time.Now().UTC())
+	response, err := s3.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %v", request.Method, request.URL.Redacted(), err)
+	}
+	if response.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 4<<10))
+		response.Body.Close()
+		return nil, &statusCodeError{statusCode: response.StatusCode, err: fmt.Errorf("%s %s: %s %s", request.Method, request.URL.Redacted(), response.Status, strings.TrimSpace(string(body)))}
+	}
+	return response, nil
+}
+
+// sign adds the AWS Signature Version 4 headers to a request.
+func (s3 *s3Client) sign(request *http.Request, payloadSHA256 string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	request.Header.Set("x-amz-date", amzDate)
+	request.Header.Set("x-amz-content-sha256", payloadSHA256)
+	if s3.sessionToken != "" {
+		request.Header.Set("x-amz-security-token", s3.sessionToken)
+	}
+	// Sign the host and every x-amz-* and content header.
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" || lower == "content-md5" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadSHA256,
+	}, "\n")
+	scope := day + "/" + s3.region + "/s3/aws4_request"
+	hashedRequest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashedRequest[:])
+	key := hmacSHA256([]byte("AWS4"+s3.secretKey), day)
+	for _, part := range []string{s3.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s3.accessKey, scope, signedHeaders, signature))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data under key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"log"
+)
+
+// downloadSampler picks a deterministic pseudo-random subset of the planned documents for QA runs.
+// Each URL gets a score from a seeded hash, so the same seed and input always give the same sample
+// and a larger sample contains every document of a smaller one.
+type downloadSampler struct {
+	fraction float64     // Keep documents scoring below this, 0 when sampling by count
+	count    int         // Keep this many lowest-scoring documents, 0 when sampling by fraction
+	seed     uint64      // Varies the sample between runs that want a different one
+	kept     sampledHeap // Lowest-scoring documents so far, when sampling by count
+	offered  int         // Documents considered
+}
+
+// newDownloadSampler returns the configured sampler, or nil when every document is wanted.
+func newDownloadSampler(cfg *Config) (*downloadSampler, error) {
+	switch {
+	case cfg.Sample < 0 || cfg.Sample > 1:
+		return nil, fmt.Errorf("sample must be a fraction between 0 and 1, got %v", cfg.Sample)
+	case cfg.Sample > 0 && cfg.SampleCount > 0:
+		return nil, fmt.Errorf("sample and sample_count can't be combined")
+	case cfg.Sample > 0 && cfg.Sample < 1:
+		return &downloadSampler{fraction: cfg.Sample, seed: cfg.SampleSeed}, nil
+	case cfg.SampleCount > 0:
+		return &downloadSampler{count: cfg.SampleCount, seed: cfg.SampleSeed}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// score maps a URL to [0, 1), using the 53 bits a float64 holds exactly.
+func (sampler *downloadSampler) score(url string) float64 {
+	hash := fnv.New64a()
+	var seed [8]byte
+	binary.BigEndian.PutUint64(seed[:], sampler.seed)
+	hash.Write(seed[:])
+	hash.Write([]byte(url))
+	return float64(hash.Sum64()>>11) / float64(uint64(1)<<53)
+}
+
+// offer considers one planned document, sending it on right away when sampling by fraction.
+func (sampler *downloadSampler) offer(doc documentRef, send func(doc documentRef) error) error {
+	sampler.offered = sampler.offered + 1
+	score := sampler.score(doc.url)
+	if sampler.count == 0 {
+		if score < sampler.fraction {
+			return send(doc)
+		}
+		return nil
+	}
+	// Keep the count lowest scores, dropping the highest when full.
+	if sampler.kept.Len() < sampler.count {
+		heap.Push(&sampler.kept, sampledDocument{score: score, doc: doc})
+	} else if score < sampler.kept[0].score {
+		sampler.kept[0] = sampledDocument{score: score, doc: doc}
+		heap.Fix(&sampler.kept, 0)
+	}
+	return nil
+}
+
+// flush sends the documents sampled by count, once every document was offered.
+func (sampler *downloadSampler) flush(send func(doc documentRef) error) error {
+	if sampler.count == 0 {
+		log.Printf("sampling about %.2f%% of %d planned documents (seed %d)", sampler.fraction*100, sampler.offered, sampler.seed)
+		return nil
+	}
+	log.Printf("sampling %d of %d planned documents (seed %d)", min(sampler.kept.Len(), sampler.count), sampler.offered, sampler.seed)
+	for _, sampled := range sampler.kept {
+		if err := send(sampled.doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sampledDocument is a document held by a count sampler.
+type sampledDocument struct {
+	score float64
+	doc   documentRef
+}
+
+// sampledHeap is a max-heap of sampled documents by score, so the worst one is at the top.
+type sampledHeap []sampledDocument
+
+func (kept sampledHeap) Len() int           { return len(kept) }
+func (kept sampledHeap) Less(i, j int) bool { return kept[i].score > kept[j].score }
+func (kept sampledHeap) Swap(i, j int)      { kept[i], kept[j] = kept[j], kept[i] }
+func (kept *sampledHeap) Push(x any)        { *kept = append(*kept, x.(sampledDocument)) }
+func (kept *sampledHeap) Pop() any {
+	old := *kept
+	last := old[len(old)-1]
+	*kept = old[:len(old)-1]
+	return last
+}
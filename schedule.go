@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// downloadWindow is a daily time range, in a given time zone, during which downloads may be dispatched.
+type downloadWindow struct {
+	start    time.Duration  // Offset from midnight when the window opens
+	end      time.Duration  // Offset from midnight when the window closes
+	location *time.Location // Time zone the offsets are in
+}
+
+// parseDownloadWindow parses a window like "22:00-06:00"; an empty spec means downloads are always allowed.
+func parseDownloadWindow(spec, timezone string) (*downloadWindow, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	// Resolve the time zone, local time when none is configured.
+	location := time.Local
+	if timezone != "" {
+		loaded, err := time.LoadLocation(timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %v", timezone, err)
+		}
+		location = loaded
+	}
+	// Split the spec into its two clock times.
+	startText, endText, found := strings.Cut(spec, "-")
+	if !found {
+		return nil, fmt.Errorf("invalid window %q, expected HH:MM-HH:MM", spec)
+	}
+	start, err := parseClock(startText)
+	if err != nil {
+		return nil, fmt.Errorf("invalid window %q: %v", spec, err)
+	}
+	end, err := parseClock(endText)
+	if err != nil {
+		return nil, fmt.Errorf("invalid window %q: %v", spec, err)
+	}
+	return &downloadWindow{start: start, end: end, location: location}, nil
+}
+
+// parseClock converts "HH:MM" into an offset from midnight.
+func parseClock(clock string) (time.Duration, error) {
+	parsed, err := time.Parse("15:04", strings.TrimSpace(clock))
+	if err != nil {
+		return 0, fmt.Errorf("invalid clock time %q", clock)
+	}
+	return time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute, nil
+}
+
+// contains reports whether downloads are allowed at the given time.
+func (window *downloadWindow) contains(t time.Time) bool {
+	if window == nil || window.start == window.end {
+		return true
+	}
+	// Offset of t from midnight in the window's time zone.
+	local := t.In(window.location)
+	offset := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute
+	if window.start < window.end {
+		return offset >= window.start && offset < window.end
+	}
+	// The window wraps around midnight, e.g. 22:00-06:00.
+	return offset >= window.start || offset < window.end
+}
+
+// nextOpen returns the next time at or after t when the window opens.
+func (window *downloadWindow) nextOpen(t time.Time) time.Time {
+	local := t.In(window.location)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, window.location)
+	open := midnight.Add(window.start)
+	if open.Before(local) {
+		open = time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, window.location).Add(window.start)
+	}
+	return open
+}
+
+// waitForDownloadWindow blocks until downloads are allowed or the context is cancelled.
+func waitForDownloadWindow(ctx context.Context, window *downloadWindow) error {
+	if window.contains(time.Now()) {
+		return nil
+	}
+	// Pause until the window opens again.
+	resumeAt := window.nextOpen(time.Now())
+	log.Printf("outside download window, pausing until %s", resumeAt.Format(time.RFC3339))
+	timer := time.NewTimer(time.Until(resumeAt))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+	}
+	log.Println("download window open, resuming")
+	return nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// headerSchema maps the properties of header results using the types the service's $metadata declares,
+// so properties SABIC adds later are carried along instead of silently dropped.
+type headerSchema struct {
+	types  map[string]string // Property name to its Edm type, empty when $metadata isn't available
+	fields map[string]string // Our property names to the tenant's, see Config.FieldMap
+}
+
+// loadHeaderSchema reads the header entity set from $metadata, caching the document so a run
+// without network access still maps with the last known schema. It never fails the run:
+// without metadata every scalar property is kept as text.
+func loadHeaderSchema(cfg *Config) *headerSchema {
+	schema := &headerSchema{types: make(map[string]string), fields: cfg.FieldMap}
+	if !cfg.UseMetadata {
+		return schema
+	}
+	data, err := fetchMetadata(cfg.ServiceURL)
+	if err == nil && cfg.MetadataCache != "" {
+		if err := writeFileAtomically(cfg.MetadataCache, data, 0o644); err != nil {
+			log.Println("Failed to cache $metadata:", err)
+		}
+	}
+	if err != nil {
+		log.Println(err)
+		if cfg.MetadataCache == "" {
+			return schema
+		}
+		// Fall back to the copy from an earlier run.
+		data, err = os.ReadFile(cfg.MetadataCache)
+		if err != nil {
+			log.Println("No cached $metadata, header properties are kept untyped")
+			return schema
+		}
+	}
+	sets, err := parseMetadata(data)
+	if err != nil {
+		log.Println(err)
+		return schema
+	}
+	for _, set := range sets {
+		if set.Name != cfg.HeaderEntitySet {
+			continue
+		}
+		for _, property := range set.Properties {
+			schema.types[property.Name] = property.Type
+		}
+		reportNewProperties(cfg, set)
+	}
+	return schema
+}
+
+// reportNewProperties logs header properties the config, as scaffolded by discover, doesn't know yet.
+func reportNewProperties(cfg *Config, set entitySetInfo) {
+	known, ok := cfg.EntitySets[set.Name]
+	if !ok {
+		return
+	}
+	knownNames := make(map[string]bool)
+	for _, name := range known {
+		knownNames[name] = true
+	}
+	var added []string
+	for _, property := range set.Properties {
+		if !knownNames[property.Name] {
+			added = append(added, property.Name)
+		}
+	}
+	if len(added) > 0 {
+		sort.Strings(added)
+		log.Printf("%s has new properties in $metadata: %s (run discover -scaffold to update entity_sets)", set.Name, strings.Join(added, ", "))
+	}
+}
+
+// odataDatePattern matches the OData v2 JSON date format, e.g. /Date(1700000000000)/ or /Date(1700000000000+0060)/.
+var odataDatePattern = regexp.MustCompile(`^/Date\((-?\d+)([+-]\d{4})?\)/$`)
+
+// propertyValue renders one property value as text, converting it by its Edm type.
+// Deferred navigation properties and other nested values are skipped.
+func propertyValue(raw any, edmType string) (string, bool) {
+	switch value := raw.(type) {
+	case nil:
+		return "", true
+	case string:
+		// Dates become RFC 3339 so they sort and parse like every other timestamp we store.
+		if edmType == "Edm.DateTime" || edmType == "Edm.DateTimeOffset" || strings.HasPrefix(value, "/Date(") {
+			if match := odataDatePattern.FindStringSubmatch(value); match != nil {
+				milliseconds, err := strconv.ParseInt(match[1], 10, 64)
+				if err == nil {
+					return time.UnixMilli(milliseconds).UTC().Format(time.RFC3339), true
+				}
+			}
+		}
+		return value, true
+	case bool:
+		return strconv.FormatBool(value), true
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), true
+	default:
+		return "", false
+	}
+}
+
+// mapResult turns one raw header result into the fields the downloader needs and the full property set.
+func (schema *headerSchema) mapResult(raw map[string]any) (headerResult, map[string]string) {
+	properties := make(map[string]string)
+	for name, value := range raw {
+		// __metadata and friends describe the entity rather than being part of it.
+		if strings.HasPrefix(name, "__") {
+			continue
+		}
+		if text, ok := propertyValue(value, schema.types[name]); ok {
+			properties[name] = text
+		}
+	}
+	item := headerResult{
+		MaterialNumber:  properties[fieldName(schema.fields, "Matnr")],
+		SubID:           properties[fieldName(schema.fields, "Subid")],
+		StorageLocation: properties[fieldName(schema.fields, "Sbgvid")],
+		LanguageISO:     properties[fieldName(schema.fields, "Laiso")],
+	}
+	return item, properties
+}
+
+// fieldName returns the tenant's name of one of our property names, the name itself when it isn't mapped.
+func fieldName(fields map[string]string, name string) string {
+	if mapped, ok := fields[name]; ok && mapped != "" {
+		return mapped
+	}
+	return name
+}
+
+// describe returns a short summary of the schema for the log.
+func (schema *headerSchema) describe() string {
+	if len(schema.types) == 0 {
+		return "untyped header properties"
+	}
+	return fmt.Sprintf("%d typed header properties", len(schema.types))
+}
+
+// requiredHeaderFields are the properties every header result needs to become a download, by our names.
+var requiredHeaderFields = []string{"Matnr", "Subid", "Sbgvid", "Laiso"}
+
+// validateHeaderResult checks one raw header result against what we expect: the key properties present
+// and, where $metadata typed them, every property holding a value of its Edm type.
+func (schema *headerSchema) validateHeaderResult(raw map[string]any) error {
+	for _, name := range requiredHeaderFields {
+		field := fieldName(schema.fields, name)
+		value, ok := raw[field]
+		if !ok {
+			return fmt.Errorf("missing %s", field)
+		}
+		text, isString := value.(string)
+		if !isString {
+			return fmt.Errorf("%s is %T, not a string", field, value)
+		}
+		// Subid may legitimately be blank; the others form the file name.
+		if text == "" && name != "Subid" {
+			return fmt.Errorf("%s is empty", field)
+		}
+	}
+	for name, value := range raw {
+		edmType, typed := schema.types[name]
+		if !typed || value == nil {
+			continue
+		}
+		if !matchesEdmType(value, edmType) {
+			return fmt.Errorf("%s is %T, not %s", name, value, edmType)
+		}
+	}
+	return nil
+}
+
+// matchesEdmType reports whether a JSON value is a valid OData v2 JSON encoding of an Edm type.
+// Int64 and Decimal travel as strings in OData v2 JSON, so numeric strings are accepted for them.
+func matchesEdmType(value any, edmType string) bool {
+	switch edmType {
+	case "Edm.String", "Edm.Guid", "Edm.DateTime", "Edm.DateTimeOffset", "Edm.Time", "Edm.Binary":
+		_, ok := value.(string)
+		return ok
+	case "Edm.Boolean":
+		_, ok := value.(bool)
+		return ok
+	case "Edm.Byte", "Edm.SByte", "Edm.Int16", "Edm.Int32", "Edm.Double", "Edm.Single":
+		_, ok := value.(float64)
+		return ok
+	case "Edm.Int64", "Edm.Decimal":
+		switch number := value.(type) {
+		case float64:
+			return true
+		case string:
+			_, err := strconv.ParseFloat(number, 64)
+			return err == nil
+		}
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// scrapeState is where an interrupted scrape stands, so the next one resumes instead of starting over.
+type scrapeState struct {
+	StartURL  string    `json:"start_url"`  // First page of the scrape, to notice a changed service
+	NextURL   string    `json:"next_url"`   // __next link of the last stored page, empty once complete
+	SkipToken string    `json:"skip_token"` // $skiptoken of NextURL, for the logs
+	Pages     int       `json:"pages"`      // Pages stored so far
+	Count     int       `json:"count"`      // __count of the first page, -1 when not sent
+	PageSize  int       `json:"page_size"`  // Results per page once the rest is fetched by $skip, 0 while following __next links
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// scrapePage is the part of an OData v2 header page the scraper reads.
+type scrapePage struct {
+	D struct {
+		Count   string            `json:"__count"`
+		Next    string            `json:"__next"`
+		Results []json.RawMessage `json:"results"`
+	} `json:"d"`
+}
+
+// scrapePagesDir holds the pages of a scrape in progress, next to the file it becomes.
+func scrapePagesDir(cfg *Config) string {
+	if cfg.InputFile != "" {
+		return cfg.InputFile + ".pages"
+	}
+	return filepath.Join(cfg.SnapshotDir, ".pages")
+}
+
+// scrapePagePath returns the file of one stored page, numbered from 1.
+func scrapePagePath(cfg *Config, page int) string {
+	return filepath.Join(scrapePagesDir(cfg), fmt.Sprintf("page-%06d.json", page))
+}
+
+// loadScrapeState reads the saved scrape state, false when there is none.
+func loadScrapeState(path string) (scrapeState, bool, error) {
+	var state scrapeState
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, false, nil
+	}
+	if err != nil {
+		return state, false, err
+	}
+	if err := json.Unmarshal(content, &state); err != nil {
+		return state, false, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return state, true, nil
+}
+
+// saveScrapeState writes the scrape state atomically.
+func saveScrapeState(path string, state scrapeState) error {
+	state.UpdatedAt = time.Now().UTC()
+	encoded, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(path, encoded, 0o644)
+}
+
+// resolveNextLink makes a __next link absolute; gateways send them relative to the service root or the page.
+func resolveNextLink(pageURL string, next string) (string, error) {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return "", err
+	}
+	link, err := url.Parse(next)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(link).String(), nil
+}
+
+// fetchScrapePage downloads one header page.
+func fetchScrapePage(ctx context.Context, cfg *Config, client *http.Client, pageURL string) (*scrapePage, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/json")
+	resp, err := openDownload(ctx, cfg, client, request)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to fetch %s: %w", ErrNetwork, pageURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp, pageURL)
+	}
+	var page scrapePage
+	if err := json.NewDecoder(bufio.NewReader(resp.Body)).Decode(&page); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", pageURL, err)
+	}
+	return &page, nil
+}
+
+// scrapeRetryDelay is the wait before fetching a rejected page again, growing with each attempt.
+const scrapeRetryDelay = 5 * time.Second
+
+// validateScrapePage checks a page before it is stored: it needs a results array of header results
+// that each pass validateHeaderResult.
+func validateScrapePage(schema *headerSchema, page *scrapePage) error {
+	if page.D.Results == nil {
+		return fmt.Errorf("no results array")
+	}
+	for i, rawResult := range page.D.Results {
+		var result map[string]any
+		if err := json.Unmarshal(rawResult, &result); err != nil || result == nil {
+			return fmt.Errorf("result %d is not an object", i+1)
+		}
+		if err := schema.validateHeaderResult(result); err != nil {
+			return fmt.Errorf("result %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// fetchValidPage fetches a page until it parses and validates, up to cfg.ScrapeRetries more times.
+// Truncated and malformed pages are never stored.
+func fetchValidPage(ctx context.Context, cfg *Config, client *http.Client, schema *headerSchema, pageURL string) (*scrapePage, error) {
+	for attempt := 0; ; attempt++ {
+		page, err := fetchScrapePage(ctx, cfg, client, pageURL)
+		if err == nil {
+			if err = validateScrapePage(schema, page); err == nil {
+				return page, nil
+			}
+			err = fmt.Errorf("invalid page %s: %v", pageURL, err)
+		}
+		if ctx.Err() != nil || attempt >= cfg.ScrapeRetries {
+			return nil, err
+		}
+		delay := time.Duration(attempt+1) * scrapeRetryDelay
+		log.Printf("rejected page, fetching it again in %s: %v", delay, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// scrapeHeaders downloads every page of the header entity set, following the server-driven __next
+// links ($skiptoken paging) of SAP gateways that don't honor a client $skip. Each page is stored as it
+// arrives and the last link is saved, so an interrupted scrape resumes where it stopped. Once the last
+// page is in, the pages are merged into the input file the sync run reads.
+func scrapeHeaders(ctx context.Context, cfg *Config, restart bool) error {
+	startURL := cfg.entitySetURL(cfg.HeaderEntitySet) + "?$inlinecount=allpages"
+	state, found, err := loadScrapeState(cfg.ScrapeState)
+	if err != nil {
+		return err
+	}
+	switch {
+	case restart || !found || state.NextURL == "":
+		state = scrapeState{StartURL: startURL, NextURL: startURL, Count: -1}
+		if err := os.RemoveAll(scrapePagesDir(cfg)); err != nil {
+			return err
+		}
+	case state.StartURL != startURL:
+		return fmt.Errorf("the interrupted scrape in %s was of %s, not %s; use -restart to start over", cfg.ScrapeState, state.StartURL, startURL)
+	case state.PageSize > 0:
+		log.Printf("resuming scrape of the pages after the first by $skip")
+	default:
+		log.Printf("resuming scrape after page %d at skip token %q", state.Pages, state.SkipToken)
+	}
+	if err := os.MkdirAll(scrapePagesDir(cfg), 0o755); err != nil {
+		return err
+	}
+	// Pages are checked against the header properties $metadata declares.
+	schema := loadHeaderSchema(cfg)
+	// Pages are large, so only a stall aborts reading one.
+	client := newDownloadClient(cfg)
+	for state.NextURL != "" && state.PageSize == 0 {
+		page, err := fetchValidPage(ctx, cfg, client, schema, state.NextURL)
+		if err != nil {
+			return err
+		}
+		if state.Pages == 0 {
+			if count, err := strconv.Atoi(page.D.Count); err == nil {
+				state.Count = count
+			}
+		}
+		encoded, err := json.Marshal(page.D.Results)
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomically(scrapePagePath(cfg, state.Pages+1), encoded, 0o644); err != nil {
+			return err
+		}
+		state.Pages = state.Pages + 1
+		pageURL := state.NextURL
+		state.NextURL, state.SkipToken = "", ""
+		if page.D.Next != "" {
+			if state.NextURL, err = resolveNextLink(pageURL, page.D.Next); err != nil {
+				return fmt.Errorf("invalid __next link %q: %v", page.D.Next, err)
+			}
+			if parsed, err := url.Parse(state.NextURL); err == nil {
+				state.SkipToken = parsed.Query().Get("$skiptoken")
+			}
+		}
+		// Knowing the count and the page size, the rest can be fetched side by side.
+		if state.Pages == 1 && state.NextURL != "" && cfg.ScrapeWorkers > 1 {
+			if state.Count > 0 && len(page.D.Results) > 0 {
+				state.PageSize = len(page.D.Results)
+			} else {
+				log.Printf("the service sent no __count, fetching the pages one at a time")
+			}
+		}
+		// Saved after the page, so a crash between the two only fetches that page again.
+		if err := saveScrapeState(cfg.ScrapeState, state); err != nil {
+			return err
+		}
+		log.Printf("scraped page %d (%d results)", state.Pages, len(page.D.Results))
+	}
+	if state.PageSize > 0 {
+		if err := scrapePagesBySkip(ctx, cfg, client, schema, &state); err != nil {
+			return err
+		}
+	}
+	// An explicit -input is rewritten in place, otherwise the scrape becomes a new snapshot.
+	target := cfg.InputFile
+	if target == "" {
+		target = snapshotPath(cfg, time.Now())
+	}
+	total, err := mergeScrapePages(cfg, state, target)
+	if err != nil {
+		return err
+	}
+	if state.Count >= 0 && total != state.Count {
+		log.Printf("the service announced %d documents but the pages held %d", state.Count, total)
+	}
+	log.Printf("scraped %d documents in %d pages into %s", total, state.Pages, target)
+	if err := os.RemoveAll(scrapePagesDir(cfg)); err != nil {
+		return err
+	}
+	return pruneSnapshots(cfg)
+}
+
+// scrapePagesBySkip fetches the pages after the first with cfg.ScrapeWorkers workers, addressing
+// each by $skip and $top in steps of the first page's size instead of following the __next links
+// one after the other. Pages an interrupted scrape stored already aren't fetched again.
+func scrapePagesBySkip(ctx context.Context, cfg *Config, client *http.Client, schema *headerSchema, state *scrapeState) error {
+	pages := (state.Count + state.PageSize - 1) / state.PageSize
+	first, err := firstScrapeResult(cfg)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var firstErr error
+	var failOnce sync.Once
+	jobs := make(chan int)
+	var workers sync.WaitGroup
+	for range max(cfg.ScrapeWorkers, 1) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for number := range jobs {
+				pageURL := fmt.Sprintf("%s&$skip=%d&$top=%d", state.StartURL, (number-1)*state.PageSize, state.PageSize)
+				if err := scrapePageBySkip(ctx, cfg, client, schema, pageURL, number, first); err != nil {
+					failOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+	for number := 2; number <= pages && ctx.Err() == nil; number++ {
+		if fileExists(scrapePagePath(cfg, number)) {
+			continue
+		}
+		select {
+		case jobs <- number:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	workers.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	state.Pages = pages
+	return saveScrapeState(cfg.ScrapeState, *state)
+}
+
+// scrapePageBySkip fetches and stores one page addressed by $skip. A service that ignores $skip
+// answers with the first page again, which is refused rather than merged as duplicates.
+func scrapePageBySkip(ctx context.Context, cfg *Config, client *http.Client, schema *headerSchema, pageURL string, number int, first json.RawMessage) error {
+	page, err := fetchValidPage(ctx, cfg, client, schema, pageURL)
+	if err != nil {
+		return err
+	}
+	// Stored pages are compact, so compare the result compacted.
+	var compact bytes.Buffer
+	if len(page.D.Results) > 0 && json.Compact(&compact, page.D.Results[0]) == nil && bytes.Equal(compact.Bytes(), first) {
+		return fmt.Errorf("page %d repeats the first page, the service ignores $skip; set scrape_workers to 1", number)
+	}
+	encoded, err := json.Marshal(page.D.Results)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomically(scrapePagePath(cfg, number), encoded, 0o644); err != nil {
+		return err
+	}
+	log.Printf("scraped page %d (%d results)", number, len(page.D.Results))
+	return nil
+}
+
+// firstScrapeResult returns the first result of the stored first page, nil when it has none.
+func firstScrapeResult(cfg *Config) (json.RawMessage, error) {
+	content, err := os.ReadFile(scrapePagePath(cfg, 1))
+	if err != nil {
+		return nil, err
+	}
+	var results []json.RawMessage
+	if err := json.Unmarshal(content, &results); err != nil {
+		return nil, fmt.Errorf("failed to parse stored page 1: %v", err)
+	}
+	if len(results) == 0 {
+		return nil, nil
+	}
+	return results[0], nil
+}
+
+// mergeScrapePages writes the stored pages into target as one OData response and returns
+// the number of results. Unless raw snapshots are configured the results are written in canonical
+// form and order, one per line, so a diff of two snapshots shows only catalog changes.
+func mergeScrapePages(cfg *Config, state scrapeState, target string) (int, error) {
+	temp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(temp.Name())
+	// Snapshots ending in .gz are compressed as they are written.
+	var compressed *gzip.Writer
+	var output io.Writer = temp
+	if strings.HasSuffix(target, ".gz") {
+		compressed = gzip.NewWriter(temp)
+		output = compressed
+	}
+	writer := bufio.NewWriter(output)
+	writer.WriteString(`{"d":{`)
+	if state.Count >= 0 {
+		fmt.Fprintf(writer, `"__count":"%d",`, state.Count)
+	}
+	var total int
+	if cfg.RawSnapshots {
+		writer.WriteString(`"results":[`)
+		total, err = writeRawResults(cfg, state, writer)
+		writer.WriteString("]}}\n")
+	} else {
+		writer.WriteString("\"results\":[\n")
+		total, err = writeCanonicalResults(cfg, state, writer)
+		writer.WriteString("\n]}}\n")
+	}
+	if err != nil {
+		temp.Close()
+		return 0, err
+	}
+	if err := writer.Flush(); err != nil {
+		temp.Close()
+		return 0, err
+	}
+	if compressed != nil {
+		if err := compressed.Close(); err != nil {
+			temp.Close()
+			return 0, err
+		}
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return 0, err
+	}
+	if err := temp.Close(); err != nil {
+		return 0, err
+	}
+	// The input file is replaced by valid JSON or not at all.
+	if err := checkJSONFile(temp.Name()); err != nil {
+		return 0, fmt.Errorf("merged scrape is not valid JSON, not writing %s: %v", target, err)
+	}
+	if err := os.Chmod(temp.Name(), 0o644); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(temp.Name(), target); err != nil {
+		return 0, err
+	}
+	// A finished scrape leaves nothing to resume.
+	state.NextURL, state.SkipToken = "", ""
+	return total, saveScrapeState(cfg.ScrapeState, state)
+}
+
+// writeRawResults writes the results of the stored pages as the service sent them and returns how
+// many it wrote. Results are streamed page by page, never all held at once.
+func writeRawResults(cfg *Config, state scrapeState, writer *bufio.Writer) (int, error) {
+	var total int
+	for page := 1; page <= state.Pages; page++ {
+		content, err := os.ReadFile(scrapePagePath(cfg, page))
+		if err != nil {
+			return 0, err
+		}
+		var results []json.RawMessage
+		if err := json.Unmarshal(content, &results); err != nil {
+			return 0, fmt.Errorf("failed to parse stored page %d: %v", page, err)
+		}
+		for _, result := range results {
+			if total > 0 {
+				writer.WriteByte(',')
+			}
+			writer.Write(result)
+			total = total + 1
+		}
+	}
+	return total, nil
+}
+
+// checkJSONFile reads a file token by token to check it holds exactly one JSON value, without loading it whole.
+func checkJSONFile(path string) error {
+	file, err := openHeaderFile(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(file)
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if delim, ok := token.(json.Delim); ok {
+			if delim == '{' || delim == '[' {
+				depth = depth + 1
+			} else {
+				depth = depth - 1
+			}
+		}
+		if depth == 0 {
+			break
+		}
+	}
+	// Nothing may follow the value.
+	if _, err := decoder.Token(); err != io.EOF {
+		return fmt.Errorf("unexpected data after the JSON value")
+	}
+	return nil
+}
+
+// runScrape implements the scrape command, which refreshes the input file from the service.
+func runScrape(args []string) error {
+	var restart bool
+	cfg, _, err := loadConfig("scrape", args, func(flagSet *flag.FlagSet) {
+		flagSet.BoolVar(&restart, "restart", false, "discard an interrupted scrape instead of resuming it")
+	})
+	if err != nil {
+		return err
+	}
+	// An interrupted scrape resumes next time.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return scrapeHeaders(ctx, cfg, restart)
+}
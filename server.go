@@ -0,0 +1,676 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// apiResponse describes one possible response of an endpoint.
+type apiResponse struct {
+	Description string         // Human readable meaning of the status
+	ContentType string         // Media type of the body, empty when there is none
+	Schema      map[string]any // JSON schema of the body
+}
+
+// apiRoute describes one endpoint; the same table registers the handler and generates the OpenAPI spec,
+// so the two can never drift apart.
+type apiRoute struct {
+	Method      string                                   // HTTP method
+	Path        string                                   // Path with {param} placeholders, shared by ServeMux and OpenAPI
+	OperationID string                                   // Stable name for generated clients
+	Summary     string                                   // One line description
+	Role        string                                   // Role required to call it, empty for public endpoints
+	Streams     bool                                     // Counts against the concurrent download caps
+	Query       map[string]string                        // Optional query parameters and what they do
+	Responses   map[int]apiResponse                      // Documented responses by status code
+	Handler     func(srv *corpusServer) http.HandlerFunc // Builds the handler for a server
+}
+
+// documentInfo is the JSON view of a stored document.
+type documentInfo struct {
+	Name          string    `json:"name"`                     // File name in the output directory
+	InternalCode  string    `json:"internal_code,omitempty"`  // ERP material code, when mapped
+	Material      string    `json:"material"`                 // Matnr
+	SubID         string    `json:"sub_id"`                   // Subid
+	Sbgvid        string    `json:"sbgvid"`                   // Regional SDS generation variant, e.g. SDS_FR
+	Language      string    `json:"language"`                 // Laiso
+	Country       string    `json:"country,omitempty"`        // Country the SDS was generated for, see jurisdiction.go
+	Jurisdiction  string    `json:"jurisdiction,omitempty"`   // Regulatory area, e.g. EU
+	Regulation    string    `json:"regulation,omitempty"`     // SDS regulation of that area
+	ProductFamily string    `json:"product_family,omitempty"` // Product family from the catalog, see family.go
+	Size          int64     `json:"size"`                     // Size in bytes
+	SHA256        string    `json:"sha256,omitempty"`         // Hex SHA-256 of the content, from the catalog
+	Integrity     string    `json:"integrity,omitempty"`      // The same as an SRI value for integrity attributes, e.g. sha256-...
+	Modified      time.Time `json:"modified"`                 // Last modification time of the local copy
+}
+
+// documentSchema is the OpenAPI schema of documentInfo.
+var documentSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"name":           map[string]any{"type": "string"},
+		"internal_code":  map[string]any{"type": "string"},
+		"material":       map[string]any{"type": "string"},
+		"sub_id":         map[string]any{"type": "string"},
+		"sbgvid":         map[string]any{"type": "string"},
+		"language":       map[string]any{"type": "string"},
+		"country":        map[string]any{"type": "string"},
+		"jurisdiction":   map[string]any{"type": "string"},
+		"regulation":     map[string]any{"type": "string"},
+		"product_family": map[string]any{"type": "string"},
+		"size":           map[string]any{"type": "integer", "format": "int64"},
+		"sha256":         map[string]any{"type": "string"},
+		"integrity":      map[string]any{"type": "string"},
+		"modified":       map[string]any{"type": "string", "format": "date-time"},
+	},
+}
+
+// topDocumentSchema is the OpenAPI schema of documentHits.
+var topDocumentSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"document":      map[string]any{"type": "string"},
+		"hits":          map[string]any{"type": "integer", "format": "int64"},
+		"last_accessed": map[string]any{"type": "string", "format": "date-time"},
+	},
+}
+
+// errorSchema is the OpenAPI schema of every error body.
+var errorSchema = map[string]any{
+	"type":       "object",
+	"properties": map[string]any{"error": map[string]any{"type": "string"}},
+}
+
+// apiRoutes lists every endpoint of the server.
+var apiRoutes = []apiRoute{
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/documents",
+		OperationID: "listDocuments",
+		Summary:     "List the documents in the local corpus",
+		Role:        roleReader,
+		Query: map[string]string{
+			"jurisdiction":   "Only documents for these comma separated jurisdictions or countries, e.g. EU,US",
+			"product_family": "Only documents of these comma separated product families",
+		},
+		Responses: map[int]apiResponse{
+			http.StatusOK: {Description: "Documents in the corpus", ContentType: "application/json", Schema: map[string]any{"type": "array", "items": documentSchema}},
+		},
+		Handler: (*corpusServer).handleListDocuments,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/documents/{name}",
+		OperationID: "getDocument",
+		Summary:     "Download one document",
+		Role:        roleReader,
+		Streams:     true,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                           {Description: "The PDF", ContentType: "application/pdf", Schema: map[string]any{"type": "string", "format": "binary"}},
+			http.StatusPartialContent:               {Description: "The requested byte ranges of the PDF", ContentType: "application/pdf", Schema: map[string]any{"type": "string", "format": "binary"}},
+			http.StatusNotModified:                  {Description: "The copy named by If-None-Match is current"},
+			http.StatusRequestedRangeNotSatisfiable: {Description: "The range lies outside the document"},
+			http.StatusNotFound:                     {Description: "No such document", ContentType: "application/json", Schema: errorSchema},
+			http.StatusTooManyRequests:              {Description: "Rate limit or download cap reached, see Retry-After", ContentType: "application/json", Schema: errorSchema},
+		},
+		Handler: (*corpusServer).handleGetDocument,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/documents/{name}/preview",
+		OperationID: "getDocumentPreview",
+		Summary:     "PNG rendering of the first page of a document",
+		Role:        roleReader,
+		Responses: map[int]apiResponse{
+			http.StatusOK:          {Description: "The preview", ContentType: "image/png", Schema: map[string]any{"type": "string", "format": "binary"}},
+			http.StatusNotModified: {Description: "The copy named by If-None-Match is current"},
+			http.StatusNotFound:    {Description: "No preview for this document", ContentType: "application/json", Schema: errorSchema},
+		},
+		Handler: (*corpusServer).handleGetPreview,
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/documents/{name}/share",
+		OperationID: "shareDocument",
+		Summary:     "Sign an expiring link to a document that opens without credentials, for sharing by email",
+		Role:        roleReader,
+		Query:       map[string]string{"ttl": "How long the link stays valid, e.g. 72h; share_ttl by default and at most share_max_ttl"},
+		Responses: map[int]apiResponse{
+			http.StatusOK:             {Description: "The signed link", ContentType: "application/json", Schema: sharedLinkSchema},
+			http.StatusBadRequest:     {Description: "Invalid ttl", ContentType: "application/json", Schema: errorSchema},
+			http.StatusNotFound:       {Description: "No such document", ContentType: "application/json", Schema: errorSchema},
+			http.StatusNotImplemented: {Description: "No share signing key is configured", ContentType: "application/json", Schema: errorSchema},
+		},
+		Handler: (*corpusServer).handleShareDocument,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/shared/{name}",
+		OperationID: "getSharedDocument",
+		Summary:     "Download a document through a signed link",
+		Streams:     true,
+		Query: map[string]string{
+			"expires":   "Expiry of the link in Unix seconds",
+			"signature": "Signature of the link",
+		},
+		Responses: map[int]apiResponse{
+			http.StatusOK:             {Description: "The PDF", ContentType: "application/pdf", Schema: map[string]any{"type": "string", "format": "binary"}},
+			http.StatusPartialContent: {Description: "The requested byte ranges of the PDF", ContentType: "application/pdf", Schema: map[string]any{"type": "string", "format": "binary"}},
+			http.StatusForbidden:      {Description: "The link expired or its signature is invalid", ContentType: "application/json", Schema: errorSchema},
+			http.StatusNotFound:       {Description: "No such document", ContentType: "application/json", Schema: errorSchema},
+		},
+		Handler: (*corpusServer).handleGetSharedDocument,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/top-documents",
+		OperationID: "listTopDocuments",
+		Summary:     "The most downloaded documents, for the dashboard",
+		Role:        roleReader,
+		Query:       map[string]string{"limit": "Documents to list, 0 for all; 20 by default"},
+		Responses: map[int]apiResponse{
+			http.StatusOK:         {Description: "Documents by downloads, most first", ContentType: "application/json", Schema: map[string]any{"type": "array", "items": topDocumentSchema}},
+			http.StatusBadRequest: {Description: "Invalid limit", ContentType: "application/json", Schema: errorSchema},
+		},
+		Handler: (*corpusServer).handleTopDocuments,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/openapi.json",
+		OperationID: "getOpenAPISpec",
+		Summary:     "This OpenAPI specification",
+		Responses: map[int]apiResponse{
+			http.StatusOK: {Description: "OpenAPI 3 document", ContentType: "application/json", Schema: map[string]any{"type": "object"}},
+		},
+		Handler: (*corpusServer).handleOpenAPISpec,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/metrics",
+		OperationID: "getMetrics",
+		Summary:     "Sync and server counters in the Prometheus text format",
+		Responses: map[int]apiResponse{
+			http.StatusOK: {Description: "Prometheus metrics", ContentType: "text/plain", Schema: map[string]any{"type": "string"}},
+		},
+		Handler: (*corpusServer).handleMetrics,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/sitemap.xml",
+		OperationID: "getSitemap",
+		Summary:     "Sitemap of the documents for intranet search engines, an index of /sitemaps/{part} for large libraries",
+		Responses: map[int]apiResponse{
+			http.StatusOK: {Description: "Sitemap or sitemap index", ContentType: "application/xml", Schema: map[string]any{"type": "string"}},
+		},
+		Handler: (*corpusServer).handleSitemap,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/sitemaps/{part}",
+		OperationID: "getSitemapPart",
+		Summary:     "One part of a sitemap split for size",
+		Responses: map[int]apiResponse{
+			http.StatusOK:       {Description: "Sitemap", ContentType: "application/xml", Schema: map[string]any{"type": "string"}},
+			http.StatusNotFound: {Description: "No such sitemap part", ContentType: "application/json", Schema: errorSchema},
+		},
+		Handler: (*corpusServer).handleSitemapPart,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/robots.txt",
+		OperationID: "getRobots",
+		Summary:     "Crawler directives: documents may be indexed, the rest of the API may not",
+		Responses: map[int]apiResponse{
+			http.StatusOK: {Description: "robots.txt", ContentType: "text/plain", Schema: map[string]any{"type": "string"}},
+		},
+		Handler: (*corpusServer).handleRobots,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/healthz",
+		OperationID: "getHealth",
+		Summary:     "Liveness probe: the server is up and answering",
+		Responses: map[int]apiResponse{
+			http.StatusOK: {Description: "Alive", ContentType: "application/json", Schema: probeReportSchema},
+		},
+		Handler: (*corpusServer).handleHealthz,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/readyz",
+		OperationID: "getReadiness",
+		Summary:     "Readiness probe: upstream reachable, catalog readable and storage writable",
+		Responses: map[int]apiResponse{
+			http.StatusOK:                 {Description: "Ready", ContentType: "application/json", Schema: probeReportSchema},
+			http.StatusServiceUnavailable: {Description: "A check failed or the server is shutting down", ContentType: "application/json", Schema: probeReportSchema},
+		},
+		Handler: (*corpusServer).handleReadyz,
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/sync",
+		OperationID: "triggerSync",
+		Summary:     "Start a sync run in the background",
+		Role:        roleAdmin,
+		Responses: map[int]apiResponse{
+			http.StatusAccepted: {Description: "Sync started", ContentType: "application/json", Schema: map[string]any{"type": "object"}},
+			http.StatusConflict: {Description: "A sync is already running", ContentType: "application/json", Schema: errorSchema},
+		},
+		Handler: (*corpusServer).handleTriggerSync,
+	},
+}
+
+// pathParameterPattern finds the {param} placeholders of a route path.
+var pathParameterPattern = regexp.MustCompile(`\{([^}]+)\}`)
+
+// corpusServer serves the local corpus over HTTP.
+type corpusServer struct {
+	cfg         *Config
+	audit       *auditLog
+	store       documentStore // Stored documents being served
+	auth        *authenticator
+	limiter     *clientLimiter
+	routes      []apiRoute // Served endpoints, also the source of the OpenAPI spec
+	syncMutex   sync.Mutex
+	syncRunning bool        // Whether a triggered sync is in progress
+	digests     digestCache // Hashes of served files for their Digest headers
+	probes      *probes     // Liveness and readiness of the server
+	access      *accessLog  // Log of answered requests, nil when disabled
+	hits        *kvStore    // Downloads per document, nil when disabled
+}
+
+// buildOpenAPISpec generates the OpenAPI 3 document from the route table.
+func buildOpenAPISpec(routes []apiRoute) map[string]any {
+	paths := make(map[string]any)
+	for _, route := range routes {
+		// Every {param} in the path becomes a required path parameter.
+		var parameters []any
+		for _, match := range pathParameterPattern.FindAllStringSubmatch(route.Path, -1) {
+			parameters = append(parameters, map[string]any{
+				"name":     match[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]any{"type": "string"},
+			})
+		}
+		queryNames := make([]string, 0, len(route.Query))
+		for name := range route.Query {
+			queryNames = append(queryNames, name)
+		}
+		sort.Strings(queryNames)
+		for _, name := range queryNames {
+			parameters = append(parameters, map[string]any{
+				"name":        name,
+				"in":          "query",
+				"description": route.Query[name],
+				"schema":      map[string]any{"type": "string"},
+			})
+		}
+		responses := make(map[string]any)
+		for status, response := range route.Responses {
+			documented := map[string]any{"description": response.Description}
+			if response.ContentType != "" {
+				documented["content"] = map[string]any{response.ContentType: map[string]any{"schema": response.Schema}}
+			}
+			responses[strconv.Itoa(status)] = documented
+		}
+		operation := map[string]any{
+			"operationId": route.OperationID,
+			"summary":     route.Summary,
+			"responses":   responses,
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+		// Protected endpoints accept either credential and name the role they need.
+		if route.Role != "" {
+			operation["security"] = []any{
+				map[string]any{"apiKey": []any{}},
+				map[string]any{"bearer": []any{}},
+			}
+			operation["x-required-role"] = route.Role
+			responses["401"] = map[string]any{"description": "Missing or invalid credentials"}
+			responses["403"] = map[string]any{"description": "Role " + route.Role + " required"}
+		}
+		// Several methods can share one path.
+		item, ok := paths[route.Path].(map[string]any)
+		if !ok {
+			item = make(map[string]any)
+			paths[route.Path] = item
+		}
+		item[strings.ToLower(route.Method)] = operation
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "SABIC SDS cache",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"securitySchemes": map[string]any{
+				"apiKey": map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+				"bearer": map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+	}
+}
+
+// newServeMux registers every route of the table.
+func (srv *corpusServer) newServeMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	for _, route := range srv.routes {
+		// Authenticate first so limits apply per caller rather than per address; failed authentications
+		// count against the address.
+		handler := srv.logAccess(route, srv.limiter.limit(route.Streams, route.Handler(srv)))
+		mux.HandleFunc(route.Method+" "+route.Path, srv.limiter.limitFailures(srv.auth.requireRole(route.Role, handler)))
+	}
+	return mux
+}
+
+// writeJSON writes a JSON response with the given status.
+func writeJSON(writer http.ResponseWriter, status int, value any) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	if err := json.NewEncoder(writer).Encode(value); err != nil {
+		log.Println(err)
+	}
+}
+
+// writeJSONError writes an error body matching errorSchema.
+func writeJSONError(writer http.ResponseWriter, status int, message string) {
+	writeJSON(writer, status, map[string]string{"error": message})
+}
+
+// parseDocumentFilename splits [internal--]matnr_subid_sbgvid_laiso.pdf back into its parts.
+func parseDocumentFilename(name string) (documentInfo, bool) {
+	internalCode, rest := splitInternalCode(name)
+	parts := strings.Split(strings.TrimSuffix(rest, filepath.Ext(rest)), "_")
+	if len(parts) < 4 {
+		return documentInfo{}, false
+	}
+	// Sbgvid keeps its underscores, e.g. sds_fr; one that had to be escaped is shown without its hash.
+	sbgvid := strings.Join(parts[2:len(parts)-1], "_")
+	if escaped, _, found := strings.Cut(sbgvid, "~"); found {
+		sbgvid = escaped
+	}
+	return documentInfo{
+		Name:         name,
+		InternalCode: internalCode,
+		Material:     parts[0],
+		SubID:        parts[1],
+		Sbgvid:       strings.ToUpper(sbgvid),
+		Language:     strings.ToUpper(parts[len(parts)-1]),
+	}, true
+}
+
+// listDocuments returns every stored PDF, sorted by name.
+func listDocuments(store documentStore) ([]documentInfo, error) {
+	names, err := store.names()
+	if err != nil {
+		return nil, err
+	}
+	documents := []documentInfo{}
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".pdf") {
+			continue
+		}
+		document, ok := parseDocumentFilename(name)
+		if !ok {
+			continue
+		}
+		filePath, ok := store.path(name)
+		if !ok {
+			continue
+		}
+		info, err := os.Stat(filePath)
+		if err != nil {
+			continue
+		}
+		document.Size = info.Size()
+		document.Modified = info.ModTime().UTC()
+		// Encrypted documents are listed with the size of what a download returns.
+		if key := storeKey(store); key != nil {
+			file, err := openReadable(key, filePath)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			document.Size = file.Size()
+			file.Close()
+		}
+		documents = append(documents, document)
+	}
+	sort.Slice(documents, func(i, j int) bool { return documents[i].Name < documents[j].Name })
+	return documents, nil
+}
+
+// handleListDocuments serves GET /api/documents.
+func (srv *corpusServer) handleListDocuments() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		documents, err := listDocuments(srv.store)
+		if err != nil {
+			writeJSONError(writer, http.StatusInternalServerError, "failed to list documents")
+			log.Println(err)
+			return
+		}
+		docs, err := openCatalog(srv.cfg.CatalogFile)
+		if err != nil {
+			writeJSONError(writer, http.StatusInternalServerError, "failed to read the catalog")
+			log.Println(err)
+			return
+		}
+		withProductFamilies(docs, documents)
+		withChecksums(docs, documents)
+		filter := request.URL.Query().Get("jurisdiction")
+		familyFilter := request.URL.Query().Get("product_family")
+		filtered := []documentInfo{}
+		for _, document := range documents {
+			if !matchesJurisdiction(srv.cfg, filter, document.Sbgvid) {
+				continue
+			}
+			if !matchesProductFamily(familyFilter, document.ProductFamily) {
+				continue
+			}
+			info := jurisdictionFor(srv.cfg, document.Sbgvid)
+			document.Country, document.Jurisdiction, document.Regulation = info.Country, info.Jurisdiction, info.Regulation
+			filtered = append(filtered, document)
+		}
+		writeJSON(writer, http.StatusOK, filtered)
+	}
+}
+
+// handleGetDocument serves GET /api/documents/{name}.
+func (srv *corpusServer) handleGetDocument() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		name := request.PathValue("name")
+		// Only plain file names from the output directory are served.
+		if name != filepath.Base(name) || !strings.HasSuffix(name, ".pdf") {
+			writeJSONError(writer, http.StatusNotFound, "no such document")
+			return
+		}
+		srv.serveDocument(writer, request, name, principalFromRequest(request).Name+"@"+request.RemoteAddr)
+	}
+}
+
+// serveDocument sends a stored document and records who it went to in the audit log.
+func (srv *corpusServer) serveDocument(writer http.ResponseWriter, request *http.Request, name string, recipient string) {
+	filePath, ok := srv.store.path(name)
+	if !ok {
+		writeJSONError(writer, http.StatusNotFound, "no such document")
+		return
+	}
+	srv.serveFile(writer, request, filePath, "application/pdf")
+	srv.audit.record(auditServed, name, recipient, "")
+}
+
+// serveFile sends a stored file with validators for conditional and partial requests: an ETag made of
+// its SHA-256 next to Last-Modified, so If-None-Match, If-Range and Range let viewers and download
+// managers revalidate and resume instead of fetching the whole file again.
+func (srv *corpusServer) serveFile(writer http.ResponseWriter, request *http.Request, filePath string, contentType string) {
+	header := writer.Header()
+	header.Set("Content-Type", contentType)
+	// Documents change with new revisions, so caches must ask before reusing a copy.
+	header.Set("Cache-Control", "no-cache")
+	if sum, err := srv.digests.sha256(filePath); err == nil {
+		header.Set("ETag", `"`+sum+`"`)
+		// Let clients check what they received.
+		setDigestHeaders(header, sum)
+	} else {
+		log.Printf("failed to hash %s: %v", filePath, err)
+	}
+	// Encrypted stores are decrypted on the fly; ServeContent answers ranges against the plaintext.
+	if srv.digests.key != nil {
+		file, err := openReadable(srv.digests.key, filePath)
+		if err != nil {
+			writeJSONError(writer, http.StatusInternalServerError, "failed to read the document")
+			log.Println(err)
+			return
+		}
+		defer file.Close()
+		var modified time.Time
+		if info, err := os.Stat(filePath); err == nil {
+			modified = info.ModTime()
+		}
+		http.ServeContent(writer, request, filepath.Base(filePath), modified, file)
+		return
+	}
+	// ServeFile answers If-None-Match, If-Match, If-Range and Range against the headers set above.
+	http.ServeFile(writer, request, filePath)
+}
+
+// handleGetPreview serves GET /api/documents/{name}/preview.
+func (srv *corpusServer) handleGetPreview() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		name := request.PathValue("name")
+		if name != filepath.Base(name) || !strings.HasSuffix(name, ".pdf") {
+			writeJSONError(writer, http.StatusNotFound, "no such document")
+			return
+		}
+		pdfPath, ok := srv.store.path(name)
+		if !ok {
+			writeJSONError(writer, http.StatusNotFound, "no such document")
+			return
+		}
+		pngPath := previewPath(pdfPath)
+		if !fileExists(pngPath) {
+			writeJSONError(writer, http.StatusNotFound, "no preview for this document")
+			return
+		}
+		srv.serveFile(writer, request, pngPath, "image/png")
+	}
+}
+
+// handleOpenAPISpec serves GET /openapi.json.
+func (srv *corpusServer) handleOpenAPISpec() http.HandlerFunc {
+	spec := buildOpenAPISpec(srv.routes)
+	return func(writer http.ResponseWriter, request *http.Request) {
+		writeJSON(writer, http.StatusOK, spec)
+	}
+}
+
+// handleTriggerSync serves POST /api/sync.
+func (srv *corpusServer) handleTriggerSync() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		srv.syncMutex.Lock()
+		defer srv.syncMutex.Unlock()
+		if srv.syncRunning {
+			writeJSONError(writer, http.StatusConflict, "a sync is already running")
+			return
+		}
+		srv.syncRunning = true
+		log.Printf("sync triggered by %s", principalFromRequest(request).Name)
+		// The sync outlives the request, and records to the server's audit log.
+		go func() {
+			if err := runSync(context.Background(), srv.cfg, srv.audit); err != nil {
+				log.Println(err)
+			}
+			srv.syncMutex.Lock()
+			srv.syncRunning = false
+			srv.syncMutex.Unlock()
+		}()
+		writeJSON(writer, http.StatusAccepted, map[string]string{"status": "sync started"})
+	}
+}
+
+// runServe implements the serve command.
+func runServe(args []string) error {
+	cfg, _, err := loadConfig("serve", args, nil)
+	if err != nil {
+		return err
+	}
+	// Bind the ports first, they may need root, and then give root up before touching any file.
+	listener, err := net.Listen("tcp", cfg.ListenAddress)
+	if err != nil {
+		return err
+	}
+	stopProfiling, err := startProfiling(cfg)
+	if err != nil {
+		return err
+	}
+	defer stopProfiling()
+	if err := dropPrivileges(cfg); err != nil {
+		return err
+	}
+	audit, err := openAuditLog(cfg.AuditLog)
+	if err != nil {
+		return err
+	}
+	auth, err := newAuthenticator(cfg)
+	if err != nil {
+		return err
+	}
+	store, err := openDocumentStore(cfg)
+	if err != nil {
+		return err
+	}
+	access, err := openAccessLog(cfg.AccessLog)
+	if err != nil {
+		return err
+	}
+	defer access.close()
+	hits, err := openAccessCounts(cfg.AccessCounts)
+	if err != nil {
+		return err
+	}
+	defer hits.close()
+	srv := &corpusServer{cfg: cfg, audit: audit, store: store, auth: auth, limiter: newClientLimiter(cfg), routes: apiRoutes, access: access, hits: hits}
+	srv.digests.key = storeKey(store)
+	srv.probes = newProbes(cfg)
+	httpServer := &http.Server{
+		Addr:              cfg.ListenAddress,
+		Handler:           srv.newServeMux(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	// Shut down cleanly on SIGINT or SIGTERM.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		srv.probes.drain()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), max(cfg.ShutdownGrace.Duration, time.Second))
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			log.Println(err)
+		}
+	}()
+	log.Printf("serving %s on %s", cfg.OutputDir, cfg.ListenAddress)
+	if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
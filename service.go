@@ -0,0 +1,216 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// defaultServiceName is the name the daemon is registered under.
+const defaultServiceName = "sabic-com-documentation"
+
+// systemdUnitTemplate runs the daemon as a notify service: systemd learns it is up from sdNotify,
+// stops it with SIGTERM and reloads it with SIGHUP.
+const systemdUnitTemplate = `[Unit]
+Description=SABIC SDS document sync
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=notify
+ExecStart=%s
+ExecReload=/bin/kill -HUP $MAINPID
+WorkingDirectory=%s
+%sRestart=on-failure
+RestartSec=30s
+TimeoutStopSec=60s
+NoNewPrivileges=true
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// serviceOptions are the install-service and uninstall-service settings.
+type serviceOptions struct {
+	name       string
+	user       string   // Account the service runs as, the service manager's default when empty
+	print      bool     // Show what would be installed instead of installing it
+	daemonArgs []string // Arguments after --, passed to the daemon command
+}
+
+// parseServiceOptions reads the flags of install-service and uninstall-service.
+func parseServiceOptions(command string, args []string) (serviceOptions, error) {
+	options := serviceOptions{}
+	flagSet := flag.NewFlagSet(command, flag.ContinueOnError)
+	flagSet.StringVar(&options.name, "name", defaultServiceName, "service name")
+	flagSet.StringVar(&options.user, "user", "", "account the service runs as")
+	flagSet.BoolVar(&options.print, "print", false, "print the service definition and commands instead of running them")
+	if err := flagSet.Parse(args); err != nil {
+		return options, err
+	}
+	// Validate the daemon flags now rather than when the service first starts.
+	options.daemonArgs = absoluteConfigArgs(flagSet.Args())
+	if _, _, err := loadConfig("daemon", options.daemonArgs, nil); err != nil {
+		return options, fmt.Errorf("invalid daemon flags: %v", err)
+	}
+	return options, nil
+}
+
+// absoluteConfigArgs makes a -config path absolute, since services don't start in the current directory.
+func absoluteConfigArgs(args []string) []string {
+	resolved := make([]string, len(args))
+	copy(resolved, args)
+	for i, arg := range resolved {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if name != "config" || !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		if hasValue {
+			if absolute, err := filepath.Abs(value); err == nil {
+				resolved[i] = "-config=" + absolute
+			}
+		} else if i+1 < len(resolved) {
+			if absolute, err := filepath.Abs(resolved[i+1]); err == nil {
+				resolved[i+1] = absolute
+			}
+		}
+	}
+	return resolved
+}
+
+// quoteCommandLine joins arguments for a systemd ExecStart or a printed command line, quoting those with spaces.
+func quoteCommandLine(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if strings.ContainsAny(arg, " \t\"") {
+			arg = `"` + strings.ReplaceAll(arg, `"`, `\"`) + `"`
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
+
+// runServiceCommands runs the service manager commands in order, or prints them.
+func runServiceCommands(print bool, commands [][]string) error {
+	for _, command := range commands {
+		if print {
+			fmt.Println(quoteCommandLine(command))
+			continue
+		}
+		output, err := exec.Command(command[0], command[1:]...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s failed: %v: %s", quoteCommandLine(command), err, strings.TrimSpace(string(output)))
+		}
+	}
+	return nil
+}
+
+// runInstallService implements install-service: it registers the daemon with systemd on Linux and with
+// the Service Control Manager on Windows. Daemon flags follow --, e.g.
+// install-service -user sds -- -config /etc/sabic.json
+func runInstallService(args []string) error {
+	options, err := parseServiceOptions("install-service", args)
+	if err != nil {
+		return err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	workDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	switch runtime.GOOS {
+	case "linux":
+		commandLine := quoteCommandLine(append([]string{executable, "daemon"}, options.daemonArgs...))
+		var userLine string
+		if options.user != "" {
+			userLine = "User=" + options.user + "\n"
+		}
+		unit := fmt.Sprintf(systemdUnitTemplate, commandLine, workDir, userLine)
+		unitPath := filepath.Join("/etc/systemd/system", options.name+".service")
+		if options.print {
+			fmt.Printf("# %s\n%s\n", unitPath, unit)
+		} else if err := writeFileAtomically(unitPath, []byte(unit), 0o644); err != nil {
+			return fmt.Errorf("failed to write %s: %v", unitPath, err)
+		}
+		if err := runServiceCommands(options.print, [][]string{
+			{"systemctl", "daemon-reload"},
+			{"systemctl", "enable", "--now", options.name},
+		}); err != nil {
+			return err
+		}
+	case "windows":
+		if err := installWindowsService(options, executable, workDir); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("install-service supports systemd on Linux and the Windows Service Control Manager, not %s", runtime.GOOS)
+	}
+	if !options.print {
+		log.Printf("installed and started %s", options.name)
+	}
+	return nil
+}
+
+// runUninstallService implements uninstall-service, stopping the daemon and removing its registration.
+func runUninstallService(args []string) error {
+	options, err := parseServiceOptions("uninstall-service", args)
+	if err != nil {
+		return err
+	}
+	switch runtime.GOOS {
+	case "linux":
+		if err := runServiceCommands(options.print, [][]string{{"systemctl", "disable", "--now", options.name}}); err != nil {
+			return err
+		}
+		unitPath := filepath.Join("/etc/systemd/system", options.name+".service")
+		if options.print {
+			fmt.Println("rm", unitPath)
+		} else if err := os.Remove(unitPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := runServiceCommands(options.print, [][]string{{"systemctl", "daemon-reload"}}); err != nil {
+			return err
+		}
+	case "windows":
+		if err := uninstallWindowsService(options); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("uninstall-service supports systemd on Linux and the Windows Service Control Manager, not %s", runtime.GOOS)
+	}
+	if !options.print {
+		log.Printf("removed %s", options.name)
+	}
+	return nil
+}
+
+// sdNotify sends a state change such as READY=1 to systemd when it started us as a notify service.
+// Outside systemd it does nothing.
+func sdNotify(state string) {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return
+	}
+	// A leading @ names a socket in the abstract namespace.
+	if strings.HasPrefix(socketPath, "@") {
+		socketPath = "\x00" + socketPath[1:]
+	}
+	connection, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		log.Println("Failed to notify systemd:", err)
+		return
+	}
+	defer connection.Close()
+	if _, err := connection.Write([]byte(state)); err != nil {
+		log.Println("Failed to notify systemd:", err)
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// errNotWindows is what the Windows service functions return on other systems.
+var errNotWindows = errors.New("windows services are only available on windows")
+
+// isWindowsService reports whether the Service Control Manager started the process, never outside Windows.
+func isWindowsService() bool {
+	return false
+}
+
+// runWindowsService is not available outside Windows.
+func runWindowsService(args []string) error {
+	return errNotWindows
+}
+
+// installWindowsService is not available outside Windows.
+func installWindowsService(options serviceOptions, executable string, workDir string) error {
+	return errNotWindows
+}
+
+// uninstallWindowsService is not available outside Windows.
+func uninstallWindowsService(options serviceOptions) error {
+	return errNotWindows
+}
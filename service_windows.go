@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceDisplayName is how the Services console lists the daemon.
+const serviceDisplayName = "SABIC SDS document sync"
+
+// serviceStopTimeout is how long uninstall-service waits for a running daemon to stop.
+const serviceStopTimeout = 2 * time.Minute
+
+// workingDirFlag leads the arguments install-service registers, naming the directory the service
+// runs in, as the Service Control Manager starts services in the system directory.
+const workingDirFlag = "-working-dir"
+
+// isWindowsService reports whether the Service Control Manager started the process.
+func isWindowsService() bool {
+	service, err := svc.IsWindowsService()
+	if err != nil {
+		log.Println("Failed to tell whether we run as a service:", err)
+	}
+	return service
+}
+
+// daemonService runs the daemon for the Service Control Manager, whose stop and shutdown requests
+// take the place of SIGTERM.
+type daemonService struct {
+	args []string // Daemon flags, as registered by install-service
+}
+
+// Execute implements svc.Handler. A stop request cancels the daemon's context, which lets a sync in
+// progress finish within shutdown_grace; the manager is kept waiting meanwhile.
+func (service *daemonService) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	const accepted = svc.AcceptStop | svc.AcceptShutdown
+	status <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- serveDaemon(ctx, service.args)
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: accepted}
+	for {
+		select {
+		case err := <-done:
+			// The daemon gave up on its own, e.g. on a bad config: the recovery actions restart it.
+			if err != nil {
+				log.Println(err)
+				return false, 1
+			}
+			return false, 0
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				cancel()
+				return false, waitForDaemon(done, status)
+			}
+		}
+	}
+}
+
+// waitForDaemon reports progress to the Service Control Manager until the daemon returns, and
+// returns its exit code.
+func waitForDaemon(done <-chan error, status chan<- svc.Status) uint32 {
+	const hint = 10 * time.Second
+	checkpoint := uint32(1)
+	status <- svc.Status{State: svc.StopPending, CheckPoint: checkpoint, WaitHint: uint32(hint / time.Millisecond)}
+	ticker := time.NewTicker(hint / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				log.Println(err)
+				return 1
+			}
+			return 0
+		case <-ticker.C:
+			checkpoint = checkpoint + 1
+			status <- svc.Status{State: svc.StopPending, CheckPoint: checkpoint, WaitHint: uint32(hint / time.Millisecond)}
+		}
+	}
+}
+
+// runWindowsService runs the daemon under the Service Control Manager until it asks it to stop, in
+// the directory install-service was run from, so relative paths of the config resolve as they did
+// there. The name is only checked for services sharing a process, which this one doesn't.
+func runWindowsService(args []string) error {
+	if len(args) >= 2 && args[0] == workingDirFlag {
+		if err := os.Chdir(args[1]); err != nil {
+			return fmt.Errorf("failed to change to the working directory of the service: %v", err)
+		}
+		args = args[2:]
+	}
+	return svc.Run(defaultServiceName, &daemonService{args: args})
+}
+
+// installWindowsService registers the daemon with the Service Control Manager, started at boot and
+// restarted after failures, and starts it. It runs in workDir, like WorkingDirectory of the systemd unit.
+func installWindowsService(options serviceOptions, executable string, workDir string) error {
+	config := mgr.Config{
+		DisplayName:      serviceDisplayName,
+		Description:      "Keeps the SABIC safety data sheet corpus in sync.",
+		StartType:        mgr.StartAutomatic,
+		DelayedAutoStart: true, // Like systemd's After=network-online.target
+		ServiceStartName: options.user,
+	}
+	daemonArgs := append([]string{"daemon", workingDirFlag, workDir}, options.daemonArgs...)
+	if options.print {
+		account := options.user
+		if account == "" {
+			account = "LocalSystem"
+		}
+		fmt.Printf("service %s (%s)\n  command: %s\n  directory: %s\n  account: %s\n  start: automatic, delayed\n  restart on failure after 30s\n",
+			options.name, serviceDisplayName, quoteCommandLine(append([]string{executable}, daemonArgs...)), workDir, account)
+		return nil
+	}
+	manager, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the Service Control Manager: %v", err)
+	}
+	defer manager.Disconnect()
+	service, err := manager.CreateService(options.name, executable, config, daemonArgs...)
+	if err != nil {
+		return fmt.Errorf("failed to create service %s: %v", options.name, err)
+	}
+	defer service.Close()
+	// Restart after failures, like Restart=on-failure and RestartSec=30s of the systemd unit.
+	if err := service.SetRecoveryActions([]mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: 30 * time.Second}}, uint32((24 * time.Hour).Seconds())); err != nil {
+		return fmt.Errorf("failed to set the recovery actions of %s: %v", options.name, err)
+	}
+	if err := service.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %v", options.name, err)
+	}
+	return nil
+}
+
+// uninstallWindowsService stops the daemon, waiting for it to finish, and removes its registration.
+func uninstallWindowsService(options serviceOptions) error {
+	if options.print {
+		fmt.Printf("stop service %s\ndelete service %s\n", options.name, options.name)
+		return nil
+	}
+	manager, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the Service Control Manager: %v", err)
+	}
+	defer manager.Disconnect()
+	service, err := manager.OpenService(options.name)
+	if err != nil {
+		return fmt.Errorf("failed to open service %s: %v", options.name, err)
+	}
+	defer service.Close()
+	current, err := service.Control(svc.Stop)
+	switch {
+	case errors.Is(err, windows.ERROR_SERVICE_NOT_ACTIVE):
+	case err != nil:
+		return fmt.Errorf("failed to stop %s: %v", options.name, err)
+	default:
+		deadline := time.Now().Add(serviceStopTimeout)
+		for current.State != svc.Stopped {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("%s didn't stop within %s", options.name, serviceStopTimeout)
+			}
+			time.Sleep(time.Second)
+			if current, err = service.Query(); err != nil {
+				return fmt.Errorf("failed to query %s: %v", options.name, err)
+			}
+		}
+	}
+	if err := service.Delete(); err != nil {
+		return fmt.Errorf("failed to delete service %s: %v", options.name, err)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sharedPathPrefix is where signed links point; the route carries no role, the signature is the credential.
+const sharedPathPrefix = "/shared/"
+
+// sharedLink is a signed, expiring link to one document.
+type sharedLink struct {
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+// sharedLinkSchema is the OpenAPI schema of sharedLink.
+var sharedLinkSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"url":     map[string]any{"type": "string"},
+		"expires": map[string]any{"type": "string", "format": "date-time"},
+	},
+}
+
+// shareSignature returns the signature of a link to name valid until expires (Unix seconds).
+// Both are signed, so a link can't be pointed at another document or kept alive longer.
+func shareSignature(key string, name string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	fmt.Fprintf(mac, "share/1\n%s\n%d", name, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signShareURL returns the signed link to name below baseURL, valid for ttl from now.
+func signShareURL(key string, baseURL string, name string, ttl time.Duration, now time.Time) sharedLink {
+	expires := now.Add(ttl).Truncate(time.Second)
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("signature", shareSignature(key, name, expires.Unix()))
+	link := strings.TrimSuffix(baseURL, "/") + sharedPathPrefix + url.PathEscape(name) + "?" + query.Encode()
+	return sharedLink{URL: link, Expires: expires.UTC()}
+}
+
+// verifyShareURL checks the expiry and signature of a request for a shared document.
+func verifyShareURL(key string, name string, query url.Values, now time.Time) error {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("missing or invalid expiry")
+	}
+	if now.Unix() > expires {
+		return fmt.Errorf("the link expired at %s", time.Unix(expires, 0).UTC().Format(time.RFC3339))
+	}
+	if !hmac.Equal([]byte(query.Get("signature")), []byte(shareSignature(key, name, expires))) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// shareTTL parses a requested lifetime, the configured default when empty, capped at the maximum.
+func shareTTL(cfg *Config, requested string) (time.Duration, error) {
+	if requested == "" {
+		return cfg.ShareTTL.Duration, nil
+	}
+	ttl, err := time.ParseDuration(requested)
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("invalid ttl %q, expected a duration such as 72h", requested)
+	}
+	if cfg.ShareMaxTTL.Duration > 0 && ttl > cfg.ShareMaxTTL.Duration {
+		return 0, fmt.Errorf("ttl %s is above the maximum of %s", ttl, cfg.ShareMaxTTL.Duration)
+	}
+	return ttl, nil
+}
+
+// handleShareDocument serves POST /api/documents/{name}/share, signing a link any recipient can open until it expires.
+func (srv *corpusServer) handleShareDocument() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		key := srv.cfg.shareSigningKey()
+		if key == "" {
+			writeJSONError(writer, http.StatusNotImplemented, "share_signing_key is not configured")
+			return
+		}
+		name := request.PathValue("name")
+		if name != filepath.Base(name) || !strings.HasSuffix(name, ".pdf") {
+			writeJSONError(writer, http.StatusNotFound, "no such document")
+			return
+		}
+		if _, ok := srv.store.path(name); !ok {
+			writeJSONError(writer, http.StatusNotFound, "no such document")
+			return
+		}
+		ttl, err := shareTTL(srv.cfg, request.URL.Query().Get("ttl"))
+		if err != nil {
+			writeJSONError(writer, http.StatusBadRequest, err.Error())
+			return
+		}
+		link := signShareURL(key, serverBaseURL(srv.cfg, request), name, ttl, time.Now())
+		srv.audit.record(auditShared, name, principalFromRequest(request).Name+"@"+request.RemoteAddr+" until "+link.Expires.Format(time.RFC3339), "")
+		writeJSON(writer, http.StatusOK, link)
+	}
+}
+
+// handleGetSharedDocument serves GET /shared/{name}, the document behind a valid signed link.
+func (srv *corpusServer) handleGetSharedDocument() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		key := srv.cfg.shareSigningKey()
+		name := request.PathValue("name")
+		if key == "" || name != filepath.Base(name) || !strings.HasSuffix(name, ".pdf") {
+			writeJSONError(writer, http.StatusNotFound, "no such document")
+			return
+		}
+		if err := verifyShareURL(key, name, request.URL.Query(), time.Now()); err != nil {
+			writeJSONError(writer, http.StatusForbidden, err.Error())
+			return
+		}
+		srv.serveDocument(writer, request, name, "shared-link@"+request.RemoteAddr)
+	}
+}
+
+// runShareURL implements the share-url command, printing a signed link for each named document.
+func runShareURL(args []string) error {
+	var ttlText string
+	cfg, names, err := loadConfig("share-url", args, func(flagSet *flag.FlagSet) {
+		flagSet.StringVar(&ttlText, "ttl", "", "how long the links stay valid, share_ttl when empty")
+	})
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("usage: share-url [flags] <document>...")
+	}
+	key := cfg.shareSigningKey()
+	if key == "" {
+		return fmt.Errorf("share_signing_key is not configured")
+	}
+	if cfg.SiteURL == "" {
+		return fmt.Errorf("site_url must name the server the links point at")
+	}
+	ttl, err := shareTTL(cfg, ttlText)
+	if err != nil {
+		return err
+	}
+	store, err := openDocumentStore(cfg)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, name := range names {
+		if _, ok := store.path(name); !ok {
+			return fmt.Errorf("%s is not stored", name)
+		}
+		link := signShareURL(key, cfg.SiteURL, name, ttl, now)
+		fmt.Println(link.URL)
+	}
+	return nil
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Microsoft Graph endpoints used by sharepoint:// destinations.
+const (
+	graphBaseURL  = "https://graph.microsoft.com/v1.0"
+	graphLoginURL = "https://login.microsoftonline.com"
+)
+
+// Upload sizes of the Graph API: small files go in one PUT, larger ones through an upload session
+// in chunks that must be multiples of 320 KiB.
+const (
+	graphSimpleUploadLimit = 4 << 20
+	graphChunkSize         = 10 * 320 << 10
+)
+
+// graphClient calls Microsoft Graph with an app-only token from the client credentials flow.
+type graphClient struct {
+	baseURL  string
+	tokenURL string
+	clientID string
+	secret   string
+	client   *http.Client
+	mutex    sync.Mutex
+	token    string
+	expires  time.Time
+}
+
+// newGraphClient returns a client for the configured app registration.
+func newGraphClient(cfg *Config) (*graphClient, error) {
+	if cfg.GraphTenantID == "" || cfg.GraphClientID == "" || cfg.graphClientSecret() == "" {
+		return nil, fmt.Errorf("graph_tenant_id, graph_client_id and graph_client_secret (or GRAPH_CLIENT_SECRET) must be set to use sharepoint:// destinations")
+	}
+	return &graphClient{
+		baseURL:  graphBaseURL,
+		tokenURL: graphLoginURL + "/" + url.PathEscape(cfg.GraphTenantID) + "/oauth2/v2.0/token",
+		clientID: cfg.GraphClientID,
+		secret:   cfg.graphClientSecret(),
+		client:   &http.Client{Timeout: 10 * time.Minute},
+	}, nil
+}
+
+// accessToken returns a valid token, fetching a new one shortly before the old one expires.
+func (graph *graphClient) accessToken(ctx context.Context) (string, error) {
+	graph.mutex.Lock()
+	defer graph.mutex.Unlock()
+	if graph.token != "" && time.Now().Before(graph.expires) {
+		return graph.token, nil
+	}
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {graph.clientID},
+		"client_secret": {graph.secret},
+		"scope":         {"https://graph.microsoft.com/.default"},
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, graph.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, err := graph.client.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("failed to get a Graph token: %v", err)
+	}
+	defer response.Body.Close()
+	var reply struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		Error       string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&reply); err != nil || reply.AccessToken == "" {
+		return "", fmt.Errorf("failed to get a Graph token: %s %s", response.Status, reply.Error)
+	}
+	graph.token = reply.AccessToken
+	graph.expires = time.Now().Add(time.Duration(reply.ExpiresIn)*time.Second - time.Minute)
+	return graph.token, nil
+}
+
+// do sends a request to Graph, path relative to the API root or an absolute URL such as an upload
+// session's, turning non-2xx responses into statusCodeErrors.
+func (graph *graphClient) do(ctx context.Context, method string, path string, body io.Reader, header http.Header) (*http.Response, error) {
+	// Upload session URLs are absolute and carry their own authorization, refusing a second one.
+	absolute := strings.HasPrefix(path, "https://") || strings.HasPrefix(path, "http://")
+	target := path
+	if !absolute {
+		target = graph.baseURL + path
+	}
+	request, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		request.Header[http.CanonicalHeaderKey(name)] = values
+	}
+	if !absolute {
+		token, err := graph.accessToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	if reader, ok := body.(*bytes.Reader); ok {
+		request.ContentLength = int64(reader.Len())
+	}
+	response, err := graph.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %v", method, request.URL.Redacted(), err)
+	}
+	if response.StatusCode/100 != 2 {
+		detail, _ := io.ReadAll(io.LimitReader(response.Body, 4<<10))
+		response.Body.Close()
+		return nil, &statusCodeError{statusCode: response.StatusCode, err: fmt.Errorf("%s %s: %s %s", method, request.URL.Redacted(), response.Status, strings.TrimSpace(string(detail)))}
+	}
+	return response, nil
+}
+
+// doJSON sends a request with an optional JSON body and decodes the JSON reply into result.
+func (graph *graphClient) doJSON(ctx context.Context, method string, path string, value any, result any) error {
+	var body io.Reader
+	header := http.Header{}
+	if value != nil {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(encoded)
+		header.Set("Content-Type", "application/json")
+	}
+	response, err := graph.do(ctx, method, path, body, header)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// sharePointMirror mirrors to a folder of a SharePoint document library, or a OneDrive, and fills
+// in the library's metadata columns for each document. Destinations look like
+// sharepoint://contoso.sharepoint.com/sites/EHS/Safety Data Sheets/SABIC.
+type sharePointMirror struct {
+	graph   *graphClient
+	cfg     *Config
+	docs    *catalog
+	host    string // SharePoint host name
+	site    string // Server-relative site path, e.g. /sites/EHS
+	library string // Display name of the document library
+	folder  string // Folder inside the library, empty for its root
+	driveID string // Resolved on first use
+}
+
+// newSharePointMirror parses a sharepoint:// destination.
+func newSharePointMirror(cfg *Config, docs *catalog, parsed *url.URL) (*sharePointMirror, error) {
+	segments := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+	if len(segments) < 3 || (segments[0] != "sites" && segments[0] != "teams") {
+		return nil, fmt.Errorf("sharepoint destinations look like sharepoint://host/sites/<site>/<library>[/folder], not %s", parsed.Redacted())
+	}
+	graph, err := newGraphClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &sharePointMirror{
+		graph:   graph,
+		cfg:     cfg,
+		docs:    docs,
+		host:    parsed.Host,
+		site:    "/" + segments[0] + "/" + segments[1],
+		library: segments[2],
+		folder:  strings.Join(segments[3:], "/"),
+	}, nil
+}
+
+// drive resolves the site and library to the id of the library's drive.
+func (target *sharePointMirror) drive(ctx context.Context) (string, error) {
+	if target.driveID != "" {
+		return target.driveID, nil
+	}
+	var site struct {
+		ID string `json:"id"`
+	}
+	if err := target.graph.doJSON(ctx, http.MethodGet, "/sites/"+target.host+":"+escapeGraphPath(target.site), nil, &site); err != nil {
+		return "", fmt.Errorf("failed to find SharePoint site %s%s: %w", target.host, target.site, err)
+	}
+	var drives struct {
+		Value []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"value"`
+	}
+	if err := target.graph.doJSON(ctx, http.MethodGet, "/sites/"+site.ID+"/drives", nil, &drives); err != nil {
+		return "", fmt.Errorf("failed to list the libraries of %s: %w", target.site, err)
+	}
+	for _, drive := range drives.Value {
+		if strings.EqualFold(drive.Name, target.library) {
+			target.driveID = drive.ID
+			return drive.ID, nil
+		}
+	}
+	return "", fmt.Errorf("site %s has no document library named %q", target.site, target.library)
+}
+
+// escapeGraphPath percent-encodes the segments of a path, keeping the slashes.
+func escapeGraphPath(value string) string {
+	segments := strings.Split(value, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// itemPath returns the path-based address of a document in the library, e.g. /drives/{id}/root:/SABIC/x.pdf:
+func (target *sharePointMirror) itemPath(driveID string, name string) string {
+	relative := name
+	if target.folder != "" {
+		relative = target.folder + "/" + name
+	}
+	return "/drives/" + driveID + "/root:/" + escapeGraphPath(relative) + ":"
+}
+
+// put implements mirrorTarget: it uploads the file, then sets the library columns of the new version.
+func (target *sharePointMirror) put(ctx context.Context, name string, localPath string, sha256 string) error {
+	if !isSafeName(name) {
+		return fmt.Errorf("refusing to mirror %q: not a plain file name", name)
+	}
+	driveID, err := target.drive(ctx)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	var item struct {
+		ID string `json:"id"`
+	}
+	if info.Size() <= graphSimpleUploadLimit {
+		content, err := os.ReadFile(localPath)
+		if err != nil {
+			return err
+		}
+		response, err := target.graph.do(ctx, http.MethodPut, target.itemPath(driveID, name)+"/content", bytes.NewReader(content), http.Header{"Content-Type": {"application/pdf"}})
+		if err != nil {
+			return err
+		}
+		err = json.NewDecoder(response.Body).Decode(&item)
+		response.Body.Close()
+		if err != nil {
+			return err
+		}
+	} else if item.ID, err = target.uploadSession(ctx, driveID, name, localPath, info.Size()); err != nil {
+		return err
+	}
+	fields := target.columns(name, sha256)
+	if len(fields) == 0 {
+		return nil
+	}
+	if err := target.graph.doJSON(ctx, http.MethodPatch, "/drives/"+driveID+"/items/"+item.ID+"/listItem/fields", fields, nil); err != nil {
+		return fmt.Errorf("uploaded %s but failed to set its columns: %w", name, err)
+	}
+	return nil
+}
+
+// uploadSession uploads a large file in chunks and returns the id of the new item.
+func (target *sharePointMirror) uploadSession(ctx context.Context, driveID string, name string, localPath string, size int64) (string, error) {
+	var session struct {
+		UploadURL string `json:"uploadUrl"`
+	}
+	request := map[string]any{"item": map[string]any{"@microsoft.graph.conflictBehavior": "replace"}}
+	if err := target.graph.doJSON(ctx, http.MethodPost, target.itemPath(driveID, name)+"/createUploadSession", request, &session); err != nil {
+		return "", err
+	}
+	file, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	chunk := make([]byte, graphChunkSize)
+	for offset := int64(0); offset < size; {
+		read, err := io.ReadFull(file, chunk)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return "", err
+		}
+		header := http.Header{"Content-Range": {fmt.Sprintf("bytes %d-%d/%d", offset, offset+int64(read)-1, size)}}
+		response, err := target.graph.do(ctx, http.MethodPut, session.UploadURL, bytes.NewReader(chunk[:read]), header)
+		if err != nil {
+			return "", err
+		}
+		offset = offset + int64(read)
+		// The last chunk is answered with the finished item.
+		if offset == size {
+			var item struct {
+				ID string `json:"id"`
+			}
+			err = json.NewDecoder(response.Body).Decode(&item)
+			response.Body.Close()
+			return item.ID, err
+		}
+		response.Body.Close()
+	}
+	return "", fmt.Errorf("upload of %s ended early", name)
+}
+
+// columns returns the library column values of a document, as mapped by sharepoint_columns.
+func (target *sharePointMirror) columns(name string, sha256 string) map[string]any {
+	info, _ := parseDocumentFilename(name)
+	entry, _ := target.docs.get(name)
+	values := map[string]string{
+		"material":      info.Material,
+		"language":      info.Language,
+		"sbgvid":        info.Sbgvid,
+		"internal_code": info.InternalCode,
+		"sha256":        sha256,
+	}
+	// Date columns take ISO 8601 date-times.
+	if entry.IssueDate != "" {
+		values["revision_date"] = entry.IssueDate + "T00:00:00Z"
+	}
+	fields := make(map[string]any)
+	for field, column := range target.cfg.SharePointColumns {
+		if value := values[field]; value != "" && column != "" {
+			fields[column] = value
+		}
+	}
+	return fields
+}
+
+// hash implements mirrorTarget by downloading the copy and hashing it, since SharePoint keeps no SHA-256.
+func (target *sharePointMirror) hash(ctx context.Context, name string) (string, bool, error) {
+	driveID, err := target.drive(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	response, err := target.graph.do(ctx, http.MethodGet, target.itemPath(driveID, name)+"/content", nil, nil)
+	if err != nil {
+		var statusErr *statusCodeError
+		if errors.As(err, &statusErr) && statusErr.statusCode == http.StatusNotFound {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	defer response.Body.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, response.Body); err != nil {
+		return "", false, err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), true, nil
+}
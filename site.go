@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// siteDocumentsDir is the folder of the exported site holding the PDFs it links to.
+const siteDocumentsDir = "documents"
+
+// siteDocument is one document as listed on the exported site.
+type siteDocument struct {
+	documentInfo
+	Description string // Material description from the catalog, see locale.go
+	Link        string // Where the PDF is served, relative to the site or absolute with -link-base
+}
+
+// siteGroup is one heading of a listing page, e.g. one material and its documents.
+type siteGroup struct {
+	Name      string
+	Anchor    string
+	Documents []siteDocument
+}
+
+// sitePage is what the page template renders.
+type sitePage struct {
+	Title     string
+	Generated string
+	Total     int
+	Listing   string // Empty on the index page
+	Groups    []siteGroup
+	Listings  []siteListing
+}
+
+// siteListing is one page of the site grouping the documents one way.
+type siteListing struct {
+	File  string
+	Title string
+	// groupOf returns the heading a document goes under.
+	groupOf func(document siteDocument) string
+}
+
+// siteListings are the listing pages of the exported site.
+var siteListings = []siteListing{
+	{File: "by-material.html", Title: "By material", groupOf: func(document siteDocument) string {
+		name := document.Material
+		if document.InternalCode != "" {
+			name = document.InternalCode + " (" + document.Material + ")"
+		}
+		if document.Description != "" {
+			name = name + " – " + document.Description
+		}
+		return name
+	}},
+	{File: "by-language.html", Title: "By language", groupOf: func(document siteDocument) string { return document.Language }},
+}
+
+// sitePageTemplate renders the index and every listing page. It loads nothing from outside the page,
+// so the site works from any web server, a bucket or a file share.
+var sitePageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}{{with .Listing}} – {{.}}{{end}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em auto; max-width: 60em; padding: 0 1em; color: #222; }
+nav a { margin-right: 1em; }
+h2 { font-size: 1.1em; margin-top: 1.5em; border-bottom: 1px solid #ddd; }
+ul { padding-left: 1.2em; }
+.meta { color: #666; font-size: 0.9em; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<nav><a href="index.html">Overview</a>{{range .Listings}}<a href="{{.File}}">{{.Title}}</a>{{end}}</nav>
+{{if .Listing}}<p class="meta">{{.Listing}}: {{len .Groups}} groups, {{.Total}} documents</p>
+{{range .Groups}}<h2 id="{{.Anchor}}">{{.Name}}</h2>
+<ul>
+{{range .Documents}}<li><a href="{{.Link}}">{{.Name}}</a> <span class="meta">{{.Sbgvid}} {{.Language}}{{with .ProductFamily}} · {{.}}{{end}}</span></li>
+{{end}}</ul>
+{{end}}{{else}}<p>{{.Total}} safety data sheets.</p>
+<ul>
+{{range .Listings}}<li><a href="{{.File}}">{{.Title}}</a></li>
+{{end}}</ul>
+{{end}}<p class="meta">Generated {{.Generated}}</p>
+</body>
+</html>
+`))
+
+// exportSite writes the static site to dir: an index, one page per listing and, unless linkBase
+// is given, a copy of every PDF so the directory can be published as it is.
+func exportSite(cfg *Config, store documentStore, dir string, linkBase string) error {
+	documents, err := listDocuments(store)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	docs, err := openCatalog(cfg.CatalogFile)
+	if err != nil {
+		return err
+	}
+	withProductFamilies(docs, documents)
+	if err := os.MkdirAll(filepath.Join(dir, siteDocumentsDir), 0o755); err != nil {
+		return err
+	}
+	site := make([]siteDocument, 0, len(documents))
+	for _, document := range documents {
+		entry, _ := docs.get(document.Name)
+		link := siteDocumentsDir + "/" + url.PathEscape(document.Name)
+		if linkBase != "" {
+			link = strings.TrimSuffix(linkBase, "/") + "/" + url.PathEscape(document.Name)
+		} else if err := copySiteDocument(store, document.Name, filepath.Join(dir, siteDocumentsDir, document.Name)); err != nil {
+			return err
+		}
+		site = append(site, siteDocument{documentInfo: document, Description: materialDescription(cfg, entry.Properties), Link: link})
+	}
+	shareDescriptions(site)
+	// Copies of documents that left the store leave the site too.
+	if linkBase == "" {
+		if err := pruneSiteDocuments(filepath.Join(dir, siteDocumentsDir), documents); err != nil {
+			return err
+		}
+	}
+	page := sitePage{Title: "SABIC safety data sheets", Generated: time.Now().UTC().Format(time.RFC3339), Total: len(site), Listings: siteListings}
+	if err := writeSitePage(filepath.Join(dir, "index.html"), page); err != nil {
+		return err
+	}
+	for _, listing := range siteListings {
+		page.Listing = listing.Title
+		page.Groups = groupSiteDocuments(site, listing.groupOf)
+		if err := writeSitePage(filepath.Join(dir, listing.File), page); err != nil {
+			return err
+		}
+	}
+	if err := writeSiteSitemaps(cfg, dir, site); err != nil {
+		return err
+	}
+	log.Printf("exported %d documents to %s", len(site), dir)
+	return nil
+}
+
+// shareDescriptions gives every document of a material the same description. Not every language
+// version carries one, so the first one found is used for all.
+func shareDescriptions(site []siteDocument) {
+	descriptions := make(map[string]string)
+	for _, document := range site {
+		if descriptions[document.Material] == "" {
+			descriptions[document.Material] = document.Description
+		}
+	}
+	for i := range site {
+		site[i].Description = descriptions[site[i].Material]
+	}
+}
+
+// groupSiteDocuments groups documents by heading, headings sorted and documents in name order.
+func groupSiteDocuments(documents []siteDocument, groupOf func(document siteDocument) string) []siteGroup {
+	byName := make(map[string]*siteGroup)
+	var groups []siteGroup
+	var names []string
+	for _, document := range documents {
+		name := groupOf(document)
+		if name == "" {
+			name = "unknown"
+		}
+		if _, ok := byName[name]; !ok {
+			byName[name] = &siteGroup{Name: name}
+			names = append(names, name)
+		}
+		byName[name].Documents = append(byName[name].Documents, document)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		group := *byName[name]
+		group.Anchor = fmt.Sprintf("g%d", i+1)
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// writeSitePage renders one page and writes it atomically, so a web server never serves half a page.
+func writeSitePage(path string, page sitePage) error {
+	var rendered bytes.Buffer
+	if err := sitePageTemplate.Execute(&rendered, page); err != nil {
+		return err
+	}
+	return writeFileAtomically(path, rendered.Bytes(), 0o644)
+}
+
+// copySiteDocument copies a stored PDF into the site unless an identical copy is already there.
+func copySiteDocument(store documentStore, name string, target string) error {
+	source, ok := store.path(name)
+	if !ok {
+		return nil
+	}
+	sourceInfo, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	// Same size and time means the copy came from this revision.
+	if targetInfo, err := os.Stat(target); err == nil && targetInfo.Size() == sourceInfo.Size() && targetInfo.ModTime().Equal(sourceInfo.ModTime()) {
+		return nil
+	}
+	if err := copyStoredDocument(store, name, target); err != nil {
+		return fmt.Errorf("failed to copy %s to the site: %v", name, err)
+	}
+	return os.Chtimes(target, sourceInfo.ModTime(), sourceInfo.ModTime())
+}
+
+// pruneSiteDocuments removes copies of documents no longer in the store.
+func pruneSiteDocuments(dir string, documents []documentInfo) error {
+	stored := make(map[string]bool, len(documents))
+	for _, document := range documents {
+		stored[document.Name] = true
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || stored[entry.Name()] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runExportSite implements the export-site command.
+func runExportSite(args []string) error {
+	var linkBase string
+	cfg, _, err := loadConfig("export-site", args, func(flagSet *flag.FlagSet) {
+		flagSet.StringVar(&linkBase, "link-base", "", "link to the PDFs under this URL instead of copying them into the site")
+	})
+	if err != nil {
+		return err
+	}
+	store, err := openDocumentStore(cfg)
+	if err != nil {
+		return err
+	}
+	return exportSite(cfg, store, cfg.SiteDir, linkBase)
+}
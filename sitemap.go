@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxSitemapURLs is the most URLs one sitemap file may list; larger libraries get a sitemap index.
+const maxSitemapURLs = 50000
+
+// sitemapPartsDir holds the parts of a sitemap too large for one file, both on the site and on the server.
+const sitemapPartsDir = "sitemaps"
+
+// sitemapURL is one <url> of a sitemap.
+type sitemapURL struct {
+	Location     string `xml:"loc"`
+	LastModified string `xml:"lastmod,omitempty"`
+}
+
+// sitemapURLSet is a sitemap file.
+type sitemapURLSet struct {
+	XMLName   xml.Name     `xml:"urlset"`
+	Namespace string       `xml:"xmlns,attr"`
+	URLs      []sitemapURL `xml:"url"`
+}
+
+// sitemapIndex lists the parts of a split sitemap.
+type sitemapIndex struct {
+	XMLName   xml.Name     `xml:"sitemapindex"`
+	Namespace string       `xml:"xmlns,attr"`
+	Sitemaps  []sitemapURL `xml:"sitemap"`
+}
+
+// sitemapNamespace is the XML namespace of the sitemaps protocol.
+const sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+// buildSitemaps renders the sitemap of urls, keyed by path relative to baseURL: sitemap.xml alone,
+// or sitemap.xml as an index of sitemaps/1.xml, sitemaps/2.xml and so on past maxSitemapURLs.
+func buildSitemaps(baseURL string, urls []sitemapURL) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	if len(urls) <= maxSitemapURLs {
+		content, err := encodeSitemap(sitemapURLSet{Namespace: sitemapNamespace, URLs: urls})
+		if err != nil {
+			return nil, err
+		}
+		files["sitemap.xml"] = content
+		return files, nil
+	}
+	index := sitemapIndex{Namespace: sitemapNamespace}
+	for part := 1; len(urls) > 0; part++ {
+		chunk := urls[:min(len(urls), maxSitemapURLs)]
+		urls = urls[len(chunk):]
+		content, err := encodeSitemap(sitemapURLSet{Namespace: sitemapNamespace, URLs: chunk})
+		if err != nil {
+			return nil, err
+		}
+		name := fmt.Sprintf("%s/%d.xml", sitemapPartsDir, part)
+		files[name] = content
+		index.Sitemaps = append(index.Sitemaps, sitemapURL{Location: joinURL(baseURL, name)})
+	}
+	content, err := encodeSitemap(index)
+	if err != nil {
+		return nil, err
+	}
+	files["sitemap.xml"] = content
+	return files, nil
+}
+
+// encodeSitemap renders a sitemap or sitemap index with its XML declaration.
+func encodeSitemap(value any) ([]byte, error) {
+	content, err := xml.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(content, '\n')...), nil
+}
+
+// robotsTxt returns the robots directives of the library: everything under allow may be crawled,
+// nothing under disallow, and the sitemap says where to start.
+func robotsTxt(baseURL string, allow []string, disallow []string) []byte {
+	var robots strings.Builder
+	robots.WriteString("User-agent: *\n")
+	for _, path := range disallow {
+		fmt.Fprintf(&robots, "Disallow: %s\n", path)
+	}
+	for _, path := range allow {
+		fmt.Fprintf(&robots, "Allow: %s\n", path)
+	}
+	fmt.Fprintf(&robots, "\nSitemap: %s\n", joinURL(baseURL, "sitemap.xml"))
+	return []byte(robots.String())
+}
+
+// joinURL appends a slash separated path to a base URL.
+func joinURL(baseURL string, path string) string {
+	return strings.TrimSuffix(baseURL, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
+// sitemapDate is the W3C date format of <lastmod>.
+func sitemapDate(at time.Time) string {
+	return at.UTC().Format("2006-01-02")
+}
+
+// writeSiteSitemaps writes sitemap.xml and robots.txt of an exported site. Sitemaps need absolute URLs,
+// so without cfg.SiteURL the site is exported without them. Crawlers only read robots.txt at the root
+// of a host, so it takes effect when the site is published there.
+func writeSiteSitemaps(cfg *Config, dir string, documents []siteDocument) error {
+	if cfg.SiteURL == "" {
+		log.Println("site_url is not set, skipping sitemap.xml and robots.txt")
+		return nil
+	}
+	today := sitemapDate(time.Now())
+	urls := []sitemapURL{{Location: joinURL(cfg.SiteURL, "index.html"), LastModified: today}}
+	for _, listing := range siteListings {
+		urls = append(urls, sitemapURL{Location: joinURL(cfg.SiteURL, listing.File), LastModified: today})
+	}
+	for _, document := range documents {
+		location := document.Link
+		if !strings.Contains(location, "://") {
+			location = joinURL(cfg.SiteURL, location)
+		}
+		urls = append(urls, sitemapURL{Location: location, LastModified: sitemapDate(document.Modified)})
+	}
+	files, err := buildSitemaps(cfg.SiteURL, urls)
+	if err != nil {
+		return err
+	}
+	// Parts of an earlier, larger sitemap must not linger.
+	if err := os.RemoveAll(filepath.Join(dir, sitemapPartsDir)); err != nil {
+		return err
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		if err := writeFileAtomically(path, content, 0o644); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomically(filepath.Join(dir, "robots.txt"), robotsTxt(cfg.SiteURL, []string{"/"}, nil), 0o644)
+}
+
+// serverBaseURL returns the public URL of the server: cfg.SiteURL, or the scheme and host the request came in on.
+func serverBaseURL(cfg *Config, request *http.Request) string {
+	if cfg.SiteURL != "" {
+		return cfg.SiteURL
+	}
+	scheme := "http"
+	if request.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + request.Host
+}
+
+// serverSitemaps builds the sitemap of the documents the server serves.
+func (srv *corpusServer) serverSitemaps(request *http.Request) (map[string][]byte, error) {
+	documents, err := listDocuments(srv.store)
+	if err != nil {
+		return nil, err
+	}
+	baseURL := serverBaseURL(srv.cfg, request)
+	urls := make([]sitemapURL, 0, len(documents))
+	for _, document := range documents {
+		urls = append(urls, sitemapURL{Location: joinURL(baseURL, "api/documents/"+url.PathEscape(document.Name)), LastModified: sitemapDate(document.Modified)})
+	}
+	return buildSitemaps(baseURL, urls)
+}
+
+// handleSitemap serves GET /sitemap.xml.
+func (srv *corpusServer) handleSitemap() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		srv.serveSitemapFile(writer, request, "sitemap.xml")
+	}
+}
+
+// handleSitemapPart serves GET /sitemaps/{part}.
+func (srv *corpusServer) handleSitemapPart() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		srv.serveSitemapFile(writer, request, sitemapPartsDir+"/"+request.PathValue("part"))
+	}
+}
+
+// serveSitemapFile writes one file of the server's sitemap.
+func (srv *corpusServer) serveSitemapFile(writer http.ResponseWriter, request *http.Request, name string) {
+	files, err := srv.serverSitemaps(request)
+	if err != nil {
+		writeJSONError(writer, http.StatusInternalServerError, "failed to list documents")
+		log.Println(err)
+		return
+	}
+	content, ok := files[name]
+	if !ok {
+		writeJSONError(writer, http.StatusNotFound, "no such sitemap")
+		return
+	}
+	writer.Header().Set("Content-Type", "application/xml")
+	writer.Write(content)
+}
+
+// handleRobots serves GET /robots.txt: documents may be indexed, the API's machinery may not.
+func (srv *corpusServer) handleRobots() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writer.Write(robotsTxt(serverBaseURL(srv.cfg, request),
+			[]string{"/api/documents/"},
+			[]string{"/api/", "/shared/", "/metrics", "/openapi.json", "/healthz", "/readyz"}))
+	}
+}
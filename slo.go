@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// sloLatencyBuckets are the bounds of the publication latency histogram, around the usual targets.
+var sloLatencyBuckets = []time.Duration{time.Hour, 2 * time.Hour, 4 * time.Hour, 8 * time.Hour, 12 * time.Hour,
+	24 * time.Hour, 48 * time.Hour, 72 * time.Hour, 7 * 24 * time.Hour}
+
+// sloBucketBounds are sloLatencyBuckets in seconds, the unit of the latency metric.
+var sloBucketBounds = func() []float64 {
+	bounds := make([]float64, len(sloLatencyBuckets))
+	for i, bucket := range sloLatencyBuckets {
+		bounds[i] = bucket.Seconds()
+	}
+	return bounds
+}()
+
+// documentPublishedAt returns when a document was published upstream, from the first of the
+// publication_fields its header properties hold a date in. A date without a time stands for the
+// start of that day in UTC, the earliest it can have been published, so no breach goes unnoticed.
+func documentPublishedAt(cfg *Config, properties map[string]string) (time.Time, bool) {
+	for _, field := range cfg.PublicationFields {
+		if published, ok := parseIssueDate(properties[field]); ok {
+			return published.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// holdsPublication reports whether a catalog entry is of the revision published at published or a
+// later one. Entries from before publication dates were recorded go by when they were downloaded.
+func holdsPublication(entry catalogEntry, published time.Time) bool {
+	if entry.PublishedAt != nil {
+		return !entry.PublishedAt.Before(published)
+	}
+	return !entry.DownloadedAt.Before(published)
+}
+
+// sloBreach is a document that wasn't available locally within slo_target of its publication.
+type sloBreach struct {
+	Filename    string     `json:"filename"`
+	URL         string     `json:"url,omitempty"`
+	PublishedAt time.Time  `json:"published_at"`
+	AvailableAt *time.Time `json:"available_at,omitempty"` // Nil while it is still missing
+	Latency     Duration   `json:"latency"`                // Up to when it became available, or up to the check while missing
+}
+
+// sloTracker measures how long new documents take from their publication upstream to being stored,
+// against the promise of slo_target. It is shared by the runs of a process, so a daemon alerts on
+// each breach once: documents stored late when they are stored, and missing ones in the first run
+// after they became overdue.
+type sloTracker struct {
+	mutex       sync.Mutex
+	target      time.Duration
+	runStarted  time.Time   // Start of the current run
+	lastStarted time.Time   // Start of the previous run; documents overdue before it were reported then
+	met         int         // Documents of the current run stored within the target
+	late        int         // Documents of the current run stored after it
+	overdue     int         // Documents of the current run that failed and are past it
+	pending     []sloBreach // Breaches not alerted yet
+}
+
+// publicationSLO is the process-wide tracker.
+var publicationSLO = &sloTracker{}
+
+// configureSLO starts a run of the tracker with the settings of cfg.
+func configureSLO(cfg *Config) {
+	publicationSLO.mutex.Lock()
+	defer publicationSLO.mutex.Unlock()
+	publicationSLO.target = cfg.SLOTarget.Duration
+	publicationSLO.lastStarted = publicationSLO.runStarted
+	publicationSLO.runStarted = time.Now()
+	publicationSLO.met, publicationSLO.late, publicationSLO.overdue = 0, 0, 0
+}
+
+// stored measures a document that became available now.
+func (tracker *sloTracker) stored(filename, url string, published, available time.Time) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	if tracker.target <= 0 {
+		return
+	}
+	latency := max(available.Sub(published), 0)
+	metrics.observe("sabic_publication_latency_seconds", nil, sloBucketBounds, latency.Seconds())
+	if latency <= tracker.target {
+		tracker.met = tracker.met + 1
+		metrics.inc("sabic_slo_documents_total", map[string]string{"outcome": "met"})
+		return
+	}
+	tracker.late = tracker.late + 1
+	metrics.inc("sabic_slo_documents_total", map[string]string{"outcome": "breached"})
+	log.Printf("SLO breach: %s became available %s after its publication, the target is %s", filename, latency.Round(time.Minute), tracker.target)
+	tracker.pending = append(tracker.pending, sloBreach{Filename: filename, URL: url, PublishedAt: published, AvailableAt: &available, Latency: Duration{latency}})
+}
+
+// failed notes a document that couldn't be stored. One past the target is overdue, and reported
+// unless it was already overdue when the previous run started.
+func (tracker *sloTracker) failed(filename, url string, published time.Time) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	if tracker.target <= 0 || published.IsZero() {
+		return
+	}
+	due := published.Add(tracker.target)
+	if due.After(tracker.runStarted) {
+		return
+	}
+	tracker.overdue = tracker.overdue + 1
+	if due.Before(tracker.lastStarted) {
+		return
+	}
+	metrics.inc("sabic_slo_documents_total", map[string]string{"outcome": "overdue"})
+	latency := time.Since(published)
+	log.Printf("SLO breach: %s is still missing %s after its publication, the target is %s", filename, latency.Round(time.Minute), tracker.target)
+	tracker.pending = append(tracker.pending, sloBreach{Filename: filename, URL: url, PublishedAt: published, Latency: Duration{latency}})
+}
+
+// summary describes the current run, empty when it measured nothing.
+func (tracker *sloTracker) summary() string {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	if tracker.met+tracker.late+tracker.overdue == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d of %d new documents available within %s, %d overdue", tracker.met, tracker.met+tracker.late, tracker.target, tracker.overdue)
+}
+
+// takeBreaches returns the breaches not alerted yet and forgets them.
+func (tracker *sloTracker) takeBreaches() []sloBreach {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	breaches := tracker.pending
+	tracker.pending = nil
+	return breaches
+}
+
+// requeue keeps breaches whose alert failed for the next one.
+func (tracker *sloTracker) requeue(breaches []sloBreach) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	tracker.pending = append(breaches, tracker.pending...)
+}
+
+// sloAlertRecipients returns who is emailed about breaches: slo_alert_to, or the digest recipients.
+func sloAlertRecipients(cfg *Config) []string {
+	if len(cfg.SLOAlertTo) > 0 {
+		return cfg.SLOAlertTo
+	}
+	return cfg.DigestTo
+}
+
+// alertSLOBreaches emails the breaches found since the last alert, called by the daemon after each
+// run. Without a mail server they were only logged as they were found. A failed email is tried
+// again after the next run.
+func alertSLOBreaches(cfg *Config) error {
+	breaches := publicationSLO.takeBreaches()
+	recipients := sloAlertRecipients(cfg)
+	if len(breaches) == 0 || cfg.SMTPHost == "" || len(recipients) == 0 {
+		return nil
+	}
+	var body strings.Builder
+	fmt.Fprintf(&body, "%d SABIC documents were not available locally within %s of their publication.\n\n", len(breaches), cfg.SLOTarget)
+	for _, breach := range breaches {
+		if breach.AvailableAt == nil {
+			fmt.Fprintf(&body, "  %s: still missing, published %s\n", breach.Filename, breach.PublishedAt.Format(time.RFC3339))
+		} else {
+			fmt.Fprintf(&body, "  %s: available after %s, published %s\n", breach.Filename, breach.Latency.Round(time.Minute), breach.PublishedAt.Format(time.RFC3339))
+		}
+	}
+	subject := fmt.Sprintf("SABIC SDS SLO breached: %d documents later than %s", len(breaches), cfg.SLOTarget)
+	if err := sendEmail(cfg, recipients, subject, body.String()); err != nil {
+		publicationSLO.requeue(breaches)
+		return fmt.Errorf("failed to send the SLO alert: %v", err)
+	}
+	log.Printf("SLO alert about %d documents sent to %s", len(breaches), strings.Join(recipients, ", "))
+	return nil
+}
+
+// sloBucket counts the documents available within one bound of the latency histogram.
+type sloBucket struct {
+	Within    Duration `json:"within"`
+	Documents int      `json:"documents"` // Cumulative, like the buckets of the metric
+}
+
+// sloReport is the publication latency of the documents stored in a period.
+type sloReport struct {
+	Since        time.Time   `json:"since"`
+	Until        time.Time   `json:"until"`
+	Target       Duration    `json:"target"`
+	Objective    float64     `json:"objective"`
+	Documents    int         `json:"documents"`
+	WithinTarget int         `json:"within_target"`
+	Compliance   float64     `json:"compliance"` // Fraction within the target, 1 without documents
+	Met          bool        `json:"met"`        // Whether compliance reaches the objective
+	P50          Duration    `json:"p50"`
+	P90          Duration    `json:"p90"`
+	P99          Duration    `json:"p99"`
+	Max          Duration    `json:"max"`
+	Buckets      []sloBucket `json:"buckets"`
+	Breaches     []sloBreach `json:"breaches"` // Slowest first
+}
+
+// latencyPercentile returns the nearest-rank percentile of ascending latencies.
+func latencyPercentile(latencies []time.Duration, percentile float64) Duration {
+	if len(latencies) == 0 {
+		return Duration{}
+	}
+	rank := int(math.Ceil(percentile / 100 * float64(len(latencies))))
+	return Duration{latencies[max(rank, 1)-1]}
+}
+
+// buildSLOReport measures the documents stored between since and until from the catalog history,
+// counting a revision only when its publication date moved, as a document fetched again
+// unchanged upstream wasn't published anew.
+func buildSLOReport(cfg *Config, since, until time.Time) (*sloReport, error) {
+	report := &sloReport{Since: since, Until: until, Target: cfg.SLOTarget, Objective: cfg.SLOObjective, Buckets: []sloBucket{}, Breaches: []sloBreach{}}
+	published := make(map[string]time.Time) // Last publication date by file name
+	var latencies []time.Duration
+	err := readCatalogHistory(cfg.CatalogFile, func(event catalogEvent) {
+		if event.Entry == nil || event.Entry.PublishedAt == nil {
+			return
+		}
+		previous, seen := published[event.Filename]
+		published[event.Filename] = *event.Entry.PublishedAt
+		if seen && previous.Equal(*event.Entry.PublishedAt) {
+			return
+		}
+		available := event.Entry.DownloadedAt
+		if available.Before(since) || available.After(until) {
+			return
+		}
+		latency := max(available.Sub(*event.Entry.PublishedAt), 0)
+		latencies = append(latencies, latency)
+		if latency > cfg.SLOTarget.Duration {
+			report.Breaches = append(report.Breaches, sloBreach{Filename: event.Filename, URL: event.Entry.SourceURL,
+				PublishedAt: *event.Entry.PublishedAt, AvailableAt: &available, Latency: Duration{latency}})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	sort.SliceStable(report.Breaches, func(i, j int) bool { return report.Breaches[i].Latency.Duration > report.Breaches[j].Latency.Duration })
+	report.Documents = len(latencies)
+	report.WithinTarget = report.Documents - len(report.Breaches)
+	report.Compliance = 1
+	if report.Documents > 0 {
+		report.Compliance = float64(report.WithinTarget) / float64(report.Documents)
+		report.Max = Duration{latencies[len(latencies)-1]}
+	}
+	report.Met = report.Compliance >= cfg.SLOObjective
+	report.P50, report.P90, report.P99 = latencyPercentile(latencies, 50), latencyPercentile(latencies, 90), latencyPercentile(latencies, 99)
+	for _, bound := range sloLatencyBuckets {
+		within := sort.Search(len(latencies), func(i int) bool { return latencies[i] > bound })
+		report.Buckets = append(report.Buckets, sloBucket{Within: Duration{bound}, Documents: within})
+	}
+	return report, nil
+}
+
+// runSLOCommand implements the slo command: how long the documents stored in a recent period took
+// from their publication upstream to being available locally, against slo_target and slo_objective.
+// Documents still missing aren't in the catalog; the daemon alerts on those.
+func runSLOCommand(args []string) error {
+	var asJSON bool
+	period := Duration{30 * 24 * time.Hour}
+	cfg, _, err := loadConfig("slo", args, func(flagSet *flag.FlagSet) {
+		flagSet.BoolVar(&asJSON, "json", false, "print the report as JSON")
+		flagSet.Var(&period, "period", "report on the documents stored in this period up to now")
+	})
+	if err != nil {
+		return err
+	}
+	until := time.Now().UTC()
+	report, err := buildSLOReport(cfg, until.Add(-period.Duration), until)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	verdict := "met"
+	if !report.Met {
+		verdict = "missed"
+	}
+	fmt.Printf("%.2f%% of %d documents available within %s of their publication, objective %.2f%%: %s\n",
+		report.Compliance*100, report.Documents, report.Target, report.Objective*100, verdict)
+	fmt.Printf("p50 %s, p90 %s, p99 %s, max %s\n\n", report.P50.Round(time.Minute), report.P90.Round(time.Minute), report.P99.Round(time.Minute), report.Max.Round(time.Minute))
+	table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "WITHIN\tDOCUMENTS")
+	for _, bucket := range report.Buckets {
+		fmt.Fprintf(table, "%s\t%d\n", bucket.Within, bucket.Documents)
+	}
+	if len(report.Breaches) > 0 {
+		fmt.Fprintln(table, "\nFILENAME\tPUBLISHED\tAVAILABLE\tLATENCY")
+		for _, breach := range report.Breaches {
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", breach.Filename, breach.PublishedAt.Format(time.RFC3339), breach.AvailableAt.Format(time.RFC3339), breach.Latency.Round(time.Minute))
+		}
+	}
+	return table.Flush()
+}
+
+func init() {
+	metrics.describe("sabic_publication_latency_seconds", "Time from a document's publication upstream to its being stored locally, for new documents and revisions.")
+	metrics.describe("sabic_slo_documents_total", "New documents measured against slo_target, by outcome: met, breached when stored late, overdue when still missing.")
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// maxOpenPages bounds the stored pages read at once while writing a canonical snapshot.
+const maxOpenPages = 64
+
+// canonicalEntry locates one normalized result among the rewritten pages of a scrape.
+type canonicalEntry struct {
+	key    string // Sort key, see canonicalSortKey
+	page   int
+	offset int64
+	length int
+}
+
+// stripVolatile removes what changes between two scrapes of an unchanged catalog from a decoded
+// result: __metadata with its gateway URIs and etags, and navigation properties that are only a
+// __deferred link. It reports false for a value that held nothing else.
+func stripVolatile(value any) (any, bool) {
+	switch value := value.(type) {
+	case map[string]any:
+		stripped := make(map[string]any, len(value))
+		for name, property := range value {
+			if strings.HasPrefix(name, "__") {
+				continue
+			}
+			if property, keep := stripVolatile(property); keep {
+				stripped[name] = property
+			}
+		}
+		return stripped, len(stripped) > 0 || len(value) == 0
+	case []any:
+		for i, element := range value {
+			value[i], _ = stripVolatile(element)
+		}
+		return value, true
+	}
+	return value, true
+}
+
+// canonicalResult returns a header result in canonical form: volatile metadata stripped, keys sorted,
+// numbers as sent and nothing escaped that needn't be. Equal results give equal bytes.
+func canonicalResult(raw json.RawMessage) (map[string]any, []byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var decoded any
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, nil, err
+	}
+	result, ok := decoded.(map[string]any)
+	if !ok {
+		return nil, nil, fmt.Errorf("not an object")
+	}
+	stripped, _ := stripVolatile(result)
+	var encoded bytes.Buffer
+	encoder := json.NewEncoder(&encoded)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(stripped); err != nil {
+		return nil, nil, err
+	}
+	return stripped.(map[string]any), bytes.TrimSuffix(encoded.Bytes(), []byte("\n")), nil
+}
+
+// canonicalSortKey orders results by DocumentID, then by a hash of their canonical bytes, so the
+// order doesn't depend on the paging of the service and a result repeated across pages sorts next
+// to itself. Results without keys sort first.
+func canonicalSortKey(cfg *Config, result map[string]any, canonical []byte) string {
+	property := func(name string) string {
+		if value, ok := result[fieldName(cfg.FieldMap, name)]; ok && value != nil {
+			return fmt.Sprint(value)
+		}
+		return ""
+	}
+	id := DocumentID{Matnr: property("Matnr"), Subid: property("Subid"), Sbgvid: property("Sbgvid"), Laiso: property("Laiso"), Vkorg: property("Vkorg")}
+	sum := sha256.Sum256(canonical)
+	return id.String() + "\x00" + hex.EncodeToString(sum[:8])
+}
+
+// canonicalizePage rewrites a stored page with its results in canonical form, one per line, and
+// returns where each one is. Canonical pages come out unchanged, so a resumed merge may run it again.
+func canonicalizePage(cfg *Config, page int) ([]canonicalEntry, error) {
+	path := scrapePagePath(cfg, page)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var results []json.RawMessage
+	if err := json.Unmarshal(content, &results); err != nil {
+		return nil, fmt.Errorf("failed to parse stored page %d: %v", page, err)
+	}
+	var rewritten bytes.Buffer
+	rewritten.WriteString("[\n")
+	entries := make([]canonicalEntry, 0, len(results))
+	for i, raw := range results {
+		result, canonical, err := canonicalResult(raw)
+		if err != nil {
+			return nil, fmt.Errorf("stored page %d, result %d: %v", page, i+1, err)
+		}
+		if i > 0 {
+			rewritten.WriteString(",\n")
+		}
+		entries = append(entries, canonicalEntry{key: canonicalSortKey(cfg, result, canonical), page: page, offset: int64(rewritten.Len()), length: len(canonical)})
+		rewritten.Write(canonical)
+	}
+	rewritten.WriteString("\n]\n")
+	if err := writeFileAtomically(path, rewritten.Bytes(), 0o644); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// writeCanonicalResults writes the results of the stored pages in canonical form and order, one per
+// line, and returns how many it wrote. Only the sort keys are held in memory; the results are read
+// back from the rewritten pages. A result the service sent twice, as happens when the catalog
+// changes during paging, is written once.
+func writeCanonicalResults(cfg *Config, state scrapeState, writer *bufio.Writer) (int, error) {
+	var entries []canonicalEntry
+	for page := 1; page <= state.Pages; page++ {
+		pageEntries, err := canonicalizePage(cfg, page)
+		if err != nil {
+			return 0, err
+		}
+		entries = append(entries, pageEntries...)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	pages := make(map[int]*os.File)
+	defer func() {
+		for _, file := range pages {
+			file.Close()
+		}
+	}()
+	var total, repeated int
+	for i, entry := range entries {
+		if i > 0 && entry.key == entries[i-1].key {
+			repeated = repeated + 1
+			continue
+		}
+		file, ok := pages[entry.page]
+		if !ok {
+			if len(pages) >= maxOpenPages {
+				for page, open := range pages {
+					open.Close()
+					delete(pages, page)
+				}
+			}
+			var err error
+			if file, err = os.Open(scrapePagePath(cfg, entry.page)); err != nil {
+				return 0, err
+			}
+			pages[entry.page] = file
+		}
+		result := make([]byte, entry.length)
+		if _, err := file.ReadAt(result, entry.offset); err != nil {
+			return 0, fmt.Errorf("failed to read stored page %d: %v", entry.page, err)
+		}
+		if total > 0 {
+			writer.WriteString(",\n")
+		}
+		writer.Write(result)
+		total = total + 1
+	}
+	if repeated > 0 {
+		log.Printf("dropped %d results the service sent more than once", repeated)
+	}
+	return total, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Header snapshots are named by the minute their scrape completed, e.g. headers-20240501T0300.json,
+// so their names sort in time order.
+const (
+	snapshotPrefix     = "headers-"
+	snapshotTimeLayout = "20060102T1504"
+)
+
+// Snapshot compression settings. Only gzip is offered: it needs nothing outside the standard library
+// and header JSON, being mostly repeated property names, shrinks to a few percent either way.
+const (
+	compressionNone = "none"
+	compressionGzip = "gzip"
+)
+
+// legacyInputFile is where header JSON was kept before snapshots; it is still read when no snapshot exists.
+const legacyInputFile = "main.json"
+
+// snapshotPath returns the snapshot file of a scrape completed at the given time.
+func snapshotPath(cfg *Config, at time.Time) string {
+	name := snapshotPrefix + at.UTC().Format(snapshotTimeLayout) + ".json"
+	if cfg.SnapshotCompression == compressionGzip {
+		name = name + ".gz"
+	}
+	return filepath.Join(cfg.SnapshotDir, name)
+}
+
+// isSnapshotName reports whether a file name is a snapshot, compressed or not.
+func isSnapshotName(name string) bool {
+	return strings.HasPrefix(name, snapshotPrefix) && (strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".json.gz"))
+}
+
+// openHeaderFile opens header JSON for reading, decompressing gzip files transparently.
+// Compression is recognized by content rather than name, so a renamed snapshot still reads.
+func openHeaderFile(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewReader(file)
+	magic, _ := buffered.Peek(2)
+	if len(magic) < 2 || magic[0] != 0x1f || magic[1] != 0x8b {
+		return readCloser{Reader: buffered, Closer: file}, nil
+	}
+	decompressed, err := gzip.NewReader(buffered)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to decompress %s: %v", path, err)
+	}
+	return readCloser{Reader: decompressed, Closer: file}, nil
+}
+
+// readCloser pairs a reader with the file beneath it.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// listSnapshots returns the header snapshots, oldest first.
+func listSnapshots(cfg *Config) ([]string, error) {
+	entries, err := os.ReadDir(cfg.SnapshotDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !isSnapshotName(name) {
+			continue
+		}
+		snapshots = append(snapshots, filepath.Join(cfg.SnapshotDir, name))
+	}
+	sort.Strings(snapshots)
+	return snapshots, nil
+}
+
+// resolveInputFile returns the header JSON a run reads: the -input file when one is given,
+// otherwise the latest snapshot, otherwise the legacy main.json.
+func resolveInputFile(cfg *Config) (string, error) {
+	if cfg.InputFile != "" {
+		return cfg.InputFile, nil
+	}
+	snapshots, err := listSnapshots(cfg)
+	if err != nil {
+		return "", err
+	}
+	if len(snapshots) > 0 {
+		return snapshots[len(snapshots)-1], nil
+	}
+	if fileExists(legacyInputFile) {
+		log.Printf("no header snapshot in %s, reading %s", cfg.SnapshotDir, legacyInputFile)
+		return legacyInputFile, nil
+	}
+	return "", fmt.Errorf("no header snapshot in %s, run the scrape command first or pass -input", cfg.SnapshotDir)
+}
+
+// pruneSnapshots removes all but the newest cfg.KeepSnapshots snapshots; 0 keeps every one.
+func pruneSnapshots(cfg *Config) error {
+	if cfg.KeepSnapshots <= 0 {
+		return nil
+	}
+	snapshots, err := listSnapshots(cfg)
+	if err != nil {
+		return err
+	}
+	for len(snapshots) > cfg.KeepSnapshots {
+		if err := os.Remove(snapshots[0]); err != nil {
+			return err
+		}
+		log.Printf("removed old snapshot %s", snapshots[0])
+		snapshots = snapshots[1:]
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Source is a supplier portal documents are pulled from. Every source shares the pipeline,
+// catalog and storage; it only has to say what it offers and how to fetch one document.
+type Source interface {
+	// Name identifies the source in the catalog, manifests and logs.
+	Name() string
+	// List sends every document the source offers, with the file name to store it under.
+	// onCount, when the source knows it, receives the number of documents up front.
+	List(ctx context.Context, out chan<- documentRef, onCount func(total int)) error
+	// Fetch opens the content of one listed document.
+	// Failures should wrap the typed errors in errors.go so they are classified like SABIC's.
+	Fetch(ctx context.Context, doc documentRef) (*fetchedContent, error)
+}
+
+// fetchedContent is an open document body returned by a source.
+type fetchedContent struct {
+	Body        io.ReadCloser
+	ContentType string      // Media type as announced, e.g. application/pdf
+	Length      int64       // Announced length, -1 when unknown
+	Status      string      // HTTP status line, empty for sources that aren't HTTP
+	Header      http.Header // HTTP response headers, nil for sources that aren't HTTP
+	// Ranges, when set, fetches the content as parallel byte ranges into a file instead, see
+	// rangedDownload; Body then still holds it whole for callers that don't stage it.
+	Ranges func(ctx context.Context, file *os.File) error
+}
+
+// sourceFactories builds the sources named in Config.Sources.
+var sourceFactories = map[string]func(cfg *Config, materials materialMap) (Source, error){
+	"sabic": newSABICSource,
+}
+
+// openSources builds every configured source.
+func openSources(cfg *Config, materials materialMap) ([]Source, error) {
+	var sources []Source
+	for _, name := range cfg.Sources {
+		// Out-of-tree providers run as plugin processes.
+		if commandLine, ok := cfg.Plugins[name]; ok {
+			plugin, err := newPluginSource(cfg, name, commandLine)
+			if err != nil {
+				closeSources(sources)
+				return nil, err
+			}
+			sources = append(sources, plugin)
+			continue
+		}
+		factory, ok := sourceFactories[name]
+		if !ok {
+			closeSources(sources)
+			known := make([]string, 0, len(sourceFactories))
+			for sourceName := range sourceFactories {
+				known = append(known, sourceName)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown source %q, known sources are %s or a configured plugin", name, strings.Join(known, ", "))
+		}
+		source, err := factory(cfg, materials)
+		if err != nil {
+			closeSources(sources)
+			return nil, fmt.Errorf("source %s: %v", name, err)
+		}
+		sources = append(sources, source)
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no sources configured")
+	}
+	return sources, nil
+}
+
+// closeSources shuts down sources that hold resources, such as plugin processes.
+func closeSources(sources []Source) {
+	for _, source := range sources {
+		if closer, ok := source.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Printf("source %s: %v", source.Name(), err)
+			}
+		}
+	}
+}
+
+// sabicSource lists documents from the scraped DocHeaderSet JSON and fetches them from SABIC's OData service.
+type sabicSource struct {
+	cfg       *Config
+	materials materialMap   // Internal codes used in file names
+	schema    *headerSchema // Maps the properties of header results
+	client    *http.Client  // Shared by every download of the run
+}
+
+// newSABICSource builds the SABIC source, loading the header schema from $metadata.
+func newSABICSource(cfg *Config, materials materialMap) (Source, error) {
+	source := &sabicSource{cfg: cfg, materials: materials}
+	// Create an HTTP client with connect and header timeouts; bodies are guarded against stalls instead
+	source.client = newDownloadClient(cfg)
+	// Map header properties by the types the service declares.
+	source.schema = loadHeaderSchema(cfg)
+	log.Printf("sabic: mapping header results with %s", source.schema.describe())
+	return source, nil
+}
+
+// Name implements Source.
+func (source *sabicSource) Name() string {
+	return "sabic"
+}
+
+// List implements Source by streaming the scraped header JSON.
+func (source *sabicSource) List(ctx context.Context, out chan<- documentRef, onCount func(total int)) error {
+	return source.streamDocuments(ctx, out, onCount)
+}
+
+// Fetch implements Source with a plain GET of the document's $value URL.
+func (source *sabicSource) Fetch(ctx context.Context, doc documentRef) (*fetchedContent, error) {
+	// Send GET request
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, doc.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request for %s: %v", doc.url, err)
+	}
+	// Alternate formats are asked for by media type, and by name where the system needs a parameter.
+	if format := doc.formatOf(); format != formatPDF {
+		request.Header.Set("Accept", documentFormats[format].mediaTypes[0])
+		if source.cfg.FormatParameter != "" {
+			query := request.URL.Query()
+			query.Set(source.cfg.FormatParameter, strings.ToUpper(format))
+			request.URL.RawQuery = query.Encode()
+		}
+	}
+	for name, values := range doc.validators {
+		request.Header[name] = values
+	}
+	resp, err := openDownload(ctx, source.cfg, source.client, request)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to download %s: %w", ErrNetwork, doc.url, err)
+	}
+	if resp.StatusCode == http.StatusNotModified && doc.validators != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s", ErrNotModified, doc.url)
+	}
+	// Check HTTP response status
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		// Print the error since its not valid.
+		return nil, responseError(resp, doc.url)
+	}
+	content := &fetchedContent{Body: resp.Body, ContentType: resp.Header.Get("Content-Type"), Length: resp.ContentLength, Status: resp.Status, Header: resp.Header}
+	// Very large documents come faster as parallel byte ranges, once the response passed the checks.
+	if rangedEligible(source.cfg, resp) {
+		content.Ranges = rangedDownload(source.cfg, source.client, doc, resp)
+	}
+	return content, nil
+}
+
+// documentURL builds the download URL of one header result, naming the keys as the tenant does.
+// Content URLs have always had a double slash before the entity set; it is kept so stored source URLs stay stable.
+// Plain alphanumeric keys come out as they always have; see keyLiteral for the rest.
+func documentURL(cfg *Config, item headerResult) string {
+	return fmt.Sprintf("%s//%s(%s=%s,%s=%s,%s=%s,%s=%s,%s='')/%s",
+		strings.TrimSuffix(cfg.ServiceURL, "/"), cfg.ContentEntitySet,
+		fieldName(cfg.FieldMap, "Matnr"), keyLiteral(item.MaterialNumber),
+		fieldName(cfg.FieldMap, "Subid"), keyLiteral(item.SubID),
+		fieldName(cfg.FieldMap, "Sbgvid"), keyLiteral(item.StorageLocation),
+		fieldName(cfg.FieldMap, "Laiso"), keyLiteral(item.LanguageISO),
+		fieldName(cfg.FieldMap, "Vkorg"), cfg.ContentValuePath)
+}
+
+// keyLiteral renders a key value as an OData string literal for a URL path: quotes inside
+// are doubled as OData requires, then everything but unreserved characters is percent-encoded,
+// so quotes, commas, slashes and non-ASCII text can't break the predicate or the path.
+func keyLiteral(value string) string {
+	return "'" + url.PathEscape(strings.ReplaceAll(value, "'", "''")) + "'"
+}
+
+// parseKeyLiteral reverses keyLiteral on the text between a literal's quotes.
+// Values that aren't valid percent-encoding are kept as they are.
+func parseKeyLiteral(text string) string {
+	if unescaped, err := url.PathUnescape(text); err == nil {
+		text = unescaped
+	}
+	return strings.ReplaceAll(text, "''", "'")
+}
+
+// enterObjectField reads the start of the next JSON object up to the value of the named field.
+func enterObjectField(decoder *json.Decoder, name string) error {
+	if err := expectDelim(decoder, '{'); err != nil {
+		return fmt.Errorf("expected an object holding %q: %v", name, err)
+	}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if key == name {
+			return nil
+		}
+		// Skip the value of any other field.
+		var skipped json.RawMessage
+		if err := decoder.Decode(&skipped); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("field %q not found", name)
+}
+
+// streamDocuments decodes the header JSON, {"d":{"results":[...]}}, one result at a time and sends each document,
+// so the input is never loaded whole.
+// When the response carries an OData __count ($inlinecount=allpages) it is passed to onCount.
+func (source *sabicSource) streamDocuments(ctx context.Context, out chan<- documentRef, onCount func(total int)) error {
+	inputFile, err := resolveInputFile(source.cfg)
+	if err != nil {
+		return err
+	}
+	file, err := openHeaderFile(inputFile)
+	if err != nil {
+		return fmt.Errorf("failed to read input JSON file: %v", err)
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(file)
+	// Walk down to d.
+	if err := enterObjectField(decoder, "d"); err != nil {
+		return fmt.Errorf("failed to parse JSON data: %v", err)
+	}
+	if err := expectDelim(decoder, '{'); err != nil {
+		return fmt.Errorf("failed to parse JSON data: %v", err)
+	}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return fmt.Errorf("failed to parse JSON data: %v", err)
+		}
+		switch key {
+		case "__count":
+			// OData v2 sends the count as a string.
+			var count string
+			if err := decoder.Decode(&count); err != nil {
+				return fmt.Errorf("failed to parse JSON data: %v", err)
+			}
+			if total, err := strconv.Atoi(count); err == nil && onCount != nil {
+				onCount(total)
+			}
+		case "results":
+			if err := source.streamResults(ctx, decoder, out); err != nil {
+				return err
+			}
+		default:
+			// Skip the value of any other field.
+			var skipped json.RawMessage
+			if err := decoder.Decode(&skipped); err != nil {
+				return fmt.Errorf("failed to parse JSON data: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// expectDelim reads the next token and checks it is the given delimiter.
+func expectDelim(decoder *json.Decoder, want json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != want {
+		return fmt.Errorf("expected %q, got %v", want, token)
+	}
+	return nil
+}
+
+// streamResults decodes the results array and sends every entry with all of its properties.
+func (source *sabicSource) streamResults(ctx context.Context, decoder *json.Decoder, out chan<- documentRef) error {
+	if err := expectDelim(decoder, '['); err != nil {
+		return fmt.Errorf("failed to parse JSON data: results: %v", err)
+	}
+	for decoder.More() {
+		var raw map[string]any
+		if err := decoder.Decode(&raw); err != nil {
+			return fmt.Errorf("failed to parse JSON data: %v", err)
+		}
+		item, properties := source.schema.mapResult(raw)
+		doc := documentRef{source: source.Name(), url: documentURL(source.cfg, item), properties: properties}
+		if id, err := documentIDFromURL(doc.url); err != nil {
+			// Sent on all the same, without a file name, so planning records it as skipped.
+			log.Println("No file name for a listed document:", err)
+		} else {
+			doc.id = id
+			doc.filename = documentFilename(source.materials, id)
+		}
+		if err := sendDocument(ctx, out, doc); err != nil {
+			return err
+		}
+	}
+	// Consume the closing bracket.
+	return expectDelim(decoder, ']')
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// workerStagingDir returns the directory a download worker streams into.
+// Without a configured staging directory workers share the output directory, which keeps the final rename on one device.
+func workerStagingDir(cfg *Config, worker int) (string, error) {
+	if cfg.StagingDir == "" {
+		return storageRoot(cfg.OutputDir)
+	}
+	root, err := storageRoot(cfg.StagingDir)
+	if err != nil {
+		return "", err
+	}
+	// Every worker gets its own directory so leftovers can be traced to the worker that wrote them.
+	dir := filepath.Join(root, "worker-"+strconv.Itoa(worker))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("%w: failed to create staging directory: %w", ErrStorage, err)
+	}
+	return dir, nil
+}
+
+// moveFile renames src to dst, falling back to copy-then-rename when they are on different devices,
+// e.g. a local staging directory and an NFS output directory. dst never holds a partial file.
+func moveFile(src string, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	// Copy next to the destination first so the final rename is atomic.
+	if err := copyFileAtomically(src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// copyFileAtomically copies src to a temporary file beside dst, syncs it and renames it into place.
+func copyFileAtomically(src string, dst string) error {
+	source, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	temp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	// Clean up the temporary file on every failure path.
+	defer os.Remove(temp.Name())
+	if _, err := io.Copy(temp, source); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(temp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), dst)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Stamp modes: where the retrieval provenance goes on a stamped copy.
+const (
+	stampModeOverlay = "overlay" // A small mark along the bottom of page 1, drawn by stamp_command
+	stampModeCover   = "cover"   // An added first page, merged in with bundle_command
+)
+
+// Layout of the overlay mark, in PDF points from the bottom left of the page.
+const (
+	stampFontSize   = 7
+	stampLineHeight = 9
+	stampLineLength = 150 // Characters per line before a long URL wraps
+)
+
+// provenanceLines describes where and when a stored document came from. sum is the hash of the
+// file being stamped, so the mark states exactly what was retrieved.
+func provenanceLines(entry catalogEntry, sum string) []string {
+	retrieved := "unknown date"
+	if !entry.DownloadedAt.IsZero() {
+		retrieved = entry.DownloadedAt.UTC().Format("2006-01-02 15:04 MST")
+	}
+	lines := []string{"Retrieved " + retrieved + " from:"}
+	source := activeRedactor.text(entry.SourceURL)
+	for len(source) > stampLineLength {
+		lines = append(lines, "  "+source[:stampLineLength])
+		source = source[stampLineLength:]
+	}
+	lines = append(lines, "  "+source, "SHA-256 "+sum, "Stamped copy; the original is kept unmodified.")
+	return lines
+}
+
+// stampOverlay returns the content stream of the overlay page, the lines in grey along its bottom.
+func stampOverlay(lines []string) string {
+	var content bytes.Buffer
+	content.WriteString("0.35 g\n")
+	y := coverMargin / 2
+	for i := len(lines) - 1; i >= 0; i-- {
+		fmt.Fprintf(&content, "BT /F1 %d Tf %d %d Td (%s) Tj ET\n", stampFontSize, coverMargin/2, y, pdfText(lines[i]))
+		y = y + stampLineHeight
+	}
+	return content.String()
+}
+
+// runStampCommand runs the overlay command with {input}, {stamp} and {output} filled in.
+func runStampCommand(ctx context.Context, command []string, inputPath, stampPath, outputPath string) error {
+	replacer := strings.NewReplacer("{input}", inputPath, "{stamp}", stampPath, "{output}", outputPath)
+	args := make([]string, len(command))
+	for index, arg := range command {
+		args[index] = replacer.Replace(arg)
+	}
+	output, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %v: %s", args[0], err, strings.TrimSpace(string(output)))
+	}
+	if !fileExists(outputPath) {
+		return fmt.Errorf("%s did not produce %s", args[0], outputPath)
+	}
+	return nil
+}
+
+// stampDocument writes a stamped copy of a stored document to the stamp directory and records the
+// outcome in the catalog. The stored original is only read, so its hash and CAS entry stay as they are.
+func stampDocument(ctx context.Context, cfg *Config, store documentStore, docs *catalog, filename string) error {
+	entry, ok := docs.get(filename)
+	if !ok {
+		return fmt.Errorf("%s is not in the catalog", filename)
+	}
+	inputPath, release, err := plaintextPath(store, filename)
+	if err != nil {
+		return err
+	}
+	defer release()
+	outputPath, err := joinWithin(cfg.StampDir, filename)
+	if err != nil {
+		return err
+	}
+	err = writeStampedCopy(ctx, cfg, entry, inputPath, outputPath)
+	docs.update(filename, func(entry *catalogEntry) {
+		if err != nil {
+			entry.StampStatus = "failed"
+			entry.StampPath = ""
+			entry.StampError = err.Error()
+			return
+		}
+		entry.StampStatus = "stamped"
+		entry.StampPath = outputPath
+		entry.StampError = ""
+	})
+	return err
+}
+
+// writeStampedCopy marks inputPath with its provenance and moves the result to outputPath.
+func writeStampedCopy(ctx context.Context, cfg *Config, entry catalogEntry, inputPath string, outputPath string) error {
+	sum, err := fileSHA256(inputPath)
+	if err != nil {
+		return err
+	}
+	lines := provenanceLines(entry, sum)
+	if err := os.MkdirAll(cfg.StampDir, 0o755); err != nil {
+		return err
+	}
+	// Build beside the copy, so a failed run never replaces a good one.
+	work, err := os.MkdirTemp(cfg.StampDir, ".stamp-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(work)
+	stampPath := filepath.Join(work, "stamp.pdf")
+	stamped := filepath.Join(work, "stamped.pdf")
+	switch cfg.StampMode {
+	case stampModeOverlay:
+		if len(cfg.StampCommand) == 0 {
+			return fmt.Errorf("stamp_command is not configured")
+		}
+		if err := writeTextPDF(stampPath, []string{stampOverlay(lines)}); err != nil {
+			return err
+		}
+		if err := runStampCommand(ctx, cfg.StampCommand, inputPath, stampPath, stamped); err != nil {
+			return err
+		}
+	case stampModeCover:
+		merger := newPDFMerger(cfg)
+		if merger == nil {
+			return fmt.Errorf("bundle_command is not configured")
+		}
+		if err := writeCoverPDF(stampPath, "Retrieval provenance of "+entry.Filename, lines); err != nil {
+			return err
+		}
+		if err := merger.MergePDFs(ctx, []string{stampPath, inputPath}, stamped); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown stamp mode %q", cfg.StampMode)
+	}
+	return os.Rename(stamped, outputPath)
+}
+
+// runStamp implements the stamp command, stamping every document without a current stamped copy.
+func runStamp(args []string) error {
+	var force bool
+	cfg, _, err := loadConfig("stamp", args, func(flagSet *flag.FlagSet) {
+		flagSet.BoolVar(&force, "force", false, "stamp documents again even when a stamped copy exists")
+	})
+	if err != nil {
+		return err
+	}
+	lock, err := acquireRunLock(cfg)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+	docs, err := openCatalog(cfg.CatalogFile)
+	if err != nil {
+		return err
+	}
+	store, err := openDocumentStore(cfg)
+	if err != nil {
+		return err
+	}
+	documents, err := listDocuments(store)
+	if err != nil {
+		return err
+	}
+	var stamped, failed int
+	for _, document := range documents {
+		if entry, ok := docs.get(document.Name); ok && !force && entry.StampStatus == "stamped" && fileExists(entry.StampPath) {
+			continue
+		}
+		if err := stampDocument(context.Background(), cfg, store, docs, document.Name); err != nil {
+			log.Println(err)
+			failed = failed + 1
+			continue
+		}
+		stamped = stamped + 1
+	}
+	log.Printf("stamped %d documents, %d failed", stamped, failed)
+	return docs.save()
+}
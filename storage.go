@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Storage layouts of the output directory.
+const (
+	layoutFlat = "flat" // One file per document, named after it
+	layoutCAS  = "cas"  // Content-addressed objects plus a name index, like git blobs
+)
+
+// documentStore is where stored documents live on disk.
+type documentStore interface {
+	// put moves a finished temporary file into the store under a document's name.
+	put(tempPath string, filename string, sha256 string) (string, error)
+	// path returns where a stored document can be read.
+	path(filename string) (string, bool)
+	// locate returns where a document would be stored without touching the disk, false when the
+	// layout has no place for it.
+	locate(filename string) (string, bool)
+	// names lists every stored document, sorted.
+	names() ([]string, error)
+	// flush persists any index the layout keeps.
+	flush() error
+	// forget drops a document from the layout's index, leaving its content to orphans.
+	forget(filename string)
+	// orphans lists the files no referenced document needs, including stale temporary files.
+	orphans(referenced map[string]bool) ([]string, error)
+}
+
+// staleTempAge is how old a temporary file must be before it is treated as abandoned rather than in progress.
+const staleTempAge = time.Hour
+
+// isStaleTempFile reports whether a hidden .part- or .tmp- file was left behind by an interrupted write.
+func isStaleTempFile(entry os.DirEntry) bool {
+	name := entry.Name()
+	if !strings.HasPrefix(name, ".") || !(strings.Contains(name, ".part-") || strings.Contains(name, ".tmp-")) {
+		return false
+	}
+	info, err := entry.Info()
+	return err == nil && time.Since(info.ModTime()) > staleTempAge
+}
+
+// openDocumentStore returns the store for the configured layout, rooted where deep paths work (see storageRoot),
+// encrypting documents when an encryption key is configured.
+func openDocumentStore(cfg *Config) (documentStore, error) {
+	dir, err := storageRoot(cfg.OutputDir)
+	if err != nil {
+		return nil, err
+	}
+	// openLayout opens the store of one directory in the configured layout.
+	openLayout := func(dir string) (documentStore, error) {
+		switch cfg.StorageLayout {
+		case "", layoutFlat:
+			return &flatStore{dir: dir}, nil
+		case layoutCAS:
+			return &casStore{dir: dir, indexPath: filepath.Join(dir, "index.txt")}, nil
+		}
+		return nil, fmt.Errorf("unknown storage layout %q, expected %s or %s", cfg.StorageLayout, layoutFlat, layoutCAS)
+	}
+	store, err := openLayout(dir)
+	if err != nil {
+		return nil, err
+	}
+	// Labels and transport emergency cards get trees of their own.
+	store, err = newReportTypeStore(store, dir, cfg.ReportTypeDirs, openLayout)
+	if err != nil {
+		return nil, err
+	}
+	key, err := loadEncryptionKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		encrypted := &encryptedStore{documentStore: store, key: key}
+		docs, err := openCatalog(cfg.CatalogFile)
+		if err != nil {
+			return nil, err
+		}
+		allowLegacyPlaintext(encrypted, docs)
+		return encrypted, nil
+	}
+	return store, nil
+}
+
+// flatStore keeps every document as a file named after it, the original layout.
+type flatStore struct {
+	dir string
+}
+
+// put implements documentStore.
+func (store *flatStore) put(tempPath string, filename string, sha256 string) (string, error) {
+	filePath, err := joinWithin(store.dir, filename)
+	if err != nil {
+		os.Remove(tempPath)
+		return "", err
+	}
+	return filePath, moveFile(tempPath, filePath)
+}
+
+// path implements documentStore.
+func (store *flatStore) path(filename string) (string, bool) {
+	filePath, err := joinWithin(store.dir, filename)
+	if err != nil {
+		return "", false
+	}
+	return filePath, fileExists(filePath)
+}
+
+// locate implements documentStore.
+func (store *flatStore) locate(filename string) (string, bool) {
+	filePath, err := joinWithin(store.dir, filename)
+	return filePath, err == nil
+}
+
+// names implements documentStore.
+func (store *flatStore) names() ([]string, error) {
+	entries, err := os.ReadDir(store.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		// Hidden files are temporary downloads in progress.
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || !isDocumentFile(entry.Name()) {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+// flush implements documentStore.
+func (store *flatStore) flush() error {
+	return nil
+}
+
+// forget implements documentStore; a flat store has no index.
+func (store *flatStore) forget(filename string) {}
+
+// orphans implements documentStore: documents in any known format and previews no referenced document
+// needs, and stale temporary files.
+func (store *flatStore) orphans(referenced map[string]bool) ([]string, error) {
+	entries, err := os.ReadDir(store.dir)
+	if err != nil {
+		return nil, err
+	}
+	var orphans []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		switch {
+		case isStaleTempFile(entry):
+		case strings.HasPrefix(name, "."):
+			continue
+		case isDocumentFile(name):
+			if referenced[name] {
+				continue
+			}
+		case strings.HasSuffix(name, ".png"):
+			if referenced[strings.TrimSuffix(name, ".png")+".pdf"] {
+				continue
+			}
+		default:
+			// Leave anything we didn't write alone.
+			continue
+		}
+		orphans = append(orphans, filepath.Join(store.dir, name))
+	}
+	return orphans, nil
+}
+
+// casStore keeps each distinct content once under objects/ab/cd/<sha256> and maps document names to
+// hashes in index.txt, one "<sha256>  <name>" line per document like sha256sum prints them.
+// Objects are read-only once written, so a stored version can never change underneath its hash.
+type casStore struct {
+	dir       string
+	indexPath string
+	mutex     sync.Mutex
+	index     map[string]string // Document name to object hash
+	loadedAt  time.Time         // Modification time of the index file when it was read
+	dirty     bool              // Whether the index has unsaved changes
+}
+
+// objectPath returns where the object with the given hash lives.
+func (store *casStore) objectPath(sha256 string) string {
+	return filepath.Join(store.dir, "objects", sha256[0:2], sha256[2:4], sha256)
+}
+
+// loadIndex reads the index file when it changed since it was last read.
+// Readers such as the server pick up what a sync run flushed without restarting.
+func (store *casStore) loadIndex() error {
+	if store.dirty {
+		return nil
+	}
+	info, err := os.Stat(store.indexPath)
+	if os.IsNotExist(err) {
+		if store.index == nil {
+			store.index = make(map[string]string)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if store.index != nil && info.ModTime().Equal(store.loadedAt) {
+		return nil
+	}
+	file, err := os.Open(store.indexPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	index := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		sha256, filename, found := strings.Cut(scanner.Text(), "  ")
+		if !found || len(sha256) != 64 {
+			continue
+		}
+		index[filename] = sha256
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	store.index = index
+	store.loadedAt = info.ModTime()
+	return nil
+}
+
+// put implements documentStore. Content that is already stored is not written twice.
+func (store *casStore) put(tempPath string, filename string, sha256 string) (string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := store.loadIndex(); err != nil {
+		return "", err
+	}
+	// Names end up in the index and in views, exports and mirrors as file names.
+	if !isSafeName(filename) {
+		os.Remove(tempPath)
+		return "", fmt.Errorf("%w: refusing unsafe file name %q", ErrStorage, filename)
+	}
+	objectPath := store.objectPath(sha256)
+	if fileExists(objectPath) {
+		// Dedupe: the same bytes are already stored under another name or an earlier run.
+		os.Remove(tempPath)
+	} else {
+		if err := os.MkdirAll(filepath.Dir(objectPath), 0o755); err != nil {
+			return "", err
+		}
+		if err := moveFile(tempPath, objectPath); err != nil {
+			return "", err
+		}
+		if err := os.Chmod(objectPath, 0o444); err != nil {
+			log.Printf("failed to make %s read-only: %v", objectPath, err)
+		}
+	}
+	store.index[filename] = sha256
+	store.dirty = true
+	return objectPath, nil
+}
+
+// path implements documentStore.
+func (store *casStore) path(filename string) (string, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := store.loadIndex(); err != nil {
+		log.Println("Failed to read the storage index:", err)
+		return "", false
+	}
+	sha256, ok := store.index[filename]
+	if !ok {
+		return "", false
+	}
+	objectPath := store.objectPath(sha256)
+	return objectPath, fileExists(objectPath)
+}
+
+// locate implements documentStore from the index.
+func (store *casStore) locate(filename string) (string, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := store.loadIndex(); err != nil {
+		return "", false
+	}
+	sha256, ok := store.index[filename]
+	if !ok {
+		return "", false
+	}
+	return store.objectPath(sha256), true
+}
+
+// names implements documentStore.
+func (store *casStore) names() ([]string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := store.loadIndex(); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(store.index))
+	for filename := range store.index {
+		names = append(names, filename)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// flush implements documentStore by rewriting the index atomically.
+func (store *casStore) flush() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if !store.dirty {
+		return nil
+	}
+	names := make([]string, 0, len(store.index))
+	for filename := range store.index {
+		names = append(names, filename)
+	}
+	sort.Strings(names)
+	var content strings.Builder
+	for _, filename := range names {
+		fmt.Fprintf(&content, "%s  %s\n", store.index[filename], filename)
+	}
+	if err := writeFileAtomically(store.indexPath, []byte(content.String()), 0o644); err != nil {
+		return err
+	}
+	if info, err := os.Stat(store.indexPath); err == nil {
+		store.loadedAt = info.ModTime()
+	}
+	store.dirty = false
+	return nil
+}
+
+// forget implements documentStore.
+func (store *casStore) forget(filename string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := store.loadIndex(); err != nil {
+		log.Println("Failed to read the storage index:", err)
+		return
+	}
+	if _, ok := store.index[filename]; ok {
+		delete(store.index, filename)
+		store.dirty = true
+	}
+}
+
+// orphans implements documentStore: objects and previews whose hash no referenced document maps to,
+// and stale temporary files. An object shared by several names lives as long as one of them is referenced.
+func (store *casStore) orphans(referenced map[string]bool) ([]string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := store.loadIndex(); err != nil {
+		return nil, err
+	}
+	used := make(map[string]bool)
+	for filename, sha256 := range store.index {
+		if referenced[filename] {
+			used[sha256] = true
+		}
+	}
+	var orphans []string
+	err := filepath.WalkDir(store.dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		if isStaleTempFile(entry) {
+			orphans = append(orphans, path)
+			return nil
+		}
+		// Only objects and their previews are ours to judge.
+		if filepath.Dir(filepath.Dir(filepath.Dir(path))) != filepath.Join(store.dir, "objects") {
+			return nil
+		}
+		sha256 := strings.TrimSuffix(entry.Name(), ".png")
+		if len(sha256) == 64 && !used[sha256] {
+			orphans = append(orphans, path)
+		}
+		return nil
+	})
+	return orphans, err
+}
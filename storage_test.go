@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestFlatStoreFormats checks that a flat store lists and collects stored alternates like the PDFs
+// they belong to, and leaves files of its own alone.
+func TestFlatStoreFormats(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"1_2_sds_en.pdf", "1_2_sds_en.docx", "1_2_sds_en.rtf", "1_2_sds_en.png", "3_4_sds_de.png", "3_4_sds_de.pdf", "3_4_sds_de.docx", "notes.txt", ".1_2_sds_en.pdf.part-1"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store := &flatStore{dir: dir}
+	names, err := store.names()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1_2_sds_en.docx", "1_2_sds_en.pdf", "1_2_sds_en.rtf", "3_4_sds_de.docx", "3_4_sds_de.pdf"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("names: got %q, want %q", names, want)
+	}
+	orphans, err := store.orphans(map[string]bool{"1_2_sds_en.pdf": true, "1_2_sds_en.rtf": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "1_2_sds_en.docx"), filepath.Join(dir, "3_4_sds_de.docx"), filepath.Join(dir, "3_4_sds_de.pdf"), filepath.Join(dir, "3_4_sds_de.png")}
+	if !reflect.DeepEqual(orphans, want) {
+		t.Errorf("orphans: got %q, want %q", orphans, want)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// throttledError is ErrUpstreamThrottled carrying how long the upstream asked us to wait.
+type throttledError struct {
+	retryAfter time.Duration // Zero when the response didn't say
+	err        error
+}
+
+// Error implements error.
+func (e *throttledError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap lets errors.Is find ErrUpstreamThrottled.
+func (e *throttledError) Unwrap() error {
+	return e.err
+}
+
+// parseRetryAfter reads a Retry-After header in either of its forms: delay seconds or an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	at, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	// A date in the past means go ahead now.
+	return max(at.Sub(now), 0), true
+}
+
+// statusCodeError is an upstream failure carrying the HTTP status, so retry policies can match on it.
+type statusCodeError struct {
+	statusCode int
+	err        error
+}
+
+// Error implements error.
+func (e *statusCodeError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap lets errors.Is find the typed error.
+func (e *statusCodeError) Unwrap() error {
+	return e.err
+}
+
+// responseError returns the typed error for a non-200 download response,
+// keeping the status code and the Retry-After of throttled ones.
+func responseError(resp *http.Response, url string) error {
+	err := statusError(resp.StatusCode)
+	wrapped := &statusCodeError{statusCode: resp.StatusCode, err: fmt.Errorf("%w: download failed for %s: %s", err, url, resp.Status)}
+	if err != ErrUpstreamThrottled {
+		return wrapped
+	}
+	retryAfter, _ := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	return &throttledError{retryAfter: retryAfter, err: wrapped}
+}
+
+// throttleGate delays every download of a run while the upstream has asked us to back off,
+// so one 429 pauses all workers instead of each finding out for itself.
+type throttleGate struct {
+	mutex sync.Mutex
+	until time.Time // No download starts before this
+}
+
+// hold blocks new downloads for the given delay, unless an earlier hold already lasts longer.
+func (gate *throttleGate) hold(delay time.Duration) time.Time {
+	gate.mutex.Lock()
+	defer gate.mutex.Unlock()
+	if until := time.Now().Add(delay); until.After(gate.until) {
+		gate.until = until
+	}
+	return gate.until
+}
+
+// wait blocks until the gate opens or the run is cancelled.
+func (gate *throttleGate) wait(ctx context.Context) error {
+	gate.mutex.Lock()
+	delay := time.Until(gate.until)
+	gate.mutex.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func init() {
+	metrics.describe("sabic_throttle_events_total", "Throttled download responses that paused dispatching, by source.")
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// newDownloadClient returns the HTTP client for document downloads. Connecting, the TLS handshake and
+// waiting for response headers each have their own timeout, but reading the body has none: a large PDF
+// on a slow link may take as long as it needs while bytes keep arriving (see stallReader).
+// Connections follow the host overrides, address family and DNS server of the config (see dns.go).
+// Every request carries the configured User-Agent and respects the host delay (see politeness.go).
+// Responses can be recorded as test fixtures or answered from them (see fixtures.go). Cookies are
+// kept between runs when cookie_jar is set (see cookiejar.go).
+func newDownloadClient(cfg *Config) *http.Client {
+	dialer := &net.Dialer{Timeout: cfg.ConnectTimeout.Duration, KeepAlive: 30 * time.Second}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = newNetworkDialer(cfg, dialer).DialContext
+	transport.TLSHandshakeTimeout = cfg.TLSTimeout.Duration
+	transport.ResponseHeaderTimeout = cfg.ResponseHeaderTimeout.Duration
+	// Health is measured inside the polite delays, which are ours and not the upstream's, and outside
+	// injected faults, which should look like the upstream's.
+	return &http.Client{
+		Transport:     newPoliteTransport(cfg, &healthTransport{base: newChaosTransport(cfg, fixtureBaseTransport(cfg, transport)), tracker: upstreamHealth}),
+		CheckRedirect: checkRedirect(cfg),
+		Jar:           upstreamCookieJar(cfg),
+	}
+}
+
+// openDownload sends a download request and guards its body with the configured stall timeout.
+func openDownload(ctx context.Context, cfg *Config, client *http.Client, request *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	resp, err := client.Do(request.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = newStallReader(resp.Body, cfg.StallTimeout.Duration, cancel)
+	return resp, nil
+}
+
+// stallReader aborts a response body when no bytes arrive for the stall timeout,
+// however long the download as a whole has been running.
+type stallReader struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer // Cancels the request when it fires
+	cancel  context.CancelFunc
+	stalled atomic.Bool // Whether the timer fired
+}
+
+// newStallReader wraps body; cancel aborts the request it belongs to. A zero timeout disables the detector.
+func newStallReader(body io.ReadCloser, timeout time.Duration, cancel context.CancelFunc) *stallReader {
+	reader := &stallReader{body: body, timeout: timeout, cancel: cancel}
+	if timeout > 0 {
+		reader.timer = time.AfterFunc(timeout, func() {
+			reader.stalled.Store(true)
+			cancel()
+		})
+	}
+	return reader
+}
+
+// Read implements io.Reader, restarting the stall timer whenever data arrives.
+func (reader *stallReader) Read(buffer []byte) (int, error) {
+	n, err := reader.body.Read(buffer)
+	if reader.stalled.Load() {
+		return n, fmt.Errorf("%w: no data received for %s", ErrNetwork, reader.timeout)
+	}
+	if n > 0 && reader.timer != nil {
+		reader.timer.Reset(reader.timeout)
+	}
+	return n, err
+}
+
+// Close implements io.Closer.
+func (reader *stallReader) Close() error {
+	if reader.timer != nil {
+		reader.timer.Stop()
+	}
+	err := reader.body.Close()
+	reader.cancel()
+	return err
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ValidationCheck is one step of the validation pipeline a staged document passes before it is stored.
+type ValidationCheck struct {
+	Check   string `json:"check"`   // size, pages or text, see validators
+	Min     int64  `json:"min"`     // Smallest accepted bytes, pages or text characters, 0 disables it
+	Max     int64  `json:"max"`     // Largest accepted bytes or pages, 0 disables it
+	Pattern string `json:"pattern"` // text only: regular expression the text must match, e.g. "(?i)safety data sheet"
+}
+
+// anyReportType keys the checks of report types without their own entry in Config.Validation.
+const anyReportType = "*"
+
+// stagedFile is what the checks of one document look at; page count and text are only read when a check needs them.
+type stagedFile struct {
+	path  string
+	size  int64
+	pages *int
+	text  *string
+}
+
+// pageCount returns the number of pages of the staged file, reading it once.
+func (file *stagedFile) pageCount() (int, error) {
+	if file.pages == nil {
+		pages, err := countPDFPages(file.path)
+		if err != nil {
+			return 0, err
+		}
+		file.pages = &pages
+	}
+	return *file.pages, nil
+}
+
+// textContent returns the text of the staged file, reading it once.
+func (file *stagedFile) textContent() (string, error) {
+	if file.text == nil {
+		text, err := extractPDFText(file.path)
+		if err != nil {
+			return "", err
+		}
+		text = strings.Join(strings.Fields(text), " ")
+		file.text = &text
+	}
+	return *file.text, nil
+}
+
+// validators implements each check; they return the typed error of the failure.
+var validators = map[string]func(check ValidationCheck, file *stagedFile) error{
+	"size": func(check ValidationCheck, file *stagedFile) error {
+		if check.Min > 0 && file.size < check.Min {
+			return fmt.Errorf("%w: %d bytes, below the %d byte minimum", ErrSizeOutOfRange, file.size, check.Min)
+		}
+		if check.Max > 0 && file.size > check.Max {
+			return fmt.Errorf("%w: %d bytes, above the %d byte maximum", ErrSizeOutOfRange, file.size, check.Max)
+		}
+		return nil
+	},
+	"pages": func(check ValidationCheck, file *stagedFile) error {
+		pages, err := file.pageCount()
+		if err != nil {
+			return fmt.Errorf("%w: failed to read the staged file: %w", ErrStorage, err)
+		}
+		if check.Min > 0 && int64(pages) < check.Min {
+			return fmt.Errorf("%w: %d pages, below the %d page minimum", ErrValidationFailed, pages, check.Min)
+		}
+		if check.Max > 0 && int64(pages) > check.Max {
+			return fmt.Errorf("%w: %d pages, above the %d page maximum", ErrValidationFailed, pages, check.Max)
+		}
+		return nil
+	},
+	"text": func(check ValidationCheck, file *stagedFile) error {
+		text, err := file.textContent()
+		if err != nil {
+			return fmt.Errorf("%w: failed to read the staged file: %w", ErrStorage, err)
+		}
+		// Scanned sheets carry no text at all; one character is enough unless more is asked for.
+		if int64(len([]rune(text))) < max(check.Min, 1) {
+			return fmt.Errorf("%w: %d characters of text, below the %d character minimum", ErrValidationFailed, len([]rune(text)), max(check.Min, 1))
+		}
+		if check.Pattern == "" {
+			return nil
+		}
+		// The pattern was compiled by validateValidationChecks already.
+		if !regexp.MustCompile(check.Pattern).MatchString(text) {
+			return fmt.Errorf("%w: text doesn't match %q", ErrValidationFailed, check.Pattern)
+		}
+		return nil
+	},
+}
+
+// validationChecksFor returns the checks of a report type: its own entry, otherwise its built-in
+// checks, otherwise the "*" entry.
+func validationChecksFor(cfg *Config, reportType string) []ValidationCheck {
+	if checks, ok := cfg.Validation[strings.ToUpper(reportType)]; ok {
+		return checks
+	}
+	if checks, ok := defaultReportTypeValidation[strings.ToUpper(reportType)]; ok {
+		return checks
+	}
+	return cfg.Validation[anyReportType]
+}
+
+// validateValidationChecks reports the first unknown check or bad pattern, so a typo fails the run up front.
+func validateValidationChecks(cfg *Config) error {
+	for reportType, checks := range cfg.Validation {
+		for i, check := range checks {
+			if _, ok := validators[check.Check]; !ok {
+				known := make([]string, 0, len(validators))
+				for name := range validators {
+					known = append(known, name)
+				}
+				sort.Strings(known)
+				return fmt.Errorf("validation of %s, check %d: unknown check %q, known checks are %s", reportType, i+1, check.Check, strings.Join(known, ", "))
+			}
+			if _, err := regexp.Compile(check.Pattern); err != nil {
+				return fmt.Errorf("validation of %s, check %d: invalid pattern: %v", reportType, i+1, err)
+			}
+			// Alternate formats aren't PDFs; only their size can be checked.
+			if _, isFormat := documentFormats[strings.ToLower(reportType)]; isFormat && reportType != strings.ToUpper(formatPDF) && check.Check != "size" {
+				return fmt.Errorf("validation of %s, check %d: only size checks apply to alternate formats", reportType, i+1)
+			}
+		}
+	}
+	return nil
+}
+
+// validateStaged runs the checks of a report type over a staged file in order, stopping at the first failure.
+func validateStaged(cfg *Config, reportType string, path string, size int64) error {
+	file := &stagedFile{path: path, size: size}
+	for _, check := range validationChecksFor(cfg, reportType) {
+		if err := validators[check.Check](check, file); err != nil {
+			return fmt.Errorf("%s check failed: %w", check.Check, err)
+		}
+	}
+	return nil
+}
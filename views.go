@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Ways of materializing views.
+const (
+	viewModeSymlink = "symlink" // Links into the store, costing no space
+	viewModeCopy    = "copy"    // Plain copies, for shares and archives that don't follow links
+)
+
+// views lists the browsable trees built over the store and the folder each document goes in.
+var views = map[string]func(document documentInfo) string{
+	"by-family":   func(document documentInfo) string { return familyFolder(document.ProductFamily) },
+	"by-language": func(document documentInfo) string { return document.Language },
+	"by-material": func(document documentInfo) string {
+		// Our own material codes are what people search for when they are known.
+		if document.InternalCode != "" {
+			return document.InternalCode
+		}
+		return document.Material
+	},
+}
+
+// buildViews regenerates the view trees under cfg.ViewsDir, e.g. views/by-language/EN/<name>.pdf.
+// Documents the catalog knows no product family of go in by-family/unknown.
+// The new trees are built beside the old ones and swapped in, so browsers never see a half-built view.
+func buildViews(cfg *Config, store documentStore) error {
+	if cfg.ViewMode != viewModeSymlink && cfg.ViewMode != viewModeCopy {
+		return fmt.Errorf("unknown view mode %q, expected %s or %s", cfg.ViewMode, viewModeSymlink, viewModeCopy)
+	}
+	documents, err := listDocuments(store)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	docs, err := openCatalog(cfg.CatalogFile)
+	if err != nil {
+		return err
+	}
+	withProductFamilies(docs, documents)
+	viewsDir, err := filepath.Abs(cfg.ViewsDir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(viewsDir), 0o755); err != nil {
+		return err
+	}
+	buildDir, err := os.MkdirTemp(filepath.Dir(viewsDir), "."+filepath.Base(viewsDir)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	// Clean up the half-built tree on every failure path.
+	defer os.RemoveAll(buildDir)
+	if err := os.Chmod(buildDir, 0o755); err != nil {
+		return err
+	}
+	for _, document := range documents {
+		target, ok := store.path(document.Name)
+		if !ok {
+			continue
+		}
+		target, err = filepath.Abs(target)
+		if err != nil {
+			return err
+		}
+		for view, folderOf := range views {
+			folder := folderOf(document)
+			// Folder names come from catalog data; anything that isn't a plain name goes in unknown.
+			if !isSafeName(folder) {
+				folder = "unknown"
+			}
+			if !isSafeName(document.Name) {
+				return fmt.Errorf("refusing unsafe file name %q in view %s", document.Name, view)
+			}
+			dir := filepath.Join(buildDir, view, folder)
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return err
+			}
+			linkPath := filepath.Join(dir, document.Name)
+			if cfg.ViewMode == viewModeCopy {
+				err = copyStoredDocument(store, document.Name, linkPath)
+			} else {
+				// Relative to where the link ends up once swapped in, so the tree survives moving the whole directory.
+				var relative string
+				relative, err = filepath.Rel(filepath.Join(viewsDir, view, folder), target)
+				if err == nil {
+					err = os.Symlink(relative, linkPath)
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("failed to add %s to view %s: %v", document.Name, view, err)
+			}
+		}
+	}
+	// Swap the new trees in.
+	oldDir := buildDir + ".old"
+	if err := os.Rename(viewsDir, oldDir); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(buildDir, viewsDir); err != nil {
+		// Put the previous views back.
+		os.Rename(oldDir, viewsDir)
+		return err
+	}
+	os.RemoveAll(oldDir)
+	log.Printf("built %d views of %d documents in %s", len(views), len(documents), cfg.ViewsDir)
+	return nil
+}
+
+// runViews implements the views command, rebuilding every view from the store.
+func runViews(args []string) error {
+	cfg, _, err := loadConfig("views", args, nil)
+	if err != nil {
+		return err
+	}
+	store, err := openDocumentStore(cfg)
+	if err != nil {
+		return err
+	}
+	return buildViews(cfg, store)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// webDAVMirror mirrors to a WebDAV collection, such as the WebDAV interface of a DMS, with the
+// semantics of the local mirror: parent collections are created as needed and a copy only appears
+// under its name once complete, by uploading to a temporary name and moving it over.
+type webDAVMirror struct {
+	base     *url.URL // Collection URL, http or https, without credentials
+	username string
+	password string
+	client   *http.Client
+	created  bool // Whether the collection is known to exist
+}
+
+// newWebDAVMirror parses a webdav:// or webdav+http:// destination.
+func newWebDAVMirror(cfg *Config, parsed *url.URL) *webDAVMirror {
+	base := *parsed
+	base.Scheme = "https"
+	if parsed.Scheme == "webdav+http" {
+		base.Scheme = "http"
+	}
+	base.User = nil
+	base.Path = "/" + strings.Trim(parsed.Path, "/")
+	return &webDAVMirror{
+		base:     &base,
+		username: parsed.User.Username(),
+		password: cfg.webDAVPassword(),
+		client:   &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+// resourceURL returns the URL of a path below the collection.
+func (target *webDAVMirror) resourceURL(relative string) string {
+	resource := *target.base
+	resource.Path = path.Join(target.base.Path, relative)
+	return resource.String()
+}
+
+// do sends a WebDAV request with the destination's credentials.
+func (target *webDAVMirror) do(ctx context.Context, method string, resource string, body io.Reader, header http.Header) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, resource, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		request.Header[http.CanonicalHeaderKey(name)] = values
+	}
+	if target.username != "" {
+		request.SetBasicAuth(target.username, target.password)
+	}
+	if file, ok := body.(*os.File); ok {
+		if info, err := file.Stat(); err == nil {
+			request.ContentLength = info.Size()
+		}
+	}
+	response, err := target.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %v", method, request.URL.Redacted(), err)
+	}
+	return response, nil
+}
+
+// expectStatus closes a response and turns a status outside wanted into a statusCodeError.
+func expectStatus(response *http.Response, method string, resource string, wanted ...int) error {
+	defer response.Body.Close()
+	for _, status := range wanted {
+		if response.StatusCode == status {
+			return nil
+		}
+	}
+	detail, _ := io.ReadAll(io.LimitReader(response.Body, 4<<10))
+	return &statusCodeError{statusCode: response.StatusCode, err: fmt.Errorf("%s %s: %s %s", method, resource, response.Status, strings.TrimSpace(string(detail)))}
+}
+
+// makeCollections creates the destination collection and its parents, once per run. MKCOL answers
+// 405 for a collection that exists already.
+func (target *webDAVMirror) makeCollections(ctx context.Context) error {
+	if target.created {
+		return nil
+	}
+	for _, collection := range parentDirs(target.base.Path) {
+		resource := *target.base
+		resource.Path = collection + "/"
+		response, err := target.do(ctx, "MKCOL", resource.String(), nil, nil)
+		if err != nil {
+			return err
+		}
+		if err := expectStatus(response, "MKCOL", resource.String(), http.StatusCreated, http.StatusMethodNotAllowed); err != nil {
+			return err
+		}
+	}
+	target.created = true
+	return nil
+}
+
+// put implements mirrorTarget: it uploads beside the destination under a temporary name and moves it over.
+func (target *webDAVMirror) put(ctx context.Context, name string, localPath string, sha256 string) error {
+	if !isSafeName(name) {
+		return fmt.Errorf("refusing to mirror %q: not a plain file name", name)
+	}
+	if err := target.makeCollections(ctx); err != nil {
+		return err
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	temp := target.resourceURL("." + name + ".part-" + hex.EncodeToString(suffix))
+	destination := target.resourceURL(name)
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	response, err := target.do(ctx, http.MethodPut, temp, file, http.Header{"Content-Type": {"application/pdf"}})
+	if err != nil {
+		return err
+	}
+	if err := expectStatus(response, http.MethodPut, temp, http.StatusCreated, http.StatusNoContent, http.StatusOK); err != nil {
+		return err
+	}
+	response, err = target.do(ctx, "MOVE", temp, nil, http.Header{"Destination": {destination}, "Overwrite": {"T"}})
+	if err == nil {
+		err = expectStatus(response, "MOVE", temp, http.StatusCreated, http.StatusNoContent)
+	}
+	if err != nil {
+		// Leave nothing half-named behind; a failed cleanup is found by the next verify.
+		if response, cleanupErr := target.do(ctx, http.MethodDelete, temp, nil, nil); cleanupErr == nil {
+			response.Body.Close()
+		}
+		return err
+	}
+	return nil
+}
+
+// exists reports whether a resource is there, with a depth 0 PROPFIND.
+func (target *webDAVMirror) exists(ctx context.Context, resource string) (bool, error) {
+	body := strings.NewReader(`<?xml version="1.0" encoding="utf-8"?><propfind xmlns="DAV:"><prop><getcontentlength/></prop></propfind>`)
+	response, err := target.do(ctx, "PROPFIND", resource, body, http.Header{"Depth": {"0"}, "Content-Type": {"application/xml"}})
+	if err != nil {
+		return false, err
+	}
+	if response.StatusCode == http.StatusNotFound {
+		response.Body.Close()
+		return false, nil
+	}
+	return true, expectStatus(response, "PROPFIND", resource, http.StatusMultiStatus, http.StatusOK)
+}
+
+// hash implements mirrorTarget by downloading the copy and hashing it, since WebDAV keeps no checksum.
+func (target *webDAVMirror) hash(ctx context.Context, name string) (string, bool, error) {
+	resource := target.resourceURL(name)
+	exists, err := target.exists(ctx, resource)
+	if err != nil || !exists {
+		return "", false, err
+	}
+	response, err := target.do(ctx, http.MethodGet, resource, nil, nil)
+	if err != nil {
+		return "", false, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", false, expectStatus(response, http.MethodGet, resource, http.StatusOK)
+	}
+	defer response.Body.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, response.Body); err != nil {
+		return "", false, err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), true, nil
+}